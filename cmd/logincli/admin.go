@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"loginsvc/pkg/grpcadmin"
+	"loginsvc/pkg/logintransport"
+)
+
+// admin lists or describes the services of a single instance started with
+// -grpc-admin, or invokes one of its unary methods with a JSON request, and
+// returns the exit code. It connects over TLS if tlsConfig isn't nil. The
+// request is read from stdin if args has none or it is "-".
+func admin(grpcAddr, method string, args []string, timeout time.Duration, tlsConfig *tls.Config) int {
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	switch {
+	case grpcAddr == "":
+		fmt.Fprintf(os.Stderr, "error: %s needs -grpc-addr\n", method)
+		return 1
+	case discovered(grpcAddr):
+		fmt.Fprintf(os.Stderr, "error: %s calls a single instance\n", method)
+		return 1
+	case method != "list" && len(args) == 0:
+		fmt.Fprintf(os.Stderr, "error: %s needs a symbol\n", method)
+		return 1
+	}
+	conn, err := logintransport.DialGRPC(ctx, grpcAddr, logintransport.ClientTLS(tlsConfig))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	defer conn.Close()
+	c := grpcadmin.NewClient(conn)
+
+	switch method {
+	case "list":
+		services, err := c.ListServices(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		for _, s := range services {
+			fmt.Fprintln(os.Stdout, s)
+		}
+
+	case "describe":
+		d, err := c.Describe(ctx, args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		fmt.Fprint(os.Stdout, d)
+
+	case "invoke":
+		var req []byte
+		if len(args) > 1 && args[1] != "-" {
+			req = []byte(args[1])
+		} else if req, err = ioutil.ReadAll(os.Stdin); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		reply, err := c.Invoke(ctx, args[0], req)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", reply)
+	}
+	return 0
+}
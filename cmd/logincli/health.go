@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"loginsvc/pkg/logintransport"
+)
+
+// defaultProbeTimeout bounds a probe run without -timeout.
+const defaultProbeTimeout = 5 * time.Second
+
+// probe checks the health of a single instance and returns the exit code:
+// 0 if it is ready, 1 otherwise. Over gRPC it asks the grpc.health.v1
+// service about service, "" for the server as a whole, over TLS if
+// tlsConfig isn't nil. Over HTTP it gets
+// /readyz, so httpAddr should be the debug listener.
+func probe(httpAddr, grpcAddr, service string, timeout time.Duration, tlsConfig *tls.Config) int {
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	switch {
+	case discovered(httpAddr + grpcAddr):
+		fmt.Fprintf(os.Stderr, "error: health probes a single instance\n")
+		return 1
+
+	case grpcAddr != "":
+		conn, err := logintransport.DialGRPC(ctx, grpcAddr, logintransport.ClientTLS(tlsConfig))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		defer conn.Close()
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stdout, "status: %s\n", resp.Status)
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			return 1
+		}
+		return 0
+
+	case httpAddr != "":
+		if !strings.HasPrefix(httpAddr, "http") {
+			httpAddr = "http://" + httpAddr
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(httpAddr, "/")+"/readyz", nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		fmt.Fprintf(os.Stdout, "%s", body)
+		if resp.StatusCode != http.StatusOK {
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "error: no remote address specified\n")
+	return 1
+}
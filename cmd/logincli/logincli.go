@@ -2,14 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"text/tabwriter"
 	"time"
 
-	"google.golang.org/grpc"
-
 	lightstep "github.com/lightstep/lightstep-tracer-go"
 	stdopentracing "github.com/opentracing/opentracing-go"
 	zipkinot "github.com/openzipkin-contrib/zipkin-go-opentracing"
@@ -18,24 +19,25 @@ import (
 	"sourcegraph.com/sourcegraph/appdash"
 	appdashot "sourcegraph.com/sourcegraph/appdash/opentracing"
 
+	"loginsvc/pkg/discovery"
 	"loginsvc/pkg/loginservice"
 	"loginsvc/pkg/logintransport"
+	"loginsvc/pkg/tlsconfig"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
 	// addthrift "github.com/go-kit/examples/addsvc/thrift/gen-go/addsvc"
 )
 
 func main() {
-	// The addcli presumes no service discovery system, and expects users to
-	// provide the direct address of an addsvc. This presumption is reflected in
-	// the addcli binary and the client packages: the -transport.addr flags
-	// and various client constructors both expect host:port strings. For an
-	// example service with a client built on top of a service discovery system,
-	// see profilesvc.
+	// The -transport.addr flags take the direct address of a loginsvc, or
+	// several instances to balance calls across: a comma separated list, a
+	// file listing them with file:path, or DNS records with dns:name (SRV) or
+	// dns:host:port (A and AAAA).
 	fs := flag.NewFlagSet("addcli", flag.ExitOnError)
 	var (
-		httpAddr = fs.String("http-addr", "", "HTTP address of addsvc")
-		grpcAddr = fs.String("grpc-addr", "", "gRPC address of addsvc")
+		httpAddr = fs.String("http-addr", "", "HTTP address of loginsvc, a comma separated list, file:path or dns:name")
+		grpcAddr = fs.String("grpc-addr", "", "gRPC address of loginsvc, a comma separated list, file:path or dns:name")
 		// thriftAddr     = fs.String("thrift-addr", "", "Thrift address of addsvc")
 		// jsonRPCAddr = fs.String("jsonrpc-addr", "", "JSON RPC address of addsvc")
 		// thriftProtocol = fs.String("thrift-protocol", "binary", "binary, compact, json, simplejson")
@@ -45,10 +47,45 @@ func main() {
 		zipkinBridge   = fs.Bool("zipkin-ot-bridge", false, "Use Zipkin OpenTracing bridge instead of native implementation")
 		lightstepToken = fs.String("lightstep-token", "", "Enable LightStep tracing via a LightStep access token")
 		appdashAddr    = fs.String("appdash-addr", "", "Enable Appdash tracing via an Appdash server host:port")
-		method         = fs.String("method", "name", "name")
+		method         = fs.String("method", "name", "name, authenticate, check, health, or over gRPC reflection list, describe, invoke")
+		retries        = fs.Int("retries", 1, "attempts per call to idempotent methods")
+		timeout        = fs.Duration("timeout", 0, "total time allowed per call, 0 for none")
+		attemptTimeout = fs.Duration("attempt-timeout", 0, "time allowed per attempt, 0 for none")
+		hedgeDelay     = fs.Duration("hedge-delay", 0, "send a second request to idempotent methods after this delay, 0 to disable")
+		random         = fs.Bool("random", false, "balance calls randomly instead of round robin")
+		sdInterval     = fs.Duration("sd-interval", 5*time.Second, "how often to read file: and dns: addresses again")
+		healthInterval = fs.Duration("health-interval", 0, "check instances every interval and eject unhealthy ones, 0 to disable")
+		useTLS         = fs.Bool("tls", false, "connect over TLS, verifying the server against the system roots or -tls-ca")
+		tlsCA          = fs.String("tls-ca", "", "PEM CA bundle to verify the server against, implies -tls")
+		tlsCert        = fs.String("tls-cert", "", "PEM client certificate for mutual TLS, implies -tls")
+		tlsKey         = fs.String("tls-key", "", "PEM private key of -tls-cert")
+		tlsServerName  = fs.String("tls-server-name", "", "name to verify the server certificate against (default the host dialed)")
+		tenantID       = fs.String("tenant", "", "tenant to call, if the server doesn't tell by host name")
 	)
-	fs.Usage = usageFor(fs, os.Args[0]+" [flags] <n>")
+	fs.Usage = usageFor(fs, os.Args[0]+" [flags] <n> [password] | -method check <subject> <permission> [resource] | -method health [service] | -method list | -method describe <symbol> | -method invoke <pkg.Service/Method> [json|-]")
 	fs.Parse(os.Args[1:])
+
+	var tlsConfig *tls.Config
+	if *useTLS || *tlsCA != "" || *tlsCert != "" {
+		certs, err := tlsconfig.New(tlsconfig.Options{
+			CertFile:   *tlsCert,
+			KeyFile:    *tlsKey,
+			CAFile:     *tlsCA,
+			ServerName: *tlsServerName,
+		}, log.NewNopLogger())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		tlsConfig = certs.ClientConfig()
+	}
+
+	switch *method {
+	case "health":
+		os.Exit(probe(*httpAddr, *grpcAddr, fs.Arg(0), *timeout, tlsConfig))
+	case "list", "describe", "invoke":
+		os.Exit(admin(*grpcAddr, *method, fs.Args(), *timeout, tlsConfig))
+	}
 	if len(fs.Args()) == 0 {
 		fs.Usage()
 		os.Exit(1)
@@ -96,23 +133,48 @@ func main() {
 
 	// This is a demonstration client, which supports multiple transports.
 	// Your clients will probably just define and stick with 1 transport.
+	opts := []logintransport.ClientOption{
+		logintransport.ClientRetries(*retries, 50*time.Millisecond, time.Second),
+		logintransport.ClientTimeout(*timeout),
+		logintransport.ClientAttemptTimeout(*attemptTimeout),
+		logintransport.ClientHedging(*hedgeDelay, 1),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, logintransport.ClientTLS(tlsConfig))
+	}
+	if *random {
+		opts = append(opts, logintransport.ClientRandom(time.Now().UnixNano()))
+	}
+	if *tenantID != "" {
+		opts = append(opts, logintransport.ClientTenant(*tenantID))
+	}
 	var (
 		svc loginservice.Service
 		err error
 	)
-	if *httpAddr != "" {
-		svc, err = logintransport.NewHTTPClient(*httpAddr, otTracer, zipkinTracer, log.NewNopLogger())
+	if addr := *httpAddr + *grpcAddr; discovered(addr) {
+		instancer, stop := newInstancer(addr, *sdInterval, *healthInterval)
+		defer stop()
+		var closer io.Closer
+		if *httpAddr != "" {
+			svc, closer = logintransport.NewBalancedHTTPClient(instancer, otTracer, zipkinTracer, log.NewNopLogger(), opts...)
+		} else {
+			svc, closer = logintransport.NewBalancedGRPCClient(instancer, otTracer, zipkinTracer, log.NewNopLogger(), opts...)
+		}
+		defer closer.Close()
+	} else if *httpAddr != "" {
+		svc, err = logintransport.NewHTTPClient(*httpAddr, otTracer, zipkinTracer, log.NewNopLogger(), opts...)
 	} else if *grpcAddr != "" {
 		// conn, err := grpc.Dial(*grpcAddr, grpc.WithInsecure(), grpc.WithTimeout(time.Second))
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		conn, err := grpc.DialContext(ctx, *grpcAddr, grpc.WithInsecure())
+		conn, err := logintransport.DialGRPC(ctx, *grpcAddr, opts...)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v", err)
 			cancel()
 			os.Exit(1)
 		}
 		defer conn.Close()
-		svc = logintransport.NewGRPCClient(conn, otTracer, zipkinTracer, log.NewNopLogger())
+		svc = logintransport.NewGRPCClient(conn, otTracer, zipkinTracer, log.NewNopLogger(), opts...)
 		// } else if *jsonRPCAddr != "" {
 		// svc, err = logintransport.NewJSONRPCClient(*jsonRPCAddr, otTracer, log.NewNopLogger())
 		// } else if *thriftAddr != "" {
@@ -178,12 +240,69 @@ func main() {
 		}
 		fmt.Fprintf(os.Stdout, "name: %s, sid: %s\n", n, v)
 
+	case "authenticate":
+		if len(fs.Args()) < 2 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		n, pw := fs.Args()[0], fs.Args()[1]
+		v, err := svc.Authenticate(context.Background(), n, pw)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stdout, "name: %s, sid: %s\n", n, v)
+
+	case "check":
+		if len(fs.Args()) < 2 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		subject, permission, resource := fs.Args()[0], fs.Args()[1], ""
+		if len(fs.Args()) > 2 {
+			resource = fs.Args()[2]
+		}
+		v, err := svc.Check(context.Background(), subject, permission, resource)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stdout, "subject: %s, permission: %s, resource: %s, allowed: %t\n", subject, permission, resource, v)
+
 	default:
 		fmt.Fprintf(os.Stderr, "error: invalid method %q\n", *method)
 		os.Exit(1)
 	}
 }
 
+// discovered reports whether addr names instances to discover rather than a
+// single host:port.
+func discovered(addr string) bool {
+	return strings.HasPrefix(addr, "file:") || strings.HasPrefix(addr, "dns:") || strings.Contains(addr, ",")
+}
+
+// newInstancer returns an Instancer for addr, checking the health of the
+// instances if healthInterval is positive, and a function stopping it.
+func newInstancer(addr string, interval, healthInterval time.Duration) (sd.Instancer, func()) {
+	var instancer sd.Instancer
+	switch logger := log.NewNopLogger(); {
+	case strings.HasPrefix(addr, "file:"):
+		instancer = discovery.NewFileInstancer(strings.TrimPrefix(addr, "file:"), interval, logger)
+	case strings.HasPrefix(addr, "dns:"):
+		instancer = discovery.NewDNSInstancer(strings.TrimPrefix(addr, "dns:"), interval, nil, logger)
+	default:
+		instancer = sd.FixedInstancer(strings.Split(addr, ","))
+	}
+	if healthInterval <= 0 {
+		return instancer, instancer.Stop
+	}
+	health := discovery.NewHealthInstancer(instancer, nil, healthInterval, log.NewNopLogger())
+	return health, func() {
+		health.Stop()
+		instancer.Stop()
+	}
+}
+
 func usageFor(fs *flag.FlagSet, short string) func() {
 	return func() {
 		fmt.Fprintf(os.Stderr, "USAGE\n")
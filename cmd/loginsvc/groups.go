@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"loginsvc/repo"
+)
+
+// runGroups implements the "loginsvc groups" subcommands and returns the
+// process exit code.
+func runGroups(args []string) int {
+	a, ok := parseAdminArgs("groups", args, map[string][2]int{
+		"list":     {0, 0},
+		"create":   {1, 1},
+		"delete":   {1, 1},
+		"add":      {2, 2},
+		"remove":   {2, 2},
+		"members":  {1, 1},
+		"nest":     {2, 2},
+		"unnest":   {2, 2},
+		"assign":   {2, 2},
+		"unassign": {2, 2},
+	}, "USAGE\n"+
+		"  loginsvc groups list [flags]\n"+
+		"  loginsvc groups create <group> [flags]\n"+
+		"  loginsvc groups delete <group> [flags]\n"+
+		"  loginsvc groups add <group> <user> [flags]\n"+
+		"  loginsvc groups remove <group> <user> [flags]\n"+
+		"  loginsvc groups members <group> [flags]\n"+
+		"  loginsvc groups nest <group> <parent> [flags]\n"+
+		"  loginsvc groups unnest <group> <parent> [flags]\n"+
+		"  loginsvc groups assign <group> <role> [flags]\n"+
+		"  loginsvc groups unassign <group> <role> [flags]\n")
+	if !ok {
+		return 1
+	}
+	_, groups, closeStores, err := adminRepositories(a.store, a.tenant, a.redisAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	defer closeStores()
+
+	pos := a.pos
+	switch a.command {
+	case "list":
+		var gs []repo.Group
+		if gs, err = groups.Groups(); err != nil {
+			break
+		}
+		for _, g := range gs {
+			fmt.Fprintln(os.Stdout, g.Name)
+			if len(g.Parents) > 0 {
+				fmt.Fprintf(os.Stdout, "  in %s\n", strings.Join(g.Parents, ", "))
+			}
+			if len(g.Roles) > 0 {
+				fmt.Fprintf(os.Stdout, "  roles %s\n", strings.Join(g.Roles, ", "))
+			}
+		}
+	case "create":
+		err = groups.CreateGroup(pos[0])
+	case "delete":
+		err = groups.DeleteGroup(pos[0])
+	case "add":
+		err = groups.AddMember(pos[0], pos[1])
+	case "remove":
+		err = groups.RemoveMember(pos[0], pos[1])
+	case "members":
+		var names []string
+		if names, err = groups.Members(pos[0]); err == nil {
+			for _, name := range names {
+				fmt.Fprintln(os.Stdout, name)
+			}
+		}
+	case "nest":
+		err = groups.Nest(pos[0], pos[1])
+	case "unnest":
+		err = groups.Unnest(pos[0], pos[1])
+	case "assign":
+		err = groups.AssignGroupRole(pos[0], pos[1])
+	case "unassign":
+		err = groups.UnassignGroupRole(pos[0], pos[1])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
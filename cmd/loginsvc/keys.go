@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"loginsvc/pkg/tenant"
+	"loginsvc/repo"
+)
+
+// runKeys implements the "loginsvc keys" subcommands and returns the
+// process exit code.
+func runKeys(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "USAGE\n  loginsvc keys <rewrap> [flags]\n")
+		return 1
+	}
+	switch args[0] {
+	case "rewrap":
+		return runKeysRewrap(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "error: unknown keys command %q\n", args[0])
+	return 1
+}
+
+// runKeysRewrap moves the personal data of a tenant to the primary key of
+// its keyring. Run it after adding a new primary master key, and only then
+// remove the old one from the key file.
+func runKeysRewrap(args []string) int {
+	fs := flag.NewFlagSet("loginsvc keys rewrap", flag.ExitOnError)
+	var (
+		store    = fs.String("store", "mysql", "mysql, sqlite")
+		tenantID = fs.String("tenant", tenant.Default, "Tenant whose users to rewrap")
+	)
+	fs.Usage = usageFor(fs, "loginsvc keys rewrap [flags]")
+	fs.Parse(args)
+
+	k, err := tenantKeyring(*tenantID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	var r interface{ Rewrap() (int, error) }
+	switch *store {
+	case "mysql":
+		r = repo.GetMySQLLoginRepo().ForTenant(*tenantID, k)
+	case "sqlite":
+		r = repo.GetSqliteLoginRepository().ForTenant(*tenantID, k)
+	default:
+		fmt.Fprintf(os.Stderr, "error: unknown store %q\n", *store)
+		return 1
+	}
+
+	n, err := r.Rewrap()
+	fmt.Fprintf(os.Stderr, "rewrapped=%d\n", n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"loginsvc/pkg/envelope"
+	"loginsvc/repo"
+)
+
+// writeKeyFile writes a key file holding keys, with primary as the primary.
+func writeKeyFile(t *testing.T, path, primary string, keys map[string][]byte, indexKey []byte) {
+	f := map[string]interface{}{"primary": primary, "index_key": base64.StdEncoding.EncodeToString(indexKey)}
+	encoded := map[string]string{}
+	for id, key := range keys {
+		encoded[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	f["keys"] = encoded
+	buf, _ := json.Marshal(f)
+	if err := ioutil.WriteFile(path, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeysRewrap(t *testing.T) {
+	dir := t.TempDir()
+	dbPath, keyPath := filepath.Join(dir, "users.db"), filepath.Join(dir, "keys.json")
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	schema, err := ioutil.ReadFile("../../sqlite.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("sqliteConnStr", dbPath)
+	viper.Set("masterKeyFile", keyPath)
+	defer viper.Set("sqliteConnStr", nil)
+	defer viper.Set("masterKeyFile", nil)
+
+	k1, k2, index := envelope.GenerateKey(), envelope.GenerateKey(), envelope.GenerateKey()
+	writeKeyFile(t, keyPath, "k1", map[string][]byte{"k1": k1}, index)
+	assert.NoError(t, repo.GetSqliteLoginRepository().Register(repo.User{Name: "al", SID: "c111111111", Email: "al@example.com"}))
+
+	writeKeyFile(t, keyPath, "k2", map[string][]byte{"k1": k1, "k2": k2}, index)
+	assert.Equal(t, 0, runKeys([]string{"rewrap", "-store", "sqlite"}))
+	assert.Equal(t, 1, runKeys([]string{"rewrap", "-store", "sqlite", "-tenant", "acme"}), "unknown tenants are refused")
+
+	writeKeyFile(t, keyPath, "k2", map[string][]byte{"k2": k2}, index)
+	u, err := repo.GetSqliteLoginRepository().User("al")
+	assert.NoError(t, err)
+	assert.Equal(t, "al@example.com", u.Email)
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"text/tabwriter"
+	"time"
 
 	kitgrpc "github.com/go-kit/kit/transport/grpc"
 	lightstep "github.com/lightstep/lightstep-tracer-go"
@@ -20,12 +25,30 @@ import (
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"sourcegraph.com/sourcegraph/appdash"
 	appdashot "sourcegraph.com/sourcegraph/appdash/opentracing"
 
+	"loginsvc/config"
+	"loginsvc/pkg/audit"
+	"loginsvc/pkg/breaker"
+	"loginsvc/pkg/discovery"
+	"loginsvc/pkg/envelope"
+	"loginsvc/pkg/grpcadmin"
+	"loginsvc/pkg/health"
+	"loginsvc/pkg/invalidation"
+	"loginsvc/pkg/limiter"
 	"loginsvc/pkg/loginendpoint"
 	"loginsvc/pkg/loginservice"
 	"loginsvc/pkg/logintransport"
+	"loginsvc/pkg/password"
+	"loginsvc/pkg/shutdown"
+	"loginsvc/pkg/tenant"
+	"loginsvc/pkg/tlsconfig"
+	"loginsvc/pkg/webhook"
+	"loginsvc/repo"
 
 	loginpb "loginsvc/pb"
 
@@ -35,6 +58,24 @@ import (
 )
 
 func main() {
+	// Maintenance commands share the binary, and its config, with the
+	// service itself.
+	if len(os.Args) > 1 && os.Args[1] == "users" {
+		os.Exit(runUsers(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "roles" {
+		os.Exit(runRoles(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "groups" {
+		os.Exit(runGroups(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(runAudit(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		os.Exit(runKeys(os.Args[2:]))
+	}
+
 	// Define our flags. Your service probably won't need to bind listeners for
 	// *all* supported transports, or support both Zipkin and LightStep, and so
 	// on, but we do it here for demonstration purposes.
@@ -52,6 +93,22 @@ func main() {
 		zipkinBridge   = fs.Bool("zipkin-ot-bridge", false, "Use Zipkin OpenTracing bridge instead of native implementation")
 		lightstepToken = fs.String("lightstep-token", "", "Enable LightStep tracing via a LightStep access token")
 		appdashAddr    = fs.String("appdash-addr", "", "Enable Appdash tracing via an Appdash server host:port")
+		cacheTTL       = fs.Duration("cache-ttl", 30*time.Second, "How long users are cached; also bounds staleness if an invalidation is lost")
+		redisAddr      = fs.String("invalidation-redis-addr", "", "Share cache invalidations between replicas via Redis pub/sub at host:port")
+		schemeInterval = fs.Duration("password-scheme-interval", time.Minute, "How often to count users by password scheme")
+		consulAddr     = fs.String("consul-addr", "", "Register the HTTP and gRPC servers with the Consul agent at this address")
+		consulToken    = fs.String("consul-token", os.Getenv("CONSUL_HTTP_TOKEN"), "ACL token for the Consul agent")
+		registryTTL    = fs.Duration("registry-ttl", 15*time.Second, "How long a registration lives without heartbeats, which are sent every third of it")
+		advertiseHost  = fs.String("advertise-host", "", "Host or IP registered for this instance (default the hostname)")
+		shutdownDelay  = fs.Duration("shutdown-delay", 0, "How long to keep serving once readiness fails on shutdown, so load balancers stop sending traffic first")
+		drainTimeout   = fs.Duration("drain-timeout", 20*time.Second, "How long requests in flight may take to complete on shutdown")
+		healthInterval = fs.Duration("health-interval", 5*time.Second, "How often the gRPC health service runs the readiness checks")
+		tlsCert        = fs.String("tls-cert", "", "Serve HTTP and gRPC over TLS with this PEM certificate chain, reloaded when it changes")
+		tlsKey         = fs.String("tls-key", "", "PEM private key of -tls-cert")
+		tlsClientCA    = fs.String("tls-client-ca", "", "Require client certificates signed by this PEM CA bundle (mutual TLS)")
+		tlsMinVersion  = fs.String("tls-min-version", "1.2", "Oldest TLS version accepted: 1.2 or 1.3")
+		tlsCiphers     = fs.String("tls-cipher-suites", "", "Comma separated TLS 1.2 cipher suites (default Go's)")
+		grpcAdmin      = fs.Bool("grpc-admin", false, "Register gRPC server reflection and channelz; needs -tls-client-ca or -grpc-addr bound to localhost")
 	)
 	fs.Usage = usageFor(fs, os.Args[0]+" [flags]")
 	fs.Parse(os.Args[1:])
@@ -71,6 +128,60 @@ func main() {
 		logger = log.With(logger, "caller", log.DefaultCaller)
 	}
 
+	// Settings the background loops can't run with are refused before
+	// anything starts.
+	{
+		if err := config.GetAudit().Validate(); err != nil {
+			logger.Log("during", "config", "err", err)
+			os.Exit(1)
+		}
+		if err := config.GetWebhooks().Validate(); err != nil {
+			logger.Log("during", "config", "err", err)
+			os.Exit(1)
+		}
+		if *healthInterval <= 0 {
+			logger.Log("during", "flags", "err", fmt.Sprintf("-health-interval must be positive, got %v", *healthInterval))
+			os.Exit(1)
+		}
+	}
+
+	// The HTTP and gRPC listeners share a TLS configuration, whose files are
+	// read again when they change. The debug listener stays in plaintext: it
+	// serves metrics and probes, and shouldn't be exposed beyond the cluster.
+	var certs *tlsconfig.Reloader
+	{
+		if *tlsCert != "" || *tlsKey != "" || *tlsClientCA != "" {
+			minVersion, err := tlsconfig.ParseVersion(*tlsMinVersion)
+			if err != nil {
+				logger.Log("during", "TLS", "err", err)
+				os.Exit(1)
+			}
+			suites, err := tlsconfig.ParseCipherSuites(*tlsCiphers)
+			if err != nil {
+				logger.Log("during", "TLS", "err", err)
+				os.Exit(1)
+			}
+			if *tlsCert == "" {
+				logger.Log("during", "TLS", "err", "-tls-client-ca needs -tls-cert and -tls-key")
+				os.Exit(1)
+			}
+			certs, err = tlsconfig.New(tlsconfig.Options{
+				CertFile:     *tlsCert,
+				KeyFile:      *tlsKey,
+				CAFile:       *tlsClientCA,
+				MinVersion:   minVersion,
+				CipherSuites: suites,
+			}, logger)
+			if err != nil {
+				logger.Log("during", "TLS", "err", err)
+				os.Exit(1)
+			}
+			logger.Log("tls", "enabled", "mutual", certs.MutualTLS())
+		} else {
+			logger.Log("tls", "disabled", "warning", "passwords are sent in plaintext")
+		}
+	}
+
 	var zipkinTracer *zipkin.Tracer
 	{
 		if *zipkinURL != "" {
@@ -117,7 +228,8 @@ func main() {
 
 	// Create the (sparse) metrics we'll use in the service. They, too, are
 	// dependencies that we pass to components that use them.
-	var ints, chars metrics.Counter
+	var ints, chars, upgrades metrics.Counter
+	var schemes metrics.Gauge
 	{
 		// Business-level metrics.
 		ints = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
@@ -126,6 +238,18 @@ func main() {
 			Name:      "login_name_times",
 			Help:      "Total count of login with username via the Name method.",
 		}, []string{})
+		upgrades = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "demo",
+			Subsystem: "loginsvc",
+			Name:      "password_upgrades_total",
+			Help:      "Total count of password hashes replaced on login with the default scheme.",
+		}, []string{"result"})
+		schemes = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "demo",
+			Subsystem: "loginsvc",
+			Name:      "password_scheme_users",
+			Help:      "Number of users of each tenant whose password hash uses each scheme.",
+		}, []string{"tenant", "scheme"})
 	}
 	var duration metrics.Histogram
 	{
@@ -139,6 +263,213 @@ func main() {
 	}
 	http.DefaultServeMux.Handle("/metrics", promhttp.Handler())
 
+	// Replicas cache users locally, so writes must be announced on a bus that
+	// reaches every instance. Without Redis only this process is reached.
+	var bus invalidation.Bus
+	{
+		if *redisAddr != "" {
+			logger.Log("bus", "Redis", "addr", *redisAddr)
+			bus = invalidation.NewRedisBus(*redisAddr, "", logger)
+		} else {
+			bus = invalidation.NewInProcBus()
+		}
+		defer bus.Close()
+	}
+	// Every tenant has a service of its own, on repositories scoped to its
+	// rows and encrypting with its keys, hashing new passwords with its
+	// scheme. Without tenants configured everyone is tenant.Default.
+	tenantConfigs := config.GetTenants()
+	multiTenant := len(tenantConfigs) > 0
+	resolver := tenant.Resolver{Hosts: map[string]string{}}
+	if !multiTenant {
+		tenantConfigs = map[string]config.Tenant{tenant.Default: {SCIMTokens: config.GetSCIMTokens()}}
+		resolver.Fallback = tenant.Default
+	}
+	wc := config.GetWatch()
+	watchOpts := loginservice.WatchOptions{PollInterval: wc.PollInterval, Heartbeat: wc.Heartbeat}
+	var (
+		services     = loginservice.Tenants{}
+		admins       = loginservice.AdminTenants{}
+		audits       = map[string]*audit.Recorder{}
+		outboxes     = map[string]repo.Outbox{}
+		events       = loginservice.EventTenants{}
+		provisioning = loginservice.ProvisioningTenants{}
+		scimTokens   = loginendpoint.SCIMTokens{}
+		counters     = map[string]repo.SchemeCounter{}
+		dbHealth     repo.HealthChecker
+		keys         []repo.HealthChecker
+	)
+	{
+		// Storage-level metrics, one series per connection pool.
+		queries := prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "demo",
+			Subsystem: "loginsvc",
+			Name:      "db_queries_total",
+			Help:      "Total count of queries routed to each database pool.",
+		}, []string{"pool", "op"})
+		up := prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "demo",
+			Subsystem: "loginsvc",
+			Name:      "db_pool_up",
+			Help:      "Whether each database pool passed its last health check.",
+		}, []string{"pool"})
+		openConns := prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "demo",
+			Subsystem: "loginsvc",
+			Name:      "db_pool_open_connections",
+			Help:      "Open connections of each database pool.",
+		}, []string{"pool"})
+		mysql := repo.GetMySQLLoginRepo(
+			repo.ClusterLogger(log.With(logger, "component", "db")),
+			repo.ClusterInstrumentation(queries, up, openConns),
+		)
+		dbHealth = mysql
+		for id, tc := range tenantConfigs {
+			var keyring *envelope.Keyring
+			if tc.MasterKeyFile != "" {
+				k, err := envelope.LoadKeyring(tc.MasterKeyFile)
+				if err != nil {
+					logger.Log("tenant", id, "during", "LoadKeyring", "err", err)
+					os.Exit(1)
+				}
+				keyring = k
+			}
+			hashers := password.DefaultRegistry()
+			if tc.PasswordScheme != "" {
+				if err := hashers.SetDefault(tc.PasswordScheme); err != nil {
+					logger.Log("tenant", id, "err", err)
+					os.Exit(1)
+				}
+			}
+			scoped := mysql.ForTenant(id, keyring)
+			counters[id] = scoped
+			keys = append(keys, scoped)
+			users := repo.NewCachingLoginRepository(scoped, bus, *cacheTTL)
+			roles := repo.NewCachingRoleRepository(scoped, bus, *cacheTTL)
+			// Logins and admin calls go to the audit log of the tenant.
+			tenantLogger := log.With(logger, "tenant", id)
+			rec := audit.NewRecorder(scoped, tenantLogger)
+			audits[id] = rec
+			outboxes[id] = scoped
+			events[id] = loginservice.NewEventService(scoped, id, watchOpts)
+			services[id] = loginservice.AuditingMiddleware(rec)(
+				loginservice.New(users, roles, hashers, tenantLogger, ints, chars, upgrades),
+			)
+			admins[id] = loginservice.AdminAuditingMiddleware(rec)(
+				loginservice.NewAdmin(users, repo.NewCachingUserAdmin(scoped, users, roles), scoped, scoped, hashers, tenantLogger),
+			)
+			// SCIM clients provision users through the audited admin
+			// service, with the bearer tokens of the tenant.
+			provisioning[id] = loginservice.NewProvisioning(admins[id], repo.NewCachingGroupRepository(scoped, roles))
+			if len(tc.SCIMTokens) > 0 {
+				scimTokens[id] = tc.SCIMTokens
+			} else {
+				tenantLogger.Log("scim", "no tokens, the SCIM endpoints deny every call")
+			}
+			resolver.Tenants = append(resolver.Tenants, id)
+			for _, host := range tc.Hosts {
+				resolver.Hosts[strings.ToLower(host)] = id
+			}
+		}
+	}
+
+	// Rate limits hold per replica, unless a Redis server shares the
+	// buckets between them.
+	var lim *limiter.Limiter
+	{
+		rl := config.GetRateLimit()
+		rule := func(r config.RateLimitRule) limiter.Rule {
+			return limiter.Rule{Rate: r.Rate, Burst: r.Burst}
+		}
+		var store limiter.Store = limiter.NewMemoryStore(rl.MaxKeys)
+		if rl.RedisAddr != "" {
+			logger.Log("ratelimit", "Redis", "addr", rl.RedisAddr)
+			redisStore := limiter.NewRedisStore(rl.RedisAddr, "")
+			defer redisStore.Close()
+			store = redisStore
+		}
+		lim = limiter.New(store, limiter.Rules{
+			Global:    rule(rl.Global),
+			PerIP:     rule(rl.PerIP),
+			PerUser:   rule(rl.PerUser),
+			PerClient: rule(rl.PerClient),
+		}, rl.MaxKeys, log.With(logger, "component", "ratelimit"))
+		for id, tc := range tenantConfigs {
+			if trl := tc.RateLimit; trl != nil {
+				lim.SetTenantRules(id, limiter.Rules{
+					Global:    rule(trl.Global),
+					PerIP:     rule(trl.PerIP),
+					PerUser:   rule(trl.PerUser),
+					PerClient: rule(trl.PerClient),
+				})
+			}
+		}
+	}
+
+	// Each endpoint has its own breaker, configured under breakers.<method>.
+	// Operators can inspect and reset them on the debug listener.
+	var breakers *breaker.Registry
+	{
+		state := prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "demo",
+			Subsystem: "loginsvc",
+			Name:      "circuit_breaker_state",
+			Help:      "State of each circuit breaker: 0 closed, 1 half-open, 2 open.",
+		}, []string{"name"})
+		breakers = breaker.NewRegistry(func(name string) breaker.Settings {
+			b := config.GetBreaker(name)
+			return breaker.Settings{
+				FailureRatio: b.FailureRatio,
+				MinRequests:  b.MinRequests,
+				Interval:     b.Interval,
+				Timeout:      b.Timeout,
+				MaxRequests:  b.MaxRequests,
+			}
+		}, log.With(logger, "component", "breaker"), state)
+		http.DefaultServeMux.Handle("/debug/breakers", breakers)
+	}
+
+	// Methods may be restricted to the callers listed under
+	// allowlists.<method>, identified by their client certificate, which
+	// takes mutual TLS. The admin methods also take allowlists.Admin and,
+	// unlike the others, deny every call without an allowlist, as does
+	// WatchEvents. With tenants configured, each tenant has its lists under
+	// tenants.<id>.allowlists, so that no caller manages or watches a
+	// tenant it isn't listed for: only Name, Authenticate and Check still
+	// take allowlists.<method> for the tenants without their own.
+	shared := map[string]bool{"Name": true, "Authenticate": true, "Check": true}
+	allow := loginendpoint.TenantAllowlists{}
+	for id := range tenantConfigs {
+		lists := loginendpoint.Allowlists{}
+		for _, method := range append([]string{"Name", "Authenticate", "Check", "Admin", "WatchEvents"}, loginendpoint.AdminMethods...) {
+			list := config.GetTenantAllowlist(id, method)
+			if list == nil && (!multiTenant || shared[method]) {
+				list = config.GetAllowlist(method)
+			}
+			if list == nil {
+				continue
+			}
+			lists[method] = list
+			if certs == nil || !certs.MutualTLS() {
+				logger.Log("tenant", id, "method", method, "warning", "allowlist without -tls-client-ca denies every call")
+			}
+		}
+		if _, ok := lists["Admin"]; !ok {
+			logger.Log("tenant", id, "method", "Admin", "warning", "no allowlist, the admin API denies every call")
+		}
+		if _, ok := lists["WatchEvents"]; !ok {
+			logger.Log("tenant", id, "method", "WatchEvents", "warning", "no allowlist, WatchEvents denies every call")
+		}
+		allow[id] = lists
+	}
+	if multiTenant {
+		for _, method := range append([]string{"Admin", "WatchEvents"}, loginendpoint.AdminMethods...) {
+			if config.GetAllowlist(method) != nil {
+				logger.Log("method", method, "warning", "allowlists."+method+" is ignored with tenants configured, list the callers under tenants.<id>.allowlists")
+			}
+		}
+	}
+
 	// Build the layers of the service "onion" from the inside out. First, the
 	// business logic service; then, the set of endpoints that wrap the service;
 	// and finally, a series of concrete transport adapters. The adapters, like
@@ -146,10 +477,14 @@ func main() {
 	// the interfaces that the transports expect. Note that we're not binding
 	// them to ports or anything yet; we'll do that next.
 	var (
-		service     = loginservice.New(logger, ints, chars)
-		endpoints   = loginendpoint.New(service, logger, duration, tracer, zipkinTracer)
-		httpHandler = logintransport.NewHTTPHandler(endpoints, tracer, zipkinTracer, logger)
-		grpcServer  = logintransport.NewGRPCServer(endpoints, tracer, zipkinTracer, logger)
+		endpoints        = loginendpoint.New(services, lim, breakers, allow, resolver, logger, duration, tracer, zipkinTracer)
+		adminEndpoints   = loginendpoint.NewAdmin(admins, lim, breakers, allow, resolver, logger, duration, tracer, zipkinTracer)
+		eventEndpoints   = loginendpoint.NewEvents(events, lim, allow, resolver, logger, tracer, zipkinTracer)
+		scimEndpoints    = loginendpoint.NewSCIM(provisioning, lim, breakers, scimTokens, resolver, logger, duration, tracer, zipkinTracer)
+		httpHandler      = logintransport.NewHTTPHandlerWithAdmin(endpoints, adminEndpoints, scimEndpoints, tracer, zipkinTracer, logger)
+		grpcServer       = logintransport.NewGRPCServer(endpoints, tracer, zipkinTracer, logger)
+		adminGRPCServer  = logintransport.NewAdminGRPCServer(adminEndpoints, tracer, zipkinTracer, logger)
+		eventsGRPCServer = logintransport.NewEventsGRPCServer(eventEndpoints, wc.SendTimeout, tracer, zipkinTracer, logger)
 		// thriftServer   = logintransport.NewThriftServer(endpoints)
 		// jsonrpcHandler = logintransport.NewJSONRPCHandler(endpoints, logger)
 	)
@@ -167,6 +502,37 @@ func main() {
 	// Putting each component into its own block is mostly for aesthetics: it
 	// clearly demarcates the scope in which each listener/socket may be used.
 	var g group.Group
+
+	// The drainer goes first: on shutdown, readiness fails at once, and the
+	// HTTP and gRPC servers drain before the actors after them, like the
+	// debug listener, are interrupted.
+	drainer := shutdown.NewDrainer(*shutdownDelay, *drainTimeout, log.With(logger, "component", "shutdown"))
+	g.Add(drainer.Run, drainer.Stop)
+
+	// The orchestrator probes liveness and readiness on the debug listener.
+	// Readiness fails as soon as shutdown begins, and while the database,
+	// its schema or the keys are not usable.
+	checker := health.NewChecker(2 * time.Second)
+	{
+		checker.Add("shutdown", func(context.Context) error {
+			if drainer.ShuttingDown() {
+				return errors.New("shutting down")
+			}
+			return nil
+		})
+		checker.Add("db", dbHealth.Ping)
+		checker.Add("schema", dbHealth.CheckSchema)
+		checker.Add("keys", func(ctx context.Context) error {
+			for _, k := range keys {
+				if err := k.CheckKeys(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		http.DefaultServeMux.HandleFunc("/healthz", health.Live)
+		http.DefaultServeMux.Handle("/readyz", checker)
+	}
 	{
 		// The debug listener mounts the http.DefaultServeMux, and serves up
 		// stuff like the Prometheus metrics route, the Go debug and profiling
@@ -190,29 +556,52 @@ func main() {
 			logger.Log("transport", "HTTP", "during", "Listen", "err", err)
 			os.Exit(1)
 		}
-		g.Add(func() error {
-			logger.Log("transport", "HTTP", "addr", *httpAddr)
-			return http.Serve(httpListener, httpHandler)
-		}, func(error) {
-			httpListener.Close()
-		})
+		if certs != nil {
+			httpListener = tls.NewListener(httpListener, certs.ServerConfig("h2", "http/1.1"))
+		}
+		logger.Log("transport", "HTTP", "addr", *httpAddr)
+		g.Add(drainer.HTTP(&http.Server{Handler: httpHandler}, httpListener))
 	}
 	{
 		// The gRPC listener mounts the Go kit gRPC server we created.
+		if *grpcAdmin {
+			if err := grpcadmin.CheckExposure(*grpcAddr, certs != nil && certs.MutualTLS()); err != nil {
+				logger.Log("transport", "gRPC", "during", "grpc-admin", "err", err)
+				os.Exit(1)
+			}
+		}
 		grpcListener, err := net.Listen("tcp", *grpcAddr)
 		if err != nil {
 			logger.Log("transport", "gRPC", "during", "Listen", "err", err)
 			os.Exit(1)
 		}
+		logger.Log("transport", "gRPC", "addr", *grpcAddr)
+		// we add the Go Kit gRPC Interceptor to our gRPC service as it is used by
+		// the here demonstrated zipkin tracing middleware. Event streams end as
+		// shutdown begins, rather than hold up the drain.
+		serverOptions := []grpc.ServerOption{grpc.UnaryInterceptor(kitgrpc.Interceptor), grpc.StreamInterceptor(drainer.StreamInterceptor())}
+		if certs != nil {
+			serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(certs.ServerConfig("h2"))))
+		}
+		baseServer := grpc.NewServer(serverOptions...)
+		loginpb.RegisterLoginServer(baseServer, grpcServer)
+		loginpb.RegisterUserAdminServer(baseServer, adminGRPCServer)
+		loginpb.RegisterIdentityEventsServer(baseServer, eventsGRPCServer)
+		if *grpcAdmin {
+			grpcadmin.Register(baseServer)
+		}
+		g.Add(drainer.GRPC(baseServer, grpcListener))
+
+		// The standard health service reports the server as a whole ("")
+		// and the login service, from the readiness checks.
+		healthServer := grpchealth.NewServer()
+		healthpb.RegisterHealthServer(baseServer, healthServer)
+		ctx, cancel := context.WithCancel(drainer.Context())
 		g.Add(func() error {
-			logger.Log("transport", "gRPC", "addr", *grpcAddr)
-			// we add the Go Kit gRPC Interceptor to our gRPC service as it is used by
-			// the here demonstrated zipkin tracing middleware.
-			baseServer := grpc.NewServer(grpc.UnaryInterceptor(kitgrpc.Interceptor))
-			loginpb.RegisterLoginServer(baseServer, grpcServer)
-			return baseServer.Serve(grpcListener)
+			checker.UpdateGRPC(ctx, healthServer, *healthInterval, "", "pb.Login")
+			return nil
 		}, func(error) {
-			grpcListener.Close()
+			cancel()
 		})
 	}
 	// {
@@ -271,6 +660,112 @@ func main() {
 	// 		httpListener.Close()
 	// 	})
 	// }
+	if ac := config.GetAudit(); ac.Retention > 0 {
+		// Prune the audit events past their retention. Every replica does,
+		// which is harmless: the later ones find nothing left to prune.
+		ctx, cancel := context.WithCancel(context.Background())
+		prune := func() {
+			for id, rec := range audits {
+				if seq, err := rec.Prune(ctx, time.Now().Add(-ac.Retention)); err != nil {
+					logger.Log("tenant", id, "during", "Prune", "err", err)
+				} else if seq > 0 {
+					logger.Log("tenant", id, "audit", "pruned", "through", seq)
+				}
+			}
+		}
+		ticker := time.NewTicker(ac.PruneInterval)
+		g.Add(func() error {
+			for {
+				prune()
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}, func(error) {
+			ticker.Stop()
+			cancel()
+		})
+	}
+	{
+		// Deliver the identity events of every tenant to its webhooks. Every
+		// replica does: events and attempts are claimed by one of them.
+		wc := config.GetWebhooks()
+		opts := webhook.Options{MaxAttempts: wc.MaxAttempts, MinBackoff: wc.MinBackoff, MaxBackoff: wc.MaxBackoff, Timeout: wc.Timeout, BatchSize: wc.BatchSize}
+		dispatchers := map[string]*webhook.Dispatcher{}
+		for id, outbox := range outboxes {
+			dispatchers[id] = webhook.NewDispatcher(outbox, id, &http.Client{}, opts, log.With(logger, "tenant", id, "component", "webhook"))
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		ticker := time.NewTicker(wc.Interval)
+		g.Add(func() error {
+			for {
+				for id, d := range dispatchers {
+					if _, err := d.RunOnce(ctx); err != nil && ctx.Err() == nil {
+						logger.Log("tenant", id, "component", "webhook", "during", "RunOnce", "err", err)
+					}
+				}
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}, func(error) {
+			ticker.Stop()
+			cancel()
+		})
+	}
+	{
+		// Periodically count users by password scheme, so the progress of
+		// upgrades from legacy hashes shows on the dashboards.
+		var refreshers []func()
+		for id, counter := range counters {
+			refreshers = append(refreshers, schemeRefresher(counter, schemes.With("tenant", id), log.With(logger, "tenant", id)))
+		}
+		refresh := func() {
+			for _, r := range refreshers {
+				r()
+			}
+		}
+		ticker := time.NewTicker(*schemeInterval)
+		done := make(chan struct{})
+		g.Add(func() error {
+			for {
+				refresh()
+				select {
+				case <-ticker.C:
+				case <-done:
+					return nil
+				}
+			}
+		}, func(error) {
+			ticker.Stop()
+			close(done)
+		})
+	}
+	if *consulAddr != "" {
+		// Announce the servers so that clients can discover them, until we're
+		// interrupted.
+		var registrations []discovery.Registration
+		for _, server := range [][2]string{{"http", *httpAddr}, {"grpc", *grpcAddr}} {
+			r, err := registration(server[0], *advertiseHost, server[1])
+			if err != nil {
+				logger.Log("during", "registration", "err", err)
+				os.Exit(1)
+			}
+			registrations = append(registrations, r)
+		}
+		consul := discovery.NewConsulRegistrar(*consulAddr, *consulToken, registrations, *registryTTL, nil)
+		// Deregister as soon as shutdown begins, while the servers drain.
+		ctx, cancel := context.WithCancel(drainer.Context())
+		g.Add(func() error {
+			return runRegistrar(ctx, consul, *registryTTL/3, log.With(logger, "registrar", "consul"))
+		}, func(error) {
+			cancel()
+		})
+	}
 	{
 		// This function just sits and waits for ctrl-C.
 		cancelInterrupt := make(chan struct{})
@@ -288,6 +783,31 @@ func main() {
 		})
 	}
 	logger.Log("exit", g.Run())
+	// The deferred closes run now, with every server drained, so the spans
+	// of the last requests are finished before the tracers flush them.
+}
+
+// schemeRefresher returns a func that sets the gauge of every password
+// scheme in use. Schemes no longer in use drop to zero rather than keeping
+// their last count.
+func schemeRefresher(counter repo.SchemeCounter, schemes metrics.Gauge, logger log.Logger) func() {
+	var last map[string]int
+	return func() {
+		counts, err := counter.CountPasswordSchemes()
+		if err != nil {
+			logger.Log("during", "CountPasswordSchemes", "err", err)
+			return
+		}
+		for scheme := range last {
+			if _, ok := counts[scheme]; !ok {
+				schemes.With("scheme", scheme).Set(0)
+			}
+		}
+		for scheme, n := range counts {
+			schemes.With("scheme", scheme).Set(float64(n))
+		}
+		last = counts
+	}
 }
 
 func usageFor(fs *flag.FlagSet, short string) func() {
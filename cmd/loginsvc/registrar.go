@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"loginsvc/pkg/discovery"
+)
+
+// deregisterTimeout bounds deregistration on shutdown, so that an
+// unreachable registry doesn't hold the process up.
+const deregisterTimeout = 5 * time.Second
+
+// registrar announces this instance to a service registry. Registrations
+// expire unless heartbeats keep them alive.
+type registrar interface {
+	Register(ctx context.Context) error
+	Heartbeat(ctx context.Context) error
+	Deregister(ctx context.Context) error
+}
+
+// runRegistrar registers r and sends a heartbeat every interval until ctx is
+// done, then deregisters. A failed registration or heartbeat is logged and
+// registered again on the next tick, which also restores registrations the
+// registry lost.
+func runRegistrar(ctx context.Context, r registrar, interval time.Duration, logger log.Logger) error {
+	registered := register(ctx, r, logger)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if registered {
+				err := r.Heartbeat(ctx)
+				if err == nil {
+					continue
+				}
+				logger.Log("during", "Heartbeat", "err", err)
+			}
+			registered = register(ctx, r, logger)
+		case <-ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), deregisterTimeout)
+			defer cancel()
+			if err := r.Deregister(ctx); err != nil {
+				logger.Log("during", "Deregister", "err", err)
+				return nil
+			}
+			logger.Log("event", "deregistered")
+			return nil
+		}
+	}
+}
+
+// register registers r and sends a first heartbeat, since registries start
+// TTL checks off failing.
+func register(ctx context.Context, r registrar, logger log.Logger) bool {
+	if err := r.Register(ctx); err != nil {
+		logger.Log("during", "Register", "err", err)
+		return false
+	}
+	if err := r.Heartbeat(ctx); err != nil {
+		logger.Log("during", "Heartbeat", "err", err)
+		return false
+	}
+	logger.Log("event", "registered")
+	return true
+}
+
+// registration returns the registration of the server of the transport
+// listening on addr, advertised at host, or the hostname if host is empty.
+func registration(transport, host, addr string) (discovery.Registration, error) {
+	_, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return discovery.Registration{}, err
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return discovery.Registration{}, fmt.Errorf("invalid port in %q", addr)
+	}
+	if host == "" {
+		if host, err = os.Hostname(); err != nil {
+			return discovery.Registration{}, err
+		}
+	}
+	return discovery.Registration{
+		ID:      fmt.Sprintf("loginsvc-%s-%s-%d", transport, host, port),
+		Name:    "loginsvc",
+		Tags:    []string{transport},
+		Address: host,
+		Port:    port,
+	}, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+
+	"loginsvc/pkg/invalidation"
+	"loginsvc/pkg/tenant"
+	"loginsvc/repo"
+)
+
+// runRoles implements the "loginsvc roles" subcommands and returns the
+// process exit code.
+func runRoles(args []string) int {
+	a, ok := parseAdminArgs("roles", args, map[string][2]int{
+		"list":     {0, 0},
+		"create":   {1, 1},
+		"delete":   {1, 1},
+		"grant":    {2, 3},
+		"revoke":   {2, 3},
+		"assign":   {2, 2},
+		"unassign": {2, 2},
+		"show":     {1, 1},
+	}, "USAGE\n"+
+		"  loginsvc roles list [flags]\n"+
+		"  loginsvc roles create <role> [flags]\n"+
+		"  loginsvc roles delete <role> [flags]\n"+
+		"  loginsvc roles grant <role> <permission> [resource] [flags]\n"+
+		"  loginsvc roles revoke <role> <permission> [resource] [flags]\n"+
+		"  loginsvc roles assign <user> <role> [flags]\n"+
+		"  loginsvc roles unassign <user> <role> [flags]\n"+
+		"  loginsvc roles show <user> [flags]\n")
+	if !ok {
+		return 1
+	}
+	roles, groups, closeStores, err := adminRepositories(a.store, a.tenant, a.redisAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	defer closeStores()
+
+	pos := a.pos
+	permission := func() repo.Permission {
+		p := repo.Permission{Name: pos[1], Resource: repo.AnyResource}
+		if len(pos) > 2 {
+			p.Resource = pos[2]
+		}
+		return p
+	}
+	switch a.command {
+	case "list":
+		var rs []repo.Role
+		if rs, err = roles.Roles(); err == nil {
+			for _, r := range rs {
+				fmt.Fprintln(os.Stdout, r.Name)
+				for _, p := range r.Permissions {
+					fmt.Fprintf(os.Stdout, "  %s %s\n", p.Name, p.Resource)
+				}
+			}
+		}
+	case "create":
+		err = roles.CreateRole(pos[0])
+	case "delete":
+		err = roles.DeleteRole(pos[0])
+	case "grant":
+		err = roles.Grant(pos[0], permission())
+	case "revoke":
+		err = roles.Revoke(pos[0], permission())
+	case "assign":
+		err = roles.AssignRole(pos[0], pos[1])
+	case "unassign":
+		err = roles.UnassignRole(pos[0], pos[1])
+	case "show":
+		// Effective roles, with those assigned directly marked.
+		var direct, effective []string
+		if direct, err = roles.UserRoles(pos[0]); err != nil {
+			break
+		}
+		if effective, err = groups.EffectiveRoles(pos[0]); err == nil {
+			for _, name := range effective {
+				mark := ""
+				for _, d := range direct {
+					if d == name {
+						mark = " (direct)"
+					}
+				}
+				fmt.Fprintln(os.Stdout, name+mark)
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// adminArgs are the arguments of a "loginsvc roles" or "loginsvc groups"
+// command.
+type adminArgs struct {
+	command   string
+	pos       []string
+	store     string
+	tenant    string
+	redisAddr string
+}
+
+// parseAdminArgs parses the command, its positional arguments, whose
+// minimum and maximum number are given by arity, and the flags after them.
+// It prints usage and returns false if they don't fit.
+func parseAdminArgs(name string, args []string, arity map[string][2]int, usage string) (adminArgs, bool) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return adminArgs{}, false
+	}
+	a := adminArgs{command: args[0]}
+	fs := flag.NewFlagSet("loginsvc "+name+" "+a.command, flag.ExitOnError)
+	fs.StringVar(&a.store, "store", "mysql", "mysql, sqlite")
+	fs.StringVar(&a.tenant, "tenant", tenant.Default, "Tenant whose "+name+" to manage")
+	fs.StringVar(&a.redisAddr, "invalidation-redis-addr", "", "Announce changes to the replicas sharing this Redis server, so they evict cached permissions at once")
+	fs.Usage = usageFor(fs, "loginsvc "+name+" "+a.command+" [args] [flags]")
+
+	// Positional arguments come before the flags.
+	for len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+		a.pos = append(a.pos, args[1])
+		args = args[1:]
+	}
+	fs.Parse(args[1:])
+
+	n, ok := arity[a.command]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "error: unknown %s command %q\n", name, a.command)
+		return adminArgs{}, false
+	}
+	if len(a.pos) < n[0] || len(a.pos) > n[1] {
+		fmt.Fprint(os.Stderr, usage)
+		return adminArgs{}, false
+	}
+	return a, true
+}
+
+// adminRepositories returns the role and group repositories of store,
+// scoped to tenantID. Unless redisAddr is set, changes made through them are not announced, so
+// running instances may use cached permissions for up to their -cache-ttl.
+func adminRepositories(store, tenantID, redisAddr string) (repo.RoleRepository, repo.GroupRepository, func(), error) {
+	k, err := tenantKeyring(tenantID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var r interface {
+		repo.RoleRepository
+		repo.GroupRepository
+	}
+	switch store {
+	case "mysql":
+		r = repo.GetMySQLLoginRepo().ForTenant(tenantID, k)
+	case "sqlite":
+		r = repo.GetSqliteLoginRepository().ForTenant(tenantID, k)
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown store %q", store)
+	}
+	if redisAddr == "" {
+		return r, r, func() {}, nil
+	}
+	bus := invalidation.NewRedisBus(redisAddr, "", log.NewLogfmtLogger(os.Stderr))
+	roles := repo.NewCachingRoleRepository(r, bus, 0)
+	return roles, repo.NewCachingGroupRepository(r, roles), func() {
+		roles.Close()
+		bus.Close()
+	}, nil
+}
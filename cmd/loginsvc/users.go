@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/go-kit/kit/log"
+
+	"loginsvc/config"
+	"loginsvc/pkg/envelope"
+	"loginsvc/pkg/invalidation"
+	"loginsvc/pkg/tenant"
+	"loginsvc/pkg/userio"
+	"loginsvc/repo"
+)
+
+// runUsers implements the "loginsvc users" subcommands and returns the
+// process exit code.
+func runUsers(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "USAGE\n  loginsvc users <import|export> [flags]\n")
+		return 1
+	}
+	switch args[0] {
+	case "import":
+		return runUsersImport(args[1:])
+	case "export":
+		return runUsersExport(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "error: unknown users command %q\n", args[0])
+	return 1
+}
+
+func runUsersImport(args []string) int {
+	fs := flag.NewFlagSet("loginsvc users import", flag.ExitOnError)
+	var (
+		store      = fs.String("store", "mysql", "mysql, sqlite")
+		tenantID   = fs.String("tenant", tenant.Default, "Tenant whose users to import")
+		format     = fs.String("format", "csv", "csv, jsonl")
+		file       = fs.String("file", "-", "Input file, - for stdin")
+		batchSize  = fs.Int("batch-size", userio.DefaultBatchSize, "Users written per transaction")
+		onConflict = fs.String("on-conflict", "fail", "What to do with existing users: fail, skip, overwrite")
+		dryRun     = fs.Bool("dry-run", false, "Validate the input and report problems without writing anything")
+		reportFile = fs.String("report", "-", "Per-row error report (CSV), - for stderr")
+		redisAddr  = fs.String("invalidation-redis-addr", "", "Announce imported users to the replicas sharing this Redis server, so they evict cached users at once")
+	)
+	fs.Usage = usageFor(fs, "loginsvc users import [flags]")
+	fs.Parse(args)
+
+	f, err := userio.ParseFormat(*format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	policy, err := parseConflictPolicy(*onConflict)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	in := os.Stdin
+	if *file != "-" {
+		if in, err = os.Open(*file); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		defer in.Close()
+	}
+	out := os.Stderr
+	if *reportFile != "-" {
+		if out, err = os.Create(*reportFile); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		defer out.Close()
+	}
+	bulk, closeStore, err := bulkRepository(*store, *tenantID, *redisAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	defer closeStore()
+	if *redisAddr == "" && policy == repo.ConflictOverwrite && !*dryRun {
+		fmt.Fprintf(os.Stderr, "warning: without -invalidation-redis-addr, running instances may use overwritten users for up to their -cache-ttl\n")
+	}
+
+	dec, err := userio.NewDecoder(in, f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	report := csv.NewWriter(out)
+	report.Write([]string{"line", "name", "error"})
+	summary, err := userio.Import(bulk, dec, userio.ImportOptions{
+		BatchSize: *batchSize,
+		Policy:    policy,
+		DryRun:    *dryRun,
+	}, func(e *userio.RowError) {
+		report.Write([]string{strconv.Itoa(e.Line), e.Name, e.Err.Error()})
+	})
+	report.Flush()
+
+	fmt.Fprintf(os.Stderr, "dry_run=%t created=%d updated=%d skipped=%d invalid=%d\n",
+		*dryRun, summary.Created, summary.Updated, summary.Skipped, summary.Invalid)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if summary.Invalid > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runUsersExport(args []string) int {
+	fs := flag.NewFlagSet("loginsvc users export", flag.ExitOnError)
+	var (
+		store    = fs.String("store", "mysql", "mysql, sqlite")
+		tenantID = fs.String("tenant", tenant.Default, "Tenant whose users to export")
+		format   = fs.String("format", "csv", "csv, jsonl")
+		file     = fs.String("file", "-", "Output file, - for stdout")
+	)
+	fs.Usage = usageFor(fs, "loginsvc users export [flags]")
+	fs.Parse(args)
+
+	f, err := userio.ParseFormat(*format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	var out io.Writer = os.Stdout
+	if *file != "-" {
+		// Exports hold personal data in clear, so keep them private.
+		fout, err := os.OpenFile(*file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		defer fout.Close()
+		out = fout
+	}
+	bulk, closeStore, err := bulkRepository(*store, *tenantID, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	defer closeStore()
+
+	enc, err := userio.NewEncoder(out, f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	n, err := userio.Export(bulk, enc)
+	fmt.Fprintf(os.Stderr, "exported=%d\n", n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// bulkRepository returns the bulk repository of store, scoped to tenantID.
+// Unless redisAddr is set, users imported through it are not announced, so
+// running instances may use cached users for up to their -cache-ttl.
+func bulkRepository(store, tenantID, redisAddr string) (repo.BulkRepository, func(), error) {
+	k, err := tenantKeyring(tenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var r interface {
+		repo.LoginRepository
+		repo.BulkRepository
+	}
+	switch store {
+	case "mysql":
+		r = repo.GetMySQLLoginRepo().ForTenant(tenantID, k)
+	case "sqlite":
+		r = repo.GetSqliteLoginRepository().ForTenant(tenantID, k)
+	default:
+		return nil, nil, fmt.Errorf("unknown store %q", store)
+	}
+	if redisAddr == "" {
+		return r, func() {}, nil
+	}
+	bus := invalidation.NewRedisBus(redisAddr, "", log.NewLogfmtLogger(os.Stderr))
+	users := repo.NewCachingLoginRepository(r, bus, 0)
+	return repo.NewCachingBulkRepository(r, users), func() {
+		users.Close()
+		bus.Close()
+	}, nil
+}
+
+// tenantKeyring returns the keyring of tenant id, or nil for the one in
+// masterKeyFile. Unless no tenants are configured, id must be one of them,
+// for which the server would take requests.
+func tenantKeyring(id string) (*envelope.Keyring, error) {
+	tenants := config.GetTenants()
+	if len(tenants) == 0 {
+		if id != tenant.Default {
+			return nil, fmt.Errorf("unknown tenant %q", id)
+		}
+		return nil, nil
+	}
+	tc, ok := tenants[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown tenant %q", id)
+	}
+	if tc.MasterKeyFile == "" {
+		return nil, nil
+	}
+	return envelope.LoadKeyring(tc.MasterKeyFile)
+}
+
+func parseConflictPolicy(s string) (repo.ConflictPolicy, error) {
+	switch s {
+	case "fail":
+		return repo.ConflictFail, nil
+	case "skip":
+		return repo.ConflictSkip, nil
+	case "overwrite":
+		return repo.ConflictOverwrite, nil
+	}
+	return 0, fmt.Errorf("unknown conflict policy %q", s)
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -12,9 +13,18 @@ func init() {
 	viper.AddConfigPath("../")
 	viper.AddConfigPath("../../")
 	viper.SetConfigName("config")
+	setRateLimitDefaults()
+	setBreakerDefaults()
+	setAuditDefaults()
+	setWebhookDefaults()
+	setWatchDefaults()
 	err := viper.ReadInConfig()
 	if err != nil {
-		panic(err)
+		// Without a config file every setting falls back to its zero value,
+		// which lets packages that don't need one (and their tests) load.
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			panic(err)
+		}
 	}
 
 	if viper.GetBool(`debug`) {
@@ -29,3 +39,246 @@ func GetSqliteConnectionString() string {
 func GetMysqliteConnectionString() string {
 	return viper.GetString("mysqlConnStr")
 }
+
+// GetMysqlReaderConnectionStrings returns the DSNs of the read replicas of
+// the MySQL primary.
+func GetMysqlReaderConnectionStrings() []string {
+	return viper.GetStringSlice("mysqlReaderConnStrs")
+}
+
+// GetMasterKeyFile returns the path of the key file used to encrypt
+// personal data at rest.
+func GetMasterKeyFile() string {
+	return viper.GetString("masterKeyFile")
+}
+
+// RateLimitRule is a token bucket refilling at Rate tokens per second up to
+// Burst tokens. A zero Rate disables it.
+type RateLimitRule struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimit configures request rate limiting. Buckets are kept per caller IP,
+// per user name and per client ID, under a Global ceiling. MaxKeys bounds the
+// buckets kept in memory; with a RedisAddr they are shared by all replicas
+// through that Redis server instead.
+type RateLimit struct {
+	Global    RateLimitRule
+	PerIP     RateLimitRule
+	PerUser   RateLimitRule
+	PerClient RateLimitRule
+	MaxKeys   int
+	RedisAddr string
+}
+
+func setRateLimitDefaults() {
+	viper.SetDefault("rateLimit.global.rate", 1000)
+	viper.SetDefault("rateLimit.global.burst", 2000)
+	viper.SetDefault("rateLimit.perIP.rate", 20)
+	viper.SetDefault("rateLimit.perIP.burst", 40)
+	viper.SetDefault("rateLimit.perUser.rate", 1)
+	viper.SetDefault("rateLimit.perUser.burst", 10)
+	viper.SetDefault("rateLimit.perClient.rate", 100)
+	viper.SetDefault("rateLimit.perClient.burst", 200)
+	viper.SetDefault("rateLimit.maxKeys", 100000)
+}
+
+// GetRateLimit returns the rate limiting settings.
+func GetRateLimit() RateLimit {
+	var rl RateLimit
+	if err := viper.UnmarshalKey("rateLimit", &rl); err != nil {
+		panic(err)
+	}
+	return rl
+}
+
+// Breaker configures the circuit breaker of an endpoint; see
+// breaker.Settings.
+type Breaker struct {
+	FailureRatio float64
+	MinRequests  uint32
+	Interval     time.Duration
+	Timeout      time.Duration
+	MaxRequests  uint32
+}
+
+func setBreakerDefaults() {
+	viper.SetDefault("breakers.default.failureRatio", 0.5)
+	viper.SetDefault("breakers.default.minRequests", 10)
+	viper.SetDefault("breakers.default.interval", "1m")
+	viper.SetDefault("breakers.default.timeout", "30s")
+	viper.SetDefault("breakers.default.maxRequests", 1)
+}
+
+// GetBreaker returns the settings of the circuit breaker of the endpoint
+// called name: those under breakers.<name>, with missing fields taken from
+// breakers.default.
+func GetBreaker(name string) Breaker {
+	var b Breaker
+	if err := viper.UnmarshalKey("breakers.default", &b); err != nil {
+		panic(err)
+	}
+	if err := viper.UnmarshalKey("breakers."+name, &b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// GetAllowlist returns the client certificate identities, SPIFFE IDs or
+// subjects, allowed to call the method name, listed under
+// allowlists.<name>. It returns nil, allowing everyone, if the method has
+// no list; an empty list allows no one.
+func GetAllowlist(name string) []string {
+	return getAllowlist("allowlists." + name)
+}
+
+// GetTenantAllowlist is GetAllowlist for the tenant id, from
+// tenants.<id>.allowlists.<name>. With tenants configured, the admin
+// methods and WatchEvents only take these.
+func GetTenantAllowlist(id, name string) []string {
+	return getAllowlist("tenants." + id + ".allowlists." + name)
+}
+
+func getAllowlist(key string) []string {
+	if !viper.IsSet(key) {
+		return nil
+	}
+	return append([]string{}, viper.GetStringSlice(key)...)
+}
+
+// Audit configures the audit log of every tenant.
+type Audit struct {
+	// Retention is how long events are kept; zero keeps them forever.
+	Retention time.Duration
+	// PruneInterval is how often events past their retention are pruned.
+	PruneInterval time.Duration
+}
+
+func setAuditDefaults() {
+	viper.SetDefault("audit.pruneInterval", "1h")
+}
+
+// GetAudit returns the audit log settings under audit.
+func GetAudit() Audit {
+	var a Audit
+	if err := viper.UnmarshalKey("audit", &a); err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// Validate returns an error for settings the audit log can't run with.
+func (a Audit) Validate() error {
+	if a.Retention > 0 && a.PruneInterval <= 0 {
+		return fmt.Errorf("audit.pruneInterval must be positive, got %v", a.PruneInterval)
+	}
+	return nil
+}
+
+// Webhooks configures the delivery of identity events to webhooks; see
+// package webhook. Zero fields but Interval take the defaults of
+// webhook.Options.
+type Webhooks struct {
+	// Interval is how often the outbox and the due deliveries are checked.
+	Interval    time.Duration
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	Timeout     time.Duration
+	BatchSize   int
+}
+
+func setWebhookDefaults() {
+	viper.SetDefault("webhooks.interval", "5s")
+}
+
+// GetWebhooks returns the webhook settings under webhooks.
+func GetWebhooks() Webhooks {
+	var w Webhooks
+	if err := viper.UnmarshalKey("webhooks", &w); err != nil {
+		panic(err)
+	}
+	return w
+}
+
+// Validate returns an error for settings the webhooks can't be delivered
+// with.
+func (w Webhooks) Validate() error {
+	if w.Interval <= 0 {
+		return fmt.Errorf("webhooks.interval must be positive, got %v", w.Interval)
+	}
+	return nil
+}
+
+// Watch configures the streams of identity events of WatchEvents. Zero
+// fields but SendTimeout take the defaults of loginservice.WatchOptions.
+type Watch struct {
+	PollInterval time.Duration
+	Heartbeat    time.Duration
+	// SendTimeout is how long a stream waits for its watcher to take a
+	// message before it ends; zero waits for as long as it takes.
+	SendTimeout time.Duration
+}
+
+func setWatchDefaults() {
+	viper.SetDefault("watch.sendTimeout", "30s")
+}
+
+// GetWatch returns the event stream settings under watch.
+func GetWatch() Watch {
+	var w Watch
+	if err := viper.UnmarshalKey("watch", &w); err != nil {
+		panic(err)
+	}
+	return w
+}
+
+// Tenant configures one of the tenants sharing the service; see
+// GetTenants.
+type Tenant struct {
+	// Hosts are the host names serving the tenant alone.
+	Hosts []string
+	// MasterKeyFile is the key file encrypting the personal data of the
+	// tenant, instead of masterKeyFile.
+	MasterKeyFile string
+	// PasswordScheme is the PHC identifier of the scheme hashing the
+	// tenant's new passwords, instead of bcrypt.
+	PasswordScheme string
+	// RateLimit holds the rules of the tenant, with missing ones taken
+	// from rateLimit. Its Global rule caps the tenant as a whole, under
+	// the ceiling of rateLimit.global; MaxKeys and RedisAddr are ignored.
+	RateLimit *RateLimit
+	// SCIMTokens maps the names of the SCIM clients provisioning the
+	// tenant to the hex-encoded SHA-256 digest of their bearer token.
+	SCIMTokens map[string]string
+}
+
+// GetTenants returns the tenants configured under tenants.<id>. Without
+// any, the service has the single tenant tenant.Default.
+func GetTenants() map[string]Tenant {
+	tenants := map[string]Tenant{}
+	for id := range viper.GetStringMap("tenants") {
+		var t Tenant
+		if err := viper.UnmarshalKey("tenants."+id, &t); err != nil {
+			panic(err)
+		}
+		t.RateLimit = nil
+		if viper.IsSet("tenants." + id + ".rateLimit") {
+			rl := GetRateLimit()
+			if err := viper.UnmarshalKey("tenants."+id+".rateLimit", &rl); err != nil {
+				panic(err)
+			}
+			t.RateLimit = &rl
+		}
+		tenants[id] = t
+	}
+	return tenants
+}
+
+// GetSCIMTokens returns the SCIM clients under scimTokens, as in
+// Tenant.SCIMTokens, for the tenant.Default of a service without tenants
+// configured.
+func GetSCIMTokens() map[string]string {
+	return viper.GetStringMapString("scimTokens")
+}
@@ -1,9 +1,179 @@
+-- Every table is partitioned by tenant_id: names are unique per tenant
+-- only, and each query of the repository is scoped to one tenant.
+--
+-- created_at and sessions_revoked_at are Unix seconds; 0 is unknown or
+-- never. The status and created_at indexes serve the admin user lists.
 CREATE TABLE users (
-    `id`   int auto_increment PRIMARY KEY,
-    `name` VARCHAR(50) NOT NULL,
-    `sid`  VARCHAR(50) NOT NULL,
-    CONSTRAINT users_name_uindex UNIQUE (name),
-    CONSTRAINT Users_sid_uindex UNIQUE (sid)
+    `id`                  int auto_increment PRIMARY KEY,
+    `tenant_id`           VARCHAR(50) NOT NULL DEFAULT 'default',
+    `name`                VARCHAR(50) NOT NULL,
+    `sid`                 VARCHAR(50) NOT NULL,
+    `email`               TEXT NOT NULL,
+    `email_bidx`          CHAR(64) NOT NULL DEFAULT '',
+    `phone`               TEXT NOT NULL,
+    `totp_secret`         TEXT NOT NULL,
+    `password_hash`       VARCHAR(255) NOT NULL DEFAULT '',
+    `password_scheme`     VARCHAR(32) NOT NULL DEFAULT 'none',
+    `status`              VARCHAR(16) NOT NULL DEFAULT 'active',
+    `created_at`          BIGINT NOT NULL DEFAULT 0,
+    `sessions_revoked_at` BIGINT NOT NULL DEFAULT 0,
+    CONSTRAINT users_name_uindex UNIQUE (tenant_id, name),
+    CONSTRAINT Users_sid_uindex UNIQUE (tenant_id, sid),
+    INDEX users_email_bidx_index (tenant_id, email_bidx),
+    INDEX users_status_index (tenant_id, status, name),
+    INDEX users_created_index (tenant_id, created_at, name)
 );
 
-INSERT INTO `users` (`name`, `sid`) VALUES ('ed', 'a123456789');
\ No newline at end of file
+-- The blind indexes of every prefix of the email address of a user, for
+-- the admin user lists to search the encrypted addresses by prefix.
+CREATE TABLE user_email_prefixes (
+    `id`          int auto_increment PRIMARY KEY,
+    `tenant_id`   VARCHAR(50) NOT NULL DEFAULT 'default',
+    `user_name`   VARCHAR(50) NOT NULL,
+    `prefix_bidx` CHAR(64) NOT NULL,
+    CONSTRAINT user_email_prefixes_uindex UNIQUE (tenant_id, user_name, prefix_bidx),
+    INDEX user_email_prefixes_bidx_index (tenant_id, prefix_bidx)
+);
+
+-- Roles group permissions, which are granted on a resource or on every
+-- resource ('*'). Users are assigned roles by name.
+CREATE TABLE roles (
+    `id`        int auto_increment PRIMARY KEY,
+    `tenant_id` VARCHAR(50) NOT NULL DEFAULT 'default',
+    `name`      VARCHAR(100) NOT NULL,
+    CONSTRAINT roles_name_uindex UNIQUE (tenant_id, name)
+);
+
+CREATE TABLE permissions (
+    `id`         int auto_increment PRIMARY KEY,
+    `tenant_id`  VARCHAR(50) NOT NULL DEFAULT 'default',
+    `role`       VARCHAR(100) NOT NULL,
+    `permission` VARCHAR(100) NOT NULL,
+    `resource`   VARCHAR(255) NOT NULL DEFAULT '*',
+    CONSTRAINT permissions_uindex UNIQUE (tenant_id, role, permission, resource)
+);
+
+CREATE TABLE user_roles (
+    `id`        int auto_increment PRIMARY KEY,
+    `tenant_id` VARCHAR(50) NOT NULL DEFAULT 'default',
+    `user_name` VARCHAR(50) NOT NULL,
+    `role`      VARCHAR(100) NOT NULL,
+    CONSTRAINT user_roles_uindex UNIQUE (tenant_id, user_name, role),
+    INDEX user_roles_role_index (tenant_id, role)
+);
+
+-- Groups hold users and other groups, and pass their roles on to all of
+-- their members. GROUPS is reserved in MySQL 8, hence usergroups.
+CREATE TABLE usergroups (
+    `id`        int auto_increment PRIMARY KEY,
+    `tenant_id` VARCHAR(50) NOT NULL DEFAULT 'default',
+    `name`      VARCHAR(100) NOT NULL,
+    CONSTRAINT usergroups_name_uindex UNIQUE (tenant_id, name)
+);
+
+CREATE TABLE group_members (
+    `id`         int auto_increment PRIMARY KEY,
+    `tenant_id`  VARCHAR(50) NOT NULL DEFAULT 'default',
+    `group_name` VARCHAR(100) NOT NULL,
+    `user_name`  VARCHAR(50) NOT NULL,
+    CONSTRAINT group_members_uindex UNIQUE (tenant_id, user_name, group_name),
+    INDEX group_members_group_index (tenant_id, group_name)
+);
+
+CREATE TABLE group_parents (
+    `id`         int auto_increment PRIMARY KEY,
+    `tenant_id`  VARCHAR(50) NOT NULL DEFAULT 'default',
+    `group_name` VARCHAR(100) NOT NULL,
+    `parent`     VARCHAR(100) NOT NULL,
+    CONSTRAINT group_parents_uindex UNIQUE (tenant_id, group_name, parent),
+    INDEX group_parents_parent_index (tenant_id, parent)
+);
+
+CREATE TABLE group_roles (
+    `id`         int auto_increment PRIMARY KEY,
+    `tenant_id`  VARCHAR(50) NOT NULL DEFAULT 'default',
+    `group_name` VARCHAR(100) NOT NULL,
+    `role`       VARCHAR(100) NOT NULL,
+    CONSTRAINT group_roles_uindex UNIQUE (tenant_id, group_name, role),
+    INDEX group_roles_role_index (tenant_id, role)
+);
+
+-- The audit log is append-only: each event holds the hash of the one
+-- before it in its tenant, so changed or removed events are detected.
+-- occurred_at is Unix nanoseconds.
+CREATE TABLE audit_log (
+    `id`          bigint auto_increment PRIMARY KEY,
+    `tenant_id`   VARCHAR(50) NOT NULL DEFAULT 'default',
+    `seq`         BIGINT NOT NULL,
+    `occurred_at` BIGINT NOT NULL,
+    `type`        VARCHAR(64) NOT NULL,
+    `actor`       VARCHAR(255) NOT NULL DEFAULT '',
+    `subject`     VARCHAR(50) NOT NULL DEFAULT '',
+    `ip`          VARCHAR(64) NOT NULL DEFAULT '',
+    `outcome`     VARCHAR(16) NOT NULL DEFAULT '',
+    `detail`      TEXT NOT NULL,
+    `prev_hash`   CHAR(64) NOT NULL DEFAULT '',
+    `hash`        CHAR(64) NOT NULL,
+    CONSTRAINT audit_log_seq_uindex UNIQUE (tenant_id, seq),
+    INDEX audit_log_time_index (tenant_id, occurred_at)
+);
+
+-- audit_chain holds the head of the audit log of each tenant: the seq and
+-- hash of its last event. Appending an event locks it until the commit.
+CREATE TABLE audit_chain (
+    `tenant_id` VARCHAR(50) PRIMARY KEY,
+    `seq`       BIGINT NOT NULL,
+    `hash`      CHAR(64) NOT NULL DEFAULT ''
+);
+
+-- The outbox holds the identity events of users, written in the transaction
+-- of the change they record. The webhook dispatcher copies each event into
+-- a delivery per matching subscription, then marks it dispatched. seq
+-- numbers committed events in commit order for WatchEvents, and is NULL
+-- until then.
+-- occurred_at and next_attempt_at are Unix nanoseconds.
+CREATE TABLE outbox (
+    `id`          bigint auto_increment PRIMARY KEY,
+    `tenant_id`   VARCHAR(50) NOT NULL DEFAULT 'default',
+    `type`        VARCHAR(64) NOT NULL,
+    `user_name`   VARCHAR(50) NOT NULL,
+    `occurred_at` BIGINT NOT NULL,
+    `dispatched`  TINYINT NOT NULL DEFAULT 0,
+    `seq`         BIGINT NULL,
+    INDEX outbox_dispatched_index (tenant_id, dispatched, id),
+    INDEX outbox_seq_index (tenant_id, seq)
+);
+
+-- outbox_sequence has a single row, the last seq given to an outbox event.
+-- Numbering events locks it until they commit.
+CREATE TABLE outbox_sequence (
+    `id`  INT PRIMARY KEY,
+    `seq` BIGINT NOT NULL
+);
+
+INSERT INTO outbox_sequence (id, seq) VALUES (1, 0);
+
+-- event_types is a comma-separated list; empty matches every type.
+CREATE TABLE webhook_subscriptions (
+    `id`          bigint auto_increment PRIMARY KEY,
+    `tenant_id`   VARCHAR(50) NOT NULL DEFAULT 'default',
+    `url`         VARCHAR(2048) NOT NULL,
+    `secret`      VARCHAR(512) NOT NULL,
+    `event_types` VARCHAR(255) NOT NULL DEFAULT '',
+    `created_at`  BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE webhook_deliveries (
+    `id`              bigint auto_increment PRIMARY KEY,
+    `tenant_id`       VARCHAR(50) NOT NULL DEFAULT 'default',
+    `subscription_id` BIGINT NOT NULL,
+    `event_id`        BIGINT NOT NULL,
+    `state`           VARCHAR(16) NOT NULL DEFAULT 'pending',
+    `attempts`        INT NOT NULL DEFAULT 0,
+    `next_attempt_at` BIGINT NOT NULL DEFAULT 0,
+    `last_error`      TEXT NOT NULL,
+    CONSTRAINT webhook_deliveries_uindex UNIQUE (subscription_id, event_id),
+    INDEX webhook_deliveries_due_index (tenant_id, state, next_attempt_at)
+);
+
+INSERT INTO `users` (`name`, `sid`, `email`, `phone`, `totp_secret`) VALUES ('ed', 'a123456789', '', '', '');
@@ -0,0 +1,217 @@
+// Package audit keeps a tamper-evident log of the authentication and admin
+// events of a tenant. Every event holds the SHA-256 hash of the event before
+// it, so changing or removing an event breaks the chain from there on,
+// which Verify detects.
+//
+// Events past their retention are pruned from the start of the chain.
+// Prune first records an event naming the last event it removes, which
+// anchors the chain that remains.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strconv"
+	"time"
+
+	"loginsvc/repo"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Event types. Admin events have the type "admin." followed by the name of
+// the AdminService method.
+const (
+	TypeLogin          = "login"
+	TypePasswordChange = "password.change"
+	TypePruned         = "audit.pruned"
+)
+
+// AdminType returns the type of the events of the admin method.
+func AdminType(method string) string {
+	return "admin." + method
+}
+
+// Outcomes of events.
+const (
+	OutcomeSuccess = "success"
+	// OutcomeFailure is a request refused for its credentials or
+	// arguments.
+	OutcomeFailure = "failure"
+	// OutcomeLocked is a login refused for a disabled account.
+	OutcomeLocked = "locked"
+	// OutcomeError is a request that failed for another reason.
+	OutcomeError = "error"
+)
+
+// Delays between the attempts of Record to append an event, doubling from
+// minRetryDelay up to maxRetryDelay.
+const (
+	minRetryDelay = 10 * time.Millisecond
+	maxRetryDelay = time.Second
+)
+
+// Recorder appends events to the audit log of a tenant.
+type Recorder struct {
+	log    repo.AuditLog
+	logger log.Logger
+}
+
+// NewRecorder returns a Recorder appending to l. Failed attempts to append
+// an event are logged to logger, which may be nil.
+func NewRecorder(l repo.AuditLog, logger log.Logger) *Recorder {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &Recorder{log: l, logger: logger}
+}
+
+// Record appends e to the log after the last event, setting its Seq, Time,
+// Prev and Hash, and returns it. It tries again until the event is
+// appended, and only gives up once ctx is done.
+func (r *Recorder) Record(ctx context.Context, e repo.AuditEvent) (repo.AuditEvent, error) {
+	chain := func(head repo.AuditEvent) repo.AuditEvent {
+		e.Seq, e.Prev = head.Seq+1, head.Hash
+		e.Time = time.Unix(0, time.Now().UnixNano()).UTC()
+		e.Hash = Hash(e)
+		return e
+	}
+	for delay := minRetryDelay; ; delay *= 2 {
+		appended, err := r.log.AppendAuditEvent(chain)
+		if err == nil {
+			return appended, nil
+		}
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		r.logger.Log("audit", e.Type, "subject", e.Subject, "during", "AppendAuditEvent", "err", err, "retry_in", delay)
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return repo.AuditEvent{}, err
+		}
+	}
+}
+
+// Prune deletes the events that occurred before before, if any, and
+// returns the Seq of the last one deleted. It gives up once ctx is done.
+func (r *Recorder) Prune(ctx context.Context, before time.Time) (int64, error) {
+	events, err := r.log.AuditEvents(repo.AuditQuery{To: before, Desc: true, Limit: 1})
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+	last := events[0]
+	if _, err := r.Record(ctx, repo.AuditEvent{
+		Type:    TypePruned,
+		Actor:   "loginsvc",
+		Outcome: OutcomeSuccess,
+		Detail:  checkpoint(last),
+	}); err != nil {
+		return 0, err
+	}
+	return last.Seq, r.log.PruneAuditEvents(last.Seq)
+}
+
+// checkpoint is the Detail of the TypePruned event recorded when e and the
+// events before it are pruned.
+func checkpoint(e repo.AuditEvent) string {
+	return "through " + strconv.FormatInt(e.Seq, 10) + " " + e.Hash
+}
+
+// Hash returns the hex SHA-256 hash of every field of e but Hash.
+func Hash(e repo.AuditEvent) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%d\n", e.Seq, e.Time.UnixNano())
+	for _, s := range []string{e.Type, e.Actor, e.Subject, e.IP, e.Outcome, e.Detail, e.Prev} {
+		writeString(h, s)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeString writes s with its length, so no two sequences of strings
+// hash alike.
+func writeString(h hash.Hash, s string) {
+	fmt.Fprintf(h, "%d:%s\n", len(s), s)
+}
+
+// ChainError reports the first event at which the chain breaks.
+type ChainError struct {
+	Seq    int64
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("audit: event %d: %s", e.Seq, e.Reason)
+}
+
+// Report summarizes a verified log.
+type Report struct {
+	Events int
+	// First and Last are the first and last events verified. Keeping the
+	// hash of Last elsewhere detects the log being rewritten from there
+	// on, which the chain alone can't.
+	First, Last repo.AuditEvent
+}
+
+// verifyPageSize is the number of events Verify reads at once.
+const verifyPageSize = 1000
+
+// Verify checks that every event of log hashes to its Hash and follows the
+// one before it, and that the first event either starts the log or is
+// anchored by the checkpoint of the prune that removed the events before
+// it. It returns a *ChainError if the chain is broken.
+func Verify(log repo.AuditLog) (Report, error) {
+	var (
+		report  Report
+		anchors = map[string]bool{}
+		prev    repo.AuditEvent
+	)
+	q := repo.AuditQuery{Limit: verifyPageSize}
+	for {
+		events, err := log.AuditEvents(q)
+		if err != nil {
+			return report, err
+		}
+		for _, e := range events {
+			if Hash(e) != e.Hash {
+				return report, &ChainError{Seq: e.Seq, Reason: "hash mismatch"}
+			}
+			if report.Events > 0 {
+				if e.Seq != prev.Seq+1 {
+					return report, &ChainError{Seq: e.Seq, Reason: fmt.Sprintf("events %d to %d are missing", prev.Seq+1, e.Seq-1)}
+				}
+				if e.Prev != prev.Hash {
+					return report, &ChainError{Seq: e.Seq, Reason: fmt.Sprintf("doesn't follow event %d", prev.Seq)}
+				}
+			} else {
+				report.First = e
+			}
+			if e.Type == TypePruned {
+				anchors[e.Detail] = true
+			}
+			report.Events++
+			prev = e
+		}
+		if len(events) < q.Limit {
+			break
+		}
+		q.AfterSeq = prev.Seq
+	}
+	if report.Events == 0 {
+		return report, nil
+	}
+	report.Last = prev
+	first := report.First
+	switch {
+	case first.Seq == 1 && first.Prev == "":
+	case first.Seq > 1 && anchors[checkpoint(repo.AuditEvent{Seq: first.Seq - 1, Hash: first.Prev})]:
+	default:
+		return report, &ChainError{Seq: first.Seq, Reason: "the events before it were removed without a checkpoint"}
+	}
+	return report, nil
+}
@@ -0,0 +1,132 @@
+package audit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"loginsvc/pkg/audit"
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func record(t *testing.T, rec *audit.Recorder, subjects ...string) {
+	for _, s := range subjects {
+		_, err := rec.Record(context.Background(), repo.AuditEvent{Type: audit.TypeLogin, Actor: "web", Subject: s, Outcome: audit.OutcomeSuccess})
+		assert.NoError(t, err)
+	}
+}
+
+// copyLog returns a log holding the events of log, changed by edit, which
+// drops those it returns false for.
+func copyLog(t *testing.T, log repo.AuditLog, edit func(*repo.AuditEvent) bool) *audit.MemoryLog {
+	events, err := log.AuditEvents(repo.AuditQuery{Limit: 1000})
+	assert.NoError(t, err)
+	c := audit.NewMemoryLog()
+	for _, e := range events {
+		if edit(&e) {
+			_, err := c.AppendAuditEvent(func(repo.AuditEvent) repo.AuditEvent { return e })
+			assert.NoError(t, err)
+		}
+	}
+	return c
+}
+
+func TestRecordChains(t *testing.T) {
+	log := audit.NewMemoryLog()
+	rec := audit.NewRecorder(log, nil)
+	report, err := audit.Verify(log)
+	assert.NoError(t, err)
+	assert.Zero(t, report.Events)
+
+	record(t, rec, "al", "bo", "cy")
+	events, _ := log.AuditEvents(repo.AuditQuery{})
+	assert.Len(t, events, 3)
+	assert.Equal(t, int64(1), events[0].Seq)
+	assert.Empty(t, events[0].Prev)
+	assert.Equal(t, events[0].Hash, events[1].Prev)
+	assert.Equal(t, events[1].Hash, events[2].Prev)
+
+	report, err = audit.Verify(log)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Events)
+	assert.Equal(t, events[2], report.Last)
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	log := audit.NewMemoryLog()
+	record(t, audit.NewRecorder(log, nil), "al", "bo", "cy", "di")
+
+	for name, tc := range map[string]struct {
+		edit func(*repo.AuditEvent) bool
+		seq  int64
+	}{
+		"changed": {func(e *repo.AuditEvent) bool {
+			if e.Seq == 2 {
+				e.Outcome = audit.OutcomeFailure
+			}
+			return true
+		}, 2},
+		"rehashed": {func(e *repo.AuditEvent) bool {
+			if e.Seq == 2 {
+				e.Subject = "eve"
+				e.Hash = audit.Hash(*e)
+			}
+			return true
+		}, 3},
+		"removed":      {func(e *repo.AuditEvent) bool { return e.Seq != 3 }, 4},
+		"head removed": {func(e *repo.AuditEvent) bool { return e.Seq > 1 }, 2},
+		"unchanged":    {func(e *repo.AuditEvent) bool { return true }, 0},
+	} {
+		_, err := audit.Verify(copyLog(t, log, tc.edit))
+		if tc.seq == 0 {
+			assert.NoError(t, err, name)
+			continue
+		}
+		var chain *audit.ChainError
+		if assert.True(t, errors.As(err, &chain), "%s: %v", name, err) {
+			assert.Equal(t, tc.seq, chain.Seq, name)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	log := audit.NewMemoryLog()
+	rec := audit.NewRecorder(log, nil)
+	record(t, rec, "al", "bo")
+	cutoff := time.Now()
+	time.Sleep(time.Millisecond)
+	record(t, rec, "cy")
+
+	seq, err := rec.Prune(context.Background(), cutoff)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), seq)
+	events, _ := log.AuditEvents(repo.AuditQuery{})
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "cy", events[0].Subject)
+		assert.Equal(t, audit.TypePruned, events[1].Type)
+	}
+	report, err := audit.Verify(log)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), report.First.Seq)
+
+	// Nothing left to prune records nothing.
+	seq, err = rec.Prune(context.Background(), cutoff)
+	assert.NoError(t, err)
+	assert.Zero(t, seq)
+	events, _ = log.AuditEvents(repo.AuditQuery{})
+	assert.Len(t, events, 2)
+
+	// Without the checkpoint the first event is no longer anchored.
+	_, err = audit.Verify(copyLog(t, log, func(e *repo.AuditEvent) bool {
+		if e.Type == audit.TypePruned {
+			e.Detail = "through 1 " + e.Prev
+			e.Hash = audit.Hash(*e)
+		}
+		return true
+	}))
+	var chain *audit.ChainError
+	assert.True(t, errors.As(err, &chain), "%v", err)
+}
@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"loginsvc/repo"
+)
+
+// MemoryLog is a repo.AuditLog kept in memory, for tests and tools.
+type MemoryLog struct {
+	mtx    sync.Mutex
+	events []repo.AuditEvent
+}
+
+// NewMemoryLog returns an empty MemoryLog.
+func NewMemoryLog() *MemoryLog {
+	return &MemoryLog{}
+}
+
+func (m *MemoryLog) LastAuditEvent() (repo.AuditEvent, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if len(m.events) == 0 {
+		return repo.AuditEvent{}, sql.ErrNoRows
+	}
+	return m.events[len(m.events)-1], nil
+}
+
+func (m *MemoryLog) AppendAuditEvent(chain func(head repo.AuditEvent) repo.AuditEvent) (repo.AuditEvent, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	var head repo.AuditEvent
+	if n := len(m.events); n > 0 {
+		head = repo.AuditEvent{Seq: m.events[n-1].Seq, Hash: m.events[n-1].Hash}
+	}
+	e := chain(head)
+	if e.Seq <= head.Seq {
+		return repo.AuditEvent{}, fmt.Errorf("audit event %d exists", e.Seq)
+	}
+	m.events = append(m.events, e)
+	return e, nil
+}
+
+func (m *MemoryLog) AuditEvents(q repo.AuditQuery) ([]repo.AuditEvent, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	limit := q.Limit
+	if limit <= 0 {
+		limit = repo.DefaultPageSize
+	}
+	var events []repo.AuditEvent
+	for i := range m.events {
+		e := m.events[i]
+		if q.Desc {
+			e = m.events[len(m.events)-1-i]
+		}
+		if len(events) == limit {
+			break
+		}
+		if matches(q, e) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func matches(q repo.AuditQuery, e repo.AuditEvent) bool {
+	switch {
+	case !q.From.IsZero() && e.Time.Before(q.From),
+		!q.To.IsZero() && !e.Time.Before(q.To),
+		q.Type != "" && e.Type != q.Type,
+		q.Actor != "" && e.Actor != q.Actor,
+		q.Subject != "" && e.Subject != q.Subject,
+		q.AfterSeq > 0 && !q.Desc && e.Seq <= q.AfterSeq,
+		q.AfterSeq > 0 && q.Desc && e.Seq >= q.AfterSeq:
+		return false
+	}
+	return true
+}
+
+func (m *MemoryLog) PruneAuditEvents(seq int64) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	i := 0
+	for i < len(m.events) && m.events[i].Seq <= seq {
+		i++
+	}
+	m.events = m.events[i:]
+	return nil
+}
@@ -0,0 +1,242 @@
+// Package breaker manages the circuit breakers wrapped around endpoints:
+// their settings, their state metrics and their operation at runtime.
+package breaker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/sony/gobreaker"
+)
+
+// Settings configures a breaker. While closed, the breaker opens once at
+// least MinRequests requests completed in the current Interval and the
+// ratio of them that failed reaches FailureRatio. It stays open for
+// Timeout, then lets MaxRequests requests through half-open: it closes if
+// they all succeed and opens again otherwise.
+type Settings struct {
+	FailureRatio float64
+	MinRequests  uint32
+	Interval     time.Duration
+	Timeout      time.Duration
+	MaxRequests  uint32
+}
+
+// DefaultSettings are used for breakers without settings of their own.
+var DefaultSettings = Settings{
+	FailureRatio: 0.5,
+	MinRequests:  10,
+	Interval:     time.Minute,
+	Timeout:      30 * time.Second,
+	MaxRequests:  1,
+}
+
+// Breaker is a named circuit breaker that can be reset.
+type Breaker struct {
+	name     string
+	settings Settings
+	registry *Registry
+
+	mtx sync.RWMutex
+	cb  *gobreaker.TwoStepCircuitBreaker
+
+	// Outcomes since the breaker was created or reset, updated atomically.
+	successes, failures, rejections, canceled uint64
+}
+
+// Counts are the outcomes of requests through a breaker since it was
+// created or last reset. Rejections are requests failed fast while open.
+// Canceled are requests the caller gave up on, such as the copies of a
+// hedged request that lost, which say nothing about the backend.
+type Counts struct {
+	Successes  uint64
+	Failures   uint64
+	Rejections uint64
+	Canceled   uint64
+}
+
+func (b *Breaker) current() *gobreaker.TwoStepCircuitBreaker {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	return b.cb
+}
+
+// Name returns the name of the breaker.
+func (b *Breaker) Name() string { return b.name }
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() gobreaker.State { return b.current().State() }
+
+// Counts returns the outcomes of requests since the breaker was created or
+// last reset.
+func (b *Breaker) Counts() Counts {
+	return Counts{
+		Successes:  atomic.LoadUint64(&b.successes),
+		Failures:   atomic.LoadUint64(&b.failures),
+		Rejections: atomic.LoadUint64(&b.rejections),
+		Canceled:   atomic.LoadUint64(&b.canceled),
+	}
+}
+
+// Settings returns the settings of the breaker.
+func (b *Breaker) Settings() Settings { return b.settings }
+
+// Reset closes the breaker and clears its counts.
+func (b *Breaker) Reset() {
+	from := b.State()
+	b.mtx.Lock()
+	b.cb = b.registry.newCircuitBreaker(b.name, b.settings)
+	atomic.StoreUint64(&b.successes, 0)
+	atomic.StoreUint64(&b.failures, 0)
+	atomic.StoreUint64(&b.rejections, 0)
+	atomic.StoreUint64(&b.canceled, 0)
+	b.mtx.Unlock()
+	b.registry.logger.Log("breaker", b.name, "event", "reset", "from", from)
+	b.registry.state.With("name", b.name).Set(float64(gobreaker.StateClosed))
+}
+
+// Middleware returns an endpoint middleware that fails fast with
+// gobreaker.ErrOpenState while the breaker is open, like
+// circuitbreaker.Gobreaker. Endpoints usually return the errors of their
+// service inside responses that implement endpoint.Failer, so such a
+// response counts as a failure too when failed reports true for its error.
+// A nil failed counts only the errors returned by the endpoint.
+//
+// Calls that fail once their context is canceled say nothing about the
+// backend: they count as neither a success nor a failure while the breaker
+// is closed, and Counts reports them apart. A canceled half-open probe
+// opens the breaker again, as it can't show that the backend recovered and
+// gobreaker has no other way to free its slot.
+func (b *Breaker) Middleware(failed func(error) bool) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			cb := b.current()
+			done, err := cb.Allow()
+			if err != nil {
+				atomic.AddUint64(&b.rejections, 1)
+				return nil, err
+			}
+			halfOpen := cb.State() == gobreaker.StateHalfOpen
+			defer func() {
+				if e := recover(); e != nil {
+					atomic.AddUint64(&b.failures, 1)
+					done(false)
+					panic(e)
+				}
+			}()
+
+			response, err = next(ctx, request)
+			switch f, ok := response.(endpoint.Failer); {
+			case err != nil && ctx.Err() == context.Canceled:
+				atomic.AddUint64(&b.canceled, 1)
+				if halfOpen {
+					done(false)
+				}
+			case err != nil, ok && failed != nil && f.Failed() != nil && failed(f.Failed()):
+				atomic.AddUint64(&b.failures, 1)
+				done(false)
+			default:
+				atomic.AddUint64(&b.successes, 1)
+				done(true)
+			}
+			return response, err
+		}
+	}
+}
+
+// Registry creates breakers by name and keeps track of them.
+type Registry struct {
+	lookup func(name string) Settings
+	logger log.Logger
+	state  metrics.Gauge
+
+	mtx      sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewRegistry returns a registry whose breakers take their settings from
+// lookup, or DefaultSettings if lookup is nil. State changes are logged to
+// logger and exported through state, labelled by "name", as the value of
+// gobreaker.State: 0 closed, 1 half-open, 2 open. logger and state may be
+// nil.
+func NewRegistry(lookup func(name string) Settings, logger log.Logger, state metrics.Gauge) *Registry {
+	if lookup == nil {
+		lookup = func(string) Settings { return DefaultSettings }
+	}
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	if state == nil {
+		state = discard.NewGauge()
+	}
+	return &Registry{
+		lookup:   lookup,
+		logger:   logger,
+		state:    state,
+		breakers: map[string]*Breaker{},
+	}
+}
+
+// Breaker returns the breaker called name, creating it on first use.
+func (r *Registry) Breaker(name string) *Breaker {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if b, ok := r.breakers[name]; ok {
+		return b
+	}
+	s := r.lookup(name)
+	b := &Breaker{name: name, settings: s, registry: r, cb: r.newCircuitBreaker(name, s)}
+	r.breakers[name] = b
+	r.state.With("name", name).Set(float64(gobreaker.StateClosed))
+	return b
+}
+
+// Middleware is shorthand for r.Breaker(name).Middleware(failed).
+func (r *Registry) Middleware(name string, failed func(error) bool) endpoint.Middleware {
+	return r.Breaker(name).Middleware(failed)
+}
+
+// Lookup returns the breaker called name, if it was created.
+func (r *Registry) Lookup(name string) (*Breaker, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	b, ok := r.breakers[name]
+	return b, ok
+}
+
+// Breakers returns every breaker created so far.
+func (r *Registry) Breakers() []*Breaker {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	bs := make([]*Breaker, 0, len(r.breakers))
+	for _, b := range r.breakers {
+		bs = append(bs, b)
+	}
+	return bs
+}
+
+func (r *Registry) newCircuitBreaker(name string, s Settings) *gobreaker.TwoStepCircuitBreaker {
+	return gobreaker.NewTwoStepCircuitBreaker(gobreaker.Settings{
+		Name:        name,
+		MaxRequests: s.MaxRequests,
+		Interval:    s.Interval,
+		Timeout:     s.Timeout,
+		// Canceled calls are requests with neither outcome, so only
+		// the requests that completed make up the failure ratio.
+		ReadyToTrip: func(c gobreaker.Counts) bool {
+			completed := c.TotalSuccesses + c.TotalFailures
+			return completed >= s.MinRequests && completed > 0 &&
+				float64(c.TotalFailures)/float64(completed) >= s.FailureRatio
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			r.logger.Log("breaker", name, "event", "state_change", "from", from, "to", to)
+			r.state.With("name", name).Set(float64(to))
+		},
+	})
+}
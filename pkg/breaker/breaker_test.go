@@ -0,0 +1,142 @@
+package breaker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"loginsvc/pkg/breaker"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+)
+
+// stateGauge records the last value set for each breaker name.
+type stateGauge struct {
+	values map[string]float64
+	name   string
+}
+
+func (g *stateGauge) With(labelValues ...string) metrics.Gauge {
+	return &stateGauge{values: g.values, name: labelValues[1]}
+}
+
+func (g *stateGauge) Set(value float64) { g.values[g.name] = value }
+
+func (g *stateGauge) Add(delta float64) { g.values[g.name] += delta }
+
+var errBackend = errors.New("backend down")
+
+func newRegistry() (*breaker.Registry, map[string]float64) {
+	states := map[string]float64{}
+	r := breaker.NewRegistry(func(name string) breaker.Settings {
+		return breaker.Settings{FailureRatio: 0.5, MinRequests: 4, Interval: time.Minute, Timeout: time.Hour, MaxRequests: 1}
+	}, nil, &stateGauge{values: states})
+	return r, states
+}
+
+func call(r *breaker.Registry, name string, fail bool) error {
+	_, err := r.Middleware(name, nil)(func(context.Context, interface{}) (interface{}, error) {
+		if fail {
+			return nil, errBackend
+		}
+		return "ok", nil
+	})(context.Background(), nil)
+	return err
+}
+
+func TestBreakerTripsOnFailureRatio(t *testing.T) {
+	r, states := newRegistry()
+	assert.NoError(t, call(r, "Name", false))
+	assert.NoError(t, call(r, "Name", false))
+	assert.Equal(t, errBackend, call(r, "Name", true))
+	assert.Equal(t, float64(gobreaker.StateClosed), states["Name"])
+
+	// Two failures out of four requests reach the ratio.
+	assert.Equal(t, errBackend, call(r, "Name", true))
+	assert.Equal(t, gobreaker.StateOpen, r.Breaker("Name").State())
+	assert.Equal(t, float64(gobreaker.StateOpen), states["Name"])
+	assert.Equal(t, gobreaker.ErrOpenState, call(r, "Name", false))
+	assert.Equal(t, breaker.Counts{Successes: 2, Failures: 2, Rejections: 1}, r.Breaker("Name").Counts())
+
+	// Breakers are independent.
+	assert.NoError(t, call(r, "Authenticate", false))
+}
+
+func TestBreakerIgnoresCanceledCalls(t *testing.T) {
+	r := breaker.NewRegistry(func(name string) breaker.Settings {
+		return breaker.Settings{FailureRatio: 0.5, MinRequests: 4, Interval: time.Minute, Timeout: 10 * time.Millisecond, MaxRequests: 1}
+	}, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	canceled := func() error {
+		_, err := r.Middleware("Name", nil)(func(ctx context.Context, _ interface{}) (interface{}, error) {
+			return nil, ctx.Err()
+		})(ctx, nil)
+		return err
+	}
+
+	// Canceled calls neither close nor hold open a failing breaker.
+	call(r, "Name", true)
+	call(r, "Name", true)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, context.Canceled, canceled())
+	}
+	call(r, "Name", true)
+	assert.Equal(t, gobreaker.StateClosed, r.Breaker("Name").State())
+	call(r, "Name", true)
+	assert.Equal(t, gobreaker.StateOpen, r.Breaker("Name").State())
+	assert.Equal(t, breaker.Counts{Failures: 4, Canceled: 10}, r.Breaker("Name").Counts())
+
+	// A canceled half-open probe doesn't close it either.
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, gobreaker.StateHalfOpen, r.Breaker("Name").State())
+	assert.Equal(t, context.Canceled, canceled())
+	assert.Equal(t, gobreaker.StateOpen, r.Breaker("Name").State())
+}
+
+func TestDebugHandler(t *testing.T) {
+	r, states := newRegistry()
+	for i := 0; i < 4; i++ {
+		call(r, "Name", true)
+	}
+	call(r, "Authenticate", false)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "Authenticate", list[0]["name"])
+		assert.Equal(t, "closed", list[0]["state"])
+		assert.Equal(t, "Name", list[1]["name"])
+		assert.Equal(t, "open", list[1]["state"])
+		assert.Equal(t, float64(4), list[1]["failures"])
+	}
+
+	resp, err = http.Post(srv.URL+"?name=Name", "", strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, gobreaker.StateClosed, r.Breaker("Name").State())
+	assert.Equal(t, float64(gobreaker.StateClosed), states["Name"])
+	assert.Equal(t, breaker.Counts{}, r.Breaker("Name").Counts())
+	assert.NoError(t, call(r, "Name", false))
+
+	resp, _ = http.Post(srv.URL+"?name=Unknown", "", strings.NewReader(""))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,70 @@
+package breaker
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// breakerStatus is how the debug handler reports a breaker.
+type breakerStatus struct {
+	Name         string  `json:"name"`
+	State        string  `json:"state"`
+	Successes    uint64  `json:"successes"`
+	Failures     uint64  `json:"failures"`
+	Rejections   uint64  `json:"rejections"`
+	Canceled     uint64  `json:"canceled"`
+	FailureRatio float64 `json:"failure_ratio"`
+	MinRequests  uint32  `json:"min_requests"`
+	Interval     string  `json:"interval"`
+	Timeout      string  `json:"timeout"`
+	MaxRequests  uint32  `json:"max_requests"`
+}
+
+func status(b *Breaker) breakerStatus {
+	c, s := b.Counts(), b.Settings()
+	return breakerStatus{
+		Name:         b.Name(),
+		State:        b.State().String(),
+		Successes:    c.Successes,
+		Failures:     c.Failures,
+		Rejections:   c.Rejections,
+		Canceled:     c.Canceled,
+		FailureRatio: s.FailureRatio,
+		MinRequests:  s.MinRequests,
+		Interval:     s.Interval.String(),
+		Timeout:      s.Timeout.String(),
+		MaxRequests:  s.MaxRequests,
+	}
+}
+
+// ServeHTTP is a debug handler. GET lists every breaker with its state,
+// counts and settings; POST with a name parameter resets that breaker and
+// reports it.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var v interface{}
+	switch req.Method {
+	case http.MethodGet:
+		bs := r.Breakers()
+		sort.Slice(bs, func(i, j int) bool { return bs[i].Name() < bs[j].Name() })
+		list := make([]breakerStatus, len(bs))
+		for i, b := range bs {
+			list[i] = status(b)
+		}
+		v = list
+	case http.MethodPost:
+		b, ok := r.Lookup(req.FormValue("name"))
+		if !ok {
+			http.Error(w, "unknown breaker", http.StatusNotFound)
+			return
+		}
+		b.Reset()
+		v = status(b)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,80 @@
+// Package caller identifies the services calling loginsvc by the client
+// certificates they present over mutual TLS, and decides whether they may
+// call a method.
+package caller
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+)
+
+// Identity is what a client certificate says about the caller.
+type Identity struct {
+	// SPIFFEID is the spiffe:// URI SAN of the certificate, if any.
+	SPIFFEID string
+	// Subject is the distinguished name of the certificate subject, as in
+	// "CN=billing,O=Example".
+	Subject string
+}
+
+// String returns the SPIFFE ID of the caller, or its subject without one.
+func (id Identity) String() string {
+	if id.SPIFFEID != "" {
+		return id.SPIFFEID
+	}
+	return id.Subject
+}
+
+// FromTLS returns the identity in the leaf client certificate of a
+// connection. Certificates that weren't verified against the client CA
+// bundle don't identify anyone.
+func FromTLS(state *tls.ConnectionState) (Identity, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return Identity{}, false
+	}
+	cert := state.VerifiedChains[0][0]
+	id := Identity{Subject: cert.Subject.String()}
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			id.SPIFFEID = u.String()
+			break
+		}
+	}
+	return id, true
+}
+
+type identityKey struct{}
+
+// NewContext returns a context for a request from the caller id.
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the identity stored by NewContext, if any.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// Allowlist lists the callers allowed to call a method. Each entry is a
+// SPIFFE ID or a subject, compared exactly, or a SPIFFE ID ending in "/*",
+// which allows every ID under that path.
+type Allowlist []string
+
+// Allows reports whether the list has an entry for id.
+func (l Allowlist) Allows(id Identity) bool {
+	for _, entry := range l {
+		switch {
+		case id.SPIFFEID != "" && strings.HasSuffix(entry, "/*"):
+			if strings.HasPrefix(id.SPIFFEID, strings.TrimSuffix(entry, "*")) {
+				return true
+			}
+		case id.SPIFFEID != "" && entry == id.SPIFFEID:
+			return true
+		case id.Subject != "" && entry == id.Subject:
+			return true
+		}
+	}
+	return false
+}
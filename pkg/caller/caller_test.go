@@ -0,0 +1,68 @@
+package caller_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"loginsvc/pkg/caller"
+)
+
+func TestFromTLS(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/ns/prod/sa/web")
+	other, _ := url.Parse("https://example.org/web")
+	cert := &x509.Certificate{
+		Subject: pkix.Name{CommonName: "web", Organization: []string{"Example"}},
+		URIs:    []*url.URL{other, spiffe},
+	}
+
+	id, ok := caller.FromTLS(&tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}})
+	assert.True(t, ok)
+	assert.Equal(t, caller.Identity{SPIFFEID: "spiffe://example.org/ns/prod/sa/web", Subject: "CN=web,O=Example"}, id)
+	assert.Equal(t, "spiffe://example.org/ns/prod/sa/web", id.String())
+
+	cert.URIs = nil
+	id, ok = caller.FromTLS(&tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}})
+	assert.True(t, ok)
+	assert.Equal(t, "CN=web,O=Example", id.String())
+
+	// Unverified certificates don't count.
+	_, ok = caller.FromTLS(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+	assert.False(t, ok)
+	_, ok = caller.FromTLS(nil)
+	assert.False(t, ok)
+}
+
+func TestContext(t *testing.T) {
+	_, ok := caller.FromContext(context.Background())
+	assert.False(t, ok)
+	want := caller.Identity{Subject: "CN=web"}
+	got, ok := caller.FromContext(caller.NewContext(context.Background(), want))
+	assert.True(t, ok)
+	assert.Equal(t, want, got)
+}
+
+func TestAllowlist(t *testing.T) {
+	l := caller.Allowlist{"spiffe://example.org/ns/prod/sa/web", "spiffe://example.org/ns/prod/sa/api/*", "CN=batch,O=Example"}
+	for _, tc := range []struct {
+		id   caller.Identity
+		want bool
+	}{
+		{caller.Identity{SPIFFEID: "spiffe://example.org/ns/prod/sa/web"}, true},
+		{caller.Identity{SPIFFEID: "spiffe://example.org/ns/prod/sa/web2"}, false},
+		{caller.Identity{SPIFFEID: "spiffe://example.org/ns/prod/sa/api/v1"}, true},
+		{caller.Identity{SPIFFEID: "spiffe://example.org/ns/prod/sa/api"}, false},
+		{caller.Identity{SPIFFEID: "spiffe://example.org/ns/prod/sa/apix/v1"}, false},
+		{caller.Identity{Subject: "CN=batch,O=Example"}, true},
+		{caller.Identity{Subject: "CN=batch"}, false},
+		{caller.Identity{}, false},
+	} {
+		assert.Equal(t, tc.want, l.Allows(tc.id), tc.id.String())
+	}
+	assert.False(t, caller.Allowlist{}.Allows(caller.Identity{Subject: "CN=batch,O=Example"}))
+}
@@ -0,0 +1,148 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Registration is a service instance announced to a registry.
+type Registration struct {
+	ID      string
+	Name    string
+	Tags    []string
+	Address string
+	Port    int
+}
+
+// ConsulRegistrar announces service instances to a Consul agent over its
+// HTTP API, each with a TTL check that Heartbeat keeps passing. Consul marks
+// an instance critical when its TTL runs out without a heartbeat and removes
+// it after DeregisterAfter, so instances that die without deregistering
+// don't linger.
+type ConsulRegistrar struct {
+	addr          string
+	token         string
+	registrations []Registration
+	ttl           time.Duration
+	client        *http.Client
+
+	// DeregisterAfter is how long an instance may stay critical before
+	// Consul deregisters it. It defaults to a minute.
+	DeregisterAfter time.Duration
+}
+
+// NewConsulRegistrar returns a ConsulRegistrar for the agent at addr, as in
+// http://localhost:8500, sending token if it isn't empty. A nil client
+// selects http.DefaultClient.
+func NewConsulRegistrar(addr, token string, registrations []Registration, ttl time.Duration, client *http.Client) *ConsulRegistrar {
+	if !strings.HasPrefix(addr, "http") {
+		addr = "http://" + addr
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &ConsulRegistrar{
+		addr:            strings.TrimSuffix(addr, "/"),
+		token:           token,
+		registrations:   registrations,
+		ttl:             ttl,
+		client:          client,
+		DeregisterAfter: time.Minute,
+	}
+}
+
+type consulService struct {
+	ID      string
+	Name    string
+	Tags    []string `json:",omitempty"`
+	Address string
+	Port    int
+	Check   consulCheck
+}
+
+type consulCheck struct {
+	CheckID                        string
+	TTL                            string
+	DeregisterCriticalServiceAfter string
+}
+
+// Register registers every instance with the agent. Registering again
+// updates the instances in place.
+func (c *ConsulRegistrar) Register(ctx context.Context) error {
+	for _, r := range c.registrations {
+		body, err := json.Marshal(consulService{
+			ID:      r.ID,
+			Name:    r.Name,
+			Tags:    r.Tags,
+			Address: r.Address,
+			Port:    r.Port,
+			Check: consulCheck{
+				CheckID:                        checkID(r),
+				TTL:                            c.ttl.String(),
+				DeregisterCriticalServiceAfter: c.DeregisterAfter.String(),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if err := c.put(ctx, "/v1/agent/service/register", body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Heartbeat passes the TTL check of every instance. It fails if the agent
+// lost them, after a restart for instance; register them again then.
+func (c *ConsulRegistrar) Heartbeat(ctx context.Context) error {
+	for _, r := range c.registrations {
+		if err := c.put(ctx, "/v1/agent/check/pass/"+url.PathEscape(checkID(r)), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deregister removes every instance from the agent. It tries them all and
+// returns the first error.
+func (c *ConsulRegistrar) Deregister(ctx context.Context) error {
+	var first error
+	for _, r := range c.registrations {
+		if err := c.put(ctx, "/v1/agent/service/deregister/"+url.PathEscape(r.ID), nil); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func checkID(r Registration) string { return "service:" + r.ID }
+
+func (c *ConsulRegistrar) put(ctx context.Context, path string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("consul: PUT %s: %s: %s", path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package discovery_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"loginsvc/pkg/discovery"
+)
+
+// fakeConsul implements the parts of the Consul agent API the registrar
+// uses, keeping services and how often their check passed.
+type fakeConsul struct {
+	mtx      sync.Mutex
+	services map[string]map[string]interface{}
+	passes   map[string]int
+	tokens   []string
+}
+
+func newFakeConsul(t *testing.T) (*fakeConsul, string) {
+	f := &fakeConsul{services: map[string]map[string]interface{}{}, passes: map[string]int{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv.URL
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f.tokens = append(f.tokens, r.Header.Get("X-Consul-Token"))
+	switch path := r.URL.Path; {
+	case path == "/v1/agent/service/register":
+		var s map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.services[s["ID"].(string)] = s
+	case strings.HasPrefix(path, "/v1/agent/check/pass/service:"):
+		id := strings.TrimPrefix(path, "/v1/agent/check/pass/service:")
+		if _, ok := f.services[id]; !ok {
+			http.Error(w, `CheckID "service:`+id+`" does not have associated TTL`, http.StatusInternalServerError)
+			return
+		}
+		f.passes[id]++
+	case strings.HasPrefix(path, "/v1/agent/service/deregister/"):
+		delete(f.services, strings.TrimPrefix(path, "/v1/agent/service/deregister/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// restart forgets every registration, as an agent restarted without
+// persistence does.
+func (f *fakeConsul) restart() {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.services = map[string]map[string]interface{}{}
+}
+
+func (f *fakeConsul) registered() map[string]map[string]interface{} {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	services := map[string]map[string]interface{}{}
+	for id, s := range f.services {
+		services[id] = s
+	}
+	return services
+}
+
+var registrations = []discovery.Registration{
+	{ID: "loginsvc-http-10.0.0.1-8081", Name: "loginsvc", Tags: []string{"http"}, Address: "10.0.0.1", Port: 8081},
+	{ID: "loginsvc-grpc-10.0.0.1-8082", Name: "loginsvc", Tags: []string{"grpc"}, Address: "10.0.0.1", Port: 8082},
+}
+
+func TestConsulRegistrar(t *testing.T) {
+	f, addr := newFakeConsul(t)
+	r := discovery.NewConsulRegistrar(addr, "secret", registrations, 10*time.Second, nil)
+	ctx := context.Background()
+
+	assert.NoError(t, r.Register(ctx))
+	services := f.registered()
+	if assert.Len(t, services, 2) {
+		s := services["loginsvc-grpc-10.0.0.1-8082"]
+		assert.Equal(t, "loginsvc", s["Name"])
+		assert.Equal(t, []interface{}{"grpc"}, s["Tags"])
+		assert.Equal(t, "10.0.0.1", s["Address"])
+		assert.Equal(t, float64(8082), s["Port"])
+		assert.Equal(t, map[string]interface{}{
+			"CheckID":                        "service:loginsvc-grpc-10.0.0.1-8082",
+			"TTL":                            "10s",
+			"DeregisterCriticalServiceAfter": "1m0s",
+		}, s["Check"])
+	}
+
+	assert.NoError(t, r.Heartbeat(ctx))
+	assert.Equal(t, map[string]int{"loginsvc-http-10.0.0.1-8081": 1, "loginsvc-grpc-10.0.0.1-8082": 1}, f.passes)
+
+	assert.NoError(t, r.Deregister(ctx))
+	assert.Empty(t, f.registered())
+	for _, token := range f.tokens {
+		assert.Equal(t, "secret", token)
+	}
+}
+
+func TestConsulRegistrarHeartbeatAfterAgentRestart(t *testing.T) {
+	f, addr := newFakeConsul(t)
+	r := discovery.NewConsulRegistrar(addr, "", registrations, 10*time.Second, nil)
+	ctx := context.Background()
+
+	assert.NoError(t, r.Register(ctx))
+	f.restart()
+	err := r.Heartbeat(ctx)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "does not have associated TTL")
+	}
+	assert.NoError(t, r.Register(ctx))
+	assert.NoError(t, r.Heartbeat(ctx))
+}
+
+func TestConsulRegistrarUnreachable(t *testing.T) {
+	r := discovery.NewConsulRegistrar("127.0.0.1:1", "", registrations, 10*time.Second, nil)
+	assert.Error(t, r.Register(context.Background()))
+}
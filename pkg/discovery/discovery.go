@@ -0,0 +1,81 @@
+// Package discovery provides go-kit sd.Instancers that find the instances of
+// loginsvc in a file or in DNS, and one that ejects unhealthy instances from
+// another Instancer. Instances are host:port strings. ConsulRegistrar
+// announces instances to Consul, where DNS can find them.
+package discovery
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/go-kit/kit/sd"
+)
+
+// publisher keeps the latest event of an Instancer and sends it to the
+// registered channels whenever it changes.
+type publisher struct {
+	mtx   sync.Mutex
+	state sd.Event
+	chans map[chan<- sd.Event]struct{}
+}
+
+func newPublisher() *publisher {
+	return &publisher{chans: map[chan<- sd.Event]struct{}{}}
+}
+
+// update publishes event. Like go-kit's own Instancers, an event with an
+// error and no instances keeps the instances last found, so that a failed
+// lookup doesn't take every instance away.
+func (p *publisher) update(event sd.Event) {
+	if event.Err != nil && len(event.Instances) == 0 {
+		event.Instances = p.instances()
+	} else {
+		event.Instances = append([]string(nil), event.Instances...)
+		sort.Strings(event.Instances)
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if equal(p.state, event) {
+		return
+	}
+	p.state = event
+	for ch := range p.chans {
+		ch <- event
+	}
+}
+
+func (p *publisher) instances() []string {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.state.Instances
+}
+
+// Register implements sd.Instancer.
+func (p *publisher) Register(ch chan<- sd.Event) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.chans[ch] = struct{}{}
+	ch <- p.state
+}
+
+// Deregister implements sd.Instancer.
+func (p *publisher) Deregister(ch chan<- sd.Event) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	delete(p.chans, ch)
+}
+
+func equal(a, b sd.Event) bool {
+	if (a.Err == nil) != (b.Err == nil) || a.Err != nil && a.Err.Error() != b.Err.Error() {
+		return false
+	}
+	if len(a.Instances) != len(b.Instances) {
+		return false
+	}
+	for i := range a.Instances {
+		if a.Instances[i] != b.Instances[i] {
+			return false
+		}
+	}
+	return true
+}
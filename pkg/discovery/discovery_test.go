@@ -0,0 +1,165 @@
+package discovery_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	"github.com/stretchr/testify/assert"
+
+	"loginsvc/pkg/discovery"
+)
+
+// fakeResolver answers from maps that tests change under its lock.
+type fakeResolver struct {
+	mtx   sync.Mutex
+	srv   map[string][]*net.SRV
+	hosts map[string][]string
+	err   error
+}
+
+func (r *fakeResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return name, r.srv[name], r.err
+}
+
+func (r *fakeResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.hosts[host], r.err
+}
+
+func (r *fakeResolver) set(f func()) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	f()
+}
+
+// watch registers with instancer and returns a function that waits for the
+// next event published.
+func watch(t *testing.T, instancer sd.Instancer) func() sd.Event {
+	events := make(chan sd.Event, 16)
+	instancer.Register(events)
+	t.Cleanup(func() { instancer.Deregister(events) })
+	return func() sd.Event {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+			return sd.Event{}
+		}
+	}
+}
+
+func TestDNSInstancerSRV(t *testing.T) {
+	r := &fakeResolver{srv: map[string][]*net.SRV{
+		"_grpc._tcp.login.example.com": {
+			{Target: "b.example.com.", Port: 8082},
+			{Target: "a.example.com.", Port: 8082},
+		},
+	}}
+	d := discovery.NewDNSInstancer("_grpc._tcp.login.example.com", 10*time.Millisecond, r, log.NewNopLogger())
+	defer d.Stop()
+	next := watch(t, d)
+	assert.Equal(t, []string{"a.example.com:8082", "b.example.com:8082"}, next().Instances)
+
+	r.set(func() { r.srv["_grpc._tcp.login.example.com"] = r.srv["_grpc._tcp.login.example.com"][:1] })
+	assert.Equal(t, []string{"b.example.com:8082"}, next().Instances)
+}
+
+func TestDNSInstancerA(t *testing.T) {
+	r := &fakeResolver{hosts: map[string][]string{"login.example.com": {"10.0.0.2", "10.0.0.1"}}}
+	d := discovery.NewDNSInstancer("login.example.com:8081", 10*time.Millisecond, r, log.NewNopLogger())
+	defer d.Stop()
+	next := watch(t, d)
+	assert.Equal(t, []string{"10.0.0.1:8081", "10.0.0.2:8081"}, next().Instances)
+
+	// A failed lookup keeps the instances last found.
+	r.set(func() { r.err = errors.New("no such host") })
+	e := next()
+	assert.Error(t, e.Err)
+	assert.Equal(t, []string{"10.0.0.1:8081", "10.0.0.2:8081"}, e.Instances)
+}
+
+func TestFileInstancer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instances")
+	write := func(s string) {
+		// Replace the file like a config map update does.
+		tmp := path + ".tmp"
+		if err := ioutil.WriteFile(tmp, []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("# login instances\n10.0.0.1:8081\n\n  10.0.0.2:8081  \n")
+	f := discovery.NewFileInstancer(path, 10*time.Millisecond, log.NewNopLogger())
+	defer f.Stop()
+	next := watch(t, f)
+	assert.Equal(t, []string{"10.0.0.1:8081", "10.0.0.2:8081"}, next().Instances)
+
+	write("10.0.0.3:8081\n")
+	assert.Equal(t, []string{"10.0.0.3:8081"}, next().Instances)
+}
+
+func TestHealthInstancerEjects(t *testing.T) {
+	var (
+		mtx       sync.Mutex
+		unhealthy = map[string]bool{}
+	)
+	setHealthy := func(instance string, healthy bool) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		unhealthy[instance] = !healthy
+	}
+	check := func(_ context.Context, instance string) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if unhealthy[instance] {
+			return errors.New("unhealthy")
+		}
+		return nil
+	}
+	setHealthy("b:1", false)
+
+	h := discovery.NewHealthInstancer(sd.FixedInstancer{"a:1", "b:1"}, check, 10*time.Millisecond, log.NewNopLogger())
+	defer h.Stop()
+	next := watch(t, h)
+	wait := func(want []string) {
+		for e := next(); !assert.ObjectsAreEqual(want, e.Instances); e = next() {
+		}
+	}
+
+	wait([]string{"a:1"})
+	setHealthy("b:1", true)
+	wait([]string{"a:1", "b:1"})
+
+	setHealthy("a:1", false)
+	wait([]string{"b:1"})
+
+	// When every instance fails, they are all published anyway.
+	setHealthy("b:1", false)
+	wait([]string{"a:1", "b:1"})
+}
+
+func TestDialCheck(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	assert.NoError(t, discovery.DialCheck(context.Background(), addr))
+	lis.Close()
+	assert.Error(t, discovery.DialCheck(context.Background(), addr))
+}
@@ -0,0 +1,103 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+)
+
+// Resolver looks up DNS records. *net.Resolver implements it; tests use a
+// fake.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// DNSInstancer finds instances in DNS, looking them up again every interval.
+// A target with a port, such as login.example.com:8081, is resolved through
+// its A and AAAA records and every address gets that port. A target without
+// one, such as _grpc._tcp.login.example.com, is resolved through its SRV
+// records, which carry the ports.
+type DNSInstancer struct {
+	*publisher
+	target   string
+	resolver Resolver
+	timeout  time.Duration
+	logger   log.Logger
+	quit     chan struct{}
+}
+
+// NewDNSInstancer returns a DNSInstancer for target, looked up once before it
+// returns. A nil resolver selects net.DefaultResolver. Stop it when done.
+func NewDNSInstancer(target string, interval time.Duration, resolver Resolver, logger log.Logger) *DNSInstancer {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	d := &DNSInstancer{
+		publisher: newPublisher(),
+		target:    target,
+		resolver:  resolver,
+		timeout:   interval,
+		logger:    log.With(logger, "instancer", "dns", "target", target),
+		quit:      make(chan struct{}),
+	}
+	d.resolve()
+	go d.loop(interval)
+	return d
+}
+
+func (d *DNSInstancer) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.resolve()
+		case <-d.quit:
+			return
+		}
+	}
+}
+
+func (d *DNSInstancer) resolve() {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	instances, err := d.lookup(ctx)
+	if err != nil {
+		d.logger.Log("err", err)
+		d.update(sd.Event{Err: err})
+		return
+	}
+	d.update(sd.Event{Instances: instances})
+}
+
+func (d *DNSInstancer) lookup(ctx context.Context) ([]string, error) {
+	if host, port, err := net.SplitHostPort(d.target); err == nil {
+		addrs, err := d.resolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		instances := make([]string, len(addrs))
+		for i, addr := range addrs {
+			instances[i] = net.JoinHostPort(addr, port)
+		}
+		return instances, nil
+	}
+	_, srvs, err := d.resolver.LookupSRV(ctx, "", "", d.target)
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]string, len(srvs))
+	for i, srv := range srvs {
+		instances[i] = net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+	}
+	return instances, nil
+}
+
+// Stop implements sd.Instancer.
+func (d *DNSInstancer) Stop() { close(d.quit) }
@@ -0,0 +1,76 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+)
+
+// FileInstancer reads instances from a file, one host:port per line, and
+// reads it again every interval. Blank lines and lines starting with # are
+// ignored. Polling, rather than watching, also follows files that are
+// replaced by a rename or a symlink swap, as mounted config maps are.
+type FileInstancer struct {
+	*publisher
+	path   string
+	logger log.Logger
+	quit   chan struct{}
+}
+
+// NewFileInstancer returns a FileInstancer for path, read once before it
+// returns. Stop it when done.
+func NewFileInstancer(path string, interval time.Duration, logger log.Logger) *FileInstancer {
+	f := &FileInstancer{
+		publisher: newPublisher(),
+		path:      path,
+		logger:    log.With(logger, "instancer", "file", "path", path),
+		quit:      make(chan struct{}),
+	}
+	f.read()
+	go f.loop(interval)
+	return f
+}
+
+func (f *FileInstancer) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.read()
+		case <-f.quit:
+			return
+		}
+	}
+}
+
+func (f *FileInstancer) read() {
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		f.logger.Log("err", err)
+		f.update(sd.Event{Err: err})
+		return
+	}
+	f.update(sd.Event{Instances: parseInstances(b)})
+}
+
+func parseInstances(b []byte) []string {
+	var instances []string
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		instances = append(instances, line)
+	}
+	return instances
+}
+
+// Stop implements sd.Instancer.
+func (f *FileInstancer) Stop() { close(f.quit) }
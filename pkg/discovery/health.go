@@ -0,0 +1,132 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+)
+
+// Check reports whether instance is healthy.
+type Check func(ctx context.Context, instance string) error
+
+// DialCheck is a Check that succeeds if a TCP connection to the instance can
+// be opened.
+func DialCheck(ctx context.Context, instance string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", instance)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// HealthInstancer publishes the instances of another Instancer that pass a
+// health check. Every instance is checked as soon as it appears and again
+// every interval; an instance that fails is ejected until it passes again.
+// If every instance fails, they are all published anyway: a broken check or
+// a partition of the checker should not take the whole service away, and
+// the client breakers still protect against instances that are really down.
+type HealthInstancer struct {
+	*publisher
+	upstream sd.Instancer
+	check    Check
+	interval time.Duration
+	logger   log.Logger
+	events   chan sd.Event
+	quit     chan struct{}
+}
+
+// NewHealthInstancer returns a HealthInstancer checking the instances of
+// upstream with check, or DialCheck if check is nil. Stopping it doesn't
+// stop upstream.
+func NewHealthInstancer(upstream sd.Instancer, check Check, interval time.Duration, logger log.Logger) *HealthInstancer {
+	if check == nil {
+		check = DialCheck
+	}
+	h := &HealthInstancer{
+		publisher: newPublisher(),
+		upstream:  upstream,
+		check:     check,
+		interval:  interval,
+		logger:    log.With(logger, "instancer", "health"),
+		events:    make(chan sd.Event, 1),
+		quit:      make(chan struct{}),
+	}
+	go h.loop()
+	upstream.Register(h.events)
+	return h
+}
+
+func (h *HealthInstancer) loop() {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	var (
+		instances []string
+		err       error
+		ejected   = map[string]bool{}
+	)
+	for {
+		select {
+		case event := <-h.events:
+			instances, err = event.Instances, event.Err
+		case <-ticker.C:
+		case <-h.quit:
+			return
+		}
+		healthy := h.checkAll(instances, ejected)
+		h.update(sd.Event{Instances: healthy, Err: err})
+	}
+}
+
+// checkAll checks instances concurrently and returns the healthy ones,
+// logging instances that are ejected or readmitted.
+func (h *HealthInstancer) checkAll(instances []string, ejected map[string]bool) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), h.interval)
+	defer cancel()
+	errs := make([]error, len(instances))
+	var wg sync.WaitGroup
+	for i, instance := range instances {
+		wg.Add(1)
+		go func(i int, instance string) {
+			defer wg.Done()
+			errs[i] = h.check(ctx, instance)
+		}(i, instance)
+	}
+	wg.Wait()
+
+	var healthy []string
+	seen := map[string]bool{}
+	for i, instance := range instances {
+		seen[instance] = true
+		switch {
+		case errs[i] == nil && ejected[instance]:
+			h.logger.Log("instance", instance, "event", "readmitted")
+			delete(ejected, instance)
+		case errs[i] != nil && !ejected[instance]:
+			h.logger.Log("instance", instance, "event", "ejected", "err", errs[i])
+			ejected[instance] = true
+		}
+		if errs[i] == nil {
+			healthy = append(healthy, instance)
+		}
+	}
+	for instance := range ejected {
+		if !seen[instance] {
+			delete(ejected, instance)
+		}
+	}
+	if len(healthy) == 0 {
+		return instances
+	}
+	return healthy
+}
+
+// Stop implements sd.Instancer. It doesn't stop the upstream Instancer.
+func (h *HealthInstancer) Stop() {
+	h.upstream.Deregister(h.events)
+	close(h.quit)
+}
@@ -0,0 +1,153 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// prefix marks and versions the text encoding of an encrypted value:
+//
+//	ev1.<key ID>.<wrapped data key>.<ciphertext>
+//
+// where both binary parts are unpadded base64url of nonce||sealed bytes.
+const prefix = "ev1"
+
+// ErrMalformed is returned for values that are not envelope ciphertexts.
+var ErrMalformed = errors.New("envelope: malformed ciphertext")
+
+// Encrypt seals plaintext under a fresh data key wrapped with the primary
+// master key. aad is authenticated but not stored; the same aad must be
+// passed to Decrypt, which binds the value to its context (e.g. a column).
+func (k *Keyring) Encrypt(plaintext, aad []byte) (string, error) {
+	dek := GenerateKey()
+	wrapped, err := seal(k.keys[k.primary], dek, []byte(k.primary))
+	if err != nil {
+		return "", err
+	}
+	body, err := seal(dek, plaintext, aad)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{prefix, k.primary, encode(wrapped), encode(body)}, "."), nil
+}
+
+// Decrypt opens a value produced by Encrypt with any key of the keyring.
+func (k *Keyring) Decrypt(ciphertext string, aad []byte) ([]byte, error) {
+	id, wrapped, body, err := split(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := k.unwrap(id, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return open(dek, body, aad)
+}
+
+// NeedsRewrap reports whether ciphertext was wrapped with a key other than
+// the primary one.
+func (k *Keyring) NeedsRewrap(ciphertext string) bool {
+	id, _, _, err := split(ciphertext)
+	return err == nil && id != k.primary
+}
+
+// Rewrap re-encrypts the data key of ciphertext with the primary master key.
+// The encrypted value itself is left untouched.
+func (k *Keyring) Rewrap(ciphertext string) (string, error) {
+	id, wrapped, body, err := split(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	dek, err := k.unwrap(id, wrapped)
+	if err != nil {
+		return "", err
+	}
+	if wrapped, err = seal(k.keys[k.primary], dek, []byte(k.primary)); err != nil {
+		return "", err
+	}
+	return strings.Join([]string{prefix, k.primary, encode(wrapped), encode(body)}, "."), nil
+}
+
+// BlindIndex returns a keyed hash of value that allows exact-match lookups
+// of encrypted values without decrypting them. Callers normalize value
+// first, e.g. by lower-casing emails.
+func (k *Keyring) BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsEncrypted reports whether s looks like a value produced by Encrypt.
+func IsEncrypted(s string) bool {
+	_, _, _, err := split(s)
+	return err == nil
+}
+
+func (k *Keyring) unwrap(id string, wrapped []byte) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return open(key, wrapped, []byte(id))
+}
+
+func split(ciphertext string) (id string, wrapped, body []byte, err error) {
+	parts := strings.Split(ciphertext, ".")
+	if len(parts) != 4 || parts[0] != prefix || parts[1] == "" {
+		return "", nil, nil, ErrMalformed
+	}
+	if wrapped, err = decode(parts[2]); err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	if body, err = decode(parts[3]); err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	return parts[1], wrapped, body, nil
+}
+
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, sealed, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
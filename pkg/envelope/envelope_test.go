@@ -0,0 +1,96 @@
+package envelope_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"loginsvc/pkg/envelope"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newKeyring(t *testing.T, primary string, keys map[string][]byte, indexKey []byte) *envelope.Keyring {
+	k, err := envelope.NewKeyring(primary, keys, indexKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	k := newKeyring(t, "k1", map[string][]byte{"k1": envelope.GenerateKey()}, envelope.GenerateKey())
+
+	c1, err := k.Encrypt([]byte("ed@example.com"), []byte("email"))
+	assert.NoError(t, err)
+	c2, _ := k.Encrypt([]byte("ed@example.com"), []byte("email"))
+	assert.NotEqual(t, c1, c2, "every value must get its own data key and nonce")
+	assert.False(t, strings.Contains(c1, "ed@example.com"))
+
+	plaintext, err := k.Decrypt(c1, []byte("email"))
+	assert.NoError(t, err)
+	assert.Equal(t, "ed@example.com", string(plaintext))
+
+	_, err = k.Decrypt(c1, []byte("phone"))
+	assert.Error(t, err, "ciphertext moved to another column must not decrypt")
+}
+
+func TestRotation(t *testing.T) {
+	k1, k2, index := envelope.GenerateKey(), envelope.GenerateKey(), envelope.GenerateKey()
+	old := newKeyring(t, "k1", map[string][]byte{"k1": k1}, index)
+	c, _ := old.Encrypt([]byte("+886912345678"), nil)
+
+	rotated := newKeyring(t, "k2", map[string][]byte{"k1": k1, "k2": k2}, index)
+	assert.True(t, rotated.NeedsRewrap(c))
+	plaintext, err := rotated.Decrypt(c, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "+886912345678", string(plaintext))
+
+	rewrapped, err := rotated.Rewrap(c)
+	assert.NoError(t, err)
+	assert.False(t, rotated.NeedsRewrap(rewrapped))
+
+	retired := newKeyring(t, "k2", map[string][]byte{"k2": k2}, index)
+	plaintext, err = retired.Decrypt(rewrapped, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "+886912345678", string(plaintext))
+	_, err = retired.Decrypt(c, nil)
+	assert.Equal(t, envelope.ErrUnknownKey, err)
+}
+
+func TestBlindIndex(t *testing.T) {
+	index := envelope.GenerateKey()
+	k1 := newKeyring(t, "k1", map[string][]byte{"k1": envelope.GenerateKey()}, index)
+	k2 := newKeyring(t, "k2", map[string][]byte{"k2": envelope.GenerateKey()}, index)
+	assert.Equal(t, k1.BlindIndex("ed@example.com"), k2.BlindIndex("ed@example.com"))
+	assert.NotEqual(t, k1.BlindIndex("ed@example.com"), k1.BlindIndex("al@example.com"))
+
+	other := newKeyring(t, "k1", map[string][]byte{"k1": envelope.GenerateKey()}, envelope.GenerateKey())
+	assert.NotEqual(t, k1.BlindIndex("ed@example.com"), other.BlindIndex("ed@example.com"))
+}
+
+func TestLoadKeyring(t *testing.T) {
+	b64 := base64.StdEncoding.EncodeToString
+	path := filepath.Join(t.TempDir(), "keys.json")
+	contents := fmt.Sprintf(`{"primary":"k2","keys":{"k1":%q,"k2":%q},"index_key":%q}`,
+		b64(envelope.GenerateKey()), b64(envelope.GenerateKey()), b64(envelope.GenerateKey()))
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	k, err := envelope.LoadKeyring(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "k2", k.Primary())
+
+	_, err = envelope.NewKeyring("k3", map[string][]byte{"k1": envelope.GenerateKey()}, envelope.GenerateKey())
+	assert.Equal(t, envelope.ErrUnknownKey, err)
+	_, err = envelope.NewKeyring("k1", map[string][]byte{"k1": []byte("short")}, envelope.GenerateKey())
+	assert.Equal(t, envelope.ErrInvalidKey, err)
+}
+
+func TestCheck(t *testing.T) {
+	k := newKeyring(t, "k1", map[string][]byte{"k1": envelope.GenerateKey()}, envelope.GenerateKey())
+	assert.NoError(t, k.Check())
+}
@@ -0,0 +1,123 @@
+// Package envelope implements envelope encryption for individual values.
+//
+// Every value is encrypted with its own random data key using AES-256-GCM.
+// The data key is in turn encrypted ("wrapped") with a master key from a
+// Keyring and stored next to the ciphertext, together with the ID of that
+// master key. Rotating keys therefore only means adding a new primary master
+// key: new values use it, old values still decrypt with the key named in
+// them, and Rewrap moves them over without touching the data itself.
+package envelope
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// KeySize is the size in bytes of master, data and index keys.
+const KeySize = 32
+
+var (
+	ErrUnknownKey   = errors.New("envelope: unknown master key")
+	ErrInvalidKey   = errors.New("envelope: keys must be 32 bytes")
+	ErrInvalidKeyID = errors.New("envelope: key IDs must be non-empty and must not contain '.'")
+)
+
+// Keyring holds the master keys and the blind index key.
+type Keyring struct {
+	primary  string
+	keys     map[string][]byte
+	indexKey []byte
+}
+
+// keyFile is the on-disk format of a keyring, e.g.
+//
+//	{
+//	  "primary": "2021-09",
+//	  "keys": {"2021-06": "<base64>", "2021-09": "<base64>"},
+//	  "index_key": "<base64>"
+//	}
+//
+// The index key cannot be rotated in place: changing it invalidates every
+// blind index already stored.
+type keyFile struct {
+	Primary  string            `json:"primary"`
+	Keys     map[string]string `json:"keys"`
+	IndexKey string            `json:"index_key"`
+}
+
+// LoadKeyring reads a keyring from the JSON key file at path.
+func LoadKeyring(path string) (*Keyring, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f keyFile
+	if err := json.Unmarshal(buf, &f); err != nil {
+		return nil, fmt.Errorf("envelope: %s: %v", path, err)
+	}
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, encoded := range f.Keys {
+		if keys[id], err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return nil, fmt.Errorf("envelope: key %q: %v", id, err)
+		}
+	}
+	indexKey, err := base64.StdEncoding.DecodeString(f.IndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: index key: %v", err)
+	}
+	return NewKeyring(f.Primary, keys, indexKey)
+}
+
+// NewKeyring returns a keyring that encrypts with keys[primary].
+func NewKeyring(primary string, keys map[string][]byte, indexKey []byte) (*Keyring, error) {
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ".") {
+			return nil, ErrInvalidKeyID
+		}
+		if len(key) != KeySize {
+			return nil, ErrInvalidKey
+		}
+	}
+	if _, ok := keys[primary]; !ok {
+		return nil, ErrUnknownKey
+	}
+	if len(indexKey) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	return &Keyring{primary: primary, keys: keys, indexKey: indexKey}, nil
+}
+
+// Primary returns the ID of the master key used for new values.
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// Check encrypts and decrypts a value, to prove that the keys work.
+func (k *Keyring) Check() error {
+	ciphertext, err := k.Encrypt([]byte("check"), nil)
+	if err != nil {
+		return err
+	}
+	plaintext, err := k.Decrypt(ciphertext, nil)
+	if err != nil {
+		return err
+	}
+	if string(plaintext) != "check" {
+		return errors.New("envelope: round trip mismatch")
+	}
+	return nil
+}
+
+// GenerateKey returns a random key suitable for a key file.
+func GenerateKey() []byte {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
@@ -0,0 +1,44 @@
+// Package grpcadmin exposes gRPC server reflection and channelz, which let
+// generic tools list, describe and call services and inspect connections
+// without the generated code, and provides such a tool as a client.
+package grpcadmin
+
+import (
+	"errors"
+	"net"
+
+	"google.golang.org/grpc"
+	channelz "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/reflection"
+)
+
+// ErrExposed is returned by CheckExposure for admin services that would be
+// reachable by anyone who can reach the listener.
+var ErrExposed = errors.New("grpcadmin: reflection and channelz need mTLS or a listener bound to localhost")
+
+// Register registers the reflection and channelz services on s.
+func Register(s *grpc.Server) {
+	reflection.Register(s)
+	channelz.RegisterChannelzServiceToServer(s)
+}
+
+// CheckExposure returns ErrExposed unless clients of the listener at addr
+// must present a certificate (mtls) or addr is bound to a loopback address.
+// Reflection and channelz reveal the API and the peers of the server, so
+// they shouldn't be open to the network.
+func CheckExposure(addr string, mtls bool) error {
+	if mtls {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return ErrExposed
+}
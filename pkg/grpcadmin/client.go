@@ -0,0 +1,182 @@
+package grpcadmin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Client lists, describes and calls the services of a server through its
+// reflection service.
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// NewClient returns a Client for the server at the other end of conn.
+func NewClient(conn *grpc.ClientConn) *Client {
+	return &Client{conn: conn}
+}
+
+// ListServices returns the full names of the services of the server.
+func (c *Client) ListServices(ctx context.Context) ([]string, error) {
+	resp, err := c.reflect(ctx, &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		names = append(names, s.GetName())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Describe returns the definition of a service, method or message, by full
+// name, in proto syntax.
+func (c *Client) Describe(ctx context.Context, symbol string) (string, error) {
+	d, err := c.resolve(ctx, symbol)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	switch d := d.(type) {
+	case protoreflect.ServiceDescriptor:
+		fmt.Fprintf(&b, "service %s {\n", d.FullName())
+		for i := 0; i < d.Methods().Len(); i++ {
+			fmt.Fprintf(&b, "  %s\n", method(d.Methods().Get(i)))
+		}
+		b.WriteString("}\n")
+	case protoreflect.MethodDescriptor:
+		fmt.Fprintf(&b, "%s\n", method(d))
+	case protoreflect.MessageDescriptor:
+		fmt.Fprintf(&b, "message %s {\n", d.FullName())
+		for i := 0; i < d.Fields().Len(); i++ {
+			f := d.Fields().Get(i)
+			fmt.Fprintf(&b, "  %s%s %s = %d;\n", label(f), kind(f), f.Name(), f.Number())
+		}
+		b.WriteString("}\n")
+	default:
+		return "", fmt.Errorf("grpcadmin: cannot describe %s", d.FullName())
+	}
+	return b.String(), nil
+}
+
+// Invoke calls a unary method, named as in pb.Login/Name or pb.Login.Name,
+// with the request given in protobuf JSON, and returns the reply as
+// protobuf JSON.
+func (c *Client) Invoke(ctx context.Context, name string, request []byte, opts ...grpc.CallOption) ([]byte, error) {
+	d, err := c.resolve(ctx, strings.Replace(strings.TrimPrefix(name, "/"), "/", ".", 1))
+	if err != nil {
+		return nil, err
+	}
+	m, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("grpcadmin: %s is not a method", d.FullName())
+	}
+	if m.IsStreamingClient() || m.IsStreamingServer() {
+		return nil, fmt.Errorf("grpcadmin: %s is a streaming method", m.FullName())
+	}
+	req := dynamicpb.NewMessage(m.Input())
+	if err := protojson.Unmarshal(request, req); err != nil {
+		return nil, fmt.Errorf("grpcadmin: request: %v", err)
+	}
+	reply := dynamicpb.NewMessage(m.Output())
+	path := fmt.Sprintf("/%s/%s", m.Parent().FullName(), m.Name())
+	if err := c.conn.Invoke(ctx, path, req, reply, opts...); err != nil {
+		return nil, err
+	}
+	return protojson.Marshal(reply)
+}
+
+// resolve finds the descriptor of symbol in the files the server sends for
+// it, which include their dependencies.
+func (c *Client) resolve(ctx context.Context, symbol string) (protoreflect.Descriptor, error) {
+	resp, err := c.reflect(ctx, &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol},
+	})
+	if err != nil {
+		return nil, err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, b := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		fd := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(b, fd); err != nil {
+			return nil, err
+		}
+		set.File = append(set.File, fd)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+	return files.FindDescriptorByName(protoreflect.FullName(symbol))
+}
+
+// reflect sends a single request on a reflection stream.
+func (c *Client) reflect(ctx context.Context, req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := rpb.NewServerReflectionClient(c.conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(req); err != nil {
+		return nil, err
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if e := resp.GetErrorResponse(); e != nil {
+		return nil, fmt.Errorf("grpcadmin: %s", e.GetErrorMessage())
+	}
+	return resp, nil
+}
+
+func method(m protoreflect.MethodDescriptor) string {
+	stream := func(streaming bool) string {
+		if streaming {
+			return "stream "
+		}
+		return ""
+	}
+	return fmt.Sprintf("rpc %s(%s%s) returns (%s%s);", m.Name(),
+		stream(m.IsStreamingClient()), m.Input().FullName(),
+		stream(m.IsStreamingServer()), m.Output().FullName())
+}
+
+func label(f protoreflect.FieldDescriptor) string {
+	switch {
+	case f.IsMap():
+		return ""
+	case f.IsList():
+		return "repeated "
+	case f.HasOptionalKeyword():
+		return "optional "
+	}
+	return ""
+}
+
+func kind(f protoreflect.FieldDescriptor) string {
+	switch {
+	case f.IsMap():
+		return fmt.Sprintf("map<%s, %s>", kind(f.MapKey()), kind(f.MapValue()))
+	case f.Message() != nil:
+		return string(f.Message().FullName())
+	case f.Enum() != nil:
+		return string(f.Enum().FullName())
+	}
+	return f.Kind().String()
+}
@@ -0,0 +1,112 @@
+package grpcadmin_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "loginsvc/pb"
+	"loginsvc/pkg/grpcadmin"
+)
+
+// loginServer replies to Name with the name reversed.
+type loginServer struct {
+	pb.UnimplementedLoginServer
+}
+
+func (loginServer) Name(_ context.Context, req *pb.NameRequest) (*pb.NameReply, error) {
+	r := []rune(req.N)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return &pb.NameReply{V: string(r)}, nil
+}
+
+func newClient(t *testing.T) *grpcadmin.Client {
+	lis := bufconn.Listen(1 << 16)
+	server := grpc.NewServer()
+	pb.RegisterLoginServer(server, loginServer{})
+	grpcadmin.Register(server)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return grpcadmin.NewClient(conn)
+}
+
+func TestListServices(t *testing.T) {
+	services, err := newClient(t).ListServices(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, services, "pb.Login")
+	assert.Contains(t, services, "grpc.channelz.v1.Channelz")
+	assert.Contains(t, services, "grpc.reflection.v1alpha.ServerReflection")
+}
+
+func TestDescribe(t *testing.T) {
+	c := newClient(t)
+	for symbol, want := range map[string]string{
+		"pb.Login": "service pb.Login {\n" +
+			"  rpc Name(pb.NameRequest) returns (pb.NameReply);\n" +
+			"  rpc Authenticate(pb.AuthenticateRequest) returns (pb.AuthenticateReply);\n" +
+			"  rpc Check(pb.CheckRequest) returns (pb.CheckReply);\n" +
+			"}\n",
+		"pb.Login.Name": "rpc Name(pb.NameRequest) returns (pb.NameReply);\n",
+		"pb.AuthenticateRequest": "message pb.AuthenticateRequest {\n" +
+			"  string name = 1;\n" +
+			"  string password = 2;\n" +
+			"}\n",
+	} {
+		got, err := c.Describe(context.Background(), symbol)
+		assert.NoError(t, err, symbol)
+		assert.Equal(t, want, got, symbol)
+	}
+
+	_, err := c.Describe(context.Background(), "pb.Missing")
+	assert.Error(t, err)
+}
+
+func TestInvoke(t *testing.T) {
+	c := newClient(t)
+	for _, method := range []string{"pb.Login/Name", "/pb.Login/Name", "pb.Login.Name"} {
+		reply, err := c.Invoke(context.Background(), method, []byte(`{"n": "alice"}`))
+		assert.NoError(t, err, method)
+		var got map[string]string
+		assert.NoError(t, json.Unmarshal(reply, &got), method)
+		assert.Equal(t, map[string]string{"v": "ecila"}, got, method)
+	}
+
+	_, err := c.Invoke(context.Background(), "pb.Login/Name", []byte(`{"x": 1}`))
+	assert.Error(t, err, "unknown field")
+	_, err = c.Invoke(context.Background(), "pb.Login/Authenticate", []byte(`{}`))
+	assert.Error(t, err, "unimplemented")
+	_, err = c.Invoke(context.Background(), "pb.NameRequest", []byte(`{}`))
+	assert.Error(t, err, "not a method")
+}
+
+func TestCheckExposure(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		mtls bool
+		want error
+	}{
+		{"localhost:8082", false, nil},
+		{"127.0.0.1:8082", false, nil},
+		{"[::1]:8082", false, nil},
+		{":8082", false, grpcadmin.ErrExposed},
+		{"0.0.0.0:8082", false, grpcadmin.ErrExposed},
+		{"10.0.0.1:8082", false, grpcadmin.ErrExposed},
+		{":8082", true, nil},
+	} {
+		assert.Equal(t, tc.want, grpcadmin.CheckExposure(tc.addr, tc.mtls), tc.addr)
+	}
+	assert.Error(t, grpcadmin.CheckExposure("8082", false))
+}
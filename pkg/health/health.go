@@ -0,0 +1,135 @@
+// Package health serves the liveness and readiness of the service, over
+// HTTP for orchestrators and through the standard grpc.health.v1 service for
+// gRPC clients and load balancers.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Check reports whether a dependency is ready.
+type Check func(ctx context.Context) error
+
+// Checker runs named readiness checks.
+type Checker struct {
+	timeout time.Duration
+
+	mtx    sync.Mutex
+	checks map[string]Check
+}
+
+// NewChecker returns a Checker that gives each check up to timeout.
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout, checks: map[string]Check{}}
+}
+
+// Add adds the check called name, replacing any check of that name.
+func (c *Checker) Add(name string, check Check) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.checks[name] = check
+}
+
+// Run runs every check concurrently and returns the result of each, by
+// name: nil if it passed.
+func (c *Checker) Run(ctx context.Context) map[string]error {
+	c.mtx.Lock()
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mtx.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	var (
+		mtx     sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]error, len(checks))
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			err := check(ctx)
+			mtx.Lock()
+			results[name] = err
+			mtx.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	return results
+}
+
+// Ready reports whether every check passes.
+func (c *Checker) Ready(ctx context.Context) bool {
+	for _, err := range c.Run(ctx) {
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// report is the body of the readiness probe.
+type report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// ServeHTTP is the readiness probe: it runs the checks and answers 200 if
+// they all pass and 503 otherwise, with the outcome of each check.
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rep, code := report{Status: "ok", Checks: map[string]string{}}, http.StatusOK
+	for name, err := range c.Run(r.Context()) {
+		rep.Checks[name] = "ok"
+		if err != nil {
+			rep.Checks[name] = err.Error()
+			rep.Status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(rep)
+}
+
+// Live is the liveness probe. The process is alive as long as it answers:
+// failing dependencies make it unready, but restarting it wouldn't help.
+func Live(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok\n"))
+}
+
+// UpdateGRPC sets the status of services on srv from the checks every
+// interval, and at once when it starts, until ctx is done. Then it marks
+// every service NOT_SERVING for good, which is how gRPC clients learn that
+// the server is shutting down.
+func (c *Checker) UpdateGRPC(ctx context.Context, srv *grpchealth.Server, interval time.Duration, services ...string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status := healthpb.HealthCheckResponse_NOT_SERVING
+		if c.Ready(ctx) {
+			status = healthpb.HealthCheckResponse_SERVING
+		}
+		if ctx.Err() == nil {
+			for _, service := range services {
+				srv.SetServingStatus(service, status)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			srv.Shutdown()
+			return
+		}
+	}
+}
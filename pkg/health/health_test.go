@@ -0,0 +1,111 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"loginsvc/pkg/health"
+)
+
+func TestReadiness(t *testing.T) {
+	var broken int32
+	c := health.NewChecker(time.Second)
+	c.Add("db", func(context.Context) error { return nil })
+	c.Add("keys", func(context.Context) error {
+		if atomic.LoadInt32(&broken) == 1 {
+			return errors.New("no keys")
+		}
+		return nil
+	})
+
+	probe := func() (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		c.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		var body map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return rec.Code, body
+	}
+
+	code, body := probe()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{
+		"status": "ok",
+		"checks": map[string]interface{}{"db": "ok", "keys": "ok"},
+	}, body)
+
+	atomic.StoreInt32(&broken, 1)
+	code, body = probe()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, map[string]interface{}{
+		"status": "unavailable",
+		"checks": map[string]interface{}{"db": "ok", "keys": "no keys"},
+	}, body)
+}
+
+func TestCheckTimeout(t *testing.T) {
+	c := health.NewChecker(10 * time.Millisecond)
+	c.Add("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.False(t, c.Ready(context.Background()))
+}
+
+func TestLiveness(t *testing.T) {
+	rec := httptest.NewRecorder()
+	health.Live(rec, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestUpdateGRPC(t *testing.T) {
+	var broken int32
+	c := health.NewChecker(time.Second)
+	c.Add("db", func(context.Context) error {
+		if atomic.LoadInt32(&broken) == 1 {
+			return errors.New("down")
+		}
+		return nil
+	})
+	srv := grpchealth.NewServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.UpdateGRPC(ctx, srv, 5*time.Millisecond, "", "pb.Login")
+		close(done)
+	}()
+
+	status := func(service string) func() healthpb.HealthCheckResponse_ServingStatus {
+		return func() healthpb.HealthCheckResponse_ServingStatus {
+			resp, err := srv.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+			if err != nil {
+				return healthpb.HealthCheckResponse_UNKNOWN
+			}
+			return resp.Status
+		}
+	}
+	is := func(service string, want healthpb.HealthCheckResponse_ServingStatus) func() bool {
+		return func() bool { return status(service)() == want }
+	}
+
+	assert.Eventually(t, is("pb.Login", healthpb.HealthCheckResponse_SERVING), time.Second, time.Millisecond)
+	atomic.StoreInt32(&broken, 1)
+	assert.Eventually(t, is("pb.Login", healthpb.HealthCheckResponse_NOT_SERVING), time.Second, time.Millisecond)
+	atomic.StoreInt32(&broken, 0)
+	assert.Eventually(t, is("", healthpb.HealthCheckResponse_SERVING), time.Second, time.Millisecond)
+
+	// Shutdown flips every service for good.
+	cancel()
+	<-done
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status("")())
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status("pb.Login")())
+}
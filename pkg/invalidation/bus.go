@@ -0,0 +1,120 @@
+// Package invalidation propagates cache invalidations between loginsvc
+// replicas, so that a write handled by one instance evicts the stale entry
+// held by every other instance.
+package invalidation
+
+import (
+	"context"
+	"sync"
+)
+
+// Topics used by the service. Each cache layer subscribes to the topic that
+// describes what it holds.
+const (
+	TopicUser  = "user"
+	TopicToken = "token"
+	// TopicRole keys the user whose roles changed; a change to a role
+	// itself flushes the topic.
+	TopicRole = "role"
+)
+
+// Message is a single invalidation. An empty Key means every entry under the
+// topic must be dropped; buses send such a flush after they may have missed
+// messages, e.g. after reconnecting to the network backend.
+type Message struct {
+	Topic  string `json:"topic"`
+	Key    string `json:"key"`
+	Origin string `json:"origin,omitempty"`
+}
+
+// Handler is invoked for every message published on a subscribed topic.
+// Handlers must not block.
+type Handler func(Message)
+
+// Bus delivers invalidations to all subscribers, local and remote.
+type Bus interface {
+	// Publish announces that key under topic is stale. Local subscribers are
+	// notified before Publish returns.
+	Publish(ctx context.Context, topic, key string) error
+
+	// Subscribe registers h for topic and returns a function that removes
+	// the subscription.
+	Subscribe(topic string, h Handler) (unsubscribe func())
+
+	// Close releases the resources held by the bus.
+	Close() error
+}
+
+// registry keeps the local subscribers of a bus and fans messages out to
+// them. It is shared by every Bus implementation.
+type registry struct {
+	mtx  sync.RWMutex
+	next int
+	subs map[string]map[int]Handler
+}
+
+func newRegistry() *registry {
+	return &registry{subs: map[string]map[int]Handler{}}
+}
+
+func (r *registry) subscribe(topic string, h Handler) func() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	id := r.next
+	r.next++
+	if r.subs[topic] == nil {
+		r.subs[topic] = map[int]Handler{}
+	}
+	r.subs[topic][id] = h
+	return func() {
+		r.mtx.Lock()
+		defer r.mtx.Unlock()
+		delete(r.subs[topic], id)
+	}
+}
+
+func (r *registry) dispatch(m Message) {
+	r.mtx.RLock()
+	handlers := make([]Handler, 0, len(r.subs[m.Topic]))
+	for _, h := range r.subs[m.Topic] {
+		handlers = append(handlers, h)
+	}
+	r.mtx.RUnlock()
+	for _, h := range handlers {
+		h(m)
+	}
+}
+
+// flush sends a whole-topic invalidation to every subscribed topic.
+func (r *registry) flush() {
+	r.mtx.RLock()
+	topics := make([]string, 0, len(r.subs))
+	for topic := range r.subs {
+		topics = append(topics, topic)
+	}
+	r.mtx.RUnlock()
+	for _, topic := range topics {
+		r.dispatch(Message{Topic: topic})
+	}
+}
+
+// NewInProcBus returns a Bus that only reaches subscribers in the current
+// process. It is the right choice for a single replica and for tests.
+func NewInProcBus() Bus {
+	return &inProcBus{registry: newRegistry()}
+}
+
+type inProcBus struct {
+	*registry
+}
+
+func (b *inProcBus) Publish(_ context.Context, topic, key string) error {
+	b.dispatch(Message{Topic: topic, Key: key})
+	return nil
+}
+
+func (b *inProcBus) Subscribe(topic string, h Handler) func() {
+	return b.subscribe(topic, h)
+}
+
+func (b *inProcBus) Close() error { return nil }
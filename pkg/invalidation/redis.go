@@ -0,0 +1,247 @@
+package invalidation
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"loginsvc/pkg/resp"
+)
+
+const (
+	// DefaultRedisChannel is the pub/sub channel shared by all replicas.
+	DefaultRedisChannel = "loginsvc:invalidation"
+
+	redisDialTimeout = 2 * time.Second
+	redisIOTimeout   = 2 * time.Second
+	minBackoff       = 50 * time.Millisecond
+	maxBackoff       = 5 * time.Second
+)
+
+// RedisBus is a Bus backed by Redis pub/sub. Every replica publishes to and
+// subscribes on the same channel; messages published by the bus itself are
+// recognised by their origin and not delivered twice.
+//
+// While the subscription is down, remote invalidations are lost. When it is
+// re-established the bus flushes every subscribed topic, so staleness is
+// bounded by the reconnect delay even if the network misbehaves.
+type RedisBus struct {
+	*registry
+	addr    string
+	channel string
+	origin  string
+	logger  log.Logger
+
+	pubMtx  sync.Mutex
+	pubConn net.Conn
+	pubR    *bufio.Reader
+
+	subMtx  sync.Mutex
+	subConn net.Conn
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// NewRedisBus returns a RedisBus connected to the Redis server at addr and
+// starts its subscription in the background. An empty channel selects
+// DefaultRedisChannel.
+func NewRedisBus(addr, channel string, logger log.Logger) *RedisBus {
+	if channel == "" {
+		channel = DefaultRedisChannel
+	}
+	b := &RedisBus{
+		registry: newRegistry(),
+		addr:     addr,
+		channel:  channel,
+		origin:   newOrigin(),
+		logger:   logger,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.loop()
+	return b
+}
+
+// Subscribe implements Bus.
+func (b *RedisBus) Subscribe(topic string, h Handler) func() {
+	return b.subscribe(topic, h)
+}
+
+// Publish implements Bus. Local subscribers are notified even if the
+// message cannot be sent to Redis.
+func (b *RedisBus) Publish(ctx context.Context, topic, key string) error {
+	m := Message{Topic: topic, Key: key, Origin: b.origin}
+	b.dispatch(m)
+
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	b.pubMtx.Lock()
+	defer b.pubMtx.Unlock()
+	// A pooled connection may have been closed by the server since the last
+	// publish, so retry once on a fresh one.
+	for attempt := 0; ; attempt++ {
+		err = b.publish(ctx, string(payload))
+		if err == nil {
+			return nil
+		}
+		if b.pubConn != nil {
+			b.pubConn.Close()
+			b.pubConn, b.pubR = nil, nil
+		}
+		if attempt > 0 || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+func (b *RedisBus) publish(ctx context.Context, payload string) error {
+	if b.pubConn == nil {
+		conn, err := b.dial(ctx)
+		if err != nil {
+			return err
+		}
+		b.pubConn, b.pubR = conn, bufio.NewReader(conn)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisIOTimeout)
+	}
+	b.pubConn.SetDeadline(deadline)
+	if err := resp.WriteCommand(b.pubConn, "PUBLISH", b.channel, payload); err != nil {
+		return err
+	}
+	_, err := resp.ReadReply(b.pubR)
+	return err
+}
+
+// Close stops the subscription and closes all connections.
+func (b *RedisBus) Close() error {
+	close(b.quit)
+	b.subMtx.Lock()
+	if b.subConn != nil {
+		b.subConn.Close()
+	}
+	b.subMtx.Unlock()
+	<-b.done
+
+	b.pubMtx.Lock()
+	defer b.pubMtx.Unlock()
+	if b.pubConn != nil {
+		b.pubConn.Close()
+		b.pubConn, b.pubR = nil, nil
+	}
+	return nil
+}
+
+// loop keeps the subscription alive until Close is called.
+func (b *RedisBus) loop() {
+	defer close(b.done)
+	var (
+		backoff   = minBackoff
+		connected bool
+	)
+	for {
+		err := b.listen(func() {
+			if connected {
+				b.flush()
+			}
+			connected = true
+			backoff = minBackoff
+		})
+		select {
+		case <-b.quit:
+			return
+		default:
+		}
+		b.logger.Log("bus", "redis", "addr", b.addr, "during", "Subscribe", "err", err, "retry_in", backoff)
+		select {
+		case <-b.quit:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// listen subscribes to the channel and dispatches incoming messages until
+// the connection fails. onSubscribed is called once the server confirms the
+// subscription.
+func (b *RedisBus) listen(onSubscribed func()) error {
+	conn, err := b.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	b.subMtx.Lock()
+	select {
+	case <-b.quit:
+		b.subMtx.Unlock()
+		conn.Close()
+		return nil
+	default:
+	}
+	b.subConn = conn
+	b.subMtx.Unlock()
+	defer func() {
+		b.subMtx.Lock()
+		b.subConn = nil
+		b.subMtx.Unlock()
+		conn.Close()
+	}()
+
+	if err := resp.WriteCommand(conn, "SUBSCRIBE", b.channel); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	for {
+		reply, err := resp.ReadReply(r)
+		if err != nil {
+			return err
+		}
+		values, ok := reply.([]interface{})
+		if !ok || len(values) < 3 {
+			return fmt.Errorf("redis: unexpected pub/sub reply %v", reply)
+		}
+		switch values[0] {
+		case "subscribe":
+			onSubscribed()
+		case "message":
+			payload, _ := values[2].(string)
+			var m Message
+			if err := json.Unmarshal([]byte(payload), &m); err != nil {
+				b.logger.Log("bus", "redis", "during", "Decode", "err", err)
+				continue
+			}
+			if m.Origin == b.origin {
+				continue
+			}
+			b.dispatch(m)
+		}
+	}
+}
+
+func (b *RedisBus) dial(ctx context.Context) (net.Conn, error) {
+	d := net.Dialer{Timeout: redisDialTimeout}
+	return d.DialContext(ctx, "tcp", b.addr)
+}
+
+// newOrigin returns a random identifier for this bus instance.
+func newOrigin() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
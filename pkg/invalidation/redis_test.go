@@ -0,0 +1,213 @@
+package invalidation_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"loginsvc/pkg/invalidation"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRedis is a local stand-in for a Redis server that understands only
+// SUBSCRIBE and PUBLISH.
+type fakeRedis struct {
+	ln    net.Listener
+	mtx   sync.Mutex
+	conns map[net.Conn]bool
+	subs  map[string][]net.Conn
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, conns: map[net.Conn]bool{}, subs: map[string][]net.Conn{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mtx.Lock()
+			f.conns[conn] = true
+			f.mtx.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		f.dropAll()
+	})
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer f.drop(conn)
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(args[0]) {
+		case "SUBSCRIBE":
+			f.mtx.Lock()
+			f.subs[args[1]] = append(f.subs[args[1]], conn)
+			f.mtx.Unlock()
+			fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1]), args[1])
+		case "PUBLISH":
+			f.mtx.Lock()
+			subs := f.subs[args[1]]
+			for _, sub := range subs {
+				fmt.Fprintf(sub, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(args[1]), args[1], len(args[2]), args[2])
+			}
+			f.mtx.Unlock()
+			fmt.Fprintf(conn, ":%d\r\n", len(subs))
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command\r\n")
+		}
+	}
+}
+
+func (f *fakeRedis) subscribers(channel string) int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return len(f.subs[channel])
+}
+
+func (f *fakeRedis) drop(conn net.Conn) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	conn.Close()
+	delete(f.conns, conn)
+	for channel, subs := range f.subs {
+		for i, sub := range subs {
+			if sub == conn {
+				f.subs[channel] = append(subs[:i], subs[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// dropAll closes every client connection, simulating a server restart.
+func (f *fakeRedis) dropAll() {
+	f.mtx.Lock()
+	conns := make([]net.Conn, 0, len(f.conns))
+	for conn := range f.conns {
+		conns = append(conns, conn)
+	}
+	f.mtx.Unlock()
+	for _, conn := range conns {
+		f.drop(conn)
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRedisBusDeliversAcrossReplicas(t *testing.T) {
+	f := newFakeRedis(t)
+	a := invalidation.NewRedisBus(f.ln.Addr().String(), "", log.NewNopLogger())
+	defer a.Close()
+	b := invalidation.NewRedisBus(f.ln.Addr().String(), "", log.NewNopLogger())
+	defer b.Close()
+	waitFor(t, func() bool { return f.subscribers(invalidation.DefaultRedisChannel) == 2 })
+
+	local := make(chan invalidation.Message, 4)
+	remote := make(chan invalidation.Message, 4)
+	a.Subscribe(invalidation.TopicUser, func(m invalidation.Message) { local <- m })
+	b.Subscribe(invalidation.TopicUser, func(m invalidation.Message) { remote <- m })
+
+	if err := a.Publish(context.Background(), invalidation.TopicUser, "ed"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-remote:
+		assert.Equal(t, "ed", m.Key)
+	case <-time.After(2 * time.Second):
+		t.Fatal("remote replica was not invalidated")
+	}
+	assert.Equal(t, "ed", (<-local).Key)
+	select {
+	case m := <-local:
+		t.Fatalf("own message delivered twice: %v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRedisBusFlushesAfterReconnect(t *testing.T) {
+	f := newFakeRedis(t)
+	b := invalidation.NewRedisBus(f.ln.Addr().String(), "", log.NewNopLogger())
+	defer b.Close()
+	waitFor(t, func() bool { return f.subscribers(invalidation.DefaultRedisChannel) == 1 })
+
+	received := make(chan invalidation.Message, 4)
+	b.Subscribe(invalidation.TopicToken, func(m invalidation.Message) { received <- m })
+
+	f.dropAll()
+	select {
+	case m := <-received:
+		assert.Equal(t, invalidation.Message{Topic: invalidation.TopicToken}, m)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no flush after reconnect")
+	}
+}
+
+func TestInProcBus(t *testing.T) {
+	b := invalidation.NewInProcBus()
+	var got []string
+	unsubscribe := b.Subscribe(invalidation.TopicUser, func(m invalidation.Message) { got = append(got, m.Key) })
+	b.Subscribe(invalidation.TopicToken, func(m invalidation.Message) { t.Fatalf("wrong topic: %v", m) })
+
+	b.Publish(context.Background(), invalidation.TopicUser, "ed")
+	unsubscribe()
+	b.Publish(context.Background(), invalidation.TopicUser, "al")
+	assert.Equal(t, []string{"ed"}, got)
+}
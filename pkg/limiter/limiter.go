@@ -0,0 +1,211 @@
+// Package limiter rate limits requests with token buckets keyed by the
+// identity of the caller: its IP address, the user name it acts on and the
+// client ID it presents, under a global ceiling shared by everyone. Each
+// tenant has buckets of its own, and may have rules of its own.
+package limiter
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Rule configures a token bucket that refills at Rate tokens per second up
+// to Burst tokens. A rule with a zero Rate is disabled.
+type Rule struct {
+	Rate  float64
+	Burst int
+}
+
+func (r Rule) enabled() bool { return r.Rate > 0 && r.Burst > 0 }
+
+// result computes the outcome of a take that left tokens in the bucket.
+func (r Rule) result(allowed bool, tokens float64) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     r.Burst,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     seconds((float64(r.Burst) - tokens) / r.Rate),
+	}
+	if !allowed {
+		res.RetryAfter = seconds((1 - tokens) / r.Rate)
+	}
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Max(0, s) * float64(time.Second))
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed bool
+	// Limit is the burst of the bucket and Remaining the whole tokens left.
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until a token is available, if not Allowed.
+	RetryAfter time.Duration
+}
+
+// tighter reports whether r is closer to being limited than o: denied
+// results come first, then those with fewer tokens left.
+func (r Result) tighter(o Result) bool {
+	if r.Allowed != o.Allowed {
+		return !r.Allowed
+	}
+	if !r.Allowed {
+		return r.RetryAfter > o.RetryAfter
+	}
+	return r.Remaining < o.Remaining
+}
+
+// Bucket names a token bucket and the rule governing it.
+type Bucket struct {
+	Key  string
+	Rule Rule
+}
+
+// Store holds token buckets.
+type Store interface {
+	// Take takes a token from every one of buckets if each has one, and
+	// from none of them otherwise, atomically. It returns the result of
+	// each bucket, in order; a bucket that had a token is Allowed even if
+	// another had none.
+	Take(ctx context.Context, buckets []Bucket) ([]Result, error)
+}
+
+// Rules configures the buckets of a Limiter.
+type Rules struct {
+	Global    Rule
+	PerIP     Rule
+	PerUser   Rule
+	PerClient Rule
+}
+
+// Identity identifies the caller of a request. Empty fields are not limited.
+type Identity struct {
+	IP     string
+	User   string
+	Client string
+	// Tenant, if set, scopes the other buckets to the tenant.
+	Tenant string
+}
+
+// Limiter decides whether a request may proceed.
+type Limiter struct {
+	store    Store
+	fallback Store
+	rules    Rules
+	tenants  map[string]Rules
+	logger   log.Logger
+}
+
+// New returns a Limiter keeping its buckets in store. If store fails, e.g.
+// because a shared backend is unreachable, the limiter falls back to
+// per-process buckets in a MemoryStore bounded to maxKeys.
+func New(store Store, rules Rules, maxKeys int, logger log.Logger) *Limiter {
+	return &Limiter{
+		store:    store,
+		fallback: NewMemoryStore(maxKeys),
+		rules:    rules,
+		tenants:  map[string]Rules{},
+		logger:   logger,
+	}
+}
+
+// SetTenantRules replaces the rules for the requests of tenant, whose
+// Global rule then caps the tenant as a whole. The global ceiling of the
+// limiter still applies. Call it before the limiter is used.
+func (l *Limiter) SetTenantRules(tenant string, rules Rules) {
+	l.tenants[tenant] = rules
+}
+
+// Allow takes a token from every bucket that applies to id, only if each
+// of them has one, and returns the tightest result. A request denied by one
+// bucket therefore doesn't drain the others: a caller over its own limit
+// doesn't use up those of its IP address or tenant.
+func (l *Limiter) Allow(ctx context.Context, id Identity) Result {
+	var buckets []Bucket
+	add := func(key string, rule Rule) {
+		if rule.enabled() {
+			buckets = append(buckets, Bucket{Key: key, Rule: rule})
+		}
+	}
+	rules, prefix := l.rules, ""
+	if id.Tenant != "" {
+		prefix = "tenant:" + id.Tenant + ":"
+		if r, ok := l.tenants[id.Tenant]; ok {
+			rules = r
+			add(prefix+"global", rules.Global)
+		}
+	}
+	if id.IP != "" {
+		add(prefix+"ip:"+id.IP, rules.PerIP)
+	}
+	if id.User != "" {
+		add(prefix+"user:"+id.User, rules.PerUser)
+	}
+	if id.Client != "" {
+		add(prefix+"client:"+id.Client, rules.PerClient)
+	}
+	add("global", l.rules.Global)
+	if len(buckets) == 0 {
+		// No rule applied.
+		return Result{Allowed: true}
+	}
+
+	results, err := l.store.Take(ctx, buckets)
+	if err != nil {
+		l.logger.Log("during", "Take", "buckets", len(buckets), "err", err)
+		results, _ = l.fallback.Take(ctx, buckets)
+	}
+	res := results[0]
+	for _, r := range results[1:] {
+		if r.tighter(res) {
+			res = r
+		}
+	}
+	return res
+}
+
+type callKey struct{}
+
+// call carries the identity of a request into the endpoints, and its
+// result back out to the transport.
+type call struct {
+	id     Identity
+	result *Result
+}
+
+// NewContext returns a context for a request from ip presenting client. The
+// user is added by the endpoint, which knows where to find it.
+func NewContext(ctx context.Context, ip, client string) context.Context {
+	return context.WithValue(ctx, callKey{}, &call{id: Identity{IP: ip, Client: client}})
+}
+
+// IdentityFromContext returns the identity stored by NewContext.
+func IdentityFromContext(ctx context.Context) Identity {
+	if c, ok := ctx.Value(callKey{}).(*call); ok {
+		return c.id
+	}
+	return Identity{}
+}
+
+// SetResult records the result of the request, for ResultFromContext.
+func SetResult(ctx context.Context, res Result) {
+	if c, ok := ctx.Value(callKey{}).(*call); ok {
+		c.result = &res
+	}
+}
+
+// ResultFromContext returns the result recorded by SetResult, if any.
+func ResultFromContext(ctx context.Context) (Result, bool) {
+	if c, ok := ctx.Value(callKey{}).(*call); ok && c.result != nil {
+		return *c.result, true
+	}
+	return Result{}, false
+}
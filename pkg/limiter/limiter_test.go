@@ -0,0 +1,199 @@
+package limiter_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"loginsvc/pkg/limiter"
+	"loginsvc/pkg/resp"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// slow refills so slowly that tests never see a token come back.
+var slow = limiter.Rule{Rate: 0.001, Burst: 3}
+
+// take takes a token from the single bucket key.
+func take(s limiter.Store, key string, rule limiter.Rule) (limiter.Result, error) {
+	results, err := s.Take(context.Background(), []limiter.Bucket{{Key: key, Rule: rule}})
+	if err != nil {
+		return limiter.Result{}, err
+	}
+	return results[0], nil
+}
+
+func TestMemoryStoreBucket(t *testing.T) {
+	s := limiter.NewMemoryStore(10)
+	for want := 2; want >= 0; want-- {
+		res, err := take(s, "k", slow)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 3, res.Limit)
+		assert.Equal(t, want, res.Remaining)
+	}
+	res, _ := take(s, "k", slow)
+	assert.False(t, res.Allowed)
+	assert.InDelta(t, 1000, res.RetryAfter.Seconds(), 1)
+	assert.InDelta(t, 3000, res.Reset.Seconds(), 1)
+
+	res, _ = take(s, "other", slow)
+	assert.True(t, res.Allowed)
+}
+
+func TestMemoryStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	s := limiter.NewMemoryStore(2)
+	one := limiter.Rule{Rate: 0.001, Burst: 1}
+	take(s, "a", one)
+	take(s, "b", one)
+	take(s, "a", one)
+	take(s, "c", one) // evicts b
+	assert.Equal(t, 2, s.Len())
+
+	res, _ := take(s, "a", one)
+	assert.False(t, res.Allowed)
+	res, _ = take(s, "b", one)
+	assert.True(t, res.Allowed, "b starts again with a full bucket")
+}
+
+func TestLimiterIdentities(t *testing.T) {
+	l := limiter.New(limiter.NewMemoryStore(0), limiter.Rules{
+		Global:  limiter.Rule{Rate: 0.001, Burst: 5},
+		PerUser: limiter.Rule{Rate: 0.001, Burst: 2},
+	}, 0, log.NewNopLogger())
+	ctx := context.Background()
+
+	// The per-user bucket holds whatever IP the attempts come from.
+	assert.True(t, l.Allow(ctx, limiter.Identity{IP: "10.0.0.1", User: "ed"}).Allowed)
+	assert.True(t, l.Allow(ctx, limiter.Identity{IP: "10.0.0.2", User: "ed"}).Allowed)
+	res := l.Allow(ctx, limiter.Identity{IP: "10.0.0.3", User: "ed"})
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 2, res.Limit)
+
+	// The global ceiling holds across users; the denied attempt didn't
+	// count towards it.
+	assert.True(t, l.Allow(ctx, limiter.Identity{User: "al"}).Allowed)
+	assert.True(t, l.Allow(ctx, limiter.Identity{User: "bo"}).Allowed)
+	assert.True(t, l.Allow(ctx, limiter.Identity{User: "cy"}).Allowed)
+	res = l.Allow(ctx, limiter.Identity{User: "di"})
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 5, res.Limit)
+}
+
+func TestLimiterTenants(t *testing.T) {
+	l := limiter.New(limiter.NewMemoryStore(0), limiter.Rules{
+		PerUser: limiter.Rule{Rate: 0.001, Burst: 1},
+	}, 0, log.NewNopLogger())
+	l.SetTenantRules("acme", limiter.Rules{
+		Global:  limiter.Rule{Rate: 0.001, Burst: 3},
+		PerUser: limiter.Rule{Rate: 0.001, Burst: 2},
+	})
+	ctx := context.Background()
+
+	// Tenants don't share buckets, even for users of the same name.
+	assert.True(t, l.Allow(ctx, limiter.Identity{User: "ed"}).Allowed)
+	assert.False(t, l.Allow(ctx, limiter.Identity{User: "ed"}).Allowed)
+	assert.True(t, l.Allow(ctx, limiter.Identity{User: "ed", Tenant: "globex"}).Allowed)
+	assert.False(t, l.Allow(ctx, limiter.Identity{User: "ed", Tenant: "globex"}).Allowed)
+
+	// A tenant with rules of its own is also capped as a whole.
+	assert.True(t, l.Allow(ctx, limiter.Identity{User: "ed", Tenant: "acme"}).Allowed)
+	assert.True(t, l.Allow(ctx, limiter.Identity{User: "ed", Tenant: "acme"}).Allowed)
+	assert.False(t, l.Allow(ctx, limiter.Identity{User: "ed", Tenant: "acme"}).Allowed)
+	assert.True(t, l.Allow(ctx, limiter.Identity{User: "al", Tenant: "acme"}).Allowed)
+	res := l.Allow(ctx, limiter.Identity{User: "bo", Tenant: "acme"})
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 3, res.Limit)
+}
+
+func TestMemoryStoreTakesAllOrNothing(t *testing.T) {
+	s := limiter.NewMemoryStore(10)
+	one := limiter.Rule{Rate: 0.001, Burst: 1}
+	take(s, "b", one)
+
+	results, err := s.Take(context.Background(), []limiter.Bucket{{Key: "a", Rule: slow}, {Key: "b", Rule: one}})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, false}, []bool{results[0].Allowed, results[1].Allowed})
+	assert.Equal(t, 3, results[0].Remaining, "a denied take leaves every bucket as it was")
+}
+
+func TestLimiterDeniedRequestsDontDrainOtherBuckets(t *testing.T) {
+	l := limiter.New(limiter.NewMemoryStore(0), limiter.Rules{
+		PerIP:   limiter.Rule{Rate: 0.001, Burst: 3},
+		PerUser: limiter.Rule{Rate: 0.001, Burst: 1},
+	}, 0, log.NewNopLogger())
+	ctx := context.Background()
+
+	// A caller hammering a locked out user doesn't use up the bucket of
+	// its IP address, which other users behind it share.
+	assert.True(t, l.Allow(ctx, limiter.Identity{IP: "10.0.0.1", User: "ed"}).Allowed)
+	for i := 0; i < 10; i++ {
+		assert.False(t, l.Allow(ctx, limiter.Identity{IP: "10.0.0.1", User: "ed"}).Allowed)
+	}
+	res := l.Allow(ctx, limiter.Identity{IP: "10.0.0.1", User: "al"})
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+}
+
+func TestRedisStore(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	commands := make(chan []interface{}, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			cmd, err := resp.ReadReply(r)
+			if err != nil {
+				return
+			}
+			commands <- cmd.([]interface{})
+			if cmd.([]interface{})[2] == "2" {
+				conn.Write([]byte("*3\r\n:0\r\n$3\r\n4.5\r\n$3\r\n0.5\r\n"))
+				continue
+			}
+			conn.Write([]byte("*2\r\n:1\r\n$3\r\n4.5\r\n"))
+		}
+	}()
+
+	s := limiter.NewRedisStore(lis.Addr().String(), "")
+	defer s.Close()
+	res, err := take(s, "user:ed", limiter.Rule{Rate: 2, Burst: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, limiter.Result{Allowed: true, Limit: 10, Remaining: 4, Reset: 2750 * time.Millisecond}, res)
+	cmd := <-commands
+	assert.Equal(t, "EVAL", cmd[0])
+	assert.Equal(t, []interface{}{"1", "loginsvc:ratelimit:user:ed", "2", "10"}, cmd[2:])
+
+	// Buckets are taken from together, or not at all.
+	results, err := s.Take(context.Background(), []limiter.Bucket{
+		{Key: "ip:10.0.0.1", Rule: limiter.Rule{Rate: 2, Burst: 10}},
+		{Key: "user:ed", Rule: limiter.Rule{Rate: 1, Burst: 1}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, false}, []bool{results[0].Allowed, results[1].Allowed})
+	assert.Equal(t, 500*time.Millisecond, results[1].RetryAfter)
+	cmd = <-commands
+	assert.Equal(t, []interface{}{"2", "loginsvc:ratelimit:ip:10.0.0.1", "loginsvc:ratelimit:user:ed", "2", "10", "1", "1"}, cmd[2:])
+}
+
+func TestLimiterFallsBackWhenRedisIsDown(t *testing.T) {
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := lis.Addr().String()
+	lis.Close()
+
+	l := limiter.New(limiter.NewRedisStore(addr, ""), limiter.Rules{PerIP: limiter.Rule{Rate: 0.001, Burst: 1}}, 0, log.NewNopLogger())
+	ctx := context.Background()
+	assert.True(t, l.Allow(ctx, limiter.Identity{IP: "10.0.0.1"}).Allowed)
+	assert.False(t, l.Allow(ctx, limiter.Identity{IP: "10.0.0.1"}).Allowed)
+}
@@ -0,0 +1,93 @@
+package limiter
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultMaxKeys bounds the buckets of a MemoryStore when none is given.
+const DefaultMaxKeys = 100000
+
+// MemoryStore keeps buckets in process memory. It holds at most maxKeys
+// buckets and evicts the least recently used one beyond that; an evicted
+// caller starts again with a full bucket.
+type MemoryStore struct {
+	maxKeys int
+	now     func() time.Time
+
+	mtx     sync.Mutex
+	lru     *list.List
+	buckets map[string]*list.Element
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// NewMemoryStore returns a MemoryStore holding at most maxKeys buckets, or
+// DefaultMaxKeys if maxKeys is not positive.
+func NewMemoryStore(maxKeys int) *MemoryStore {
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+	return &MemoryStore{
+		maxKeys: maxKeys,
+		now:     time.Now,
+		lru:     list.New(),
+		buckets: map[string]*list.Element{},
+	}
+}
+
+// Take implements Store.
+func (s *MemoryStore) Take(_ context.Context, buckets []Bucket) ([]Result, error) {
+	now := s.now()
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	held := make([]*bucket, len(buckets))
+	allowed := true
+	for i, b := range buckets {
+		held[i] = s.refill(b.Key, b.Rule, now)
+		allowed = allowed && held[i].tokens >= 1
+	}
+	results := make([]Result, len(buckets))
+	for i, b := range held {
+		if allowed {
+			b.tokens--
+		}
+		results[i] = buckets[i].Rule.result(allowed || b.tokens >= 1, b.tokens)
+	}
+	return results, nil
+}
+
+// refill returns the bucket key, refilled under rule until now, or a full
+// one if it isn't held.
+func (s *MemoryStore) refill(key string, rule Rule, now time.Time) *bucket {
+	if e, ok := s.buckets[key]; ok {
+		s.lru.MoveToFront(e)
+		b := e.Value.(*bucket)
+		b.tokens = math.Min(float64(rule.Burst), b.tokens+now.Sub(b.last).Seconds()*rule.Rate)
+		b.last = now
+		return b
+	}
+	b := &bucket{key: key, tokens: float64(rule.Burst), last: now}
+	s.buckets[key] = s.lru.PushFront(b)
+	if s.lru.Len() > s.maxKeys {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.buckets, oldest.Value.(*bucket).key)
+	}
+	return b
+}
+
+// Len returns the number of buckets held.
+func (s *MemoryStore) Len() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.lru.Len()
+}
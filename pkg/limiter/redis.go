@@ -0,0 +1,152 @@
+package limiter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"loginsvc/pkg/resp"
+)
+
+const (
+	// DefaultRedisPrefix prefixes the keys of the buckets in Redis.
+	DefaultRedisPrefix = "loginsvc:ratelimit:"
+
+	redisDialTimeout = 500 * time.Millisecond
+	redisIOTimeout   = 500 * time.Millisecond
+	// redisRetryDelay is how long the store fails fast after Redis failed,
+	// so that an outage doesn't add a dial timeout to every request.
+	redisRetryDelay = time.Second
+)
+
+// takeScript refills the buckets in KEYS, whose rate and burst are in
+// consecutive ARGV, and takes a token from each if every one has one,
+// atomically, using the clock of the Redis server so that replicas agree on
+// time. It returns whether the tokens were taken and the tokens left in
+// each bucket, as strings since Lua numbers are truncated to integers in
+// replies.
+const takeScript = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+local tokens, allowed = {}, 1
+for i, key in ipairs(KEYS) do
+  local rate, burst = tonumber(ARGV[2*i-1]), tonumber(ARGV[2*i])
+  local b = redis.call('HMGET', key, 'tokens', 'last')
+  local last = tonumber(b[2]) or now
+  tokens[i] = math.min(burst, (tonumber(b[1]) or burst) + math.max(0, now - last) * rate)
+  if tokens[i] < 1 then
+    allowed = 0
+  end
+end
+local reply = {allowed}
+for i, key in ipairs(KEYS) do
+  local rate, burst = tonumber(ARGV[2*i-1]), tonumber(ARGV[2*i])
+  tokens[i] = tokens[i] - allowed
+  redis.call('HSET', key, 'tokens', tostring(tokens[i]), 'last', tostring(now))
+  redis.call('PEXPIRE', key, math.ceil((burst - tokens[i]) / rate * 1000) + 1000)
+  reply[i+1] = tostring(tokens[i])
+end
+return reply
+`
+
+var errRedisDown = errors.New("limiter: redis unavailable")
+
+// RedisStore keeps buckets in Redis, so that every replica sharing the
+// server enforces the same limits. Buckets expire once they are full again,
+// which bounds the memory used on the server.
+type RedisStore struct {
+	addr   string
+	prefix string
+
+	mtx       sync.Mutex
+	conn      net.Conn
+	r         *bufio.Reader
+	downUntil time.Time
+}
+
+// NewRedisStore returns a RedisStore for the Redis server at addr. An empty
+// prefix selects DefaultRedisPrefix.
+func NewRedisStore(addr, prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = DefaultRedisPrefix
+	}
+	return &RedisStore{addr: addr, prefix: prefix}
+}
+
+// Take implements Store.
+func (s *RedisStore) Take(ctx context.Context, buckets []Bucket) ([]Result, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if time.Now().Before(s.downUntil) {
+		return nil, errRedisDown
+	}
+	reply, err := s.eval(ctx, buckets)
+	if err != nil {
+		if s.conn != nil {
+			s.conn.Close()
+			s.conn, s.r = nil, nil
+		}
+		s.downUntil = time.Now().Add(redisRetryDelay)
+		return nil, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != len(buckets)+1 {
+		return nil, fmt.Errorf("limiter: unexpected reply %v", reply)
+	}
+	allowed, _ := values[0].(int64)
+	results := make([]Result, len(buckets))
+	for i, b := range buckets {
+		left, _ := values[i+1].(string)
+		tokens, err := strconv.ParseFloat(left, 64)
+		if err != nil {
+			return nil, fmt.Errorf("limiter: unexpected reply %v", reply)
+		}
+		results[i] = b.Rule.result(allowed == 1 || tokens >= 1, tokens)
+	}
+	return results, nil
+}
+
+func (s *RedisStore) eval(ctx context.Context, buckets []Bucket) (interface{}, error) {
+	if s.conn == nil {
+		d := net.Dialer{Timeout: redisDialTimeout}
+		conn, err := d.DialContext(ctx, "tcp", s.addr)
+		if err != nil {
+			return nil, err
+		}
+		s.conn, s.r = conn, bufio.NewReader(conn)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > redisIOTimeout {
+		deadline = time.Now().Add(redisIOTimeout)
+	}
+	s.conn.SetDeadline(deadline)
+	args := []string{"EVAL", takeScript, strconv.Itoa(len(buckets))}
+	for _, b := range buckets {
+		args = append(args, s.prefix+b.Key)
+	}
+	for _, b := range buckets {
+		args = append(args, strconv.FormatFloat(b.Rule.Rate, 'f', -1, 64), strconv.Itoa(b.Rule.Burst))
+	}
+	if err := resp.WriteCommand(s.conn, args...); err != nil {
+		return nil, err
+	}
+	return resp.ReadReply(s.r)
+}
+
+// Close closes the connection to Redis.
+func (s *RedisStore) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.r = nil, nil
+	return err
+}
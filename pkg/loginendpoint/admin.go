@@ -0,0 +1,286 @@
+package loginendpoint
+
+import (
+	"context"
+
+	"loginsvc/pkg/breaker"
+	"loginsvc/pkg/limiter"
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/tenant"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/tracing/opentracing"
+	"github.com/go-kit/kit/tracing/zipkin"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	stdzipkin "github.com/openzipkin/zipkin-go"
+)
+
+// AdminMethods are the names of the methods of AdminSet, as used for
+// breakers, allowlists and metrics.
+var AdminMethods = []string{
+	"CreateUser", "GetUser", "UpdateUser", "DisableUser", "EnableUser", "DeleteUser",
+	"ForcePasswordReset", "RevokeSessions", "ResetMFA", "ListUsers", "AuditEvents",
+	"CreateWebhook", "ListWebhooks", "DeleteWebhook", "RedeliverWebhook",
+}
+
+// AdminSet collects the endpoints of an AdminService.
+type AdminSet struct {
+	CreateUserEndpoint         endpoint.Endpoint
+	GetUserEndpoint            endpoint.Endpoint
+	UpdateUserEndpoint         endpoint.Endpoint
+	DisableUserEndpoint        endpoint.Endpoint
+	EnableUserEndpoint         endpoint.Endpoint
+	DeleteUserEndpoint         endpoint.Endpoint
+	ForcePasswordResetEndpoint endpoint.Endpoint
+	RevokeSessionsEndpoint     endpoint.Endpoint
+	ResetMFAEndpoint           endpoint.Endpoint
+	ListUsersEndpoint          endpoint.Endpoint
+	AuditEventsEndpoint        endpoint.Endpoint
+	CreateWebhookEndpoint      endpoint.Endpoint
+	ListWebhooksEndpoint       endpoint.Endpoint
+	DeleteWebhookEndpoint      endpoint.Endpoint
+	RedeliverWebhookEndpoint   endpoint.Endpoint
+}
+
+// NewAdmin returns the endpoints of svc, wrapped like those of New. Unlike
+// those, they fail closed: a method without an allowlist of its own in the
+// tenant of the call takes the "Admin" one of the tenant, and without
+// either denies every call.
+func NewAdmin(svc loginservice.AdminService, lim *limiter.Limiter, breakers *breaker.Registry, allow TenantAllowlists, tenants tenant.Resolver, logger log.Logger, duration metrics.Histogram, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer) AdminSet {
+	wrap := func(method string, e endpoint.Endpoint) endpoint.Endpoint {
+		e = breakers.Middleware(method, serviceFailed)(e)
+		e = RateLimitingMiddleware(lim)(e)
+		e = TenantAuthorizingMiddleware(method, allow.closed(method, "Admin"), logger)(e)
+		e = TenantMiddleware(tenants)(e)
+		e = opentracing.TraceServer(otTracer, method)(e)
+		if zipkinTracer != nil {
+			e = zipkin.TraceEndpoint(zipkinTracer, method)(e)
+		}
+		e = LoggingMiddleware(log.With(logger, "method", method))(e)
+		e = InstrumentingMiddleware(duration.With("method", method))(e)
+		return e
+	}
+	return AdminSet{
+		CreateUserEndpoint:         wrap("CreateUser", MakeCreateUserEndpoint(svc)),
+		GetUserEndpoint:            wrap("GetUser", makeAccountEndpoint(svc.GetUser)),
+		UpdateUserEndpoint:         wrap("UpdateUser", MakeUpdateUserEndpoint(svc)),
+		DisableUserEndpoint:        wrap("DisableUser", makeAccountEndpoint(svc.DisableUser)),
+		EnableUserEndpoint:         wrap("EnableUser", makeAccountEndpoint(svc.EnableUser)),
+		DeleteUserEndpoint:         wrap("DeleteUser", MakeDeleteUserEndpoint(svc)),
+		ForcePasswordResetEndpoint: wrap("ForcePasswordReset", makeAccountEndpoint(svc.ForcePasswordReset)),
+		RevokeSessionsEndpoint:     wrap("RevokeSessions", makeAccountEndpoint(svc.RevokeSessions)),
+		ResetMFAEndpoint:           wrap("ResetMFA", makeAccountEndpoint(svc.ResetMFA)),
+		ListUsersEndpoint:          wrap("ListUsers", MakeListUsersEndpoint(svc)),
+		AuditEventsEndpoint:        wrap("AuditEvents", MakeAuditEventsEndpoint(svc)),
+		CreateWebhookEndpoint:      wrap("CreateWebhook", MakeCreateWebhookEndpoint(svc)),
+		ListWebhooksEndpoint:       wrap("ListWebhooks", MakeListWebhooksEndpoint(svc)),
+		DeleteWebhookEndpoint:      wrap("DeleteWebhook", MakeDeleteWebhookEndpoint(svc)),
+		RedeliverWebhookEndpoint:   wrap("RedeliverWebhook", MakeRedeliverWebhookEndpoint(svc)),
+	}
+}
+
+// MakeAdminEndpoints returns the bare endpoints of svc, without
+// middleware.
+func MakeAdminEndpoints(svc loginservice.AdminService) AdminSet {
+	return AdminSet{
+		CreateUserEndpoint:         MakeCreateUserEndpoint(svc),
+		GetUserEndpoint:            makeAccountEndpoint(svc.GetUser),
+		UpdateUserEndpoint:         MakeUpdateUserEndpoint(svc),
+		DisableUserEndpoint:        makeAccountEndpoint(svc.DisableUser),
+		EnableUserEndpoint:         makeAccountEndpoint(svc.EnableUser),
+		DeleteUserEndpoint:         MakeDeleteUserEndpoint(svc),
+		ForcePasswordResetEndpoint: makeAccountEndpoint(svc.ForcePasswordReset),
+		RevokeSessionsEndpoint:     makeAccountEndpoint(svc.RevokeSessions),
+		ResetMFAEndpoint:           makeAccountEndpoint(svc.ResetMFA),
+		ListUsersEndpoint:          MakeListUsersEndpoint(svc),
+		AuditEventsEndpoint:        MakeAuditEventsEndpoint(svc),
+		CreateWebhookEndpoint:      MakeCreateWebhookEndpoint(svc),
+		ListWebhooksEndpoint:       MakeListWebhooksEndpoint(svc),
+		DeleteWebhookEndpoint:      MakeDeleteWebhookEndpoint(svc),
+		RedeliverWebhookEndpoint:   MakeRedeliverWebhookEndpoint(svc),
+	}
+}
+
+func MakeCreateUserEndpoint(s loginservice.AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CreateUserRequest)
+		a, err := s.CreateUser(ctx, loginservice.Account{Name: req.Name, SID: req.SID, Email: req.Email, Phone: req.Phone}, req.Password)
+		return AccountResponse{Account: a, Err: err}, nil
+	}
+}
+
+func MakeUpdateUserEndpoint(s loginservice.AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(UpdateUserRequest)
+		a, err := s.UpdateUser(ctx, req.AccountUpdate)
+		return AccountResponse{Account: a, Err: err}, nil
+	}
+}
+
+func MakeDeleteUserEndpoint(s loginservice.AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(UserRequest)
+		return DeleteUserResponse{Err: s.DeleteUser(ctx, req.Name)}, nil
+	}
+}
+
+func MakeListUsersEndpoint(s loginservice.AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ListUsersRequest)
+		page, err := s.ListUsers(ctx, req.UserQuery)
+		return ListUsersResponse{AccountPage: page, Err: err}, nil
+	}
+}
+
+func MakeAuditEventsEndpoint(s loginservice.AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(AuditEventsRequest)
+		page, err := s.AuditEvents(ctx, req.AuditQuery)
+		return AuditEventsResponse{AuditPage: page, Err: err}, nil
+	}
+}
+
+func MakeCreateWebhookEndpoint(s loginservice.AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CreateWebhookRequest)
+		w, err := s.CreateWebhook(ctx, req.Webhook)
+		return WebhookResponse{Webhook: w, Err: err}, nil
+	}
+}
+
+func MakeListWebhooksEndpoint(s loginservice.AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		webhooks, err := s.ListWebhooks(ctx)
+		return ListWebhooksResponse{Webhooks: webhooks, Err: err}, nil
+	}
+}
+
+func MakeDeleteWebhookEndpoint(s loginservice.AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(WebhookRequest)
+		return DeleteWebhookResponse{Err: s.DeleteWebhook(ctx, req.ID)}, nil
+	}
+}
+
+func MakeRedeliverWebhookEndpoint(s loginservice.AdminService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(WebhookRequest)
+		n, err := s.RedeliverWebhook(ctx, req.ID)
+		return RedeliverWebhookResponse{Redelivered: n, Err: err}, nil
+	}
+}
+
+// makeAccountEndpoint returns the endpoint of an AdminService method taking
+// a user name and returning its account.
+func makeAccountEndpoint(method func(context.Context, string) (loginservice.Account, error)) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(UserRequest)
+		a, err := method(ctx, req.Name)
+		return AccountResponse{Account: a, Err: err}, nil
+	}
+}
+
+var (
+	_ endpoint.Failer = AccountResponse{}
+	_ endpoint.Failer = DeleteUserResponse{}
+	_ endpoint.Failer = ListUsersResponse{}
+	_ endpoint.Failer = AuditEventsResponse{}
+	_ endpoint.Failer = WebhookResponse{}
+	_ endpoint.Failer = ListWebhooksResponse{}
+	_ endpoint.Failer = DeleteWebhookResponse{}
+	_ endpoint.Failer = RedeliverWebhookResponse{}
+)
+
+type CreateUserRequest struct {
+	Name     string `json:"name"`
+	SID      string `json:"sid"`
+	Email    string `json:"email"`
+	Phone    string `json:"phone"`
+	Password string `json:"password"`
+}
+
+// UserRequest names the user of the admin methods that take nothing else.
+type UserRequest struct {
+	Name string `json:"name"`
+}
+
+type UpdateUserRequest struct {
+	loginservice.AccountUpdate
+}
+
+type ListUsersRequest struct {
+	loginservice.UserQuery
+}
+
+type AccountResponse struct {
+	Account loginservice.Account `json:"account"`
+	Err     error                `json:"-"`
+}
+
+func (r AccountResponse) Failed() error { return r.Err }
+
+type DeleteUserResponse struct {
+	Err error `json:"-"`
+}
+
+func (r DeleteUserResponse) Failed() error { return r.Err }
+
+type ListUsersResponse struct {
+	loginservice.AccountPage
+	Err error `json:"-"`
+}
+
+func (r ListUsersResponse) Failed() error { return r.Err }
+
+type AuditEventsRequest struct {
+	loginservice.AuditQuery
+}
+
+type AuditEventsResponse struct {
+	loginservice.AuditPage
+	Err error `json:"-"`
+}
+
+func (r AuditEventsResponse) Failed() error { return r.Err }
+
+type CreateWebhookRequest struct {
+	loginservice.Webhook
+}
+
+// WebhookRequest names the subscription of the webhook methods that take
+// nothing else.
+type WebhookRequest struct {
+	ID int64 `json:"id"`
+}
+
+// ListWebhooksRequest is empty.
+type ListWebhooksRequest struct{}
+
+type WebhookResponse struct {
+	Webhook loginservice.Webhook `json:"webhook"`
+	Err     error                `json:"-"`
+}
+
+func (r WebhookResponse) Failed() error { return r.Err }
+
+type ListWebhooksResponse struct {
+	Webhooks []loginservice.Webhook `json:"webhooks"`
+	Err      error                  `json:"-"`
+}
+
+func (r ListWebhooksResponse) Failed() error { return r.Err }
+
+type DeleteWebhookResponse struct {
+	Err error `json:"-"`
+}
+
+func (r DeleteWebhookResponse) Failed() error { return r.Err }
+
+type RedeliverWebhookResponse struct {
+	Redelivered int   `json:"redelivered"`
+	Err         error `json:"-"`
+}
+
+func (r RedeliverWebhookResponse) Failed() error { return r.Err }
@@ -0,0 +1,65 @@
+package loginendpoint
+
+import (
+	"context"
+
+	"loginsvc/pkg/limiter"
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/tenant"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/tracing/opentracing"
+	"github.com/go-kit/kit/tracing/zipkin"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	stdzipkin "github.com/openzipkin/zipkin-go"
+)
+
+// EventSet collects the endpoints of an EventService.
+type EventSet struct {
+	WatchEventsEndpoint endpoint.Endpoint
+}
+
+// NewEvents returns the endpoints of svc, wrapped like those of NewAdmin:
+// without an allowlist, WatchEvents denies every call. A call lasts as long
+// as its stream, so it is neither behind a breaker nor in the duration
+// histogram, and the rate limit applies to opening streams.
+func NewEvents(svc loginservice.EventService, lim *limiter.Limiter, allow TenantAllowlists, tenants tenant.Resolver, logger log.Logger, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer) EventSet {
+	var e endpoint.Endpoint
+	e = MakeWatchEventsEndpoint(svc)
+	e = RateLimitingMiddleware(lim)(e)
+	e = TenantAuthorizingMiddleware("WatchEvents", allow.closed("WatchEvents", ""), logger)(e)
+	e = TenantMiddleware(tenants)(e)
+	e = opentracing.TraceServer(otTracer, "WatchEvents")(e)
+	if zipkinTracer != nil {
+		e = zipkin.TraceEndpoint(zipkinTracer, "WatchEvents")(e)
+	}
+	e = LoggingMiddleware(log.With(logger, "method", "WatchEvents"))(e)
+	return EventSet{WatchEventsEndpoint: e}
+}
+
+func MakeWatchEventsEndpoint(s loginservice.EventService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(WatchEventsRequest)
+		err := s.WatchEvents(ctx, req.WatchQuery, req.Send)
+		return WatchEventsResponse{Err: err}, nil
+	}
+}
+
+var _ endpoint.Failer = WatchEventsResponse{}
+
+// WatchEventsRequest carries, besides the query, the function the stream
+// sends each event with, which the transport provides.
+type WatchEventsRequest struct {
+	loginservice.WatchQuery
+	Tenant string                                 `json:"tenant"`
+	Send   func(loginservice.IdentityEvent) error `json:"-"`
+}
+
+func (r WatchEventsRequest) TenantName() string { return r.Tenant }
+
+type WatchEventsResponse struct {
+	Err error `json:"-"`
+}
+
+func (r WatchEventsResponse) Failed() error { return r.Err }
@@ -2,9 +2,15 @@ package loginendpoint
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"loginsvc/pkg/caller"
+	"loginsvc/pkg/limiter"
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/tenant"
+
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/metrics"
@@ -41,3 +47,147 @@ func LoggingMiddleware(logger log.Logger) endpoint.Middleware {
 		}
 	}
 }
+
+// RateLimitingMiddleware returns an endpoint middleware that takes a token
+// for the caller from l, failing with loginservice.ErrRateLimited once the
+// caller has run out. The caller's IP and client ID come from the context
+// prepared by the transport with limiter.NewContext, its user name from the
+// request and its tenant from TenantMiddleware. The result is recorded in
+// the context for the transport to report.
+func RateLimitingMiddleware(l *limiter.Limiter) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			id := limiter.IdentityFromContext(ctx)
+			id.Tenant, _ = tenant.FromContext(ctx)
+			switch req := request.(type) {
+			case LoginRequest:
+				id.User = req.N
+			case AuthenticateRequest:
+				id.User = req.Name
+			}
+			res := l.Allow(ctx, id)
+			limiter.SetResult(ctx, res)
+			if !res.Allowed {
+				return nil, loginservice.ErrRateLimited
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// TenantMiddleware returns an endpoint middleware that resolves the tenant
+// of each call with r from the claims recorded by the transport with
+// tenant.WithClaims, and from the request if it is a TenantNamer, and
+// stores it with tenant.NewContext. Calls naming no tenant, an unknown one
+// or several fail with loginservice.ErrUnknownTenant.
+func TenantMiddleware(r tenant.Resolver) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			c := tenant.ClaimsFromContext(ctx)
+			if m, ok := request.(TenantNamer); ok {
+				c.Message = m.TenantName()
+			}
+			id, err := r.Resolve(c)
+			if err != nil {
+				return nil, loginservice.ErrUnknownTenant
+			}
+			return next(tenant.NewContext(ctx, id), request)
+		}
+	}
+}
+
+// TenantNamer is implemented by requests that may name their tenant in
+// the request itself. An empty name names no tenant.
+type TenantNamer interface {
+	TenantName() string
+}
+
+// Allowlists maps method names to the callers allowed to call them.
+// Methods without an entry are open to every caller.
+type Allowlists map[string]caller.Allowlist
+
+// TenantAllowlists maps tenant IDs to their Allowlists. The callers on the
+// lists of one tenant may not call the methods of another.
+type TenantAllowlists map[string]Allowlists
+
+// open returns the allowlists of method, which lets everyone through the
+// tenants without one.
+func (a TenantAllowlists) open(method string) func(string) caller.Allowlist {
+	return func(tenant string) caller.Allowlist { return a[tenant][method] }
+}
+
+// closed returns the allowlists of method, or else of fallback, which deny
+// every call to the tenants with neither.
+func (a TenantAllowlists) closed(method, fallback string) func(string) caller.Allowlist {
+	return func(tenant string) caller.Allowlist {
+		allowed, ok := a[tenant][method]
+		if !ok {
+			allowed = a[tenant][fallback]
+		}
+		if allowed == nil {
+			allowed = caller.Allowlist{}
+		}
+		return allowed
+	}
+}
+
+// AuthorizingMiddleware returns an endpoint middleware that only lets
+// through the callers on allowed, as identified by the transport with
+// caller.NewContext from their client certificate. Other calls, including
+// those without a certificate, fail with loginservice.ErrPermissionDenied
+// and are logged to logger for audit. A nil allowlist lets everyone through.
+func AuthorizingMiddleware(method string, allowed caller.Allowlist, logger log.Logger) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		if allowed == nil {
+			return next
+		}
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if !authorized(ctx, method, allowed, logger) {
+				return nil, loginservice.ErrPermissionDenied
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// TenantAuthorizingMiddleware is AuthorizingMiddleware with the allowlist
+// that allowed returns for the tenant of the call, so it goes after
+// TenantMiddleware. Calls without a tenant are denied.
+func TenantAuthorizingMiddleware(method string, allowed func(tenant string) caller.Allowlist, logger log.Logger) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			id, ok := tenant.FromContext(ctx)
+			if !ok {
+				return nil, loginservice.ErrPermissionDenied
+			}
+			if list := allowed(id); list != nil && !authorized(ctx, method, list, log.With(logger, "tenant", id)) {
+				return nil, loginservice.ErrPermissionDenied
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// authorized reports whether the caller of ctx is on allowed, and logs it
+// to logger for audit if not.
+func authorized(ctx context.Context, method string, allowed caller.Allowlist, logger log.Logger) bool {
+	id, ok := caller.FromContext(ctx)
+	if ok && allowed.Allows(id) {
+		return true
+	}
+	who := "anonymous"
+	if ok {
+		who = id.String()
+	}
+	logger.Log("audit", "denied", "method", method, "caller", who, "ip", limiter.IdentityFromContext(ctx).IP)
+	return false
+}
+
+// serviceFailed reports whether err, as returned by a service in a
+// response, is about the service rather than the request, and so counts
+// towards opening the circuit breaker of the endpoint: any error but a
+// domain error, or ErrUnavailable.
+func serviceFailed(err error) bool {
+	var e *loginservice.Error
+	return !errors.As(err, &e) || e.Code == loginservice.CodeUnavailable
+}
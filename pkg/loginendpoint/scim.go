@@ -0,0 +1,269 @@
+package loginendpoint
+
+import (
+	"context"
+
+	"loginsvc/pkg/breaker"
+	"loginsvc/pkg/caller"
+	"loginsvc/pkg/limiter"
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/scim"
+	"loginsvc/pkg/tenant"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/tracing/opentracing"
+	"github.com/go-kit/kit/tracing/zipkin"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	stdzipkin "github.com/openzipkin/zipkin-go"
+)
+
+// SCIMMethods are the names of the methods of SCIMSet, as used for
+// breakers and metrics.
+var SCIMMethods = []string{
+	"SCIMCreateUser", "SCIMGetUser", "SCIMListUsers", "SCIMReplaceUser", "SCIMPatchUser", "SCIMDeleteUser",
+	"SCIMCreateGroup", "SCIMGetGroup", "SCIMListGroups", "SCIMReplaceGroup", "SCIMPatchGroup", "SCIMDeleteGroup",
+}
+
+// SCIMSet collects the endpoints of a ProvisioningService.
+type SCIMSet struct {
+	CreateUserEndpoint   endpoint.Endpoint
+	GetUserEndpoint      endpoint.Endpoint
+	ListUsersEndpoint    endpoint.Endpoint
+	ReplaceUserEndpoint  endpoint.Endpoint
+	PatchUserEndpoint    endpoint.Endpoint
+	DeleteUserEndpoint   endpoint.Endpoint
+	CreateGroupEndpoint  endpoint.Endpoint
+	GetGroupEndpoint     endpoint.Endpoint
+	ListGroupsEndpoint   endpoint.Endpoint
+	ReplaceGroupEndpoint endpoint.Endpoint
+	PatchGroupEndpoint   endpoint.Endpoint
+	DeleteGroupEndpoint  endpoint.Endpoint
+}
+
+// SCIMTokens maps tenants to the bearer tokens of the SCIM clients allowed
+// to provision them.
+type SCIMTokens map[string]scim.Tokens
+
+// NewSCIM returns the endpoints of svc, wrapped like those of NewAdmin but
+// for the caller, which is a SCIM client authenticated by its bearer token
+// rather than a service with a client certificate. Calls to a tenant
+// without tokens fail.
+func NewSCIM(svc loginservice.ProvisioningService, lim *limiter.Limiter, breakers *breaker.Registry, tokens SCIMTokens, tenants tenant.Resolver, logger log.Logger, duration metrics.Histogram, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer) SCIMSet {
+	wrap := func(method string, e endpoint.Endpoint) endpoint.Endpoint {
+		e = breakers.Middleware(method, serviceFailed)(e)
+		e = BearerMiddleware(method, tokens, logger)(e)
+		e = RateLimitingMiddleware(lim)(e)
+		e = TenantMiddleware(tenants)(e)
+		e = opentracing.TraceServer(otTracer, method)(e)
+		if zipkinTracer != nil {
+			e = zipkin.TraceEndpoint(zipkinTracer, method)(e)
+		}
+		e = LoggingMiddleware(log.With(logger, "method", method))(e)
+		e = InstrumentingMiddleware(duration.With("method", method))(e)
+		return e
+	}
+	return SCIMSet{
+		CreateUserEndpoint:   wrap("SCIMCreateUser", MakeSCIMCreateUserEndpoint(svc)),
+		GetUserEndpoint:      wrap("SCIMGetUser", MakeSCIMGetUserEndpoint(svc)),
+		ListUsersEndpoint:    wrap("SCIMListUsers", MakeSCIMListUsersEndpoint(svc)),
+		ReplaceUserEndpoint:  wrap("SCIMReplaceUser", MakeSCIMReplaceUserEndpoint(svc)),
+		PatchUserEndpoint:    wrap("SCIMPatchUser", MakeSCIMPatchUserEndpoint(svc)),
+		DeleteUserEndpoint:   wrap("SCIMDeleteUser", makeSCIMDeleteEndpoint(svc.DeleteUser)),
+		CreateGroupEndpoint:  wrap("SCIMCreateGroup", MakeSCIMCreateGroupEndpoint(svc)),
+		GetGroupEndpoint:     wrap("SCIMGetGroup", MakeSCIMGetGroupEndpoint(svc)),
+		ListGroupsEndpoint:   wrap("SCIMListGroups", MakeSCIMListGroupsEndpoint(svc)),
+		ReplaceGroupEndpoint: wrap("SCIMReplaceGroup", MakeSCIMReplaceGroupEndpoint(svc)),
+		PatchGroupEndpoint:   wrap("SCIMPatchGroup", MakeSCIMPatchGroupEndpoint(svc)),
+		DeleteGroupEndpoint:  wrap("SCIMDeleteGroup", makeSCIMDeleteEndpoint(svc.DeleteGroup)),
+	}
+}
+
+// MakeSCIMEndpoints returns the bare endpoints of svc, without middleware.
+func MakeSCIMEndpoints(svc loginservice.ProvisioningService) SCIMSet {
+	return SCIMSet{
+		CreateUserEndpoint:   MakeSCIMCreateUserEndpoint(svc),
+		GetUserEndpoint:      MakeSCIMGetUserEndpoint(svc),
+		ListUsersEndpoint:    MakeSCIMListUsersEndpoint(svc),
+		ReplaceUserEndpoint:  MakeSCIMReplaceUserEndpoint(svc),
+		PatchUserEndpoint:    MakeSCIMPatchUserEndpoint(svc),
+		DeleteUserEndpoint:   makeSCIMDeleteEndpoint(svc.DeleteUser),
+		CreateGroupEndpoint:  MakeSCIMCreateGroupEndpoint(svc),
+		GetGroupEndpoint:     MakeSCIMGetGroupEndpoint(svc),
+		ListGroupsEndpoint:   MakeSCIMListGroupsEndpoint(svc),
+		ReplaceGroupEndpoint: MakeSCIMReplaceGroupEndpoint(svc),
+		PatchGroupEndpoint:   MakeSCIMPatchGroupEndpoint(svc),
+		DeleteGroupEndpoint:  makeSCIMDeleteEndpoint(svc.DeleteGroup),
+	}
+}
+
+// BearerMiddleware returns an endpoint middleware that only lets through
+// the calls carrying, as stored by the transport with scim.WithToken, a
+// token of the tenant resolved by TenantMiddleware. The client the token
+// belongs to is stored with caller.NewContext as the caller, with the
+// subject "scim:<name>", for the audit log. Other calls fail with
+// loginservice.ErrUnauthenticated and are logged to logger for audit.
+func BearerMiddleware(method string, tokens SCIMTokens, logger log.Logger) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			id, _ := tenant.FromContext(ctx)
+			name, ok := tokens[id].Authenticate(scim.TokenFromContext(ctx))
+			if !ok {
+				logger.Log("audit", "denied", "method", method, "tenant", id, "ip", limiter.IdentityFromContext(ctx).IP)
+				return nil, loginservice.ErrUnauthenticated
+			}
+			return next(caller.NewContext(ctx, caller.Identity{Subject: "scim:" + name}), request)
+		}
+	}
+}
+
+func MakeSCIMCreateUserEndpoint(s loginservice.ProvisioningService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SCIMUserRequest)
+		u, err := s.CreateUser(ctx, req.User)
+		return SCIMUserResponse{User: u, Err: err}, nil
+	}
+}
+
+func MakeSCIMGetUserEndpoint(s loginservice.ProvisioningService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SCIMResourceRequest)
+		u, err := s.GetUser(ctx, req.ID)
+		return SCIMUserResponse{User: u, Err: err}, nil
+	}
+}
+
+func MakeSCIMListUsersEndpoint(s loginservice.ProvisioningService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SCIMListRequest)
+		list, err := s.ListUsers(ctx, req.Query)
+		return SCIMListResponse{ListResponse: list, Err: err}, nil
+	}
+}
+
+func MakeSCIMReplaceUserEndpoint(s loginservice.ProvisioningService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SCIMUserRequest)
+		u, err := s.ReplaceUser(ctx, req.ID, req.User)
+		return SCIMUserResponse{User: u, Err: err}, nil
+	}
+}
+
+func MakeSCIMPatchUserEndpoint(s loginservice.ProvisioningService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SCIMPatchRequest)
+		u, err := s.PatchUser(ctx, req.ID, req.Patch)
+		return SCIMUserResponse{User: u, Err: err}, nil
+	}
+}
+
+func MakeSCIMCreateGroupEndpoint(s loginservice.ProvisioningService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SCIMGroupRequest)
+		g, err := s.CreateGroup(ctx, req.Group)
+		return SCIMGroupResponse{Group: g, Err: err}, nil
+	}
+}
+
+func MakeSCIMGetGroupEndpoint(s loginservice.ProvisioningService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SCIMResourceRequest)
+		g, err := s.GetGroup(ctx, req.ID)
+		return SCIMGroupResponse{Group: g, Err: err}, nil
+	}
+}
+
+func MakeSCIMListGroupsEndpoint(s loginservice.ProvisioningService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SCIMListRequest)
+		list, err := s.ListGroups(ctx, req.Query)
+		return SCIMListResponse{ListResponse: list, Err: err}, nil
+	}
+}
+
+func MakeSCIMReplaceGroupEndpoint(s loginservice.ProvisioningService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SCIMGroupRequest)
+		g, err := s.ReplaceGroup(ctx, req.ID, req.Group)
+		return SCIMGroupResponse{Group: g, Err: err}, nil
+	}
+}
+
+func MakeSCIMPatchGroupEndpoint(s loginservice.ProvisioningService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SCIMPatchRequest)
+		g, err := s.PatchGroup(ctx, req.ID, req.Patch)
+		return SCIMGroupResponse{Group: g, Err: err}, nil
+	}
+}
+
+// makeSCIMDeleteEndpoint returns the endpoint of a method deleting the
+// resource of an id.
+func makeSCIMDeleteEndpoint(method func(context.Context, string) error) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SCIMResourceRequest)
+		return SCIMDeleteResponse{Err: method(ctx, req.ID)}, nil
+	}
+}
+
+var (
+	_ endpoint.Failer = SCIMUserResponse{}
+	_ endpoint.Failer = SCIMGroupResponse{}
+	_ endpoint.Failer = SCIMListResponse{}
+	_ endpoint.Failer = SCIMDeleteResponse{}
+)
+
+// SCIMResourceRequest names the resource of the SCIM methods that take
+// nothing else.
+type SCIMResourceRequest struct {
+	ID string
+}
+
+// SCIMUserRequest is a user to create, or to replace the user ID with.
+type SCIMUserRequest struct {
+	ID   string
+	User scim.User
+}
+
+// SCIMGroupRequest is a group to create, or to replace the group ID with.
+type SCIMGroupRequest struct {
+	ID    string
+	Group scim.Group
+}
+
+type SCIMPatchRequest struct {
+	ID    string
+	Patch scim.PatchRequest
+}
+
+type SCIMListRequest struct {
+	scim.Query
+}
+
+type SCIMUserResponse struct {
+	User scim.User
+	Err  error
+}
+
+func (r SCIMUserResponse) Failed() error { return r.Err }
+
+type SCIMGroupResponse struct {
+	Group scim.Group
+	Err   error
+}
+
+func (r SCIMGroupResponse) Failed() error { return r.Err }
+
+type SCIMListResponse struct {
+	scim.ListResponse
+	Err error
+}
+
+func (r SCIMListResponse) Failed() error { return r.Err }
+
+type SCIMDeleteResponse struct {
+	Err error
+}
+
+func (r SCIMDeleteResponse) Failed() error { return r.Err }
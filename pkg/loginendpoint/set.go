@@ -2,35 +2,37 @@ package loginendpoint
 
 import (
 	"context"
-	"time"
 
+	"loginsvc/pkg/breaker"
+	"loginsvc/pkg/limiter"
 	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/tenant"
 
-	"github.com/go-kit/kit/circuitbreaker"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/metrics"
-	"github.com/go-kit/kit/ratelimit"
 	"github.com/go-kit/kit/tracing/opentracing"
 	"github.com/go-kit/kit/tracing/zipkin"
 	stdopentracing "github.com/opentracing/opentracing-go"
 	stdzipkin "github.com/openzipkin/zipkin-go"
-	"github.com/sony/gobreaker"
-	"golang.org/x/time/rate"
 )
 
 type Set struct {
-	LoginEndpoint endpoint.Endpoint
+	LoginEndpoint        endpoint.Endpoint
+	AuthenticateEndpoint endpoint.Endpoint
+	CheckEndpoint        endpoint.Endpoint
 }
 
-func New(svc loginservice.Service, logger log.Logger, duration metrics.Histogram, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer) Set {
+func New(svc loginservice.Service, lim *limiter.Limiter, breakers *breaker.Registry, allow TenantAllowlists, tenants tenant.Resolver, logger log.Logger, duration metrics.Histogram, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer) Set {
 	var loginEndpoint endpoint.Endpoint
 	{
 		loginEndpoint = MakeLoginEndpoint(svc)
-		// Sum is limited to 1 request per second with burst of 1 request.
-		// Note, rate is defined as a time interval between requests.
-		loginEndpoint = ratelimit.NewErroringLimiter(rate.NewLimiter(rate.Every(time.Second), 1))(loginEndpoint)
-		loginEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{}))(loginEndpoint)
+		loginEndpoint = breakers.Middleware("Name", serviceFailed)(loginEndpoint)
+		// Rate limiting sits outside the breaker, so that rejected requests
+		// don't count as failures.
+		loginEndpoint = RateLimitingMiddleware(lim)(loginEndpoint)
+		loginEndpoint = TenantAuthorizingMiddleware("Name", allow.open("Name"), logger)(loginEndpoint)
+		loginEndpoint = TenantMiddleware(tenants)(loginEndpoint)
 		loginEndpoint = opentracing.TraceServer(otTracer, "Name")(loginEndpoint)
 		if zipkinTracer != nil {
 			loginEndpoint = zipkin.TraceEndpoint(zipkinTracer, "Name")(loginEndpoint)
@@ -38,8 +40,38 @@ func New(svc loginservice.Service, logger log.Logger, duration metrics.Histogram
 		loginEndpoint = LoggingMiddleware(log.With(logger, "method", "Name"))(loginEndpoint)
 		loginEndpoint = InstrumentingMiddleware(duration.With("method", "Name"))(loginEndpoint)
 	}
+	var authenticateEndpoint endpoint.Endpoint
+	{
+		authenticateEndpoint = MakeAuthenticateEndpoint(svc)
+		authenticateEndpoint = breakers.Middleware("Authenticate", serviceFailed)(authenticateEndpoint)
+		authenticateEndpoint = RateLimitingMiddleware(lim)(authenticateEndpoint)
+		authenticateEndpoint = TenantAuthorizingMiddleware("Authenticate", allow.open("Authenticate"), logger)(authenticateEndpoint)
+		authenticateEndpoint = TenantMiddleware(tenants)(authenticateEndpoint)
+		authenticateEndpoint = opentracing.TraceServer(otTracer, "Authenticate")(authenticateEndpoint)
+		if zipkinTracer != nil {
+			authenticateEndpoint = zipkin.TraceEndpoint(zipkinTracer, "Authenticate")(authenticateEndpoint)
+		}
+		authenticateEndpoint = LoggingMiddleware(log.With(logger, "method", "Authenticate"))(authenticateEndpoint)
+		authenticateEndpoint = InstrumentingMiddleware(duration.With("method", "Authenticate"))(authenticateEndpoint)
+	}
+	var checkEndpoint endpoint.Endpoint
+	{
+		checkEndpoint = MakeCheckEndpoint(svc)
+		checkEndpoint = breakers.Middleware("Check", serviceFailed)(checkEndpoint)
+		checkEndpoint = RateLimitingMiddleware(lim)(checkEndpoint)
+		checkEndpoint = TenantAuthorizingMiddleware("Check", allow.open("Check"), logger)(checkEndpoint)
+		checkEndpoint = TenantMiddleware(tenants)(checkEndpoint)
+		checkEndpoint = opentracing.TraceServer(otTracer, "Check")(checkEndpoint)
+		if zipkinTracer != nil {
+			checkEndpoint = zipkin.TraceEndpoint(zipkinTracer, "Check")(checkEndpoint)
+		}
+		checkEndpoint = LoggingMiddleware(log.With(logger, "method", "Check"))(checkEndpoint)
+		checkEndpoint = InstrumentingMiddleware(duration.With("method", "Check"))(checkEndpoint)
+	}
 	return Set{
-		LoginEndpoint: loginEndpoint,
+		LoginEndpoint:        loginEndpoint,
+		AuthenticateEndpoint: authenticateEndpoint,
+		CheckEndpoint:        checkEndpoint,
 	}
 }
 
@@ -52,6 +84,24 @@ func (s Set) Name(ctx context.Context, n string) (string, error) {
 	return response.V, response.Err
 }
 
+func (s Set) Authenticate(ctx context.Context, name, password string) (string, error) {
+	resp, err := s.AuthenticateEndpoint(ctx, AuthenticateRequest{Name: name, Password: password})
+	if err != nil {
+		return "", err
+	}
+	response := resp.(AuthenticateResponse)
+	return response.SID, response.Err
+}
+
+func (s Set) Check(ctx context.Context, subject, permission, resource string) (bool, error) {
+	resp, err := s.CheckEndpoint(ctx, CheckRequest{Subject: subject, Permission: permission, Resource: resource})
+	if err != nil {
+		return false, err
+	}
+	response := resp.(CheckResponse)
+	return response.Allowed, response.Err
+}
+
 func MakeLoginEndpoint(s loginservice.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(LoginRequest)
@@ -60,8 +110,26 @@ func MakeLoginEndpoint(s loginservice.Service) endpoint.Endpoint {
 	}
 }
 
+func MakeAuthenticateEndpoint(s loginservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(AuthenticateRequest)
+		sid, err := s.Authenticate(ctx, req.Name, req.Password)
+		return AuthenticateResponse{SID: sid, Err: err}, nil
+	}
+}
+
+func MakeCheckEndpoint(s loginservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(CheckRequest)
+		allowed, err := s.Check(ctx, req.Subject, req.Permission, req.Resource)
+		return CheckResponse{Allowed: allowed, Err: err}, nil
+	}
+}
+
 var (
 	_ endpoint.Failer = LoginResponse{}
+	_ endpoint.Failer = AuthenticateResponse{}
+	_ endpoint.Failer = CheckResponse{}
 )
 
 type LoginRequest struct {
@@ -74,3 +142,28 @@ type LoginResponse struct {
 }
 
 func (r LoginResponse) Failed() error { return r.Err }
+
+type AuthenticateRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type AuthenticateResponse struct {
+	SID string `json:"sid"`
+	Err error  `json:"-"`
+}
+
+func (r AuthenticateResponse) Failed() error { return r.Err }
+
+type CheckRequest struct {
+	Subject    string `json:"subject"`
+	Permission string `json:"permission"`
+	Resource   string `json:"resource"`
+}
+
+type CheckResponse struct {
+	Allowed bool  `json:"allowed"`
+	Err     error `json:"-"`
+}
+
+func (r CheckResponse) Failed() error { return r.Err }
@@ -0,0 +1,504 @@
+package loginservice
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"loginsvc/pkg/password"
+	"loginsvc/repo"
+
+	"github.com/go-kit/kit/log"
+)
+
+// AdminService lets support staff find and fix the accounts of a tenant.
+// Every method but ListUsers returns ErrNotFound for an unknown user.
+type AdminService interface {
+	// CreateUser creates the account a, with password unless it is empty.
+	CreateUser(ctx context.Context, a Account, password string) (Account, error)
+	GetUser(ctx context.Context, name string) (Account, error)
+	// UpdateUser changes the fields of the user named u.Name that u sets.
+	UpdateUser(ctx context.Context, u AccountUpdate) (Account, error)
+	// DisableUser stops the user from authenticating, until EnableUser.
+	DisableUser(ctx context.Context, name string) (Account, error)
+	EnableUser(ctx context.Context, name string) (Account, error)
+	// DeleteUser deletes the user with its roles and group memberships.
+	DeleteUser(ctx context.Context, name string) error
+	// ForcePasswordReset removes the password of the user, who can't
+	// authenticate until a new one is set with UpdateUser.
+	ForcePasswordReset(ctx context.Context, name string) (Account, error)
+	// RevokeSessions records that the sessions the user started until now
+	// are revoked, for the services holding them to check.
+	RevokeSessions(ctx context.Context, name string) (Account, error)
+	// ResetMFA removes the TOTP secret of the user, who has to enroll
+	// again.
+	ResetMFA(ctx context.Context, name string) (Account, error)
+	ListUsers(ctx context.Context, q UserQuery) (AccountPage, error)
+	// AuditEvents lists the audit log of the tenant, oldest first unless
+	// q.Descending; see package audit.
+	AuditEvents(ctx context.Context, q AuditQuery) (AuditPage, error)
+
+	// CreateWebhook subscribes w.URL to the identity events of the tenant
+	// of the types w.EventTypes lists, or of every type; see package
+	// webhook. A secret is generated unless w.Secret is set, and the
+	// returned Webhook is the only one that holds it.
+	CreateWebhook(ctx context.Context, w Webhook) (Webhook, error)
+	ListWebhooks(ctx context.Context) ([]Webhook, error)
+	// DeleteWebhook deletes the subscription and its pending deliveries,
+	// or returns ErrNotFound.
+	DeleteWebhook(ctx context.Context, id int64) error
+	// RedeliverWebhook retries the dead deliveries of the subscription and
+	// returns how many there were, or returns ErrNotFound.
+	RedeliverWebhook(ctx context.Context, id int64) (int, error)
+}
+
+// Account is a user as support staff see it, without its secrets.
+type Account struct {
+	Name  string `json:"name"`
+	SID   string `json:"sid"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+	// Status is "active" or "disabled".
+	Status string `json:"status"`
+	// Created is zero for users created before it was recorded, and
+	// SessionsRevoked if they were never revoked.
+	Created         time.Time `json:"created"`
+	SessionsRevoked time.Time `json:"sessions_revoked"`
+	HasPassword     bool      `json:"has_password"`
+	HasMFA          bool      `json:"has_mfa"`
+}
+
+// AccountUpdate names a user and the fields of its account to change. Nil
+// fields are left alone.
+type AccountUpdate struct {
+	Name     string  `json:"name"`
+	Email    *string `json:"email,omitempty"`
+	Phone    *string `json:"phone,omitempty"`
+	Password *string `json:"password,omitempty"`
+}
+
+// MaxPageSize bounds UserQuery.PageSize.
+const MaxPageSize = 500
+
+// UserQuery filters, sorts and pages the users listed by ListUsers; see
+// repo.UserQuery. Zero fields don't filter.
+type UserQuery struct {
+	Status      string    `json:"status"`
+	CreatedFrom time.Time `json:"created_from"`
+	CreatedTo   time.Time `json:"created_to"`
+	Role        string    `json:"role"`
+	NamePrefix  string    `json:"name_prefix"`
+	// Email matches the start of the address, ignoring case.
+	Email string `json:"email"`
+	SID   string `json:"sid"`
+	// Sort is "name", the default, or "created".
+	Sort       string `json:"sort"`
+	Descending bool   `json:"descending"`
+	// PageToken is the NextPageToken of the previous page of the same
+	// query.
+	PageToken string `json:"page_token"`
+	// Offset skips that many accounts at the start of the page.
+	Offset int `json:"offset"`
+	// PageSize is repo.DefaultPageSize if zero, and at most MaxPageSize.
+	PageSize int `json:"page_size"`
+	// CountTotal fills AccountPage.Total.
+	CountTotal bool `json:"count_total"`
+}
+
+// AccountPage is a page of accounts. NextPageToken is empty after the last
+// page. Total is the number of accounts the query selects, across pages,
+// if it asked for it.
+type AccountPage struct {
+	Accounts      []Account `json:"accounts"`
+	NextPageToken string    `json:"next_page_token"`
+	Total         int       `json:"total,omitempty"`
+}
+
+// AuditQuery filters and pages the events listed by AuditEvents. Zero
+// fields don't filter.
+type AuditQuery struct {
+	// From is inclusive, To exclusive.
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Type    string    `json:"type"`
+	Actor   string    `json:"actor"`
+	Subject string    `json:"subject"`
+	// Descending lists the newest events first.
+	Descending bool `json:"descending"`
+	// PageToken is the NextPageToken of the previous page of the same
+	// query.
+	PageToken string `json:"page_token"`
+	// PageSize is repo.DefaultPageSize if zero, and at most MaxPageSize.
+	PageSize int `json:"page_size"`
+}
+
+// AuditPage is a page of audit events. NextPageToken is empty after the
+// last page.
+type AuditPage struct {
+	Events        []repo.AuditEvent `json:"events"`
+	NextPageToken string            `json:"next_page_token"`
+}
+
+// Webhook is a webhook subscription as admins see it.
+type Webhook struct {
+	ID         int64    `json:"id"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	// Secret is empty but when created.
+	Secret  string    `json:"secret,omitempty"`
+	Created time.Time `json:"created"`
+	// Pending and Dead count the deliveries to the endpoint.
+	Pending int `json:"pending"`
+	Dead    int `json:"dead"`
+}
+
+// NewAdmin returns an AdminService for the users of r, managed through
+// admin, for the audit log events and for the webhook subscriptions subs,
+// with logging. New passwords are hashed with hashers.
+func NewAdmin(r repo.LoginRepository, admin repo.UserAdmin, events repo.AuditLog, subs repo.SubscriptionRepository, hashers *password.Registry, logger log.Logger) AdminService {
+	var svc AdminService
+	{
+		svc = NewBasicAdminService(r, admin, events, subs, hashers)
+		svc = AdminLoggingMiddleware(logger)(svc)
+	}
+	return svc
+}
+
+// NewBasicAdminService returns an AdminService without logging.
+func NewBasicAdminService(r repo.LoginRepository, admin repo.UserAdmin, events repo.AuditLog, subs repo.SubscriptionRepository, hashers *password.Registry) AdminService {
+	return basicAdminService{repo: r, admin: admin, events: events, subs: subs, hashers: hashers}
+}
+
+type basicAdminService struct {
+	repo    repo.LoginRepository
+	admin   repo.UserAdmin
+	events  repo.AuditLog
+	subs    repo.SubscriptionRepository
+	hashers *password.Registry
+}
+
+func (s basicAdminService) CreateUser(ctx context.Context, a Account, pw string) (Account, error) {
+	var violations []FieldViolation
+	if a.Name == "" {
+		violations = append(violations, FieldViolation{Field: "name", Description: "is required"})
+	}
+	if a.SID == "" {
+		violations = append(violations, FieldViolation{Field: "sid", Description: "is required"})
+	}
+	if len(violations) > 0 {
+		return Account{}, NewInvalidArgument(violations...)
+	}
+	if _, err := s.repo.User(a.Name); err == nil {
+		return Account{}, ErrAlreadyExists
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return Account{}, err
+	}
+	u := repo.User{Name: a.Name, SID: a.SID, Email: a.Email, Phone: a.Phone}
+	if pw != "" {
+		hash, err := s.hashers.Hash(pw)
+		if err != nil {
+			return Account{}, err
+		}
+		u.PasswordHash = hash
+	}
+	if err := s.repo.Register(u); err != nil {
+		return Account{}, err
+	}
+	return s.GetUser(ctx, a.Name)
+}
+
+func (s basicAdminService) GetUser(_ context.Context, name string) (Account, error) {
+	u, err := s.user(name)
+	if err != nil {
+		return Account{}, err
+	}
+	return newAccount(u), nil
+}
+
+func (s basicAdminService) UpdateUser(ctx context.Context, up AccountUpdate) (Account, error) {
+	u, err := s.user(up.Name)
+	if err != nil {
+		return Account{}, err
+	}
+	if up.Password != nil && *up.Password == "" {
+		return Account{}, NewInvalidArgument(FieldViolation{Field: "password", Description: "must not be empty; use ForcePasswordReset to remove it"})
+	}
+	if up.Email != nil || up.Phone != nil {
+		if up.Email != nil {
+			u.Email = *up.Email
+		}
+		if up.Phone != nil {
+			u.Phone = *up.Phone
+		}
+		if err := s.repo.UpdateUser(u); err != nil {
+			return Account{}, err
+		}
+	}
+	if up.Password != nil {
+		hash, err := s.hashers.Hash(*up.Password)
+		if err != nil {
+			return Account{}, err
+		}
+		if err := s.admin.ChangePassword(u.Name, hash); err != nil {
+			return Account{}, err
+		}
+	}
+	return s.GetUser(ctx, u.Name)
+}
+
+func (s basicAdminService) DisableUser(ctx context.Context, name string) (Account, error) {
+	return s.write(ctx, name, func(repo.User) error { return s.admin.SetStatus(name, repo.StatusDisabled) })
+}
+
+func (s basicAdminService) EnableUser(ctx context.Context, name string) (Account, error) {
+	return s.write(ctx, name, func(repo.User) error { return s.admin.SetStatus(name, repo.StatusActive) })
+}
+
+func (s basicAdminService) DeleteUser(_ context.Context, name string) error {
+	if _, err := s.user(name); err != nil {
+		return err
+	}
+	return s.admin.DeleteUser(name)
+}
+
+func (s basicAdminService) ForcePasswordReset(ctx context.Context, name string) (Account, error) {
+	return s.write(ctx, name, func(repo.User) error { return s.admin.ChangePassword(name, "") })
+}
+
+func (s basicAdminService) RevokeSessions(ctx context.Context, name string) (Account, error) {
+	return s.write(ctx, name, func(repo.User) error { return s.admin.RevokeSessions(name, time.Now()) })
+}
+
+func (s basicAdminService) ResetMFA(ctx context.Context, name string) (Account, error) {
+	return s.write(ctx, name, func(u repo.User) error {
+		u.TOTPSecret = ""
+		return s.repo.UpdateUser(u)
+	})
+}
+
+func (s basicAdminService) ListUsers(_ context.Context, q UserQuery) (AccountPage, error) {
+	var violations []FieldViolation
+	switch repo.Status(q.Status) {
+	case "", repo.StatusActive, repo.StatusDisabled:
+	default:
+		violations = append(violations, FieldViolation{Field: "status", Description: "must be active or disabled"})
+	}
+	switch repo.UserSort(q.Sort) {
+	case "", repo.SortByName, repo.SortByCreated:
+	default:
+		violations = append(violations, FieldViolation{Field: "sort", Description: "must be name or created"})
+	}
+	if !q.CreatedFrom.IsZero() && !q.CreatedTo.IsZero() && !q.CreatedTo.After(q.CreatedFrom) {
+		violations = append(violations, FieldViolation{Field: "created_to", Description: "must be after created_from"})
+	}
+	if q.Offset < 0 {
+		violations = append(violations, FieldViolation{Field: "offset", Description: "must not be negative"})
+	}
+	if q.PageSize < 0 || q.PageSize > MaxPageSize {
+		violations = append(violations, FieldViolation{Field: "page_size", Description: "must be between 0 and 500"})
+	}
+	if len(violations) > 0 {
+		return AccountPage{}, NewInvalidArgument(violations...)
+	}
+	page, err := s.admin.ListUsers(repo.UserQuery{
+		Status:      repo.Status(q.Status),
+		CreatedFrom: q.CreatedFrom,
+		CreatedTo:   q.CreatedTo,
+		Role:        q.Role,
+		NamePrefix:  q.NamePrefix,
+		EmailPrefix: q.Email,
+		SID:         q.SID,
+		Sort:        repo.UserSort(q.Sort),
+		Desc:        q.Descending,
+		Cursor:      q.PageToken,
+		Offset:      q.Offset,
+		Limit:       q.PageSize,
+		CountTotal:  q.CountTotal,
+	})
+	switch {
+	case errors.Is(err, repo.ErrInvalidCursor):
+		return AccountPage{}, NewInvalidArgument(FieldViolation{Field: "page_token", Description: "is not from a previous page"})
+	case errors.Is(err, repo.ErrNoKeyring):
+		// Without a keyring no user can have an email address.
+		return AccountPage{Accounts: []Account{}}, nil
+	case err != nil:
+		return AccountPage{}, err
+	}
+	accounts := make([]Account, len(page.Users))
+	for i, u := range page.Users {
+		accounts[i] = newAccount(u)
+	}
+	return AccountPage{Accounts: accounts, NextPageToken: page.Next, Total: page.Total}, nil
+}
+
+// AuditEvents pages by Seq: the page token is that of the last event of
+// the previous page.
+func (s basicAdminService) AuditEvents(_ context.Context, q AuditQuery) (AuditPage, error) {
+	var violations []FieldViolation
+	if !q.From.IsZero() && !q.To.IsZero() && !q.To.After(q.From) {
+		violations = append(violations, FieldViolation{Field: "to", Description: "must be after from"})
+	}
+	if q.PageSize < 0 || q.PageSize > MaxPageSize {
+		violations = append(violations, FieldViolation{Field: "page_size", Description: "must be between 0 and 500"})
+	}
+	var after int64
+	if q.PageToken != "" {
+		n, err := strconv.ParseInt(q.PageToken, 10, 64)
+		if err != nil || n <= 0 {
+			violations = append(violations, FieldViolation{Field: "page_token", Description: "is not from a previous page"})
+		}
+		after = n
+	}
+	if len(violations) > 0 {
+		return AuditPage{}, NewInvalidArgument(violations...)
+	}
+	limit := q.PageSize
+	if limit == 0 {
+		limit = repo.DefaultPageSize
+	}
+	events, err := s.events.AuditEvents(repo.AuditQuery{
+		From:     q.From,
+		To:       q.To,
+		Type:     q.Type,
+		Actor:    q.Actor,
+		Subject:  q.Subject,
+		AfterSeq: after,
+		Desc:     q.Descending,
+		Limit:    limit + 1,
+	})
+	if err != nil {
+		return AuditPage{}, err
+	}
+	page := AuditPage{Events: events}
+	if len(events) > limit {
+		page.Events = events[:limit]
+		page.NextPageToken = strconv.FormatInt(events[limit-1].Seq, 10)
+	}
+	if page.Events == nil {
+		page.Events = []repo.AuditEvent{}
+	}
+	return page, nil
+}
+
+func (s basicAdminService) CreateWebhook(_ context.Context, w Webhook) (Webhook, error) {
+	var violations []FieldViolation
+	if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		violations = append(violations, FieldViolation{Field: "url", Description: "must be an http or https URL"})
+	}
+	for _, t := range w.EventTypes {
+		if !knownEventType(t) {
+			violations = append(violations, FieldViolation{Field: "event_types", Description: "has unknown type " + strconv.Quote(t)})
+		}
+	}
+	if len(violations) > 0 {
+		return Webhook{}, NewInvalidArgument(violations...)
+	}
+	if w.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return Webhook{}, err
+		}
+		w.Secret = hex.EncodeToString(b)
+	}
+	sub, err := s.subs.CreateSubscription(repo.Subscription{URL: w.URL, Secret: w.Secret, EventTypes: w.EventTypes})
+	if err != nil {
+		return Webhook{}, err
+	}
+	created := newWebhook(sub)
+	created.Secret = sub.Secret
+	return created, nil
+}
+
+func (s basicAdminService) ListWebhooks(context.Context) ([]Webhook, error) {
+	subs, err := s.subs.Subscriptions()
+	if err != nil {
+		return nil, err
+	}
+	webhooks := make([]Webhook, len(subs))
+	for i, sub := range subs {
+		webhooks[i] = newWebhook(sub)
+	}
+	return webhooks, nil
+}
+
+func (s basicAdminService) DeleteWebhook(_ context.Context, id int64) error {
+	err := s.subs.DeleteSubscription(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s basicAdminService) RedeliverWebhook(ctx context.Context, id int64) (int, error) {
+	webhooks, err := s.ListWebhooks(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, w := range webhooks {
+		if w.ID == id {
+			return s.subs.RedeliverDead(id)
+		}
+	}
+	return 0, ErrNotFound
+}
+
+func knownEventType(t string) bool {
+	for _, known := range repo.EventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+func newWebhook(s repo.Subscription) Webhook {
+	types := s.EventTypes
+	if types == nil {
+		types = []string{}
+	}
+	return Webhook{ID: s.ID, URL: s.URL, EventTypes: types, Created: s.Created, Pending: s.Pending, Dead: s.Dead}
+}
+
+// user returns the user named name, or ErrNotFound.
+func (s basicAdminService) user(name string) (repo.User, error) {
+	if name == "" {
+		return repo.User{}, NewInvalidArgument(FieldViolation{Field: "name", Description: "is required"})
+	}
+	u, err := s.repo.User(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repo.User{}, ErrNotFound
+	}
+	return u, err
+}
+
+// write applies fn to the user named name and returns its account
+// afterwards.
+func (s basicAdminService) write(ctx context.Context, name string, fn func(repo.User) error) (Account, error) {
+	u, err := s.user(name)
+	if err != nil {
+		return Account{}, err
+	}
+	if err := fn(u); err != nil {
+		return Account{}, err
+	}
+	return s.GetUser(ctx, name)
+}
+
+func newAccount(u repo.User) Account {
+	return Account{
+		Name:            u.Name,
+		SID:             u.SID,
+		Email:           u.Email,
+		Phone:           u.Phone,
+		Status:          string(u.Status),
+		Created:         u.Created,
+		SessionsRevoked: u.SessionsRevoked,
+		HasPassword:     u.PasswordHash != "",
+		HasMFA:          u.TOTPSecret != "",
+	}
+}
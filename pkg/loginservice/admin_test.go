@@ -0,0 +1,214 @@
+package loginservice_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"loginsvc/pkg/audit"
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/password"
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// adminRepo keeps users, audit events and webhook subscriptions in memory
+// for the admin service.
+type adminRepo struct {
+	userRepo
+	*audit.MemoryLog
+	query repo.UserQuery
+	subs  []repo.Subscription
+}
+
+func (r *adminRepo) Register(u repo.User) error {
+	u.Status, u.Created = repo.StatusActive, time.Unix(1600000000, 0)
+	r.users[u.Name] = u
+	return nil
+}
+
+func (r *adminRepo) UpdateUser(u repo.User) error {
+	old := r.users[u.Name]
+	old.Email, old.Phone, old.TOTPSecret = u.Email, u.Phone, u.TOTPSecret
+	r.users[u.Name] = old
+	return nil
+}
+
+func (r *adminRepo) SetStatus(n string, s repo.Status) error {
+	u := r.users[n]
+	u.Status = s
+	r.users[n] = u
+	return nil
+}
+
+func (r *adminRepo) RevokeSessions(n string, at time.Time) error {
+	u := r.users[n]
+	u.SessionsRevoked = at
+	r.users[n] = u
+	return nil
+}
+
+func (r *adminRepo) ChangePassword(n, hash string) error {
+	return r.SetPasswordHash(n, hash)
+}
+
+func (r *adminRepo) DeleteUser(n string) error {
+	delete(r.users, n)
+	return nil
+}
+
+func (r *adminRepo) ListUsers(q repo.UserQuery) (repo.UserPage, error) {
+	r.query = q
+	if q.Cursor == "bad" {
+		return repo.UserPage{}, repo.ErrInvalidCursor
+	}
+	return repo.UserPage{Users: []repo.User{r.users["al"]}, Next: "next"}, nil
+}
+
+func (r *adminRepo) CreateSubscription(s repo.Subscription) (repo.Subscription, error) {
+	s.ID = int64(len(r.subs) + 1)
+	r.subs = append(r.subs, s)
+	return s, nil
+}
+
+func (r *adminRepo) Subscriptions() ([]repo.Subscription, error) {
+	return r.subs, nil
+}
+
+func (r *adminRepo) DeleteSubscription(id int64) error {
+	for i, s := range r.subs {
+		if s.ID == id {
+			r.subs = append(r.subs[:i], r.subs[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (r *adminRepo) RedeliverDead(id int64) (int, error) {
+	return 2, nil
+}
+
+func newAdminService() (loginservice.AdminService, *adminRepo) {
+	r := &adminRepo{userRepo: userRepo{users: map[string]repo.User{
+		"al": {Name: "al", SID: "c111111111", TOTPSecret: "JBSWY3DPEHPK3PXP", PasswordHash: "$1$3azHgidD$SrJPt7B.9rekpmwJwtON31", Status: repo.StatusActive},
+	}}, MemoryLog: audit.NewMemoryLog()}
+	hashers := password.NewRegistry("2b")
+	hashers.Register(password.NewBcrypt(4), "2a", "2b", "2y")
+	return loginservice.NewBasicAdminService(r, r, r, r, hashers), r
+}
+
+func TestAdminLifecycle(t *testing.T) {
+	svc, r := newAdminService()
+	ctx := context.Background()
+
+	a, err := svc.CreateUser(ctx, loginservice.Account{Name: "bo", SID: "d222222222", Email: "bo@example.com"}, "s3cret")
+	assert.NoError(t, err)
+	assert.Equal(t, "active", a.Status)
+	assert.True(t, a.HasPassword)
+	assert.False(t, a.HasMFA)
+	_, err = svc.CreateUser(ctx, loginservice.Account{Name: "bo", SID: "d333333333"}, "")
+	assert.Equal(t, loginservice.ErrAlreadyExists, err)
+	_, err = svc.CreateUser(ctx, loginservice.Account{}, "")
+	assert.True(t, errors.Is(err, loginservice.ErrInvalidArgument))
+
+	phone := "+886912345678"
+	a, err = svc.UpdateUser(ctx, loginservice.AccountUpdate{Name: "bo", Phone: &phone})
+	assert.NoError(t, err)
+	assert.Equal(t, "bo@example.com", a.Email)
+	assert.Equal(t, phone, a.Phone)
+
+	a, err = svc.DisableUser(ctx, "bo")
+	assert.NoError(t, err)
+	assert.Equal(t, "disabled", a.Status)
+	a, err = svc.EnableUser(ctx, "bo")
+	assert.NoError(t, err)
+	assert.Equal(t, "active", a.Status)
+
+	a, err = svc.ForcePasswordReset(ctx, "bo")
+	assert.NoError(t, err)
+	assert.False(t, a.HasPassword)
+	pw := "n3w"
+	a, err = svc.UpdateUser(ctx, loginservice.AccountUpdate{Name: "bo", Password: &pw})
+	assert.NoError(t, err)
+	assert.True(t, a.HasPassword)
+	ok, _ := password.NewBcrypt(4).Verify(r.users["bo"].PasswordHash, "n3w")
+	assert.True(t, ok)
+
+	a, err = svc.ResetMFA(ctx, "al")
+	assert.NoError(t, err)
+	assert.False(t, a.HasMFA)
+	assert.True(t, a.HasPassword)
+	a, err = svc.RevokeSessions(ctx, "al")
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), a.SessionsRevoked, time.Minute)
+
+	assert.NoError(t, svc.DeleteUser(ctx, "bo"))
+	_, err = svc.GetUser(ctx, "bo")
+	assert.Equal(t, loginservice.ErrNotFound, err)
+	assert.Equal(t, loginservice.ErrNotFound, svc.DeleteUser(ctx, "bo"))
+	_, err = svc.DisableUser(ctx, "bo")
+	assert.Equal(t, loginservice.ErrNotFound, err)
+	_, err = r.User("bo")
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+func TestAdminListUsers(t *testing.T) {
+	svc, r := newAdminService()
+	ctx := context.Background()
+	from := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	page, err := svc.ListUsers(ctx, loginservice.UserQuery{Status: "active", CreatedFrom: from, Role: "reader", NamePrefix: "a", Sort: "created", Descending: true, PageToken: "cur", PageSize: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, repo.UserQuery{Status: repo.StatusActive, CreatedFrom: from, Role: "reader", NamePrefix: "a", Sort: repo.SortByCreated, Desc: true, Cursor: "cur", Limit: 10}, r.query)
+	assert.Equal(t, "next", page.NextPageToken)
+	assert.Equal(t, []string{"al"}, []string{page.Accounts[0].Name})
+
+	for _, q := range []loginservice.UserQuery{
+		{Status: "locked"},
+		{Sort: "email"},
+		{CreatedFrom: from, CreatedTo: from},
+		{PageSize: loginservice.MaxPageSize + 1},
+		{PageToken: "bad"},
+	} {
+		_, err := svc.ListUsers(ctx, q)
+		assert.True(t, errors.Is(err, loginservice.ErrInvalidArgument), "%+v: %v", q, err)
+	}
+}
+
+func TestAdminWebhooks(t *testing.T) {
+	svc, r := newAdminService()
+	ctx := context.Background()
+
+	w, err := svc.CreateWebhook(ctx, loginservice.Webhook{URL: "https://hooks.example.com/identity", EventTypes: []string{repo.EventUserLocked}})
+	assert.NoError(t, err)
+	assert.Len(t, w.Secret, 64)
+	assert.Equal(t, w.Secret, r.subs[0].Secret)
+	for _, bad := range []loginservice.Webhook{
+		{URL: "ftp://hooks.example.com/"},
+		{URL: "https:///identity"},
+		{URL: "https://hooks.example.com/", EventTypes: []string{"user.deleted"}},
+	} {
+		_, err := svc.CreateWebhook(ctx, bad)
+		assert.True(t, errors.Is(err, loginservice.ErrInvalidArgument), "%+v: %v", bad, err)
+	}
+
+	// The secret is only ever returned on creation.
+	webhooks, err := svc.ListWebhooks(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, webhooks, 1) {
+		assert.Empty(t, webhooks[0].Secret)
+		assert.Equal(t, []string{repo.EventUserLocked}, webhooks[0].EventTypes)
+	}
+
+	n, err := svc.RedeliverWebhook(ctx, w.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	_, err = svc.RedeliverWebhook(ctx, w.ID+1)
+	assert.Equal(t, loginservice.ErrNotFound, err)
+	assert.NoError(t, svc.DeleteWebhook(ctx, w.ID))
+	assert.Equal(t, loginservice.ErrNotFound, svc.DeleteWebhook(ctx, w.ID))
+}
@@ -0,0 +1,209 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"loginsvc/pkg/audit"
+	"loginsvc/pkg/caller"
+	"loginsvc/pkg/limiter"
+	"loginsvc/repo"
+)
+
+// AuditingMiddleware returns a service middleware that records every
+// authentication in the audit log of rec. Name and Check only read, and
+// aren't recorded. A call returns once its event is recorded, even if its
+// caller went away meanwhile, so a failing audit log holds calls up rather
+// than losing their events.
+func AuditingMiddleware(rec *audit.Recorder) Middleware {
+	return func(next Service) Service {
+		return auditingMiddleware{auditor{rec}, next}
+	}
+}
+
+type auditingMiddleware struct {
+	auditor
+	next Service
+}
+
+func (mw auditingMiddleware) Name(ctx context.Context, n string) (string, error) {
+	return mw.next.Name(ctx, n)
+}
+
+func (mw auditingMiddleware) Authenticate(ctx context.Context, name, password string) (string, error) {
+	sid, err := mw.next.Authenticate(ctx, name, password)
+	mw.record(ctx, audit.TypeLogin, name, "", err)
+	return sid, err
+}
+
+func (mw auditingMiddleware) Check(ctx context.Context, subject, permission, resource string) (bool, error) {
+	return mw.next.Check(ctx, subject, permission, resource)
+}
+
+// AdminAuditingMiddleware returns an AdminService middleware that records
+// every call, reads included, in the audit log of rec, as
+// AuditingMiddleware does. A new password set by UpdateUser is also
+// recorded as a password change.
+func AdminAuditingMiddleware(rec *audit.Recorder) AdminMiddleware {
+	return func(next AdminService) AdminService {
+		return adminAuditingMiddleware{auditor{rec}, next}
+	}
+}
+
+type adminAuditingMiddleware struct {
+	auditor
+	next AdminService
+}
+
+func (mw adminAuditingMiddleware) CreateUser(ctx context.Context, a Account, password string) (Account, error) {
+	acc, err := mw.next.CreateUser(ctx, a, password)
+	mw.record(ctx, audit.AdminType("CreateUser"), a.Name, "", err)
+	return acc, err
+}
+
+func (mw adminAuditingMiddleware) GetUser(ctx context.Context, name string) (Account, error) {
+	acc, err := mw.next.GetUser(ctx, name)
+	mw.record(ctx, audit.AdminType("GetUser"), name, "", err)
+	return acc, err
+}
+
+// UpdateUser records the names of the fields changed, not their values.
+func (mw adminAuditingMiddleware) UpdateUser(ctx context.Context, u AccountUpdate) (Account, error) {
+	acc, err := mw.next.UpdateUser(ctx, u)
+	var fields []string
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{{"email", u.Email != nil}, {"phone", u.Phone != nil}, {"password", u.Password != nil}} {
+		if f.set {
+			fields = append(fields, f.name)
+		}
+	}
+	mw.record(ctx, audit.AdminType("UpdateUser"), u.Name, strings.Join(fields, ","), err)
+	if u.Password != nil && err == nil {
+		mw.record(ctx, audit.TypePasswordChange, u.Name, "", nil)
+	}
+	return acc, err
+}
+
+func (mw adminAuditingMiddleware) DisableUser(ctx context.Context, name string) (Account, error) {
+	acc, err := mw.next.DisableUser(ctx, name)
+	mw.record(ctx, audit.AdminType("DisableUser"), name, "", err)
+	return acc, err
+}
+
+func (mw adminAuditingMiddleware) EnableUser(ctx context.Context, name string) (Account, error) {
+	acc, err := mw.next.EnableUser(ctx, name)
+	mw.record(ctx, audit.AdminType("EnableUser"), name, "", err)
+	return acc, err
+}
+
+func (mw adminAuditingMiddleware) DeleteUser(ctx context.Context, name string) error {
+	err := mw.next.DeleteUser(ctx, name)
+	mw.record(ctx, audit.AdminType("DeleteUser"), name, "", err)
+	return err
+}
+
+func (mw adminAuditingMiddleware) ForcePasswordReset(ctx context.Context, name string) (Account, error) {
+	acc, err := mw.next.ForcePasswordReset(ctx, name)
+	mw.record(ctx, audit.AdminType("ForcePasswordReset"), name, "", err)
+	return acc, err
+}
+
+func (mw adminAuditingMiddleware) RevokeSessions(ctx context.Context, name string) (Account, error) {
+	acc, err := mw.next.RevokeSessions(ctx, name)
+	mw.record(ctx, audit.AdminType("RevokeSessions"), name, "", err)
+	return acc, err
+}
+
+func (mw adminAuditingMiddleware) ResetMFA(ctx context.Context, name string) (Account, error) {
+	acc, err := mw.next.ResetMFA(ctx, name)
+	mw.record(ctx, audit.AdminType("ResetMFA"), name, "", err)
+	return acc, err
+}
+
+func (mw adminAuditingMiddleware) ListUsers(ctx context.Context, q UserQuery) (AccountPage, error) {
+	page, err := mw.next.ListUsers(ctx, q)
+	mw.record(ctx, audit.AdminType("ListUsers"), "", "", err)
+	return page, err
+}
+
+func (mw adminAuditingMiddleware) AuditEvents(ctx context.Context, q AuditQuery) (AuditPage, error) {
+	page, err := mw.next.AuditEvents(ctx, q)
+	mw.record(ctx, audit.AdminType("AuditEvents"), q.Subject, "", err)
+	return page, err
+}
+
+// CreateWebhook records the URL subscribed, never the secret.
+func (mw adminAuditingMiddleware) CreateWebhook(ctx context.Context, w Webhook) (Webhook, error) {
+	created, err := mw.next.CreateWebhook(ctx, w)
+	mw.record(ctx, audit.AdminType("CreateWebhook"), "", w.URL, err)
+	return created, err
+}
+
+func (mw adminAuditingMiddleware) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	webhooks, err := mw.next.ListWebhooks(ctx)
+	mw.record(ctx, audit.AdminType("ListWebhooks"), "", "", err)
+	return webhooks, err
+}
+
+func (mw adminAuditingMiddleware) DeleteWebhook(ctx context.Context, id int64) error {
+	err := mw.next.DeleteWebhook(ctx, id)
+	mw.record(ctx, audit.AdminType("DeleteWebhook"), "", strconv.FormatInt(id, 10), err)
+	return err
+}
+
+func (mw adminAuditingMiddleware) RedeliverWebhook(ctx context.Context, id int64) (int, error) {
+	n, err := mw.next.RedeliverWebhook(ctx, id)
+	mw.record(ctx, audit.AdminType("RedeliverWebhook"), "", strconv.FormatInt(id, 10), err)
+	return n, err
+}
+
+// auditor records the events of the auditing middlewares.
+type auditor struct {
+	rec *audit.Recorder
+}
+
+// record records an event of typ about subject, by the caller of ctx. The
+// event's detail is err if the call failed.
+func (a auditor) record(ctx context.Context, typ, subject, detail string, err error) {
+	e := repo.AuditEvent{
+		Type:    typ,
+		Actor:   callerName(ctx),
+		Subject: subject,
+		IP:      limiter.IdentityFromContext(ctx).IP,
+		Outcome: outcome(err),
+		Detail:  detail,
+	}
+	if err != nil {
+		e.Detail = err.Error()
+	}
+	// Record only gives up once its context is done, which this one never
+	// is: the event is recorded whatever becomes of the call.
+	a.rec.Record(context.Background(), e)
+}
+
+// outcome classifies the result of a call for the audit log.
+func outcome(err error) string {
+	var e *Error
+	switch {
+	case err == nil:
+		return audit.OutcomeSuccess
+	case errors.Is(err, ErrLocked):
+		return audit.OutcomeLocked
+	case errors.As(err, &e) && e.Code != CodeUnavailable:
+		return audit.OutcomeFailure
+	}
+	return audit.OutcomeError
+}
+
+// callerName names the caller of ctx, as identified by its client
+// certificate, or "anonymous".
+func callerName(ctx context.Context) string {
+	if id, ok := caller.FromContext(ctx); ok {
+		return id.String()
+	}
+	return "anonymous"
+}
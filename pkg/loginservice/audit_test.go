@@ -0,0 +1,92 @@
+package loginservice_test
+
+import (
+	"context"
+	"testing"
+
+	"loginsvc/pkg/audit"
+	"loginsvc/pkg/caller"
+	"loginsvc/pkg/limiter"
+	"loginsvc/pkg/loginservice"
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// summary reduces the events of log to type, actor, subject and outcome.
+func summary(t *testing.T, log repo.AuditLog) [][4]string {
+	events, err := log.AuditEvents(repo.AuditQuery{})
+	assert.NoError(t, err)
+	var s [][4]string
+	for _, e := range events {
+		s = append(s, [4]string{e.Type, e.Actor, e.Subject, e.Outcome})
+	}
+	return s
+}
+
+func TestAuditingMiddleware(t *testing.T) {
+	next, r, _ := newService()
+	al := r.users["al"]
+	events := audit.NewMemoryLog()
+	svc := loginservice.AuditingMiddleware(audit.NewRecorder(events, nil))(next)
+	ctx := limiter.NewContext(context.Background(), "10.0.0.1", "")
+	ctx = caller.NewContext(ctx, caller.Identity{SPIFFEID: "spiffe://example.org/web"})
+
+	svc.Authenticate(ctx, "al", "password")
+	svc.Authenticate(ctx, "al", "Password")
+	al.Status = repo.StatusDisabled
+	r.users["al"] = al
+	svc.Authenticate(ctx, "al", "password")
+	svc.Authenticate(context.Background(), "", "")
+	svc.Check(ctx, "al", "orders.read", "orders/1")
+
+	web := "spiffe://example.org/web"
+	assert.Equal(t, [][4]string{
+		{audit.TypeLogin, web, "al", audit.OutcomeSuccess},
+		{audit.TypeLogin, web, "al", audit.OutcomeFailure},
+		{audit.TypeLogin, web, "al", audit.OutcomeLocked},
+		{audit.TypeLogin, "anonymous", "", audit.OutcomeFailure},
+	}, summary(t, events))
+	last, _ := events.LastAuditEvent()
+	assert.Equal(t, "name is required; password is required", last.Detail)
+	first, _ := events.AuditEvents(repo.AuditQuery{Limit: 1})
+	assert.Equal(t, "10.0.0.1", first[0].IP)
+	_, err := audit.Verify(events)
+	assert.NoError(t, err)
+}
+
+func TestAdminAuditingMiddleware(t *testing.T) {
+	next, r := newAdminService()
+	svc := loginservice.AdminAuditingMiddleware(audit.NewRecorder(r, nil))(next)
+	ctx := caller.NewContext(context.Background(), caller.Identity{Subject: "CN=support"})
+
+	phone, pw := "+886912345678", "n3w"
+	svc.UpdateUser(ctx, loginservice.AccountUpdate{Name: "al", Phone: &phone, Password: &pw})
+	svc.DisableUser(ctx, "cy")
+	svc.ListUsers(ctx, loginservice.UserQuery{})
+
+	assert.Equal(t, [][4]string{
+		{audit.AdminType("UpdateUser"), "CN=support", "al", audit.OutcomeSuccess},
+		{audit.TypePasswordChange, "CN=support", "al", audit.OutcomeSuccess},
+		{audit.AdminType("DisableUser"), "CN=support", "cy", audit.OutcomeFailure},
+		{audit.AdminType("ListUsers"), "CN=support", "", audit.OutcomeSuccess},
+	}, summary(t, r))
+	events, _ := r.AuditEvents(repo.AuditQuery{Limit: 1})
+	// Changed fields are named, never their values.
+	assert.Equal(t, "phone,password", events[0].Detail)
+
+	page, err := svc.AuditEvents(ctx, loginservice.AuditQuery{Subject: "al", PageSize: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, page.Events, 1) {
+		assert.Equal(t, audit.AdminType("UpdateUser"), page.Events[0].Type)
+	}
+	page, err = svc.AuditEvents(ctx, loginservice.AuditQuery{Subject: "al", PageSize: 1, PageToken: page.NextPageToken})
+	assert.NoError(t, err)
+	if assert.Len(t, page.Events, 1) {
+		assert.Equal(t, audit.TypePasswordChange, page.Events[0].Type)
+	}
+	// The first query was recorded in between.
+	assert.NotEmpty(t, page.NextPageToken)
+	_, err = svc.AuditEvents(ctx, loginservice.AuditQuery{PageToken: "x"})
+	assert.Equal(t, loginservice.CodeInvalidArgument, err.(*loginservice.Error).Code)
+}
@@ -0,0 +1,89 @@
+package loginservice
+
+import "strings"
+
+// Code classifies an Error. Codes are stable and machine-readable: clients
+// may switch on them, and transports map them to their own status codes.
+type Code string
+
+const (
+	CodeNotFound         Code = "not_found"
+	CodeAlreadyExists    Code = "already_exists"
+	CodeInvalidArgument  Code = "invalid_argument"
+	CodeUnauthenticated  Code = "unauthenticated"
+	CodePermissionDenied Code = "permission_denied"
+	CodeLocked           Code = "locked"
+	CodeRateLimited      Code = "rate_limited"
+	CodeUnavailable      Code = "unavailable"
+)
+
+// Error is a domain error. Any other error returned by the service is an
+// internal one.
+type Error struct {
+	Code    Code
+	Message string
+	// Violations lists the invalid fields of an invalid_argument error.
+	Violations []FieldViolation
+	// Err is the underlying cause, if any. It stays on the server: the
+	// transports only send Code and Message.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+// FieldViolation describes why one field of a request is invalid.
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// NewInvalidArgument returns an invalid_argument error for violations.
+func NewInvalidArgument(violations ...FieldViolation) *Error {
+	msgs := make([]string, len(violations))
+	for i, v := range violations {
+		msgs[i] = v.Field + " " + v.Description
+	}
+	return &Error{Code: CodeInvalidArgument, Message: strings.Join(msgs, "; "), Violations: violations}
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is reports whether target is an *Error with the same code, so errors
+// decoded by a client still match the sentinels below with errors.Is.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+var (
+	ErrNotFound         = &Error{Code: CodeNotFound, Message: "user not found"}
+	ErrAlreadyExists    = &Error{Code: CodeAlreadyExists, Message: "user already exists"}
+	ErrInvalidArgument  = &Error{Code: CodeInvalidArgument, Message: "invalid argument"}
+	ErrUnauthenticated  = &Error{Code: CodeUnauthenticated, Message: "unauthenticated"}
+	ErrPermissionDenied = &Error{Code: CodePermissionDenied, Message: "permission denied"}
+	ErrLocked           = &Error{Code: CodeLocked, Message: "account locked"}
+	ErrRateLimited      = &Error{Code: CodeRateLimited, Message: "rate limited"}
+	ErrUnavailable      = &Error{Code: CodeUnavailable, Message: "service unavailable"}
+
+	// ErrGroupNotFound and ErrGroupExists are the ErrNotFound and
+	// ErrAlreadyExists of the groups of a ProvisioningService.
+	ErrGroupNotFound = &Error{Code: CodeNotFound, Message: "group not found"}
+	ErrGroupExists   = &Error{Code: CodeAlreadyExists, Message: "group already exists"}
+
+	// ErrUnknownTenant is returned for a request whose tenant is missing,
+	// unknown or ambiguous. It is a permission_denied error.
+	ErrUnknownTenant = &Error{Code: CodePermissionDenied, Message: "unknown tenant"}
+
+	// ErrDisabled is returned by Authenticate for a disabled user, once
+	// the password is verified. It is a locked error.
+	ErrDisabled = &Error{Code: CodeLocked, Message: "account disabled"}
+
+	// ErrInvalidCredentials is returned by Authenticate for an unknown user
+	// as well as for a wrong password, so callers can't tell them apart.
+	ErrInvalidCredentials = &Error{Code: CodeUnauthenticated, Message: "invalid credentials"}
+)
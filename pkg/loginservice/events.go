@@ -0,0 +1,175 @@
+package loginservice
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"loginsvc/pkg/tenant"
+	"loginsvc/repo"
+)
+
+// EventService streams the identity events of a tenant, as written to its
+// outbox; see package webhook for their delivery to webhooks instead.
+type EventService interface {
+	// WatchEvents calls send with each event after q.Cursor of the types q
+	// lists, or of every type, in the order they were written, until ctx
+	// is done or send fails. It starts with a heartbeat, and sends more
+	// while there are no events to send.
+	// Events are fetched as the previous ones are sent, so a watcher that
+	// doesn't keep up slows the stream down rather than piling events up.
+	WatchEvents(ctx context.Context, q WatchQuery, send func(IdentityEvent) error) error
+}
+
+// WatchQuery names where a stream of events starts, and filters it.
+type WatchQuery struct {
+	// Cursor is that of the last event or heartbeat received. Empty starts
+	// with the events written from now on, and "0" with the first one.
+	Cursor string   `json:"cursor"`
+	Types  []string `json:"types"`
+}
+
+// IdentityEvent is an identity event of a user of the tenant, or a
+// heartbeat.
+type IdentityEvent struct {
+	// Cursor resumes the stream after this event. That of a heartbeat
+	// resumes it after every event before it, sent or filtered out.
+	Cursor    string    `json:"cursor"`
+	Heartbeat bool      `json:"heartbeat,omitempty"`
+	ID        int64     `json:"id,omitempty"`
+	Type      string    `json:"type,omitempty"`
+	Tenant    string    `json:"tenant"`
+	User      string    `json:"user,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// WatchOptions tune an EventService. Zero fields take the defaults.
+type WatchOptions struct {
+	// PollInterval is how often the outbox is read for new events.
+	// Defaults to 1s.
+	PollInterval time.Duration
+	// Heartbeat is how long a stream goes without a message before a
+	// heartbeat is sent. Defaults to 15s.
+	Heartbeat time.Duration
+	// BatchSize bounds the events read at once. Defaults to 100.
+	BatchSize int
+}
+
+func (o *WatchOptions) setDefaults() {
+	if o.PollInterval <= 0 {
+		o.PollInterval = time.Second
+	}
+	if o.Heartbeat <= 0 {
+		o.Heartbeat = 15 * time.Second
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+}
+
+// NewEventService returns an EventService for events, the outbox of
+// tenant id.
+func NewEventService(events repo.EventLog, id string, opts WatchOptions) EventService {
+	opts.setDefaults()
+	return eventService{events: events, tenant: id, opts: opts}
+}
+
+type eventService struct {
+	events repo.EventLog
+	tenant string
+	opts   WatchOptions
+}
+
+func (s eventService) WatchEvents(ctx context.Context, q WatchQuery, send func(IdentityEvent) error) error {
+	var violations []FieldViolation
+	types := map[string]bool{}
+	for _, t := range q.Types {
+		if !knownEventType(t) {
+			violations = append(violations, FieldViolation{Field: "types", Description: "has unknown type " + strconv.Quote(t)})
+		}
+		types[t] = true
+	}
+	var cursor int64
+	if q.Cursor != "" {
+		n, err := strconv.ParseInt(q.Cursor, 10, 64)
+		if err != nil || n < 0 {
+			violations = append(violations, FieldViolation{Field: "cursor", Description: "is not from a previous event"})
+		}
+		cursor = n
+	}
+	if len(violations) > 0 {
+		return NewInvalidArgument(violations...)
+	}
+	if q.Cursor == "" {
+		last, err := s.events.LastOutboxSeq()
+		if err != nil {
+			return err
+		}
+		cursor = last
+	}
+
+	heartbeat := func() error {
+		return send(IdentityEvent{Cursor: strconv.FormatInt(cursor, 10), Heartbeat: true, Tenant: s.tenant, Time: time.Now().UTC()})
+	}
+	if err := heartbeat(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(s.opts.PollInterval)
+	defer ticker.Stop()
+	lastSent := time.Now()
+	for {
+		// Cursors are the Seq of events, which follows the order they
+		// commit in, so an event committed late still comes after the
+		// cursor of those sent before it.
+		numbered, err := s.events.SequenceOutbox(s.opts.BatchSize)
+		if err != nil {
+			return err
+		}
+		events, err := s.events.OutboxEvents(cursor, s.opts.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			cursor = e.Seq
+			if len(types) > 0 && !types[e.Type] {
+				continue
+			}
+			if err := send(IdentityEvent{Cursor: strconv.FormatInt(e.Seq, 10), ID: e.ID, Type: e.Type, Tenant: s.tenant, User: e.User, Time: e.Time}); err != nil {
+				return err
+			}
+			lastSent = time.Now()
+		}
+		if len(events) == s.opts.BatchSize || numbered == s.opts.BatchSize {
+			// There may be more events already.
+			continue
+		}
+		if time.Since(lastSent) >= s.opts.Heartbeat {
+			if err := heartbeat(); err != nil {
+				return err
+			}
+			lastSent = time.Now()
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			// The watcher went away, or the server is shutting down.
+			return nil
+		}
+	}
+}
+
+// EventTenants routes each call to the EventService of the tenant resolved
+// for it, as Tenants does.
+type EventTenants map[string]EventService
+
+func (t EventTenants) WatchEvents(ctx context.Context, q WatchQuery, send func(IdentityEvent) error) error {
+	id, ok := tenant.FromContext(ctx)
+	if !ok {
+		return ErrUnknownTenant
+	}
+	svc, ok := t[id]
+	if !ok {
+		return ErrUnknownTenant
+	}
+	return svc.WatchEvents(ctx, q, send)
+}
@@ -0,0 +1,163 @@
+package loginservice_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/tenant"
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// eventLog keeps outbox events in memory. Events are committed as they
+// are added, but for those begun.
+type eventLog struct {
+	mtx     sync.Mutex
+	events  []repo.OutboxEvent
+	pending map[int64]bool
+	seq     int64
+}
+
+func (l *eventLog) add(typ, user string, t time.Time) int64 {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	id := int64(len(l.events) + 1)
+	l.events = append(l.events, repo.OutboxEvent{ID: id, Type: typ, User: user, Time: t})
+	return id
+}
+
+// begin adds an event whose transaction isn't committed yet.
+func (l *eventLog) begin(typ, user string) int64 {
+	id := l.add(typ, user, time.Now())
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.pending == nil {
+		l.pending = map[int64]bool{}
+	}
+	l.pending[id] = true
+	return id
+}
+
+func (l *eventLog) commit(id int64) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	delete(l.pending, id)
+}
+
+func (l *eventLog) SequenceOutbox(limit int) (int, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	n := 0
+	for i, e := range l.events {
+		if e.Seq == 0 && !l.pending[e.ID] && n < limit {
+			l.seq++
+			l.events[i].Seq = l.seq
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (l *eventLog) OutboxEvents(after int64, limit int) ([]repo.OutboxEvent, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	var events []repo.OutboxEvent
+	for _, e := range l.events {
+		if e.Seq > after {
+			events = append(events, e)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Seq < events[j].Seq })
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (l *eventLog) LastOutboxSeq() (int64, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.seq, nil
+}
+
+var watchOpts = loginservice.WatchOptions{PollInterval: time.Millisecond, Heartbeat: 20 * time.Millisecond, BatchSize: 2}
+
+// watch returns the messages WatchEvents sends until it has sent n.
+func watch(t *testing.T, svc loginservice.EventService, q loginservice.WatchQuery, n int) []loginservice.IdentityEvent {
+	var sent []loginservice.IdentityEvent
+	done := errors.New("done")
+	err := svc.WatchEvents(context.Background(), q, func(e loginservice.IdentityEvent) error {
+		sent = append(sent, e)
+		if len(sent) == n {
+			return done
+		}
+		return nil
+	})
+	assert.Equal(t, done, err)
+	return sent
+}
+
+func TestWatchEvents(t *testing.T) {
+	log := &eventLog{}
+	old := time.Now().Add(-time.Minute)
+	log.add(repo.EventUserCreated, "al", old)
+	log.add(repo.EventUserLocked, "al", old)
+	log.add(repo.EventUserCreated, "bo", old)
+	svc := loginservice.NewEventService(log, "acme", watchOpts)
+
+	// Streams start with a heartbeat, and read past full batches.
+	sent := watch(t, svc, loginservice.WatchQuery{Cursor: "0"}, 4)
+	assert.True(t, sent[0].Heartbeat)
+	assert.Equal(t, "0", sent[0].Cursor)
+	for i, e := range sent[1:] {
+		assert.Equal(t, int64(i+1), e.ID)
+		assert.Equal(t, "acme", e.Tenant)
+	}
+	assert.Equal(t, "bo", sent[3].User)
+
+	// Filtered out events move the cursor of heartbeats on.
+	sent = watch(t, svc, loginservice.WatchQuery{Cursor: "1", Types: []string{repo.EventUserLocked}}, 3)
+	assert.Equal(t, int64(2), sent[1].ID)
+	assert.True(t, sent[2].Heartbeat)
+	assert.Equal(t, "3", sent[2].Cursor)
+
+	// Without a cursor, streams start from now.
+	sent = watch(t, svc, loginservice.WatchQuery{}, 1)
+	assert.Equal(t, "3", sent[0].Cursor)
+
+	// An event committed after one written later comes after the cursor
+	// of the latter.
+	late := log.begin(repo.EventSessionRevoked, "al")
+	log.add(repo.EventUserCreated, "cy", old)
+	sent = watch(t, svc, loginservice.WatchQuery{Cursor: "3"}, 2)
+	assert.Equal(t, []interface{}{int64(5), "4"}, []interface{}{sent[1].ID, sent[1].Cursor})
+	log.commit(late)
+	sent = watch(t, svc, loginservice.WatchQuery{Cursor: sent[1].Cursor}, 2)
+	assert.Equal(t, []interface{}{int64(4), "5"}, []interface{}{sent[1].ID, sent[1].Cursor})
+}
+
+func TestWatchEventsInvalid(t *testing.T) {
+	svc := loginservice.NewEventService(&eventLog{}, "acme", watchOpts)
+	err := svc.WatchEvents(context.Background(), loginservice.WatchQuery{Cursor: "x", Types: []string{"user.renamed"}}, nil)
+	var e *loginservice.Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, loginservice.CodeInvalidArgument, e.Code)
+		assert.Len(t, e.Violations, 2)
+	}
+
+	// Streams end without an error when their watcher goes away.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, svc.WatchEvents(ctx, loginservice.WatchQuery{}, func(loginservice.IdentityEvent) error { return nil }))
+
+	// Tenants route by the tenant resolved for the call.
+	tenants := loginservice.EventTenants{"acme": svc}
+	assert.Equal(t, loginservice.ErrUnknownTenant, tenants.WatchEvents(context.Background(), loginservice.WatchQuery{}, nil))
+	assert.Equal(t, loginservice.ErrUnknownTenant, tenants.WatchEvents(tenant.NewContext(context.Background(), "globex"), loginservice.WatchQuery{}, nil))
+}
@@ -27,6 +27,21 @@ func (mw loggingMiddleware) Name(ctx context.Context, n string) (v string, err e
 	return mw.next.Name(ctx, n)
 }
 
+// Authenticate logs the user name only; the password must never be logged.
+func (mw loggingMiddleware) Authenticate(ctx context.Context, name, password string) (sid string, err error) {
+	defer func() {
+		mw.logger.Log("method", "Authenticate", "name", name, "err", err)
+	}()
+	return mw.next.Authenticate(ctx, name, password)
+}
+
+func (mw loggingMiddleware) Check(ctx context.Context, subject, permission, resource string) (allowed bool, err error) {
+	defer func() {
+		mw.logger.Log("method", "Check", "subject", subject, "permission", permission, "resource", resource, "allowed", allowed, "err", err)
+	}()
+	return mw.next.Check(ctx, subject, permission, resource)
+}
+
 // InstrumentingMiddleware returns a service middleware that instruments
 // the number of integers summed and characters concatenated over the lifetime of
 // the service.
@@ -51,3 +66,113 @@ func (mw instrumentingMiddleware) Name(ctx context.Context, n string) (string, e
 	mw.ints.Add(float64(1))
 	return v, err
 }
+
+func (mw instrumentingMiddleware) Authenticate(ctx context.Context, name, password string) (string, error) {
+	return mw.next.Authenticate(ctx, name, password)
+}
+
+func (mw instrumentingMiddleware) Check(ctx context.Context, subject, permission, resource string) (bool, error) {
+	return mw.next.Check(ctx, subject, permission, resource)
+}
+
+// AdminMiddleware describes an AdminService middleware.
+type AdminMiddleware func(AdminService) AdminService
+
+// AdminLoggingMiddleware returns an AdminService middleware that logs every
+// call with the caller identified by its client certificate, for audit.
+// Passwords are never logged.
+func AdminLoggingMiddleware(logger log.Logger) AdminMiddleware {
+	return func(next AdminService) AdminService {
+		return adminLoggingMiddleware{logger, next}
+	}
+}
+
+type adminLoggingMiddleware struct {
+	logger log.Logger
+	next   AdminService
+}
+
+func (mw adminLoggingMiddleware) log(ctx context.Context, method, name string, err error) {
+	mw.logger.Log("audit", "admin", "method", method, "caller", callerName(ctx), "name", name, "err", err)
+}
+
+func (mw adminLoggingMiddleware) CreateUser(ctx context.Context, a Account, password string) (_ Account, err error) {
+	defer func() { mw.log(ctx, "CreateUser", a.Name, err) }()
+	return mw.next.CreateUser(ctx, a, password)
+}
+
+func (mw adminLoggingMiddleware) GetUser(ctx context.Context, name string) (_ Account, err error) {
+	defer func() { mw.log(ctx, "GetUser", name, err) }()
+	return mw.next.GetUser(ctx, name)
+}
+
+func (mw adminLoggingMiddleware) UpdateUser(ctx context.Context, u AccountUpdate) (_ Account, err error) {
+	defer func() { mw.log(ctx, "UpdateUser", u.Name, err) }()
+	return mw.next.UpdateUser(ctx, u)
+}
+
+func (mw adminLoggingMiddleware) DisableUser(ctx context.Context, name string) (_ Account, err error) {
+	defer func() { mw.log(ctx, "DisableUser", name, err) }()
+	return mw.next.DisableUser(ctx, name)
+}
+
+func (mw adminLoggingMiddleware) EnableUser(ctx context.Context, name string) (_ Account, err error) {
+	defer func() { mw.log(ctx, "EnableUser", name, err) }()
+	return mw.next.EnableUser(ctx, name)
+}
+
+func (mw adminLoggingMiddleware) DeleteUser(ctx context.Context, name string) (err error) {
+	defer func() { mw.log(ctx, "DeleteUser", name, err) }()
+	return mw.next.DeleteUser(ctx, name)
+}
+
+func (mw adminLoggingMiddleware) ForcePasswordReset(ctx context.Context, name string) (_ Account, err error) {
+	defer func() { mw.log(ctx, "ForcePasswordReset", name, err) }()
+	return mw.next.ForcePasswordReset(ctx, name)
+}
+
+func (mw adminLoggingMiddleware) RevokeSessions(ctx context.Context, name string) (_ Account, err error) {
+	defer func() { mw.log(ctx, "RevokeSessions", name, err) }()
+	return mw.next.RevokeSessions(ctx, name)
+}
+
+func (mw adminLoggingMiddleware) ResetMFA(ctx context.Context, name string) (_ Account, err error) {
+	defer func() { mw.log(ctx, "ResetMFA", name, err) }()
+	return mw.next.ResetMFA(ctx, name)
+}
+
+func (mw adminLoggingMiddleware) ListUsers(ctx context.Context, q UserQuery) (_ AccountPage, err error) {
+	defer func() { mw.log(ctx, "ListUsers", "", err) }()
+	return mw.next.ListUsers(ctx, q)
+}
+
+func (mw adminLoggingMiddleware) AuditEvents(ctx context.Context, q AuditQuery) (_ AuditPage, err error) {
+	defer func() { mw.log(ctx, "AuditEvents", q.Subject, err) }()
+	return mw.next.AuditEvents(ctx, q)
+}
+
+func (mw adminLoggingMiddleware) CreateWebhook(ctx context.Context, w Webhook) (_ Webhook, err error) {
+	defer func() {
+		mw.logger.Log("audit", "admin", "method", "CreateWebhook", "caller", callerName(ctx), "url", w.URL, "err", err)
+	}()
+	return mw.next.CreateWebhook(ctx, w)
+}
+
+func (mw adminLoggingMiddleware) ListWebhooks(ctx context.Context) (_ []Webhook, err error) {
+	defer func() { mw.log(ctx, "ListWebhooks", "", err) }()
+	return mw.next.ListWebhooks(ctx)
+}
+
+func (mw adminLoggingMiddleware) DeleteWebhook(ctx context.Context, id int64) (err error) {
+	defer func() {
+		mw.logger.Log("audit", "admin", "method", "DeleteWebhook", "caller", callerName(ctx), "webhook", id, "err", err)
+	}()
+	return mw.next.DeleteWebhook(ctx, id)
+}
+
+func (mw adminLoggingMiddleware) RedeliverWebhook(ctx context.Context, id int64) (_ int, err error) {
+	defer func() {
+		mw.logger.Log("audit", "admin", "method", "RedeliverWebhook", "caller", callerName(ctx), "webhook", id, "err", err)
+	}()
+	return mw.next.RedeliverWebhook(ctx, id)
+}
@@ -0,0 +1,582 @@
+package loginservice
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+
+	"loginsvc/pkg/scim"
+	"loginsvc/repo"
+)
+
+// ProvisioningService serves the users and groups of a tenant as SCIM 2.0
+// resources, for HR systems and identity providers to provision them; see
+// package scim. User methods return ErrNotFound for an unknown user, and
+// group methods ErrGroupNotFound for an unknown group.
+type ProvisioningService interface {
+	// CreateUser creates u, which needs a userName and an externalId, its
+	// subject ID.
+	CreateUser(ctx context.Context, u scim.User) (scim.User, error)
+	GetUser(ctx context.Context, id string) (scim.User, error)
+	// ListUsers returns the page of the users q selects, by name.
+	ListUsers(ctx context.Context, q scim.Query) (scim.ListResponse, error)
+	// ReplaceUser replaces the attributes of the user id with those of u.
+	// Its userName and externalId can't change.
+	ReplaceUser(ctx context.Context, id string, u scim.User) (scim.User, error)
+	PatchUser(ctx context.Context, id string, p scim.PatchRequest) (scim.User, error)
+	DeleteUser(ctx context.Context, id string) error
+
+	CreateGroup(ctx context.Context, g scim.Group) (scim.Group, error)
+	GetGroup(ctx context.Context, id string) (scim.Group, error)
+	ListGroups(ctx context.Context, q scim.Query) (scim.ListResponse, error)
+	// ReplaceGroup replaces the members of the group id with those of g.
+	// Its displayName can't change.
+	ReplaceGroup(ctx context.Context, id string, g scim.Group) (scim.Group, error)
+	PatchGroup(ctx context.Context, id string, p scim.PatchRequest) (scim.Group, error)
+	DeleteGroup(ctx context.Context, id string) error
+}
+
+// DefaultSCIMCount is the size of the pages of ListUsers and ListGroups
+// when the query doesn't set one; MaxPageSize bounds it.
+const DefaultSCIMCount = 100
+
+// MaxSCIMScan is the number of users of a tenant beyond which ListUsers
+// refuses the filters it can't look up, rather than match them against
+// every user.
+const MaxSCIMScan = 10000
+
+// NewProvisioning returns a ProvisioningService that manages users through
+// admin, so that their changes are audited like those of support staff,
+// and groups in groups.
+func NewProvisioning(admin AdminService, groups repo.GroupRepository) ProvisioningService {
+	return provisioningService{admin: admin, groups: groups}
+}
+
+type provisioningService struct {
+	admin  AdminService
+	groups repo.GroupRepository
+}
+
+func (s provisioningService) CreateUser(ctx context.Context, u scim.User) (scim.User, error) {
+	var violations []FieldViolation
+	if u.UserName == "" {
+		violations = append(violations, FieldViolation{Field: "userName", Description: "is required"})
+	}
+	if u.ExternalID == "" {
+		violations = append(violations, FieldViolation{Field: "externalId", Description: "is required"})
+	}
+	if len(violations) > 0 {
+		return scim.User{}, NewInvalidArgument(violations...)
+	}
+	a, err := s.admin.CreateUser(ctx, Account{
+		Name:  u.UserName,
+		SID:   u.ExternalID,
+		Email: scim.Primary(u.Emails),
+		Phone: scim.Primary(u.PhoneNumbers),
+	}, u.Password)
+	if err != nil {
+		return scim.User{}, err
+	}
+	if u.Active != nil && !*u.Active {
+		if a, err = s.admin.DisableUser(ctx, a.Name); err != nil {
+			return scim.User{}, err
+		}
+	}
+	return newSCIMUser(a), nil
+}
+
+func (s provisioningService) GetUser(ctx context.Context, id string) (scim.User, error) {
+	a, err := s.admin.GetUser(ctx, id)
+	if err != nil {
+		return scim.User{}, err
+	}
+	return newSCIMUser(a), nil
+}
+
+// ListUsers looks up filters on a single userName, id or externalId, and
+// pages the unfiltered list in the repository. Other filters are matched
+// against every user of the tenant, and fail with a tooMany error for
+// tenants of more than MaxSCIMScan users.
+func (s provisioningService) ListUsers(ctx context.Context, q scim.Query) (scim.ListResponse, error) {
+	f, err := parseFilter(q.Filter)
+	if err != nil {
+		return scim.ListResponse{}, err
+	}
+	var users []interface{}
+	if name, ok := nameFilter(f, scim.UserSchema, "userName"); ok {
+		a, err := s.admin.GetUser(ctx, name)
+		switch {
+		case errors.Is(err, ErrNotFound):
+		case err != nil:
+			return scim.ListResponse{}, err
+		default:
+			users = append(users, newSCIMUser(a))
+		}
+		return page(users, q), nil
+	}
+	if sid, ok := attrFilter(f, scim.UserSchema, "externalId"); ok {
+		p, err := s.admin.ListUsers(ctx, UserQuery{SID: sid, PageSize: 1})
+		if err != nil {
+			return scim.ListResponse{}, err
+		}
+		for _, a := range p.Accounts {
+			users = append(users, newSCIMUser(a))
+		}
+		return page(users, q), nil
+	}
+	if f == nil {
+		start, count := pageBounds(q)
+		uq := UserQuery{Offset: start - 1, PageSize: count, CountTotal: true}
+		if count == 0 {
+			// A PageSize of zero is the default one.
+			uq.PageSize = 1
+		}
+		p, err := s.admin.ListUsers(ctx, uq)
+		if err != nil {
+			return scim.ListResponse{}, err
+		}
+		for i := 0; i < len(p.Accounts) && i < count; i++ {
+			users = append(users, newSCIMUser(p.Accounts[i]))
+		}
+		return scim.NewListResponse(users, p.Total, start), nil
+	}
+	uq := UserQuery{PageSize: MaxPageSize, CountTotal: true}
+	for {
+		p, err := s.admin.ListUsers(ctx, uq)
+		if err != nil {
+			return scim.ListResponse{}, err
+		}
+		if p.Total > MaxSCIMScan {
+			return scim.ListResponse{}, scimError(scim.NewError(scim.TypeTooMany, "the filter can only be matched for up to %d users", MaxSCIMScan))
+		}
+		for _, a := range p.Accounts {
+			u := newSCIMUser(a)
+			if f.Match(u.Map()) {
+				users = append(users, u)
+			}
+		}
+		if p.NextPageToken == "" {
+			return page(users, q), nil
+		}
+		uq = UserQuery{PageSize: MaxPageSize, PageToken: p.NextPageToken}
+	}
+}
+
+func (s provisioningService) ReplaceUser(ctx context.Context, id string, u scim.User) (scim.User, error) {
+	a, err := s.admin.GetUser(ctx, id)
+	if err != nil {
+		return scim.User{}, err
+	}
+	if u.UserName == "" {
+		return scim.User{}, NewInvalidArgument(FieldViolation{Field: "userName", Description: "is required"})
+	}
+	// An externalId left out keeps its value: it can't be removed.
+	if u.ExternalID == "" {
+		u.ExternalID = a.SID
+	}
+	return s.updateUser(ctx, a, u)
+}
+
+func (s provisioningService) PatchUser(ctx context.Context, id string, p scim.PatchRequest) (scim.User, error) {
+	if err := p.Validate(); err != nil {
+		return scim.User{}, scimError(err)
+	}
+	a, err := s.admin.GetUser(ctx, id)
+	if err != nil {
+		return scim.User{}, err
+	}
+	u, err := scim.PatchUser(newSCIMUser(a), p.Operations)
+	if err != nil {
+		return scim.User{}, scimError(err)
+	}
+	return s.updateUser(ctx, a, u)
+}
+
+// updateUser makes the account a what u describes, in full: the emails
+// and phone numbers u leaves out are removed.
+func (s provisioningService) updateUser(ctx context.Context, a Account, u scim.User) (scim.User, error) {
+	switch {
+	case u.ID != "" && u.ID != a.Name:
+		return scim.User{}, scimError(scim.NewError(scim.TypeMutability, "id is read-only"))
+	case u.UserName != a.Name:
+		return scim.User{}, scimError(scim.NewError(scim.TypeMutability, "userName is immutable"))
+	case u.ExternalID != a.SID:
+		return scim.User{}, scimError(scim.NewError(scim.TypeMutability, "externalId is immutable"))
+	}
+	up := AccountUpdate{Name: a.Name}
+	if email := scim.Primary(u.Emails); email != a.Email {
+		up.Email = &email
+	}
+	if phone := scim.Primary(u.PhoneNumbers); phone != a.Phone {
+		up.Phone = &phone
+	}
+	if u.Password != "" {
+		up.Password = &u.Password
+	}
+	var err error
+	if up.Email != nil || up.Phone != nil || up.Password != nil {
+		if a, err = s.admin.UpdateUser(ctx, up); err != nil {
+			return scim.User{}, err
+		}
+	}
+	if u.Active != nil && *u.Active != (a.Status == string(repo.StatusActive)) {
+		if *u.Active {
+			a, err = s.admin.EnableUser(ctx, a.Name)
+		} else {
+			a, err = s.admin.DisableUser(ctx, a.Name)
+		}
+		if err != nil {
+			return scim.User{}, err
+		}
+	}
+	return newSCIMUser(a), nil
+}
+
+func (s provisioningService) DeleteUser(ctx context.Context, id string) error {
+	return s.admin.DeleteUser(ctx, id)
+}
+
+func (s provisioningService) CreateGroup(ctx context.Context, g scim.Group) (scim.Group, error) {
+	if g.DisplayName == "" {
+		return scim.Group{}, NewInvalidArgument(FieldViolation{Field: "displayName", Description: "is required"})
+	}
+	groups, err := s.groups.Groups()
+	if err != nil {
+		return scim.Group{}, err
+	}
+	if _, ok := findGroup(groups, g.DisplayName); ok {
+		return scim.Group{}, ErrGroupExists
+	}
+	if err := s.groups.CreateGroup(g.DisplayName); err != nil {
+		return scim.Group{}, err
+	}
+	created := scim.Group{DisplayName: g.DisplayName}
+	if err := s.setMembers(created, g.Members, append(groups, repo.Group{Name: g.DisplayName})); err != nil {
+		// Don't leave a group behind for a request that failed.
+		s.groups.DeleteGroup(g.DisplayName)
+		return scim.Group{}, err
+	}
+	return s.GetGroup(ctx, g.DisplayName)
+}
+
+func (s provisioningService) GetGroup(_ context.Context, id string) (scim.Group, error) {
+	groups, err := s.groups.Groups()
+	if err != nil {
+		return scim.Group{}, err
+	}
+	g, ok := findGroup(groups, id)
+	if !ok {
+		return scim.Group{}, ErrGroupNotFound
+	}
+	return s.scimGroup(g, groups)
+}
+
+// ListGroups reads the members of every group of the tenant, but for
+// filters on a single displayName or id.
+func (s provisioningService) ListGroups(_ context.Context, q scim.Query) (scim.ListResponse, error) {
+	f, err := parseFilter(q.Filter)
+	if err != nil {
+		return scim.ListResponse{}, err
+	}
+	groups, err := s.groups.Groups()
+	if err != nil {
+		return scim.ListResponse{}, err
+	}
+	candidates := groups
+	if name, ok := nameFilter(f, scim.GroupSchema, "displayName"); ok {
+		candidates = nil
+		if g, ok := findGroup(groups, name); ok {
+			candidates = []repo.Group{g}
+		}
+	}
+	var matched []interface{}
+	for _, g := range candidates {
+		sg, err := s.scimGroup(g, groups)
+		if err != nil {
+			return scim.ListResponse{}, err
+		}
+		if f == nil || f.Match(sg.Map()) {
+			matched = append(matched, sg)
+		}
+	}
+	return page(matched, q), nil
+}
+
+func (s provisioningService) ReplaceGroup(ctx context.Context, id string, g scim.Group) (scim.Group, error) {
+	groups, err := s.groups.Groups()
+	if err != nil {
+		return scim.Group{}, err
+	}
+	current, ok := findGroup(groups, id)
+	if !ok {
+		return scim.Group{}, ErrGroupNotFound
+	}
+	if g.DisplayName == "" {
+		return scim.Group{}, NewInvalidArgument(FieldViolation{Field: "displayName", Description: "is required"})
+	}
+	return s.updateGroup(ctx, current, groups, g)
+}
+
+func (s provisioningService) PatchGroup(ctx context.Context, id string, p scim.PatchRequest) (scim.Group, error) {
+	if err := p.Validate(); err != nil {
+		return scim.Group{}, scimError(err)
+	}
+	groups, err := s.groups.Groups()
+	if err != nil {
+		return scim.Group{}, err
+	}
+	current, ok := findGroup(groups, id)
+	if !ok {
+		return scim.Group{}, ErrGroupNotFound
+	}
+	sg, err := s.scimGroup(current, groups)
+	if err != nil {
+		return scim.Group{}, err
+	}
+	g, err := scim.PatchGroup(sg, p.Operations)
+	if err != nil {
+		return scim.Group{}, scimError(err)
+	}
+	return s.updateGroup(ctx, current, groups, g)
+}
+
+// updateGroup makes the members of current those of g.
+func (s provisioningService) updateGroup(ctx context.Context, current repo.Group, groups []repo.Group, g scim.Group) (scim.Group, error) {
+	switch {
+	case g.ID != "" && g.ID != current.Name:
+		return scim.Group{}, scimError(scim.NewError(scim.TypeMutability, "id is read-only"))
+	case g.DisplayName != current.Name:
+		return scim.Group{}, scimError(scim.NewError(scim.TypeMutability, "displayName is immutable"))
+	}
+	old, err := s.scimGroup(current, groups)
+	if err != nil {
+		return scim.Group{}, err
+	}
+	if err := s.setMembers(old, g.Members, groups); err != nil {
+		return scim.Group{}, err
+	}
+	return s.GetGroup(ctx, current.Name)
+}
+
+func (s provisioningService) DeleteGroup(ctx context.Context, id string) error {
+	if _, err := s.GetGroup(ctx, id); err != nil {
+		return err
+	}
+	return s.groups.DeleteGroup(id)
+}
+
+// setMembers adds to g the members it doesn't have and removes those
+// members leaves out. Members without a type are groups if one of groups
+// has their name, and users otherwise.
+func (s provisioningService) setMembers(g scim.Group, members []scim.Member, groups []repo.Group) error {
+	type member struct{ name, typ string }
+	want := map[member]bool{}
+	for _, m := range members {
+		typ := m.Type
+		if typ == "" {
+			typ = scim.MemberUser
+			if _, ok := findGroup(groups, m.Value); ok {
+				typ = scim.MemberGroup
+			}
+		}
+		switch {
+		case m.Value == "":
+			return NewInvalidArgument(FieldViolation{Field: "members", Description: "must have a value"})
+		case typ != scim.MemberUser && typ != scim.MemberGroup:
+			return NewInvalidArgument(FieldViolation{Field: "members", Description: "must be of type User or Group"})
+		}
+		want[member{m.Value, typ}] = true
+	}
+	have := map[member]bool{}
+	for _, m := range g.Members {
+		have[member{m.Value, m.Type}] = true
+	}
+	for m := range have {
+		if want[m] {
+			continue
+		}
+		var err error
+		if m.typ == scim.MemberGroup {
+			err = s.groups.Unnest(m.name, g.DisplayName)
+		} else {
+			err = s.groups.RemoveMember(g.DisplayName, m.name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for m := range want {
+		if have[m] {
+			continue
+		}
+		var err error
+		if m.typ == scim.MemberGroup {
+			err = s.groups.Nest(m.name, g.DisplayName)
+		} else {
+			err = s.groups.AddMember(g.DisplayName, m.name)
+		}
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return NewInvalidArgument(FieldViolation{Field: "members", Description: "has unknown user " + m.name})
+		case errors.Is(err, repo.ErrUnknownGroup):
+			return NewInvalidArgument(FieldViolation{Field: "members", Description: "has unknown group " + m.name})
+		case errors.Is(err, repo.ErrGroupCycle):
+			return NewInvalidArgument(FieldViolation{Field: "members", Description: "has group " + m.name + ", which would make the group a member of itself"})
+		case err != nil:
+			return err
+		}
+	}
+	return nil
+}
+
+// scimGroup returns g with its members: its users, then the groups of
+// groups nested in it.
+func (s provisioningService) scimGroup(g repo.Group, groups []repo.Group) (scim.Group, error) {
+	users, err := s.groups.Members(g.Name)
+	if err != nil {
+		return scim.Group{}, err
+	}
+	sg := scim.Group{
+		Schemas:     []string{scim.GroupSchema},
+		ID:          g.Name,
+		DisplayName: g.Name,
+		Meta:        &scim.Meta{ResourceType: "Group"},
+	}
+	for _, u := range users {
+		sg.Members = append(sg.Members, scim.Member{Value: u, Type: scim.MemberUser})
+	}
+	for _, child := range groups {
+		for _, p := range child.Parents {
+			if p == g.Name {
+				sg.Members = append(sg.Members, scim.Member{Value: child.Name, Type: scim.MemberGroup})
+			}
+		}
+	}
+	return sg, nil
+}
+
+func findGroup(groups []repo.Group, name string) (repo.Group, bool) {
+	for _, g := range groups {
+		if g.Name == name {
+			return g, true
+		}
+	}
+	return repo.Group{}, false
+}
+
+func newSCIMUser(a Account) scim.User {
+	active := a.Status == string(repo.StatusActive)
+	u := scim.User{
+		Schemas:    []string{scim.UserSchema},
+		ID:         a.Name,
+		ExternalID: a.SID,
+		UserName:   a.Name,
+		Active:     &active,
+		Meta:       &scim.Meta{ResourceType: "User"},
+	}
+	if a.Email != "" {
+		u.Emails = []scim.MultiValue{{Value: a.Email, Type: "work", Primary: true}}
+	}
+	if a.Phone != "" {
+		u.PhoneNumbers = []scim.MultiValue{{Value: a.Phone, Type: "work", Primary: true}}
+	}
+	if !a.Created.IsZero() {
+		created := a.Created
+		u.Meta.Created = &created
+	}
+	return u
+}
+
+// parseFilter parses a filter, if any.
+func parseFilter(filter string) (scim.Filter, error) {
+	if filter == "" {
+		return nil, nil
+	}
+	f, err := scim.ParseFilter(filter)
+	if err != nil {
+		return nil, scimError(err)
+	}
+	return f, nil
+}
+
+// nameFilter returns the name f selects, if it only selects the resource
+// of that id or of that value of the name attribute of schema.
+func nameFilter(f scim.Filter, schema, attr string) (string, bool) {
+	if name, ok := attrFilter(f, schema, attr); ok {
+		return name, true
+	}
+	return attrFilter(f, schema, "id")
+}
+
+// attrFilter returns the value f selects, if it only selects the resources
+// with that value of the string attribute attr of schema.
+func attrFilter(f scim.Filter, schema, attr string) (string, bool) {
+	c, ok := f.(scim.Compare)
+	if !ok || c.Op != "eq" || c.Path.Sub != "" || (c.Path.URI != "" && !strings.EqualFold(c.Path.URI, schema)) {
+		return "", false
+	}
+	if !strings.EqualFold(c.Path.Attr, attr) {
+		return "", false
+	}
+	v, ok := c.Value.(string)
+	return v, ok
+}
+
+// page returns the page of resources q selects.
+func page(resources []interface{}, q scim.Query) scim.ListResponse {
+	start, count := pageBounds(q)
+	sort.SliceStable(resources, func(i, j int) bool { return resourceID(resources[i]) < resourceID(resources[j]) })
+	total := len(resources)
+	from := start - 1
+	if from > total {
+		from = total
+	}
+	to := from + count
+	if to > total {
+		to = total
+	}
+	return scim.NewListResponse(resources[from:to], total, start)
+}
+
+// pageBounds returns the 1-based index of the first resource of the page q
+// selects, and its size.
+func pageBounds(q scim.Query) (start, count int) {
+	start = q.StartIndex
+	if start < 1 {
+		start = 1
+	}
+	count = DefaultSCIMCount
+	if q.Count != nil {
+		count = *q.Count
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > MaxPageSize {
+		count = MaxPageSize
+	}
+	return start, count
+}
+
+func resourceID(r interface{}) string {
+	switch r := r.(type) {
+	case scim.User:
+		return r.ID
+	case scim.Group:
+		return r.ID
+	}
+	return ""
+}
+
+// scimError returns the error of a request that is invalid for SCIM as an
+// invalid_argument error, keeping the SCIM error as its cause for the
+// transport to tell its scimType.
+func scimError(err error) error {
+	var e *scim.Error
+	if !errors.As(err, &e) {
+		return err
+	}
+	return &Error{Code: CodeInvalidArgument, Message: e.Detail, Err: e}
+}
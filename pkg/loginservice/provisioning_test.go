@@ -0,0 +1,218 @@
+package loginservice_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"loginsvc/pkg/envelope"
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/password"
+	"loginsvc/pkg/scim"
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newProvisioning returns a ProvisioningService on a SQLite database with
+// the users of sqlite.sql.
+func newProvisioning(t *testing.T) loginservice.ProvisioningService {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := ioutil.ReadFile("../../sqlite.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	c := repo.NewCluster(db, nil, repo.HealthCheckInterval(time.Hour))
+	t.Cleanup(func() { c.Close() })
+	k, _ := envelope.NewKeyring("k1", map[string][]byte{"k1": envelope.GenerateKey()}, envelope.GenerateKey())
+	r := repo.NewMySQLLoginRepo(c, k)
+	hashers := password.NewRegistry("2b")
+	hashers.Register(password.NewBcrypt(4), "2a", "2b", "2y")
+	return loginservice.NewProvisioning(loginservice.NewBasicAdminService(r, r, r, r, hashers), r)
+}
+
+// scimType returns the scimType of the SCIM error err wraps, if any.
+func scimType(err error) string {
+	var e *scim.Error
+	if errors.As(err, &e) {
+		return e.ScimType
+	}
+	return ""
+}
+
+func TestProvisionUsers(t *testing.T) {
+	svc := newProvisioning(t)
+	ctx := context.Background()
+	inactive := false
+	u, err := svc.CreateUser(ctx, scim.User{
+		UserName:   "al",
+		ExternalID: "c111111111",
+		Active:     &inactive,
+		Password:   "s3cret-passw0rd",
+		Emails:     []scim.MultiValue{{Value: "al@home.example"}, {Value: "al@acme.example", Primary: true}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"al", "al", "c111111111", false, "", "al@acme.example"}, []interface{}{u.ID, u.UserName, u.ExternalID, *u.Active, u.Password, scim.Primary(u.Emails)})
+	assert.Equal(t, "User", u.Meta.ResourceType)
+
+	_, err = svc.CreateUser(ctx, scim.User{UserName: "al", ExternalID: "c111111111"})
+	assert.Equal(t, loginservice.ErrAlreadyExists, err)
+	_, err = svc.CreateUser(ctx, scim.User{})
+	var e *loginservice.Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Len(t, e.Violations, 2)
+	}
+
+	// PATCH as identity providers send it.
+	u, err = svc.PatchUser(ctx, "al", scim.PatchRequest{
+		Schemas: []string{scim.PatchOpSchema},
+		Operations: []scim.PatchOperation{
+			{Op: "Replace", Path: "active", Value: "True"},
+			{Op: "Replace", Path: `emails[type eq "work"].value`, Value: "al@globex.example"},
+			{Op: "Add", Path: `phoneNumbers[type eq "mobile"].value`, Value: "+15555550100"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, *u.Active)
+	assert.Equal(t, "al@globex.example", scim.Primary(u.Emails))
+	assert.Equal(t, "+15555550100", scim.Primary(u.PhoneNumbers))
+
+	for _, op := range []scim.PatchOperation{
+		{Op: "replace", Path: "userName", Value: "bo"},
+		{Op: "remove", Path: "externalId"},
+		{Op: "replace", Value: map[string]interface{}{"id": "bo"}},
+	} {
+		_, err = svc.PatchUser(ctx, "al", scim.PatchRequest{Schemas: []string{scim.PatchOpSchema}, Operations: []scim.PatchOperation{op}})
+		assert.Equal(t, scim.TypeMutability, scimType(err), op.Path)
+		assert.True(t, errors.Is(err, loginservice.ErrInvalidArgument))
+	}
+	_, err = svc.PatchUser(ctx, "al", scim.PatchRequest{Operations: []scim.PatchOperation{{Op: "remove", Path: "title"}}})
+	assert.Equal(t, scim.TypeInvalidSyntax, scimType(err))
+	_, err = svc.PatchUser(ctx, "bo", scim.PatchRequest{Schemas: []string{scim.PatchOpSchema}, Operations: []scim.PatchOperation{{Op: "remove", Path: "title"}}})
+	assert.Equal(t, loginservice.ErrNotFound, err)
+
+	// PUT replaces the user in full, but for its externalId.
+	u, err = svc.ReplaceUser(ctx, "al", scim.User{UserName: "al", PhoneNumbers: []scim.MultiValue{{Value: "+15555550199"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"c111111111", 0, "+15555550199", true}, []interface{}{u.ExternalID, len(u.Emails), scim.Primary(u.PhoneNumbers), *u.Active})
+	_, err = svc.ReplaceUser(ctx, "al", scim.User{UserName: "al", ExternalID: "x"})
+	assert.Equal(t, scim.TypeMutability, scimType(err))
+
+	assert.NoError(t, svc.DeleteUser(ctx, "al"))
+	_, err = svc.GetUser(ctx, "al")
+	assert.Equal(t, loginservice.ErrNotFound, err)
+}
+
+func TestProvisionListUsers(t *testing.T) {
+	svc := newProvisioning(t)
+	ctx := context.Background()
+	for _, name := range []string{"al", "bo", "cy"} {
+		_, err := svc.CreateUser(ctx, scim.User{UserName: name, ExternalID: "s-" + name, Emails: []scim.MultiValue{{Value: name + "@acme.example"}}})
+		assert.NoError(t, err)
+	}
+
+	list := func(q scim.Query) []string {
+		resp, err := svc.ListUsers(ctx, q)
+		assert.NoError(t, err, q.Filter)
+		var names []string
+		for _, r := range resp.Resources {
+			names = append(names, r.(scim.User).ID)
+		}
+		return names
+	}
+	// ed comes from sqlite.sql.
+	assert.Equal(t, []string{"al", "bo", "cy", "ed"}, list(scim.Query{}))
+	assert.Equal(t, []string{"bo"}, list(scim.Query{Filter: `userName eq "bo"`}))
+	assert.Equal(t, []string{"bo"}, list(scim.Query{Filter: `urn:ietf:params:scim:schemas:core:2.0:User:id eq "bo"`}))
+	assert.Nil(t, list(scim.Query{Filter: `userName eq "BO"`}))
+	assert.Equal(t, []string{"al", "cy"}, list(scim.Query{Filter: `emails co "acme" and not (externalId eq "s-bo")`}))
+	assert.Equal(t, []string{"ed"}, list(scim.Query{Filter: `not (emails pr)`}))
+	assert.Equal(t, []string{"cy"}, list(scim.Query{Filter: `externalId eq "s-cy"`}))
+	assert.Nil(t, list(scim.Query{Filter: `externalId eq "s-dd"`}))
+
+	two := 2
+	resp, err := svc.ListUsers(ctx, scim.Query{Filter: `emails pr`, StartIndex: 2, Count: &two})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{3, 2, 2}, []interface{}{resp.TotalResults, resp.StartIndex, resp.ItemsPerPage})
+	resp, err = svc.ListUsers(ctx, scim.Query{StartIndex: 2, Count: &two})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{4, 2, 2}, []interface{}{resp.TotalResults, resp.StartIndex, resp.ItemsPerPage})
+	assert.Equal(t, "bo", resp.Resources[0].(scim.User).ID)
+	zero := 0
+	resp, err = svc.ListUsers(ctx, scim.Query{StartIndex: 9, Count: &zero})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{4, 0}, []interface{}{resp.TotalResults, len(resp.Resources)})
+
+	_, err = svc.ListUsers(ctx, scim.Query{Filter: `userName eq`})
+	assert.Equal(t, scim.TypeInvalidFilter, scimType(err))
+}
+
+func TestProvisionGroups(t *testing.T) {
+	svc := newProvisioning(t)
+	ctx := context.Background()
+	_, err := svc.CreateUser(ctx, scim.User{UserName: "al", ExternalID: "c111111111"})
+	assert.NoError(t, err)
+	_, err = svc.CreateGroup(ctx, scim.Group{DisplayName: "staff"})
+	assert.NoError(t, err)
+
+	// Members without a type are groups if there is one of that name.
+	g, err := svc.CreateGroup(ctx, scim.Group{DisplayName: "tour guides", Members: []scim.Member{{Value: "al"}, {Value: "staff"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []scim.Member{{Value: "al", Type: scim.MemberUser}, {Value: "staff", Type: scim.MemberGroup}}, g.Members)
+	_, err = svc.CreateGroup(ctx, scim.Group{DisplayName: "tour guides"})
+	assert.Equal(t, loginservice.ErrGroupExists, err)
+
+	// A failed create leaves no group behind.
+	_, err = svc.CreateGroup(ctx, scim.Group{DisplayName: "managers", Members: []scim.Member{{Value: "nobody"}}})
+	assert.True(t, errors.Is(err, loginservice.ErrInvalidArgument))
+	_, err = svc.GetGroup(ctx, "managers")
+	assert.Equal(t, loginservice.ErrGroupNotFound, err)
+
+	patch := func(ops ...scim.PatchOperation) (scim.Group, error) {
+		return svc.PatchGroup(ctx, "tour guides", scim.PatchRequest{Schemas: []string{scim.PatchOpSchema}, Operations: ops})
+	}
+	g, err = patch(
+		scim.PatchOperation{Op: "remove", Path: `members[value eq "al"]`},
+		scim.PatchOperation{Op: "add", Path: "members", Value: []interface{}{map[string]interface{}{"value": "ed"}}},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []scim.Member{{Value: "ed", Type: scim.MemberUser}, {Value: "staff", Type: scim.MemberGroup}}, g.Members)
+	_, err = patch(scim.PatchOperation{Op: "add", Path: "members", Value: []interface{}{map[string]interface{}{"value": "nobody", "type": "User"}}})
+	assert.True(t, errors.Is(err, loginservice.ErrInvalidArgument))
+	_, err = patch(scim.PatchOperation{Op: "replace", Path: "displayName", Value: "guides"})
+	assert.Equal(t, scim.TypeMutability, scimType(err))
+
+	// Nesting a group in one of its members fails.
+	_, err = svc.PatchGroup(ctx, "staff", scim.PatchRequest{Schemas: []string{scim.PatchOpSchema}, Operations: []scim.PatchOperation{
+		{Op: "add", Path: "members", Value: []interface{}{map[string]interface{}{"value": "tour guides"}}},
+	}})
+	assert.True(t, errors.Is(err, loginservice.ErrInvalidArgument))
+
+	resp, err := svc.ListGroups(ctx, scim.Query{Filter: `members[value eq "ed" and type eq "User"]`})
+	assert.NoError(t, err)
+	if assert.Len(t, resp.Resources, 1) {
+		assert.Equal(t, "tour guides", resp.Resources[0].(scim.Group).ID)
+	}
+	resp, err = svc.ListGroups(ctx, scim.Query{Filter: `displayName eq "staff"`})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resp.TotalResults)
+
+	g, err = svc.ReplaceGroup(ctx, "tour guides", scim.Group{DisplayName: "tour guides"})
+	assert.NoError(t, err)
+	assert.Empty(t, g.Members)
+	_, err = svc.ReplaceGroup(ctx, "tour guides", scim.Group{})
+	assert.True(t, errors.Is(err, loginservice.ErrInvalidArgument))
+
+	assert.NoError(t, svc.DeleteGroup(ctx, "tour guides"))
+	assert.Equal(t, loginservice.ErrGroupNotFound, svc.DeleteGroup(ctx, "tour guides"))
+}
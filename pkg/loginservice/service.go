@@ -2,6 +2,11 @@ package loginservice
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"loginsvc/pkg/password"
 	"loginsvc/repo"
 
 	"github.com/go-kit/kit/log"
@@ -10,34 +15,145 @@ import (
 
 type Service interface {
 	Name(ctx context.Context, N string) (string, error)
+	Authenticate(ctx context.Context, name, password string) (string, error)
+	// Check reports whether the user named subject holds permission on
+	// resource through one of its roles.
+	Check(ctx context.Context, subject, permission, resource string) (bool, error)
 }
 
-func New(logger log.Logger, ints, chars metrics.Counter) Service {
+func New(r repo.LoginRepository, roles repo.RoleRepository, hashers *password.Registry, logger log.Logger, ints, chars, upgrades metrics.Counter) Service {
 	var svc Service
 	{
-		svc = NewBasicService()
+		svc = NewBasicService(r, roles, hashers, upgrades)
 		svc = LoggingMiddleware(logger)(svc)
 		svc = InstrumentingMiddleware(ints, chars)(svc)
 	}
 	return svc
 }
 
-// NewBasicService returns a naïve, stateless implementation of Service.
-func NewBasicService() Service {
+// NewBasicService returns a naïve, stateless implementation of Service
+// backed by r, and by roles for authorization checks. Passwords are
+// verified with hashers; upgrades counts the hashes replaced on login, by
+// result.
+func NewBasicService(r repo.LoginRepository, roles repo.RoleRepository, hashers *password.Registry, upgrades metrics.Counter) Service {
 	return basicService{
-		// repo: repo.GetSqliteLoginRepository(),
-		repo: repo.GetMySQLLoginRepo(),
+		repo:     r,
+		roles:    roles,
+		hashers:  hashers,
+		upgrades: upgrades,
 	}
 }
 
 type basicService struct {
-	repo repo.LoginRepository
+	repo     repo.LoginRepository
+	roles    repo.RoleRepository
+	hashers  *password.Registry
+	upgrades metrics.Counter
 }
 
 func (s basicService) Name(c context.Context, n string) (string, error) {
+	if n == "" {
+		return "", NewInvalidArgument(FieldViolation{Field: "name", Description: "is required"})
+	}
 	sid, err := s.repo.Name(n)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
 	if err != nil {
 		return "", err
 	}
 	return sid, nil
 }
+
+// Authenticate checks the password of the user named name and returns its
+// sid. A hash made with an outdated scheme or parameters is replaced by one
+// from the default scheme while the plain password is at hand. Disabled
+// users are told so only once their password is verified. A stored hash
+// that can't be verified, e.g. in an unsupported format, fails like a wrong
+// password, with the cause kept for the logs.
+func (s basicService) Authenticate(c context.Context, name, pw string) (string, error) {
+	var violations []FieldViolation
+	if name == "" {
+		violations = append(violations, FieldViolation{Field: "name", Description: "is required"})
+	}
+	if pw == "" {
+		violations = append(violations, FieldViolation{Field: "password", Description: "is required"})
+	}
+	if len(violations) > 0 {
+		return "", NewInvalidArgument(violations...)
+	}
+	u, err := s.repo.User(name)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && u.PasswordHash == "") {
+		s.hashers.VerifyNothing(pw)
+		return "", ErrInvalidCredentials
+	}
+	if err != nil {
+		return "", err
+	}
+	ok, err := s.hashers.Verify(u.PasswordHash, pw)
+	if err != nil {
+		return "", &Error{Code: ErrInvalidCredentials.Code, Message: ErrInvalidCredentials.Message, Err: err}
+	}
+	if !ok {
+		return "", ErrInvalidCredentials
+	}
+	if u.Status == repo.StatusDisabled {
+		return "", ErrDisabled
+	}
+	if s.hashers.NeedsUpgrade(u.PasswordHash) {
+		s.upgrade(name, pw)
+	}
+	return u.SID, nil
+}
+
+// upgrade rehashes pw with the default scheme. It is best effort: the user
+// is authenticated either way, and the next login tries again.
+func (s basicService) upgrade(name, pw string) {
+	result := "error"
+	if hash, err := s.hashers.Hash(pw); err == nil && s.repo.SetPasswordHash(name, hash) == nil {
+		result = "ok"
+	}
+	s.upgrades.With("result", result).Add(1)
+}
+
+// Check looks for a permission of subject matching permission and resource.
+// Unknown users hold no permissions, so they are denied rather than
+// reported as not found.
+func (s basicService) Check(c context.Context, subject, permission, resource string) (bool, error) {
+	var violations []FieldViolation
+	if subject == "" {
+		violations = append(violations, FieldViolation{Field: "subject", Description: "is required"})
+	}
+	if permission == "" {
+		violations = append(violations, FieldViolation{Field: "permission", Description: "is required"})
+	}
+	if len(violations) > 0 {
+		return false, NewInvalidArgument(violations...)
+	}
+	perms, err := s.roles.UserPermissions(subject)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range perms {
+		if grants(p, permission, resource) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// grants reports whether p allows permission on resource. A grant on
+// repo.AnyResource covers every resource, and one on a resource ending in
+// "/*" covers the resources under it, as "orders/*" covers "orders/42".
+func grants(p repo.Permission, permission, resource string) bool {
+	if p.Name != permission {
+		return false
+	}
+	switch {
+	case p.Resource == repo.AnyResource || p.Resource == resource:
+		return true
+	case strings.HasSuffix(p.Resource, "/*"):
+		return strings.HasPrefix(resource, strings.TrimSuffix(p.Resource, "*"))
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package loginservice_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/password"
+	"loginsvc/repo"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+type userRepo struct {
+	repo.LoginRepository
+	users map[string]repo.User
+}
+
+func (r *userRepo) User(n string) (repo.User, error) {
+	u, ok := r.users[n]
+	if !ok {
+		return repo.User{}, sql.ErrNoRows
+	}
+	return u, nil
+}
+
+func (r *userRepo) SetPasswordHash(n, hash string) error {
+	u := r.users[n]
+	u.PasswordHash = hash
+	r.users[n] = u
+	return nil
+}
+
+// resultCounter counts by the value of its single label.
+type resultCounter struct {
+	counts map[string]float64
+	label  string
+}
+
+func (c *resultCounter) With(labelValues ...string) metrics.Counter {
+	return &resultCounter{counts: c.counts, label: labelValues[1]}
+}
+
+func (c *resultCounter) Add(delta float64) { c.counts[c.label] += delta }
+
+// roleRepo holds the permissions of each user.
+type roleRepo struct {
+	repo.RoleRepository
+	perms map[string][]repo.Permission
+}
+
+func (r roleRepo) UserPermissions(user string) ([]repo.Permission, error) {
+	return r.perms[user], nil
+}
+
+func newService() (loginservice.Service, *userRepo, map[string]float64) {
+	r := &userRepo{users: map[string]repo.User{
+		"al": {Name: "al", SID: "c111111111", PasswordHash: "$1$3azHgidD$SrJPt7B.9rekpmwJwtON31"},
+		"bo": {Name: "bo", SID: "d222222222"},
+	}}
+	hashers := password.NewRegistry("2b")
+	hashers.Register(password.NewBcrypt(4), "2a", "2b", "2y")
+	hashers.Register(password.MD5Crypt{}, "1")
+	roles := roleRepo{perms: map[string][]repo.Permission{
+		"al": {{Name: "orders.read", Resource: repo.AnyResource}, {Name: "orders.write", Resource: "orders/*"}},
+		"bo": {{Name: "orders.write", Resource: "orders/42"}},
+	}}
+	upgrades := map[string]float64{}
+	return loginservice.NewBasicService(r, roles, hashers, &resultCounter{counts: upgrades}), r, upgrades
+}
+
+func TestAuthenticateUpgradesLegacyHash(t *testing.T) {
+	svc, r, upgrades := newService()
+
+	sid, err := svc.Authenticate(context.Background(), "al", "password")
+	assert.NoError(t, err)
+	assert.Equal(t, "c111111111", sid)
+	assert.Equal(t, "bcrypt-4", password.Describe(r.users["al"].PasswordHash))
+	assert.Equal(t, map[string]float64{"ok": 1}, upgrades)
+
+	sid, err = svc.Authenticate(context.Background(), "al", "password")
+	assert.NoError(t, err)
+	assert.Equal(t, "c111111111", sid)
+	assert.Equal(t, map[string]float64{"ok": 1}, upgrades)
+}
+
+func TestAuthenticateRejects(t *testing.T) {
+	svc, r, upgrades := newService()
+	legacy := r.users["al"].PasswordHash
+
+	for _, tc := range []struct{ name, password string }{
+		{"al", "Password"},
+		{"bo", "password"},
+		{"cy", "password"},
+	} {
+		_, err := svc.Authenticate(context.Background(), tc.name, tc.password)
+		assert.Equal(t, loginservice.ErrInvalidCredentials, err, tc.name)
+	}
+	assert.Equal(t, legacy, r.users["al"].PasswordHash)
+	assert.Empty(t, upgrades)
+}
+
+func TestAuthenticateRejectsBadStoredHash(t *testing.T) {
+	svc, r, upgrades := newService()
+	for _, hash := range []string{"5f4dcc3b5aa765d61d8327deb882cf99", "$2b$10$tooshort"} {
+		r.users["al"] = repo.User{Name: "al", SID: "c111111111", PasswordHash: hash}
+		_, err := svc.Authenticate(context.Background(), "al", "password")
+		assert.True(t, errors.Is(err, loginservice.ErrInvalidCredentials), "%s: %v", hash, err)
+		assert.Error(t, errors.Unwrap(err), "the cause is kept for the logs")
+		assert.Equal(t, hash, r.users["al"].PasswordHash)
+	}
+	assert.Empty(t, upgrades)
+}
+
+func TestAuthenticateValidates(t *testing.T) {
+	svc, _, _ := newService()
+	_, err := svc.Authenticate(context.Background(), "", "")
+	var e *loginservice.Error
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, loginservice.CodeInvalidArgument, e.Code)
+	assert.Equal(t, []loginservice.FieldViolation{
+		{Field: "name", Description: "is required"},
+		{Field: "password", Description: "is required"},
+	}, e.Violations)
+}
+
+func TestCheck(t *testing.T) {
+	svc, _, _ := newService()
+	for _, tc := range []struct {
+		subject, permission, resource string
+		want                          bool
+	}{
+		{"al", "orders.read", "orders/42", true},
+		{"al", "orders.read", "", true},
+		{"al", "orders.write", "orders/42", true},
+		{"al", "orders.write", "orders", false},
+		{"al", "orders.write", "ordersx/1", false},
+		{"al", "orders.delete", "orders/42", false},
+		{"bo", "orders.write", "orders/42", true},
+		{"bo", "orders.write", "orders/43", false},
+		{"bo", "orders.read", "orders/42", false},
+		{"cy", "orders.read", "orders/42", false},
+	} {
+		allowed, err := svc.Check(context.Background(), tc.subject, tc.permission, tc.resource)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.want, allowed, "%s %s %s", tc.subject, tc.permission, tc.resource)
+	}
+
+	_, err := svc.Check(context.Background(), "", "", "orders/42")
+	var e *loginservice.Error
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, []loginservice.FieldViolation{
+		{Field: "subject", Description: "is required"},
+		{Field: "permission", Description: "is required"},
+	}, e.Violations)
+}
+
+func TestAuthenticateDisabled(t *testing.T) {
+	svc, r, _ := newService()
+	al := r.users["al"]
+	al.Status = repo.StatusDisabled
+	r.users["al"] = al
+
+	_, err := svc.Authenticate(context.Background(), "al", "Password")
+	assert.Equal(t, loginservice.ErrInvalidCredentials, err)
+	_, err = svc.Authenticate(context.Background(), "al", "password")
+	assert.Equal(t, loginservice.ErrDisabled, err)
+}
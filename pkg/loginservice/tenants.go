@@ -0,0 +1,299 @@
+package loginservice
+
+import (
+	"context"
+
+	"loginsvc/pkg/scim"
+	"loginsvc/pkg/tenant"
+)
+
+// Tenants is a Service that serves every tenant from a Service of its own,
+// typically built on repositories scoped to the tenant. Each call goes to
+// the service of the tenant stored in its context with tenant.NewContext;
+// calls without one, or for a tenant missing from the map, fail with
+// ErrUnknownTenant, so one tenant's data is never reached from another.
+type Tenants map[string]Service
+
+func (t Tenants) Name(ctx context.Context, n string) (string, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return "", err
+	}
+	return svc.Name(ctx, n)
+}
+
+func (t Tenants) Authenticate(ctx context.Context, name, password string) (string, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return "", err
+	}
+	return svc.Authenticate(ctx, name, password)
+}
+
+func (t Tenants) Check(ctx context.Context, subject, permission, resource string) (bool, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return false, err
+	}
+	return svc.Check(ctx, subject, permission, resource)
+}
+
+func (t Tenants) service(ctx context.Context) (Service, error) {
+	id, ok := tenant.FromContext(ctx)
+	if !ok {
+		return nil, ErrUnknownTenant
+	}
+	svc, ok := t[id]
+	if !ok {
+		return nil, ErrUnknownTenant
+	}
+	return svc, nil
+}
+
+// AdminTenants is Tenants for AdminService: support staff only ever reach
+// the users of the tenant their request names.
+type AdminTenants map[string]AdminService
+
+func (t AdminTenants) CreateUser(ctx context.Context, a Account, password string) (Account, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return Account{}, err
+	}
+	return svc.CreateUser(ctx, a, password)
+}
+
+func (t AdminTenants) GetUser(ctx context.Context, name string) (Account, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return Account{}, err
+	}
+	return svc.GetUser(ctx, name)
+}
+
+func (t AdminTenants) UpdateUser(ctx context.Context, u AccountUpdate) (Account, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return Account{}, err
+	}
+	return svc.UpdateUser(ctx, u)
+}
+
+func (t AdminTenants) DisableUser(ctx context.Context, name string) (Account, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return Account{}, err
+	}
+	return svc.DisableUser(ctx, name)
+}
+
+func (t AdminTenants) EnableUser(ctx context.Context, name string) (Account, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return Account{}, err
+	}
+	return svc.EnableUser(ctx, name)
+}
+
+func (t AdminTenants) DeleteUser(ctx context.Context, name string) error {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return err
+	}
+	return svc.DeleteUser(ctx, name)
+}
+
+func (t AdminTenants) ForcePasswordReset(ctx context.Context, name string) (Account, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return Account{}, err
+	}
+	return svc.ForcePasswordReset(ctx, name)
+}
+
+func (t AdminTenants) RevokeSessions(ctx context.Context, name string) (Account, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return Account{}, err
+	}
+	return svc.RevokeSessions(ctx, name)
+}
+
+func (t AdminTenants) ResetMFA(ctx context.Context, name string) (Account, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return Account{}, err
+	}
+	return svc.ResetMFA(ctx, name)
+}
+
+func (t AdminTenants) ListUsers(ctx context.Context, q UserQuery) (AccountPage, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return AccountPage{}, err
+	}
+	return svc.ListUsers(ctx, q)
+}
+
+func (t AdminTenants) AuditEvents(ctx context.Context, q AuditQuery) (AuditPage, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return AuditPage{}, err
+	}
+	return svc.AuditEvents(ctx, q)
+}
+
+func (t AdminTenants) CreateWebhook(ctx context.Context, w Webhook) (Webhook, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return Webhook{}, err
+	}
+	return svc.CreateWebhook(ctx, w)
+}
+
+func (t AdminTenants) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return svc.ListWebhooks(ctx)
+}
+
+func (t AdminTenants) DeleteWebhook(ctx context.Context, id int64) error {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return err
+	}
+	return svc.DeleteWebhook(ctx, id)
+}
+
+func (t AdminTenants) RedeliverWebhook(ctx context.Context, id int64) (int, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return svc.RedeliverWebhook(ctx, id)
+}
+
+func (t AdminTenants) service(ctx context.Context) (AdminService, error) {
+	id, ok := tenant.FromContext(ctx)
+	if !ok {
+		return nil, ErrUnknownTenant
+	}
+	svc, ok := t[id]
+	if !ok {
+		return nil, ErrUnknownTenant
+	}
+	return svc, nil
+}
+
+// ProvisioningTenants is Tenants for ProvisioningService: a SCIM client
+// only ever provisions the tenant its request names.
+type ProvisioningTenants map[string]ProvisioningService
+
+func (t ProvisioningTenants) CreateUser(ctx context.Context, u scim.User) (scim.User, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return scim.User{}, err
+	}
+	return svc.CreateUser(ctx, u)
+}
+
+func (t ProvisioningTenants) GetUser(ctx context.Context, id string) (scim.User, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return scim.User{}, err
+	}
+	return svc.GetUser(ctx, id)
+}
+
+func (t ProvisioningTenants) ListUsers(ctx context.Context, q scim.Query) (scim.ListResponse, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return scim.ListResponse{}, err
+	}
+	return svc.ListUsers(ctx, q)
+}
+
+func (t ProvisioningTenants) ReplaceUser(ctx context.Context, id string, u scim.User) (scim.User, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return scim.User{}, err
+	}
+	return svc.ReplaceUser(ctx, id, u)
+}
+
+func (t ProvisioningTenants) PatchUser(ctx context.Context, id string, p scim.PatchRequest) (scim.User, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return scim.User{}, err
+	}
+	return svc.PatchUser(ctx, id, p)
+}
+
+func (t ProvisioningTenants) DeleteUser(ctx context.Context, id string) error {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return err
+	}
+	return svc.DeleteUser(ctx, id)
+}
+
+func (t ProvisioningTenants) CreateGroup(ctx context.Context, g scim.Group) (scim.Group, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return scim.Group{}, err
+	}
+	return svc.CreateGroup(ctx, g)
+}
+
+func (t ProvisioningTenants) GetGroup(ctx context.Context, id string) (scim.Group, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return scim.Group{}, err
+	}
+	return svc.GetGroup(ctx, id)
+}
+
+func (t ProvisioningTenants) ListGroups(ctx context.Context, q scim.Query) (scim.ListResponse, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return scim.ListResponse{}, err
+	}
+	return svc.ListGroups(ctx, q)
+}
+
+func (t ProvisioningTenants) ReplaceGroup(ctx context.Context, id string, g scim.Group) (scim.Group, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return scim.Group{}, err
+	}
+	return svc.ReplaceGroup(ctx, id, g)
+}
+
+func (t ProvisioningTenants) PatchGroup(ctx context.Context, id string, p scim.PatchRequest) (scim.Group, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return scim.Group{}, err
+	}
+	return svc.PatchGroup(ctx, id, p)
+}
+
+func (t ProvisioningTenants) DeleteGroup(ctx context.Context, id string) error {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return err
+	}
+	return svc.DeleteGroup(ctx, id)
+}
+
+func (t ProvisioningTenants) service(ctx context.Context) (ProvisioningService, error) {
+	id, ok := tenant.FromContext(ctx)
+	if !ok {
+		return nil, ErrUnknownTenant
+	}
+	svc, ok := t[id]
+	if !ok {
+		return nil, ErrUnknownTenant
+	}
+	return svc, nil
+}
@@ -0,0 +1,455 @@
+package logintransport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/tracing/opentracing"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	httptransport "github.com/go-kit/kit/transport/http"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	stdzipkin "github.com/openzipkin/zipkin-go"
+
+	pb "loginsvc/pb"
+	"loginsvc/pkg/loginendpoint"
+	"loginsvc/pkg/loginservice"
+)
+
+// NewHTTPHandlerWithAdmin is NewHTTPHandler, also serving the admin
+// endpoints under /admin/users/, the audit log at /admin/audit/events and
+// the webhook subscriptions under /admin/webhooks/.
+// Like the others they take a JSON body and are called with POST. The SCIM
+// endpoints are served under /scim/v2/, as SCIM clients expect.
+func NewHTTPHandlerWithAdmin(endpoints loginendpoint.Set, admin loginendpoint.AdminSet, scimEndpoints loginendpoint.SCIMSet, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger) http.Handler {
+	options := httpServerOptions(zipkinTracer, logger)
+	m := newHTTPMux(endpoints, options, otTracer, logger)
+	for _, route := range []struct {
+		path, method string
+		endpoint     endpoint.Endpoint
+		decode       httptransport.DecodeRequestFunc
+	}{
+		{"/admin/users/create", "CreateUser", admin.CreateUserEndpoint, decodeHTTPCreateUserRequest},
+		{"/admin/users/get", "GetUser", admin.GetUserEndpoint, decodeHTTPUserRequest},
+		{"/admin/users/update", "UpdateUser", admin.UpdateUserEndpoint, decodeHTTPUpdateUserRequest},
+		{"/admin/users/disable", "DisableUser", admin.DisableUserEndpoint, decodeHTTPUserRequest},
+		{"/admin/users/enable", "EnableUser", admin.EnableUserEndpoint, decodeHTTPUserRequest},
+		{"/admin/users/delete", "DeleteUser", admin.DeleteUserEndpoint, decodeHTTPUserRequest},
+		{"/admin/users/force-password-reset", "ForcePasswordReset", admin.ForcePasswordResetEndpoint, decodeHTTPUserRequest},
+		{"/admin/users/revoke-sessions", "RevokeSessions", admin.RevokeSessionsEndpoint, decodeHTTPUserRequest},
+		{"/admin/users/reset-mfa", "ResetMFA", admin.ResetMFAEndpoint, decodeHTTPUserRequest},
+		{"/admin/users/list", "ListUsers", admin.ListUsersEndpoint, decodeHTTPListUsersRequest},
+		{"/admin/audit/events", "AuditEvents", admin.AuditEventsEndpoint, decodeHTTPAuditEventsRequest},
+		{"/admin/webhooks/create", "CreateWebhook", admin.CreateWebhookEndpoint, decodeHTTPCreateWebhookRequest},
+		{"/admin/webhooks/list", "ListWebhooks", admin.ListWebhooksEndpoint, decodeHTTPListWebhooksRequest},
+		{"/admin/webhooks/delete", "DeleteWebhook", admin.DeleteWebhookEndpoint, decodeHTTPWebhookRequest},
+		{"/admin/webhooks/redeliver", "RedeliverWebhook", admin.RedeliverWebhookEndpoint, decodeHTTPWebhookRequest},
+	} {
+		m.Handle(route.path, httptransport.NewServer(
+			route.endpoint,
+			route.decode,
+			encodeHTTPGenericResponse,
+			append(options, httptransport.ServerBefore(opentracing.HTTPToContext(otTracer, route.method, logger)))...,
+		))
+	}
+	m.Handle(scimPrefix+"/", newSCIMHandler(scimEndpoints, options, otTracer, logger))
+	return withRequestID(withRateLimit(withTenant(m)))
+}
+
+func decodeHTTPCreateUserRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req loginendpoint.CreateUserRequest
+	return req, decodeHTTPBody(r, &req)
+}
+
+func decodeHTTPUserRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req loginendpoint.UserRequest
+	return req, decodeHTTPBody(r, &req)
+}
+
+func decodeHTTPUpdateUserRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req loginendpoint.UpdateUserRequest
+	return req, decodeHTTPBody(r, &req)
+}
+
+func decodeHTTPListUsersRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req loginendpoint.ListUsersRequest
+	return req, decodeHTTPBody(r, &req)
+}
+
+func decodeHTTPAuditEventsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req loginendpoint.AuditEventsRequest
+	return req, decodeHTTPBody(r, &req)
+}
+
+func decodeHTTPCreateWebhookRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req loginendpoint.CreateWebhookRequest
+	return req, decodeHTTPBody(r, &req)
+}
+
+func decodeHTTPListWebhooksRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req loginendpoint.ListWebhooksRequest
+	return req, decodeHTTPBody(r, &req)
+}
+
+func decodeHTTPWebhookRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req loginendpoint.WebhookRequest
+	return req, decodeHTTPBody(r, &req)
+}
+
+// decodeHTTPBody decodes the JSON body of r into req.
+func decodeHTTPBody(r *http.Request, req interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return &loginservice.Error{Code: loginservice.CodeInvalidArgument, Message: "malformed request body", Err: err}
+	}
+	return nil
+}
+
+type adminGRPCServer struct {
+	createUser         grpctransport.Handler
+	getUser            grpctransport.Handler
+	updateUser         grpctransport.Handler
+	disableUser        grpctransport.Handler
+	enableUser         grpctransport.Handler
+	deleteUser         grpctransport.Handler
+	forcePasswordReset grpctransport.Handler
+	revokeSessions     grpctransport.Handler
+	resetMFA           grpctransport.Handler
+	listUsers          grpctransport.Handler
+	auditEvents        grpctransport.Handler
+	createWebhook      grpctransport.Handler
+	listWebhooks       grpctransport.Handler
+	deleteWebhook      grpctransport.Handler
+	redeliverWebhook   grpctransport.Handler
+	pb.UnimplementedUserAdminServer
+}
+
+// NewAdminGRPCServer makes a set of admin endpoints available as a gRPC
+// UserAdminServer, to register next to the LoginServer.
+func NewAdminGRPCServer(endpoints loginendpoint.AdminSet, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger) pb.UserAdminServer {
+	options := grpcServerOptions(zipkinTracer, logger)
+	handler := func(method string, e endpoint.Endpoint, dec grpctransport.DecodeRequestFunc, enc grpctransport.EncodeResponseFunc) grpctransport.Handler {
+		return grpctransport.NewServer(e, dec, enc, append(options, grpctransport.ServerBefore(opentracing.GRPCToContext(otTracer, method, logger)))...)
+	}
+	return &adminGRPCServer{
+		createUser:         handler("CreateUser", endpoints.CreateUserEndpoint, decodeGRPCCreateUserRequest, encodeGRPCAccountResponse),
+		getUser:            handler("GetUser", endpoints.GetUserEndpoint, decodeGRPCUserRequest, encodeGRPCAccountResponse),
+		updateUser:         handler("UpdateUser", endpoints.UpdateUserEndpoint, decodeGRPCUpdateUserRequest, encodeGRPCAccountResponse),
+		disableUser:        handler("DisableUser", endpoints.DisableUserEndpoint, decodeGRPCUserRequest, encodeGRPCAccountResponse),
+		enableUser:         handler("EnableUser", endpoints.EnableUserEndpoint, decodeGRPCUserRequest, encodeGRPCAccountResponse),
+		deleteUser:         handler("DeleteUser", endpoints.DeleteUserEndpoint, decodeGRPCUserRequest, encodeGRPCDeleteUserResponse),
+		forcePasswordReset: handler("ForcePasswordReset", endpoints.ForcePasswordResetEndpoint, decodeGRPCUserRequest, encodeGRPCAccountResponse),
+		revokeSessions:     handler("RevokeSessions", endpoints.RevokeSessionsEndpoint, decodeGRPCUserRequest, encodeGRPCAccountResponse),
+		resetMFA:           handler("ResetMFA", endpoints.ResetMFAEndpoint, decodeGRPCUserRequest, encodeGRPCAccountResponse),
+		listUsers:          handler("ListUsers", endpoints.ListUsersEndpoint, decodeGRPCListUsersRequest, encodeGRPCListUsersResponse),
+		auditEvents:        handler("AuditEvents", endpoints.AuditEventsEndpoint, decodeGRPCAuditEventsRequest, encodeGRPCAuditEventsResponse),
+		createWebhook:      handler("CreateWebhook", endpoints.CreateWebhookEndpoint, decodeGRPCCreateWebhookRequest, encodeGRPCWebhookResponse),
+		listWebhooks:       handler("ListWebhooks", endpoints.ListWebhooksEndpoint, decodeGRPCListWebhooksRequest, encodeGRPCListWebhooksResponse),
+		deleteWebhook:      handler("DeleteWebhook", endpoints.DeleteWebhookEndpoint, decodeGRPCWebhookRequest, encodeGRPCDeleteWebhookResponse),
+		redeliverWebhook:   handler("RedeliverWebhook", endpoints.RedeliverWebhookEndpoint, decodeGRPCWebhookRequest, encodeGRPCRedeliverWebhookResponse),
+	}
+}
+
+// serve serves req with h, as grpcServer does.
+func (s *adminGRPCServer) serve(ctx context.Context, h grpctransport.Handler, req interface{}) (interface{}, error) {
+	ctx = grpcRateLimitContext(ctx)
+	_, rep, err := h.ServeGRPC(ctx, req)
+	setGRPCRateLimitHeader(ctx)
+	if err != nil {
+		return nil, toGRPCStatus(err)
+	}
+	return rep, nil
+}
+
+func (s *adminGRPCServer) account(ctx context.Context, h grpctransport.Handler, req interface{}) (*pb.AccountReply, error) {
+	rep, err := s.serve(ctx, h, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.AccountReply), nil
+}
+
+func (s *adminGRPCServer) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.AccountReply, error) {
+	return s.account(ctx, s.createUser, req)
+}
+
+func (s *adminGRPCServer) GetUser(ctx context.Context, req *pb.UserRequest) (*pb.AccountReply, error) {
+	return s.account(ctx, s.getUser, req)
+}
+
+func (s *adminGRPCServer) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.AccountReply, error) {
+	return s.account(ctx, s.updateUser, req)
+}
+
+func (s *adminGRPCServer) DisableUser(ctx context.Context, req *pb.UserRequest) (*pb.AccountReply, error) {
+	return s.account(ctx, s.disableUser, req)
+}
+
+func (s *adminGRPCServer) EnableUser(ctx context.Context, req *pb.UserRequest) (*pb.AccountReply, error) {
+	return s.account(ctx, s.enableUser, req)
+}
+
+func (s *adminGRPCServer) DeleteUser(ctx context.Context, req *pb.UserRequest) (*pb.DeleteUserReply, error) {
+	rep, err := s.serve(ctx, s.deleteUser, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.DeleteUserReply), nil
+}
+
+func (s *adminGRPCServer) ForcePasswordReset(ctx context.Context, req *pb.UserRequest) (*pb.AccountReply, error) {
+	return s.account(ctx, s.forcePasswordReset, req)
+}
+
+func (s *adminGRPCServer) RevokeSessions(ctx context.Context, req *pb.UserRequest) (*pb.AccountReply, error) {
+	return s.account(ctx, s.revokeSessions, req)
+}
+
+func (s *adminGRPCServer) ResetMFA(ctx context.Context, req *pb.UserRequest) (*pb.AccountReply, error) {
+	return s.account(ctx, s.resetMFA, req)
+}
+
+func (s *adminGRPCServer) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersReply, error) {
+	rep, err := s.serve(ctx, s.listUsers, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.ListUsersReply), nil
+}
+
+func (s *adminGRPCServer) AuditEvents(ctx context.Context, req *pb.AuditEventsRequest) (*pb.AuditEventsReply, error) {
+	rep, err := s.serve(ctx, s.auditEvents, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.AuditEventsReply), nil
+}
+
+func (s *adminGRPCServer) CreateWebhook(ctx context.Context, req *pb.CreateWebhookRequest) (*pb.WebhookReply, error) {
+	rep, err := s.serve(ctx, s.createWebhook, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.WebhookReply), nil
+}
+
+func (s *adminGRPCServer) ListWebhooks(ctx context.Context, req *pb.ListWebhooksRequest) (*pb.ListWebhooksReply, error) {
+	rep, err := s.serve(ctx, s.listWebhooks, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.ListWebhooksReply), nil
+}
+
+func (s *adminGRPCServer) DeleteWebhook(ctx context.Context, req *pb.WebhookRequest) (*pb.DeleteWebhookReply, error) {
+	rep, err := s.serve(ctx, s.deleteWebhook, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.DeleteWebhookReply), nil
+}
+
+func (s *adminGRPCServer) RedeliverWebhook(ctx context.Context, req *pb.WebhookRequest) (*pb.RedeliverWebhookReply, error) {
+	rep, err := s.serve(ctx, s.redeliverWebhook, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.RedeliverWebhookReply), nil
+}
+
+func decodeGRPCCreateUserRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.CreateUserRequest)
+	return loginendpoint.CreateUserRequest{Name: req.Name, SID: req.Sid, Email: req.Email, Phone: req.Phone, Password: req.Password}, nil
+}
+
+func decodeGRPCUserRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.UserRequest)
+	return loginendpoint.UserRequest{Name: req.Name}, nil
+}
+
+func decodeGRPCUpdateUserRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.UpdateUserRequest)
+	return loginendpoint.UpdateUserRequest{AccountUpdate: loginservice.AccountUpdate{
+		Name:     req.Name,
+		Email:    req.Email,
+		Phone:    req.Phone,
+		Password: req.Password,
+	}}, nil
+}
+
+func decodeGRPCListUsersRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ListUsersRequest)
+	return loginendpoint.ListUsersRequest{UserQuery: loginservice.UserQuery{
+		Status:      req.Status,
+		CreatedFrom: fromUnixSeconds(req.CreatedFrom),
+		CreatedTo:   fromUnixSeconds(req.CreatedTo),
+		Role:        req.Role,
+		NamePrefix:  req.NamePrefix,
+		Email:       req.Email,
+		Sort:        req.Sort,
+		Descending:  req.Descending,
+		PageToken:   req.PageToken,
+		PageSize:    int(req.PageSize),
+	}}, nil
+}
+
+func decodeGRPCAuditEventsRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.AuditEventsRequest)
+	return loginendpoint.AuditEventsRequest{AuditQuery: loginservice.AuditQuery{
+		From:       fromUnixSeconds(req.From),
+		To:         fromUnixSeconds(req.To),
+		Type:       req.Type,
+		Actor:      req.Actor,
+		Subject:    req.Subject,
+		Descending: req.Descending,
+		PageToken:  req.PageToken,
+		PageSize:   int(req.PageSize),
+	}}, nil
+}
+
+func decodeGRPCCreateWebhookRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.CreateWebhookRequest)
+	return loginendpoint.CreateWebhookRequest{Webhook: loginservice.Webhook{URL: req.Url, EventTypes: req.EventTypes, Secret: req.Secret}}, nil
+}
+
+func decodeGRPCListWebhooksRequest(context.Context, interface{}) (interface{}, error) {
+	return loginendpoint.ListWebhooksRequest{}, nil
+}
+
+func decodeGRPCWebhookRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.WebhookRequest)
+	return loginendpoint.WebhookRequest{ID: req.Id}, nil
+}
+
+// encodeGRPCAccountResponse is a transport/grpc.EncodeResponseFunc that
+// converts a user-domain account response to a gRPC reply.
+func encodeGRPCAccountResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(loginendpoint.AccountResponse)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	return &pb.AccountReply{Account: pbAccount(resp.Account)}, nil
+}
+
+func encodeGRPCDeleteUserResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(loginendpoint.DeleteUserResponse)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	return &pb.DeleteUserReply{}, nil
+}
+
+func encodeGRPCListUsersResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(loginendpoint.ListUsersResponse)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	rep := &pb.ListUsersReply{NextPageToken: resp.NextPageToken}
+	for _, a := range resp.Accounts {
+		rep.Accounts = append(rep.Accounts, pbAccount(a))
+	}
+	return rep, nil
+}
+
+func encodeGRPCAuditEventsResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(loginendpoint.AuditEventsResponse)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	rep := &pb.AuditEventsReply{NextPageToken: resp.NextPageToken}
+	for _, e := range resp.Events {
+		rep.Events = append(rep.Events, &pb.AuditEvent{
+			Seq:     e.Seq,
+			Time:    e.Time.UnixNano(),
+			Type:    e.Type,
+			Actor:   e.Actor,
+			Subject: e.Subject,
+			Ip:      e.IP,
+			Outcome: e.Outcome,
+			Detail:  e.Detail,
+			Prev:    e.Prev,
+			Hash:    e.Hash,
+		})
+	}
+	return rep, nil
+}
+
+func encodeGRPCWebhookResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(loginendpoint.WebhookResponse)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	return &pb.WebhookReply{Webhook: pbWebhook(resp.Webhook)}, nil
+}
+
+func encodeGRPCListWebhooksResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(loginendpoint.ListWebhooksResponse)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	rep := &pb.ListWebhooksReply{}
+	for _, w := range resp.Webhooks {
+		rep.Webhooks = append(rep.Webhooks, pbWebhook(w))
+	}
+	return rep, nil
+}
+
+func encodeGRPCDeleteWebhookResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(loginendpoint.DeleteWebhookResponse)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	return &pb.DeleteWebhookReply{}, nil
+}
+
+func encodeGRPCRedeliverWebhookResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(loginendpoint.RedeliverWebhookResponse)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	return &pb.RedeliverWebhookReply{Redelivered: int32(resp.Redelivered)}, nil
+}
+
+func pbWebhook(w loginservice.Webhook) *pb.Webhook {
+	return &pb.Webhook{
+		Id:         w.ID,
+		Url:        w.URL,
+		EventTypes: w.EventTypes,
+		Secret:     w.Secret,
+		Created:    unixSeconds(w.Created),
+		Pending:    int32(w.Pending),
+		Dead:       int32(w.Dead),
+	}
+}
+
+func pbAccount(a loginservice.Account) *pb.Account {
+	return &pb.Account{
+		Name:            a.Name,
+		Sid:             a.SID,
+		Email:           a.Email,
+		Phone:           a.Phone,
+		Status:          a.Status,
+		Created:         unixSeconds(a.Created),
+		SessionsRevoked: unixSeconds(a.SessionsRevoked),
+		HasPassword:     a.HasPassword,
+		HasMfa:          a.HasMFA,
+	}
+}
+
+// unixSeconds returns t in Unix seconds, 0 for the zero time.
+func unixSeconds(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// fromUnixSeconds is the inverse of unixSeconds.
+func fromUnixSeconds(s int64) time.Time {
+	if s == 0 {
+		return time.Time{}
+	}
+	return time.Unix(s, 0)
+}
@@ -0,0 +1,288 @@
+package logintransport_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+	kitgrpc "github.com/go-kit/kit/transport/grpc"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "loginsvc/pb"
+	"loginsvc/pkg/breaker"
+	"loginsvc/pkg/caller"
+	"loginsvc/pkg/limiter"
+	"loginsvc/pkg/loginendpoint"
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/logintransport"
+	"loginsvc/pkg/tenant"
+	"loginsvc/repo"
+)
+
+// adminService keeps accounts in a map and lists them one per page.
+type adminService struct {
+	loginservice.AdminService
+	accounts map[string]loginservice.Account
+}
+
+func (s adminService) CreateUser(_ context.Context, a loginservice.Account, _ string) (loginservice.Account, error) {
+	if _, ok := s.accounts[a.Name]; ok {
+		return loginservice.Account{}, loginservice.ErrAlreadyExists
+	}
+	a.Status, a.Created = "active", time.Unix(1600000000, 0)
+	s.accounts[a.Name] = a
+	return a, nil
+}
+
+func (s adminService) GetUser(_ context.Context, n string) (loginservice.Account, error) {
+	a, ok := s.accounts[n]
+	if !ok {
+		return loginservice.Account{}, loginservice.ErrNotFound
+	}
+	return a, nil
+}
+
+func (s adminService) UpdateUser(ctx context.Context, u loginservice.AccountUpdate) (loginservice.Account, error) {
+	a, err := s.GetUser(ctx, u.Name)
+	if err != nil {
+		return a, err
+	}
+	if u.Email != nil {
+		a.Email = *u.Email
+	}
+	if u.Phone != nil {
+		a.Phone = *u.Phone
+	}
+	s.accounts[a.Name] = a
+	return a, nil
+}
+
+func (s adminService) DisableUser(ctx context.Context, n string) (loginservice.Account, error) {
+	a, err := s.GetUser(ctx, n)
+	if err != nil {
+		return a, err
+	}
+	a.Status = "disabled"
+	s.accounts[n] = a
+	return a, nil
+}
+
+func (s adminService) DeleteUser(ctx context.Context, n string) error {
+	if _, err := s.GetUser(ctx, n); err != nil {
+		return err
+	}
+	delete(s.accounts, n)
+	return nil
+}
+
+func (s adminService) ListUsers(_ context.Context, q loginservice.UserQuery) (loginservice.AccountPage, error) {
+	var page loginservice.AccountPage
+	for _, n := range []string{"al", "bo"} {
+		if a, ok := s.accounts[n]; ok && n > q.PageToken {
+			if len(page.Accounts) == 1 {
+				page.NextPageToken = page.Accounts[0].Name
+				break
+			}
+			page.Accounts = append(page.Accounts, a)
+		}
+	}
+	return page, nil
+}
+
+func (s adminService) AuditEvents(_ context.Context, q loginservice.AuditQuery) (loginservice.AuditPage, error) {
+	return loginservice.AuditPage{Events: []repo.AuditEvent{
+		{Seq: 7, Time: time.Unix(1600000000, 5), Type: "login", Subject: q.Subject, Outcome: "success", Hash: "ab"},
+	}}, nil
+}
+
+func (s adminService) CreateWebhook(_ context.Context, w loginservice.Webhook) (loginservice.Webhook, error) {
+	if w.URL == "" {
+		return loginservice.Webhook{}, loginservice.NewInvalidArgument(loginservice.FieldViolation{Field: "url", Description: "is required"})
+	}
+	w.ID, w.Secret, w.Created = 3, "s3cret", time.Unix(1600000000, 0)
+	return w, nil
+}
+
+func (s adminService) ListWebhooks(context.Context) ([]loginservice.Webhook, error) {
+	return []loginservice.Webhook{{ID: 3, URL: "https://hooks.example.com/", EventTypes: []string{}, Dead: 2}}, nil
+}
+
+func (s adminService) RedeliverWebhook(_ context.Context, id int64) (int, error) {
+	if id != 3 {
+		return 0, loginservice.ErrNotFound
+	}
+	return 2, nil
+}
+
+func newAdminEndpoints() loginendpoint.AdminSet {
+	return loginendpoint.MakeAdminEndpoints(adminService{accounts: map[string]loginservice.Account{
+		"bo": {Name: "bo", SID: "c2", Status: "active"},
+	}})
+}
+
+func TestHTTPAdmin(t *testing.T) {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	srv := httptest.NewServer(logintransport.NewHTTPHandlerWithAdmin(loginendpoint.Set{}, newAdminEndpoints(), loginendpoint.SCIMSet{}, tracer, nil, logger))
+	defer srv.Close()
+
+	post := func(path, body string) (int, string) {
+		resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if !assert.NoError(t, err) {
+			return 0, ""
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+	code, body := post("/admin/users/create", `{"name":"al","sid":"c1","email":"al@example.com"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"status":"active"`)
+	code, _ = post("/admin/users/create", `{"name":"al","sid":"c1"}`)
+	assert.Equal(t, http.StatusConflict, code)
+	code, body = post("/admin/users/update", `{"name":"al","phone":"555"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"email":"al@example.com","phone":"555"`)
+	code, body = post("/admin/users/disable", `{"name":"al"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"status":"disabled"`)
+
+	code, body = post("/admin/users/list", `{}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"next_page_token":"al"`)
+	code, body = post("/admin/users/list", `{"page_token":"al"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"name":"bo"`)
+	assert.Contains(t, body, `"next_page_token":""`)
+
+	code, _ = post("/admin/users/delete", `{"name":"al"}`)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = post("/admin/users/get", `{"name":"al"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = post("/admin/users/get", `{"name":`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, body = post("/admin/audit/events", `{"subject":"al"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"seq":7`)
+	assert.Contains(t, body, `"subject":"al"`)
+
+	code, body = post("/admin/webhooks/create", `{"url":"https://hooks.example.com/","event_types":["user.locked"]}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"secret":"s3cret"`)
+	assert.Contains(t, body, `"event_types":["user.locked"]`)
+	code, _ = post("/admin/webhooks/create", `{}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, body = post("/admin/webhooks/list", `{}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"dead":2`)
+	assert.NotContains(t, body, `"secret"`)
+	code, body = post("/admin/webhooks/redeliver", `{"id":3}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"redelivered":2`)
+	code, _ = post("/admin/webhooks/redeliver", `{"id":4}`)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestGRPCAdmin(t *testing.T) {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	lis := bufconn.Listen(1 << 16)
+	server := grpc.NewServer(grpc.UnaryInterceptor(kitgrpc.Interceptor))
+	pb.RegisterUserAdminServer(server, logintransport.NewAdminGRPCServer(newAdminEndpoints(), tracer, nil, logger))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	assert.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewUserAdminClient(conn)
+	ctx := context.Background()
+
+	rep, err := client.CreateUser(ctx, &pb.CreateUserRequest{Name: "al", Sid: "c1"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1600000000), rep.Account.Created)
+	assert.Zero(t, rep.Account.SessionsRevoked)
+	_, err = client.CreateUser(ctx, &pb.CreateUserRequest{Name: "al", Sid: "c1"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	// Unset optional fields are left alone.
+	email := "al@example.com"
+	rep, err = client.UpdateUser(ctx, &pb.UpdateUserRequest{Name: "al", Email: &email})
+	assert.NoError(t, err)
+	assert.Equal(t, "al@example.com", rep.Account.Email)
+	phone := "555"
+	rep, err = client.UpdateUser(ctx, &pb.UpdateUserRequest{Name: "al", Phone: &phone})
+	assert.NoError(t, err)
+	assert.Equal(t, "al@example.com", rep.Account.Email)
+	assert.Equal(t, "555", rep.Account.Phone)
+
+	list, err := client.ListUsers(ctx, &pb.ListUsersRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, list.Accounts, 1)
+	assert.Equal(t, "al", list.NextPageToken)
+
+	_, err = client.DeleteUser(ctx, &pb.UserRequest{Name: "al"})
+	assert.NoError(t, err)
+	_, err = client.GetUser(ctx, &pb.UserRequest{Name: "al"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	events, err := client.AuditEvents(ctx, &pb.AuditEventsRequest{Subject: "al"})
+	if assert.NoError(t, err) && assert.Len(t, events.Events, 1) {
+		assert.Equal(t, int64(1600000000000000005), events.Events[0].Time)
+		assert.Equal(t, "al", events.Events[0].Subject)
+	}
+
+	w, err := client.CreateWebhook(ctx, &pb.CreateWebhookRequest{Url: "https://hooks.example.com/", EventTypes: []string{"user.locked"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "s3cret", w.Webhook.Secret)
+		assert.Equal(t, []string{"user.locked"}, w.Webhook.EventTypes)
+		assert.Equal(t, int64(1600000000), w.Webhook.Created)
+	}
+	webhooks, err := client.ListWebhooks(ctx, &pb.ListWebhooksRequest{})
+	if assert.NoError(t, err) && assert.Len(t, webhooks.Webhooks, 1) {
+		assert.Equal(t, int32(2), webhooks.Webhooks[0].Dead)
+	}
+	redelivered, err := client.RedeliverWebhook(ctx, &pb.WebhookRequest{Id: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), redelivered.Redelivered)
+	_, err = client.RedeliverWebhook(ctx, &pb.WebhookRequest{Id: 4})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestAdminAllowlistsPerTenant(t *testing.T) {
+	svc := adminService{accounts: map[string]loginservice.Account{"bo": {Name: "bo", SID: "c2", Status: "active"}}}
+	lim := limiter.New(limiter.NewMemoryStore(0), limiter.Rules{}, 0, log.NewNopLogger())
+	r := tenant.Resolver{Tenants: []string{"acme", "globex"}}
+	// The acme console may manage acme alone; the globex one may only
+	// read its users, since globex has no Admin list.
+	allow := loginendpoint.TenantAllowlists{
+		"acme":   {"Admin": {"spiffe://example.org/acme-console"}},
+		"globex": {"GetUser": {"spiffe://example.org/globex-console"}},
+	}
+	endpoints := loginendpoint.NewAdmin(svc, lim, breaker.NewRegistry(nil, nil, nil), allow, r, log.NewNopLogger(), discard.NewHistogram(), stdopentracing.GlobalTracer(), nil)
+	call := func(e endpoint.Endpoint, console, tenantID string) error {
+		ctx := caller.NewContext(context.Background(), caller.Identity{SPIFFEID: "spiffe://example.org/" + console})
+		_, err := e(tenant.WithClaims(ctx, tenant.Claims{Header: tenantID}), loginendpoint.UserRequest{Name: "bo"})
+		return err
+	}
+	assert.NoError(t, call(endpoints.GetUserEndpoint, "acme-console", "acme"))
+	assert.NoError(t, call(endpoints.DisableUserEndpoint, "acme-console", "acme"))
+	assert.Equal(t, loginservice.ErrPermissionDenied, call(endpoints.GetUserEndpoint, "acme-console", "globex"))
+	assert.NoError(t, call(endpoints.GetUserEndpoint, "globex-console", "globex"))
+	assert.Equal(t, loginservice.ErrPermissionDenied, call(endpoints.DisableUserEndpoint, "globex-console", "globex"))
+	assert.Equal(t, loginservice.ErrPermissionDenied, call(endpoints.GetUserEndpoint, "globex-console", "acme"))
+}
@@ -0,0 +1,142 @@
+package logintransport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	"github.com/go-kit/kit/sd/lb"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	stdzipkin "github.com/openzipkin/zipkin-go"
+	"google.golang.org/grpc"
+
+	"loginsvc/pkg/loginendpoint"
+	"loginsvc/pkg/loginservice"
+)
+
+// noTimeout stands for no timeout in lb.Retry, which requires one. The total
+// time of a call is bounded by ClientTimeout instead.
+const noTimeout = 100 * 365 * 24 * time.Hour
+
+// ClientRandom makes balanced clients pick a random instance for each call,
+// seeded with seed, instead of going round robin.
+func ClientRandom(seed int64) ClientOption {
+	return func(o *clientOptions) { o.random, o.seed = true, seed }
+}
+
+// ClientDialOptions sets the options NewBalancedGRPCClient dials instances
+// with. By default they are dialed without TLS.
+func ClientDialOptions(opts ...grpc.DialOption) ClientOption {
+	return func(o *clientOptions) { o.dialOptions = opts }
+}
+
+// NewBalancedHTTPClient returns a LoginService balancing calls across the
+// HTTP servers found by instancer. Each instance gets its own breakers,
+// named after the method and instance, as in "Name@10.0.0.1:8081". A failed
+// call to an idempotent method is retried at once on the next instance, up
+// to the attempts set by ClientRetries. Close the returned io.Closer once
+// done with the client.
+func NewBalancedHTTPClient(instancer sd.Instancer, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger, opts ...ClientOption) (loginservice.Service, io.Closer) {
+	o := newClientOptions(opts)
+	factory := func(instance string) (endpoint.Endpoint, io.Closer, error) {
+		set, err := makeHTTPEndpoints(instance, "@"+instance, otTracer, zipkinTracer, logger, o)
+		if err != nil {
+			return nil, nil, err
+		}
+		return dispatch(set), nil, nil
+	}
+	return o.balanced(instancer, factory, logger)
+}
+
+// NewBalancedGRPCClient returns a LoginService balancing calls across the
+// gRPC servers found by instancer, each dialed with ClientDialOptions. It
+// behaves like NewBalancedHTTPClient otherwise. Closing the returned
+// io.Closer closes the connections.
+func NewBalancedGRPCClient(instancer sd.Instancer, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger, opts ...ClientOption) (loginservice.Service, io.Closer) {
+	o := newClientOptions(opts)
+	factory := func(instance string) (endpoint.Endpoint, io.Closer, error) {
+		conn, err := grpc.Dial(instance, o.dialOptions...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return dispatch(makeGRPCEndpoints(conn, "@"+instance, otTracer, zipkinTracer, logger, o)), conn, nil
+	}
+	return o.balanced(instancer, factory, logger)
+}
+
+func (o clientOptions) balanced(instancer sd.Instancer, factory sd.Factory, logger log.Logger) (loginservice.Service, io.Closer) {
+	endpointer := sd.NewEndpointer(instancer, factory, logger)
+	var balancer lb.Balancer
+	if o.random {
+		balancer = lb.NewRandom(endpointer, o.seed)
+	} else {
+		balancer = lb.NewRoundRobin(endpointer)
+	}
+	set := loginendpoint.Set{
+		LoginEndpoint:        o.balance(balancer, true),
+		AuthenticateEndpoint: o.balance(balancer, false),
+		CheckEndpoint:        o.balance(balancer, true),
+	}
+	return set, closerFunc(endpointer.Close)
+}
+
+// balance returns the endpoint of a whole call through balancer, with
+// retries on other instances, hedging and the total timeout.
+func (o clientOptions) balance(balancer lb.Balancer, idempotent bool) endpoint.Endpoint {
+	attempts := 1
+	if idempotent && o.maxAttempts > 1 {
+		attempts = o.maxAttempts
+	}
+	next := lb.RetryWithCallback(noTimeout, balancer, func(n int, err error) (bool, error) {
+		return n < attempts && retryable(err), nil
+	})
+	next = finalError(next)
+	if idempotent && o.hedgeDelay > 0 && o.hedgeExtra > 0 {
+		next = hedge(o.hedgeDelay, o.hedgeExtra)(next)
+	}
+	if o.timeout > 0 {
+		next = deadline(o.timeout)(next)
+	}
+	return next
+}
+
+// finalError replaces the lb.RetryError of a failed call with the error of
+// its last attempt, so that callers can inspect it with errors.Is.
+func finalError(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		response, err := next(ctx, request)
+		var retryErr lb.RetryError
+		if errors.As(err, &retryErr) {
+			err = retryErr.Final
+		}
+		return response, err
+	}
+}
+
+// dispatch returns a single endpoint for the methods of set, so that the
+// instances of a balanced client are shared by its methods.
+func dispatch(set loginendpoint.Set) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		switch request.(type) {
+		case loginendpoint.LoginRequest:
+			return set.LoginEndpoint(ctx, request)
+		case loginendpoint.AuthenticateRequest:
+			return set.AuthenticateEndpoint(ctx, request)
+		case loginendpoint.CheckRequest:
+			return set.CheckEndpoint(ctx, request)
+		}
+		return nil, fmt.Errorf("unexpected request %T", request)
+	}
+}
+
+type closerFunc func()
+
+func (f closerFunc) Close() error {
+	f()
+	return nil
+}
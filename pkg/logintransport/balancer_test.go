@@ -0,0 +1,101 @@
+package logintransport_test
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	stdzipkin "github.com/openzipkin/zipkin-go"
+	"github.com/stretchr/testify/assert"
+
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/logintransport"
+)
+
+var balancedTransports = map[string]struct {
+	instance  func(*flakyServer, *testing.T) string
+	newClient func(sd.Instancer, stdopentracing.Tracer, *stdzipkin.Tracer, log.Logger, ...logintransport.ClientOption) (loginservice.Service, io.Closer)
+}{
+	"HTTP": {(*flakyServer).httpInstance, logintransport.NewBalancedHTTPClient},
+	"gRPC": {(*flakyServer).grpcInstance, logintransport.NewBalancedGRPCClient},
+}
+
+func balancedClient(t *testing.T, name string, servers []*flakyServer, opts ...logintransport.ClientOption) loginservice.Service {
+	tr := balancedTransports[name]
+	var instances sd.FixedInstancer
+	for _, f := range servers {
+		instances = append(instances, tr.instance(f, t))
+	}
+	client, closer := tr.newClient(instances, stdopentracing.GlobalTracer(), nil, log.NewNopLogger(), opts...)
+	t.Cleanup(func() { closer.Close() })
+	return client
+}
+
+func TestBalancedClientRoundRobin(t *testing.T) {
+	for name := range balancedTransports {
+		servers := []*flakyServer{{}, {}}
+		client := balancedClient(t, name, servers)
+		for i := 0; i < 4; i++ {
+			_, err := client.Name(context.Background(), "ed")
+			assert.NoError(t, err, name)
+		}
+		for _, f := range servers {
+			assert.Equal(t, int32(2), atomic.LoadInt32(&f.calls), name)
+		}
+	}
+}
+
+func TestBalancedClientRandom(t *testing.T) {
+	for name := range balancedTransports {
+		servers := []*flakyServer{{}, {}, {}}
+		client := balancedClient(t, name, servers, logintransport.ClientRandom(1))
+		for i := 0; i < 30; i++ {
+			_, err := client.Name(context.Background(), "ed")
+			assert.NoError(t, err, name)
+		}
+		var total int32
+		for _, f := range servers {
+			calls := atomic.LoadInt32(&f.calls)
+			assert.NotZero(t, calls, name)
+			total += calls
+		}
+		assert.Equal(t, int32(30), total, name)
+	}
+}
+
+func TestBalancedClientRetriesOnNextInstance(t *testing.T) {
+	for name := range balancedTransports {
+		down, up := &flakyServer{down: true}, &flakyServer{}
+		client := balancedClient(t, name, []*flakyServer{down, up},
+			logintransport.ClientRetries(2, time.Millisecond, 10*time.Millisecond))
+		for i := 0; i < 4; i++ {
+			sid, err := client.Name(context.Background(), "ed")
+			assert.NoError(t, err, name)
+			assert.Equal(t, "a123456789", sid, name)
+		}
+		assert.Equal(t, int32(4), atomic.LoadInt32(&up.calls), name)
+		assert.NotZero(t, atomic.LoadInt32(&down.calls), name)
+	}
+}
+
+func TestBalancedClientDoesNotRetryNonIdempotentCalls(t *testing.T) {
+	for name := range balancedTransports {
+		down, up := &flakyServer{down: true}, &flakyServer{}
+		client := balancedClient(t, name, []*flakyServer{down, up},
+			logintransport.ClientRetries(2, time.Millisecond, 10*time.Millisecond))
+		var failed int
+		for i := 0; i < 4; i++ {
+			if _, err := client.Authenticate(context.Background(), "ed", "password"); err != nil {
+				failed++
+			}
+		}
+		assert.Equal(t, 2, failed, name)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&down.calls), name)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&up.calls), name)
+	}
+}
@@ -0,0 +1,37 @@
+package logintransport
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"loginsvc/pkg/caller"
+)
+
+// httpCallerContext identifies the caller by its verified client
+// certificate, for loginendpoint.AuthorizingMiddleware.
+func httpCallerContext(ctx context.Context, r *http.Request) context.Context {
+	if id, ok := caller.FromTLS(r.TLS); ok {
+		return caller.NewContext(ctx, id)
+	}
+	return ctx
+}
+
+// grpcCallerContext is httpCallerContext for gRPC.
+func grpcCallerContext(ctx context.Context, _ metadata.MD) context.Context {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ctx
+	}
+	if id, ok := caller.FromTLS(&info.State); ok {
+		return caller.NewContext(ctx, id)
+	}
+	return ctx
+}
@@ -0,0 +1,133 @@
+package logintransport_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	kitgrpc "github.com/go-kit/kit/transport/grpc"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"loginsvc/internal/testca"
+	pb "loginsvc/pb"
+	"loginsvc/pkg/caller"
+	"loginsvc/pkg/loginendpoint"
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/logintransport"
+)
+
+// auditLog collects log lines.
+type auditLog struct {
+	mtx   sync.Mutex
+	lines []string
+}
+
+func (l *auditLog) Write(b []byte) (int, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.lines = append(l.lines, strings.TrimSpace(string(b)))
+	return len(b), nil
+}
+
+func (l *auditLog) Lines() []string {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return append([]string{}, l.lines...)
+}
+
+// newAuthorizedEndpoints only lets the web service call Name, and leaves
+// Authenticate open.
+func newAuthorizedEndpoints(logger log.Logger) loginendpoint.Set {
+	set := newEndpoints()
+	set.LoginEndpoint = loginendpoint.AuthorizingMiddleware("Name", caller.Allowlist{"spiffe://example.org/web"}, logger)(set.LoginEndpoint)
+	set.AuthenticateEndpoint = loginendpoint.AuthorizingMiddleware("Authenticate", nil, logger)(loginendpoint.MakeAuthenticateEndpoint(errorService{}))
+	return set
+}
+
+// serverTLS verifies client certificates from ca when given.
+func serverTLS(t *testing.T, ca *testca.Authority) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{ca.Issue(t, "server", 1)},
+		ClientCAs:    ca.Pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+}
+
+// clientTLS presents cert, if not nil, to servers issued by ca.
+func clientTLS(ca *testca.Authority, cert *tls.Certificate) *tls.Config {
+	c := &tls.Config{RootCAs: ca.Pool}
+	if cert != nil {
+		c.Certificates = []tls.Certificate{*cert}
+	}
+	return c
+}
+
+func checkAuthorization(t *testing.T, ca *testca.Authority, audit *auditLog, dial func(*tls.Config) loginservice.Service) {
+	ctx := context.Background()
+	web, batch := ca.Issue(t, "web", 2, "spiffe://example.org/web"), ca.Issue(t, "batch", 3, "spiffe://example.org/batch")
+
+	sid, err := dial(clientTLS(ca, &web)).Name(ctx, "ed")
+	assert.NoError(t, err)
+	assert.Equal(t, "a123456789", sid)
+	assert.Empty(t, audit.Lines())
+
+	_, err = dial(clientTLS(ca, &batch)).Name(ctx, "ed")
+	assert.True(t, errors.Is(err, loginservice.ErrPermissionDenied), "%v", err)
+	_, err = dial(clientTLS(ca, nil)).Name(ctx, "ed")
+	assert.True(t, errors.Is(err, loginservice.ErrPermissionDenied), "%v", err)
+	// A certificate from another CA is refused by the TLS handshake.
+	other := testca.New(t, "ca").Issue(t, "web", 2, "spiffe://example.org/web")
+	_, err = dial(clientTLS(ca, &other)).Name(ctx, "ed")
+	assert.Error(t, err)
+	assert.Equal(t, []string{
+		"audit=denied method=Name caller=spiffe://example.org/batch ip=127.0.0.1",
+		"audit=denied method=Name caller=anonymous ip=127.0.0.1",
+	}, audit.Lines())
+
+	sid, err = dial(clientTLS(ca, &batch)).Authenticate(ctx, "ed", "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "a123456789", sid)
+}
+
+func TestHTTPAuthorization(t *testing.T) {
+	tracer, audit := stdopentracing.GlobalTracer(), &auditLog{}
+	ca := testca.New(t, "ca")
+	srv := httptest.NewUnstartedServer(logintransport.NewHTTPHandler(newAuthorizedEndpoints(log.NewLogfmtLogger(audit)), tracer, nil, log.NewNopLogger()))
+	srv.TLS = serverTLS(t, ca)
+	srv.StartTLS()
+	defer srv.Close()
+
+	checkAuthorization(t, ca, audit, func(config *tls.Config) loginservice.Service {
+		client, err := logintransport.NewHTTPClient(srv.Listener.Addr().String(), tracer, nil, log.NewNopLogger(), logintransport.ClientTLS(config))
+		assert.NoError(t, err)
+		return client
+	})
+}
+
+func TestGRPCAuthorization(t *testing.T) {
+	tracer, audit := stdopentracing.GlobalTracer(), &auditLog{}
+	ca := testca.New(t, "ca")
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverTLS(t, ca))), grpc.UnaryInterceptor(kitgrpc.Interceptor))
+	pb.RegisterLoginServer(server, logintransport.NewGRPCServer(newAuthorizedEndpoints(log.NewLogfmtLogger(audit)), tracer, nil, log.NewNopLogger()))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	checkAuthorization(t, ca, audit, func(config *tls.Config) loginservice.Service {
+		conn, err := logintransport.DialGRPC(context.Background(), lis.Addr().String(), logintransport.ClientTLS(config))
+		assert.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return logintransport.NewGRPCClient(conn, tracer, nil, log.NewNopLogger(), logintransport.ClientTimeout(2*time.Second))
+	})
+}
@@ -0,0 +1,77 @@
+package logintransport_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	kitgrpc "github.com/go-kit/kit/transport/grpc"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "loginsvc/pb"
+	"loginsvc/pkg/loginendpoint"
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/logintransport"
+)
+
+// checkService allows ed to read orders/42 only.
+type checkService struct {
+	loginservice.Service
+}
+
+func (checkService) Check(_ context.Context, subject, permission, resource string) (bool, error) {
+	if subject == "" {
+		return false, loginservice.NewInvalidArgument(loginservice.FieldViolation{Field: "subject", Description: "is required"})
+	}
+	return subject == "ed" && permission == "orders.read" && resource == "orders/42", nil
+}
+
+func newCheckEndpoints() loginendpoint.Set {
+	return loginendpoint.Set{CheckEndpoint: loginendpoint.MakeCheckEndpoint(checkService{})}
+}
+
+func checkDecisions(t *testing.T, client loginservice.Service) {
+	ctx := context.Background()
+	allowed, err := client.Check(ctx, "ed", "orders.read", "orders/42")
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	allowed, err = client.Check(ctx, "ed", "orders.read", "orders/43")
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+
+	_, err = client.Check(ctx, "", "orders.read", "")
+	assert.True(t, errors.Is(err, loginservice.ErrInvalidArgument), "%v", err)
+}
+
+func TestHTTPCheck(t *testing.T) {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	srv := httptest.NewServer(logintransport.NewHTTPHandler(newCheckEndpoints(), tracer, nil, logger))
+	defer srv.Close()
+
+	client, err := logintransport.NewHTTPClient(srv.URL, tracer, nil, logger)
+	assert.NoError(t, err)
+	checkDecisions(t, client)
+}
+
+func TestGRPCCheck(t *testing.T) {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	lis := bufconn.Listen(1 << 16)
+	server := grpc.NewServer(grpc.UnaryInterceptor(kitgrpc.Interceptor))
+	pb.RegisterLoginServer(server, logintransport.NewGRPCServer(newCheckEndpoints(), tracer, nil, logger))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	assert.NoError(t, err)
+	defer conn.Close()
+	checkDecisions(t, logintransport.NewGRPCClient(conn, tracer, nil, logger))
+}
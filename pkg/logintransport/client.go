@@ -0,0 +1,83 @@
+package logintransport
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"loginsvc/pkg/breaker"
+)
+
+// ClientOption configures the clients returned by NewHTTPClient and
+// NewGRPCClient.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	breakers *breaker.Registry
+
+	maxAttempts             int
+	baseBackoff, maxBackoff time.Duration
+	timeout                 time.Duration
+	attemptTimeout          time.Duration
+	hedgeDelay              time.Duration
+	hedgeExtra              int
+
+	random      bool
+	seed        int64
+	dialOptions []grpc.DialOption
+
+	tls        *tls.Config
+	httpClient *http.Client
+
+	tenant string
+}
+
+// ClientBreakers wraps every client endpoint in the breaker of r named after
+// its method. By default each client gets its own registry with
+// breaker.DefaultSettings.
+func ClientBreakers(r *breaker.Registry) ClientOption {
+	return func(o *clientOptions) { o.breakers = r }
+}
+
+// ClientTLS makes clients use TLS with config: HTTP clients default to the
+// https scheme, and gRPC connections made by DialGRPC and
+// NewBalancedGRPCClient use it as transport credentials, unless
+// ClientDialOptions is given too. Build config with package tlsconfig to
+// present a client certificate that is reloaded when it changes on disk.
+func ClientTLS(config *tls.Config) ClientOption {
+	return func(o *clientOptions) { o.tls = config }
+}
+
+// DialGRPC dials target with the dial options set by ClientTLS or
+// ClientDialOptions, for NewGRPCClient. The connection is made in the
+// background unless ctx is done first.
+func DialGRPC(ctx context.Context, target string, opts ...ClientOption) (*grpc.ClientConn, error) {
+	o := newClientOptions(opts)
+	return grpc.DialContext(ctx, target, o.dialOptions...)
+}
+
+func newClientOptions(options []ClientOption) clientOptions {
+	var o clientOptions
+	for _, option := range options {
+		option(&o)
+	}
+	if o.breakers == nil {
+		o.breakers = breaker.NewRegistry(nil, nil, nil)
+	}
+	if o.dialOptions == nil && o.tls != nil {
+		o.dialOptions = []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(o.tls))}
+	}
+	if o.dialOptions == nil {
+		o.dialOptions = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	if o.tls != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = o.tls
+		o.httpClient = &http.Client{Transport: transport}
+	}
+	return o
+}
@@ -0,0 +1,174 @@
+package logintransport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/ratelimit"
+	"github.com/golang/protobuf/proto"
+	"github.com/sony/gobreaker"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"loginsvc/pkg/loginendpoint"
+	"loginsvc/pkg/loginservice"
+)
+
+// errorDomain identifies loginsvc in the ErrorInfo detail of gRPC statuses.
+const errorDomain = "loginsvc"
+
+var httpStatuses = map[loginservice.Code]int{
+	loginservice.CodeNotFound:         http.StatusNotFound,
+	loginservice.CodeAlreadyExists:    http.StatusConflict,
+	loginservice.CodeInvalidArgument:  http.StatusBadRequest,
+	loginservice.CodeUnauthenticated:  http.StatusUnauthorized,
+	loginservice.CodePermissionDenied: http.StatusForbidden,
+	loginservice.CodeLocked:           http.StatusLocked,
+	loginservice.CodeRateLimited:      http.StatusTooManyRequests,
+	loginservice.CodeUnavailable:      http.StatusServiceUnavailable,
+}
+
+var grpcCodes = map[loginservice.Code]codes.Code{
+	loginservice.CodeNotFound:         codes.NotFound,
+	loginservice.CodeAlreadyExists:    codes.AlreadyExists,
+	loginservice.CodeInvalidArgument:  codes.InvalidArgument,
+	loginservice.CodeUnauthenticated:  codes.Unauthenticated,
+	loginservice.CodePermissionDenied: codes.PermissionDenied,
+	loginservice.CodeLocked:           codes.FailedPrecondition,
+	loginservice.CodeRateLimited:      codes.ResourceExhausted,
+	loginservice.CodeUnavailable:      codes.Unavailable,
+}
+
+// domainError returns err as a domain error, translating the errors of the
+// rate limiter and circuit breaker wrapped around the endpoints. It returns
+// nil for internal errors.
+func domainError(err error) *loginservice.Error {
+	var e *loginservice.Error
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, ratelimit.ErrLimited):
+		return loginservice.ErrRateLimited
+	case errors.Is(err, gobreaker.ErrOpenState), errors.Is(err, gobreaker.ErrTooManyRequests):
+		return loginservice.ErrUnavailable
+	}
+	return nil
+}
+
+// codeError rebuilds the domain error a server sent as code and message.
+// Unknown codes, e.g. from a newer server, are kept as they are.
+func codeError(code loginservice.Code, message string) *loginservice.Error {
+	if message == "" {
+		message = string(code)
+	}
+	return &loginservice.Error{Code: code, Message: message}
+}
+
+// toGRPCStatus converts err to a gRPC status error. Domain errors carry
+// their code in an ErrorInfo detail; internal errors hide their text.
+func toGRPCStatus(err error) error {
+	e := domainError(err)
+	if e == nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Error(codes.Internal, "internal error")
+	}
+	c, ok := grpcCodes[e.Code]
+	if !ok {
+		c = codes.Unknown
+	}
+	details := []proto.Message{&errdetails.ErrorInfo{
+		Reason: string(e.Code),
+		Domain: errorDomain,
+	}}
+	if len(e.Violations) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, v := range e.Violations {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Description,
+			})
+		}
+		details = append(details, br)
+	}
+	st, derr := status.New(c, e.Message).WithDetails(details...)
+	if derr != nil {
+		return status.Error(c, e.Message)
+	}
+	return st.Err()
+}
+
+// fromGRPCStatus converts a gRPC status error back into a domain error,
+// preferring the code in its ErrorInfo detail over the gRPC code.
+func fromGRPCStatus(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	var e *loginservice.Error
+	var violations []loginservice.FieldViolation
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			if d.Domain == errorDomain {
+				e = codeError(loginservice.Code(d.Reason), st.Message())
+			}
+		case *errdetails.BadRequest:
+			for _, v := range d.FieldViolations {
+				violations = append(violations, loginservice.FieldViolation{Field: v.Field, Description: v.Description})
+			}
+		}
+	}
+	if e != nil {
+		e.Violations = violations
+		return e
+	}
+	for code, c := range grpcCodes {
+		if c == st.Code() && code != loginservice.CodeLocked {
+			return codeError(code, st.Message())
+		}
+	}
+	return err
+}
+
+// failedResponse returns a client-side error as the response built by wrap
+// when it is about the request rather than the server, so that it fails the
+// call without tripping the client's circuit breaker.
+func failedResponse(err error, wrap func(error) interface{}) (interface{}, error) {
+	var e *loginservice.Error
+	if errors.As(err, &e) && e.Code != loginservice.CodeUnavailable && e.Code != loginservice.CodeRateLimited {
+		return wrap(err), nil
+	}
+	return nil, err
+}
+
+// grpcErrorDecoder returns a client middleware that turns the gRPC status
+// errors returned by the server into domain errors, wrapped by wrap as in
+// failedResponse.
+func grpcErrorDecoder(wrap func(error) interface{}) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			response, err := next(ctx, request)
+			if err != nil {
+				return failedResponse(fromGRPCStatus(err), wrap)
+			}
+			return response, nil
+		}
+	}
+}
+
+func failedLoginResponse(err error) interface{} {
+	return loginendpoint.LoginResponse{Err: err}
+}
+
+func failedAuthenticateResponse(err error) interface{} {
+	return loginendpoint.AuthenticateResponse{Err: err}
+}
+
+func failedCheckResponse(err error) interface{} {
+	return loginendpoint.CheckResponse{Err: err}
+}
@@ -0,0 +1,227 @@
+package logintransport_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+	kitgrpc "github.com/go-kit/kit/transport/grpc"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "loginsvc/pb"
+	"loginsvc/pkg/breaker"
+	"loginsvc/pkg/limiter"
+	"loginsvc/pkg/loginendpoint"
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/logintransport"
+	"loginsvc/pkg/tenant"
+)
+
+// errorService fails Name with the error registered for the name.
+// Authenticate always succeeds.
+type errorService struct {
+	loginservice.Service
+	errs map[string]error
+}
+
+func (s errorService) Name(_ context.Context, n string) (string, error) {
+	if err, ok := s.errs[n]; ok {
+		return "", err
+	}
+	return "a123456789", nil
+}
+
+func (s errorService) Authenticate(context.Context, string, string) (string, error) {
+	return "a123456789", nil
+}
+
+var transportErrors = map[string]error{
+	"missing": loginservice.ErrNotFound,
+	"locked":  loginservice.ErrLocked,
+	"bad":     &loginservice.Error{Code: loginservice.CodeInvalidArgument, Message: "name is too long"},
+	"db":      errors.New("dial tcp 10.0.0.1:3306: connection refused"),
+	"invalid": loginservice.NewInvalidArgument(loginservice.FieldViolation{Field: "name", Description: "must be lower case"}),
+}
+
+func newEndpoints() loginendpoint.Set {
+	svc := errorService{errs: transportErrors}
+	return loginendpoint.Set{LoginEndpoint: loginendpoint.MakeLoginEndpoint(svc)}
+}
+
+func checkErrors(t *testing.T, client loginservice.Service) {
+	ctx := context.Background()
+	sid, err := client.Name(ctx, "ed")
+	assert.NoError(t, err)
+	assert.Equal(t, "a123456789", sid)
+
+	_, err = client.Name(ctx, "missing")
+	assert.True(t, errors.Is(err, loginservice.ErrNotFound), "%v", err)
+	_, err = client.Name(ctx, "locked")
+	assert.True(t, errors.Is(err, loginservice.ErrLocked), "%v", err)
+	_, err = client.Name(ctx, "bad")
+	assert.True(t, errors.Is(err, loginservice.ErrInvalidArgument), "%v", err)
+	assert.EqualError(t, err, "name is too long")
+
+	_, err = client.Name(ctx, "invalid")
+	var violation *loginservice.Error
+	if assert.True(t, errors.As(err, &violation), "%v", err) {
+		assert.Equal(t, []loginservice.FieldViolation{{Field: "name", Description: "must be lower case"}}, violation.Violations)
+	}
+
+	// Errors about the request must not open the client's circuit breaker.
+	for i := 0; i < 10; i++ {
+		client.Name(ctx, "missing")
+	}
+	_, err = client.Name(ctx, "ed")
+	assert.NoError(t, err)
+
+	_, err = client.Name(ctx, "db")
+	var e *loginservice.Error
+	assert.False(t, errors.As(err, &e), "%v", err)
+	assert.NotContains(t, err.Error(), "10.0.0.1")
+}
+
+func TestHTTPErrors(t *testing.T) {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	srv := httptest.NewServer(logintransport.NewHTTPHandler(newEndpoints(), tracer, nil, logger))
+	defer srv.Close()
+
+	client, err := logintransport.NewHTTPClient(srv.URL, tracer, nil, logger)
+	assert.NoError(t, err)
+	checkErrors(t, client)
+}
+
+func TestHTTPClientBreakers(t *testing.T) {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	srv := httptest.NewServer(logintransport.NewHTTPHandler(newEndpoints(), tracer, nil, logger))
+	defer srv.Close()
+
+	breakers := breaker.NewRegistry(nil, nil, nil)
+	client, err := logintransport.NewHTTPClient(srv.URL, tracer, nil, logger, logintransport.ClientBreakers(breakers))
+	assert.NoError(t, err)
+	_, err = client.Name(context.Background(), "ed")
+	assert.NoError(t, err)
+	b, ok := breakers.Lookup("Name")
+	if assert.True(t, ok) {
+		assert.Equal(t, uint64(1), b.Counts().Successes)
+	}
+}
+
+func TestServerBreakers(t *testing.T) {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	breakers := breaker.NewRegistry(nil, nil, nil)
+	lim := limiter.New(limiter.NewMemoryStore(0), limiter.Rules{}, 0, logger)
+	endpoints := loginendpoint.New(errorService{errs: transportErrors}, lim, breakers, nil, tenant.Resolver{Tenants: []string{"default"}, Fallback: "default"}, logger, discard.NewHistogram(), tracer, nil)
+	srv := httptest.NewServer(logintransport.NewHTTPHandler(endpoints, tracer, nil, logger))
+	defer srv.Close()
+
+	name := func(n string) int {
+		resp, err := http.Post(srv.URL+"/name", "application/json", strings.NewReader(`{"N": "`+n+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	b := breakers.Breaker("Name")
+
+	// Errors about the request leave the breaker closed.
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, http.StatusNotFound, name("missing"))
+	}
+	assert.Equal(t, gobreaker.StateClosed, b.State())
+
+	for i := 0; i < 20; i++ {
+		name("db")
+	}
+	assert.Equal(t, gobreaker.StateOpen, b.State())
+	assert.Equal(t, http.StatusServiceUnavailable, name("ed"))
+	assert.Equal(t, breaker.Counts{Successes: 20, Failures: 20, Rejections: 1}, b.Counts())
+}
+
+func TestHTTPProblemDetails(t *testing.T) {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	srv := httptest.NewServer(logintransport.NewHTTPHandler(newEndpoints(), tracer, nil, logger))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		body   string
+		status int
+		want   map[string]interface{}
+	}{
+		{
+			body:   `{"N": "invalid"}`,
+			status: http.StatusBadRequest,
+			want: map[string]interface{}{
+				"type":     "urn:loginsvc:problem:invalid_argument",
+				"title":    "Bad Request",
+				"status":   float64(400),
+				"detail":   "name must be lower case",
+				"instance": "req-1",
+				"errors":   []interface{}{map[string]interface{}{"field": "name", "description": "must be lower case"}},
+			},
+		},
+		{
+			body:   `{"N": "db"}`,
+			status: http.StatusInternalServerError,
+			want: map[string]interface{}{
+				"type":     "about:blank",
+				"title":    "Internal Server Error",
+				"status":   float64(500),
+				"instance": "req-1",
+			},
+		},
+		{
+			body:   `{`,
+			status: http.StatusBadRequest,
+			want: map[string]interface{}{
+				"type":     "urn:loginsvc:problem:invalid_argument",
+				"title":    "Bad Request",
+				"status":   float64(400),
+				"detail":   "malformed request body",
+				"instance": "req-1",
+			},
+		},
+	} {
+		req, _ := http.NewRequest("POST", srv.URL+"/name", strings.NewReader(tc.body))
+		req.Header.Set(logintransport.RequestIDHeader, "req-1")
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		var got map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		assert.Equal(t, tc.status, resp.StatusCode, tc.body)
+		assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"), tc.body)
+		assert.Equal(t, "req-1", resp.Header.Get(logintransport.RequestIDHeader), tc.body)
+		assert.Equal(t, tc.want, got, tc.body)
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	lis := bufconn.Listen(1 << 16)
+	server := grpc.NewServer(grpc.UnaryInterceptor(kitgrpc.Interceptor))
+	pb.RegisterLoginServer(server, logintransport.NewGRPCServer(newEndpoints(), tracer, nil, logger))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	assert.NoError(t, err)
+	defer conn.Close()
+	checkErrors(t, logintransport.NewGRPCClient(conn, tracer, nil, logger))
+}
@@ -0,0 +1,113 @@
+package logintransport
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/tracing/opentracing"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	stdzipkin "github.com/openzipkin/zipkin-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "loginsvc/pb"
+	"loginsvc/pkg/loginendpoint"
+	"loginsvc/pkg/loginservice"
+)
+
+// errSlowWatcher ends the streams of watchers that don't keep up.
+var errSlowWatcher = status.Error(codes.ResourceExhausted, "watcher too slow: resume from the last cursor received")
+
+type eventsGRPCServer struct {
+	watchEvents grpctransport.Handler
+	sendTimeout time.Duration
+	pb.UnimplementedIdentityEventsServer
+}
+
+// NewEventsGRPCServer makes a set of event endpoints available as a gRPC
+// IdentityEventsServer. A stream whose watcher doesn't take a message
+// within sendTimeout ends with codes.ResourceExhausted, so that it holds
+// no buffers for it; the watcher resumes from the last cursor it received.
+func NewEventsGRPCServer(endpoints loginendpoint.EventSet, sendTimeout time.Duration, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger) pb.IdentityEventsServer {
+	options := append(grpcServerOptions(zipkinTracer, logger), grpctransport.ServerBefore(opentracing.GRPCToContext(otTracer, "WatchEvents", logger)))
+	return &eventsGRPCServer{
+		watchEvents: grpctransport.NewServer(endpoints.WatchEventsEndpoint, decodeGRPCWatchEventsRequest, encodeGRPCWatchEventsResponse, options...),
+		sendTimeout: sendTimeout,
+	}
+}
+
+// watchEventsRequest is the request of a stream, with the stream to send
+// its events on.
+type watchEventsRequest struct {
+	*pb.WatchEventsRequest
+	send func(loginservice.IdentityEvent) error
+}
+
+func (s *eventsGRPCServer) WatchEvents(req *pb.WatchEventsRequest, stream pb.IdentityEvents_WatchEventsServer) error {
+	ctx := grpcRateLimitContext(stream.Context())
+	headerSent := false
+	send := func(e loginservice.IdentityEvent) error {
+		if !headerSent {
+			setGRPCRateLimitHeader(ctx)
+			headerSent = true
+		}
+		return s.send(stream, pbIdentityEvent(e))
+	}
+	_, _, err := s.watchEvents.ServeGRPC(ctx, watchEventsRequest{WatchEventsRequest: req, send: send})
+	if !headerSent {
+		setGRPCRateLimitHeader(ctx)
+	}
+	if err != nil {
+		return toGRPCStatus(err)
+	}
+	return nil
+}
+
+// send sends e on stream, or fails with errSlowWatcher if that takes
+// longer than the send timeout. Send then still blocks, until the stream
+// ends as WatchEvents returns.
+func (s *eventsGRPCServer) send(stream pb.IdentityEvents_WatchEventsServer, e *pb.IdentityEvent) error {
+	if s.sendTimeout <= 0 {
+		return stream.Send(e)
+	}
+	done := make(chan error, 1)
+	go func() { done <- stream.Send(e) }()
+	timer := time.NewTimer(s.sendTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errSlowWatcher
+	}
+}
+
+func decodeGRPCWatchEventsRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(watchEventsRequest)
+	return loginendpoint.WatchEventsRequest{
+		WatchQuery: loginservice.WatchQuery{Cursor: req.Cursor, Types: req.Types},
+		Tenant:     req.Tenant,
+		Send:       req.send,
+	}, nil
+}
+
+// encodeGRPCWatchEventsResponse returns the error that ended the stream;
+// its events were sent as it went.
+func encodeGRPCWatchEventsResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(loginendpoint.WatchEventsResponse)
+	return nil, resp.Err
+}
+
+func pbIdentityEvent(e loginservice.IdentityEvent) *pb.IdentityEvent {
+	return &pb.IdentityEvent{
+		Cursor:    e.Cursor,
+		Heartbeat: e.Heartbeat,
+		Id:        e.ID,
+		Type:      e.Type,
+		Tenant:    e.Tenant,
+		User:      e.User,
+		Time:      e.Time.UnixNano(),
+	}
+}
@@ -0,0 +1,162 @@
+package logintransport_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "loginsvc/pb"
+	"loginsvc/pkg/caller"
+	"loginsvc/pkg/limiter"
+	"loginsvc/pkg/loginendpoint"
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/logintransport"
+	"loginsvc/pkg/tenant"
+	"loginsvc/repo"
+)
+
+// endlessLog has an event after every Seq, written a minute ago.
+type endlessLog struct{}
+
+func (endlessLog) SequenceOutbox(int) (int, error) { return 0, nil }
+
+func (endlessLog) OutboxEvents(after int64, limit int) ([]repo.OutboxEvent, error) {
+	events := make([]repo.OutboxEvent, limit)
+	for i := range events {
+		seq := after + int64(i) + 1
+		events[i] = repo.OutboxEvent{ID: seq, Seq: seq, Type: repo.EventUserCreated, User: "al", Time: time.Now().Add(-time.Minute)}
+	}
+	return events, nil
+}
+
+func (endlessLog) LastOutboxSeq() (int64, error) { return 0, nil }
+
+// listenEvents serves the events of the endless logs of acme and globex,
+// resolving the tenant of calls without checking their caller.
+func listenEvents(t *testing.T, sendTimeout time.Duration) *bufconn.Listener {
+	opts := loginservice.WatchOptions{PollInterval: time.Millisecond, Heartbeat: time.Millisecond}
+	svc := loginservice.EventTenants{
+		"acme":   loginservice.NewEventService(endlessLog{}, "acme", opts),
+		"globex": loginservice.NewEventService(endlessLog{}, "globex", opts),
+	}
+	r := tenant.Resolver{
+		Tenants: []string{"acme", "globex"},
+		Hosts:   map[string]string{"login.acme.example": "acme"},
+	}
+	endpoints := loginendpoint.EventSet{WatchEventsEndpoint: loginendpoint.TenantMiddleware(r)(loginendpoint.MakeWatchEventsEndpoint(svc))}
+	lis := bufconn.Listen(1 << 16)
+	server := grpc.NewServer()
+	pb.RegisterIdentityEventsServer(server, logintransport.NewEventsGRPCServer(endpoints, sendTimeout, stdopentracing.GlobalTracer(), nil, log.NewNopLogger()))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis
+}
+
+func dialEvents(t *testing.T, lis *bufconn.Listener, authority string) pb.IdentityEventsClient {
+	conn, err := grpc.Dial(authority, grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewIdentityEventsClient(conn)
+}
+
+func TestGRPCWatchEvents(t *testing.T) {
+	lis := listenEvents(t, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := dialEvents(t, lis, "login.acme.example").WatchEvents(ctx, &pb.WatchEventsRequest{Cursor: "41"})
+	assert.NoError(t, err)
+	e, err := stream.Recv()
+	if assert.NoError(t, err) {
+		assert.True(t, e.Heartbeat)
+		assert.Equal(t, "41", e.Cursor)
+	}
+	e, err = stream.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, []interface{}{"42", int64(42), repo.EventUserCreated, "acme", "al"}, []interface{}{e.Cursor, e.Id, e.Type, e.Tenant, e.User})
+		assert.NotZero(t, e.Time)
+	}
+
+	// The tenant may be named in the request, but not against the host.
+	client := dialEvents(t, lis, "bufnet")
+	stream, err = client.WatchEvents(ctx, &pb.WatchEventsRequest{Tenant: "globex"})
+	assert.NoError(t, err)
+	e, err = stream.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "globex", e.Tenant)
+	}
+	for _, req := range []*pb.WatchEventsRequest{{}, {Tenant: "initech"}} {
+		stream, _ = client.WatchEvents(ctx, req)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.PermissionDenied, status.Code(err), req.Tenant)
+	}
+	stream, _ = dialEvents(t, lis, "login.acme.example").WatchEvents(ctx, &pb.WatchEventsRequest{Tenant: "globex"})
+	_, err = stream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	stream, _ = client.WatchEvents(ctx, &pb.WatchEventsRequest{Tenant: "acme", Types: []string{"user.renamed"}})
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCWatchEventsSlowWatcher(t *testing.T) {
+	lis := listenEvents(t, 50*time.Millisecond)
+	stream, err := dialEvents(t, lis, "login.acme.example").WatchEvents(context.Background(), &pb.WatchEventsRequest{Cursor: "0"})
+	assert.NoError(t, err)
+
+	// A watcher that stops reading fills the flow control windows, and its
+	// stream ends once they are full; the events sent until then are still
+	// received, in order.
+	time.Sleep(time.Second)
+	var last int64
+	for {
+		e, err := stream.Recv()
+		if err != nil {
+			assert.Equal(t, codes.ResourceExhausted, status.Code(err), "%v", err)
+			break
+		}
+		if !e.Heartbeat {
+			assert.Equal(t, last+1, e.Id)
+			last = e.Id
+		}
+	}
+	assert.NotZero(t, last)
+}
+
+func TestWatchEventsAllowlist(t *testing.T) {
+	svc := loginservice.EventTenants{
+		"acme":   loginservice.NewEventService(endlessLog{}, "acme", loginservice.WatchOptions{}),
+		"globex": loginservice.NewEventService(endlessLog{}, "globex", loginservice.WatchOptions{}),
+	}
+	r := tenant.Resolver{Tenants: []string{"acme", "globex"}}
+	lim := limiter.New(limiter.NewMemoryStore(0), limiter.Rules{}, 0, log.NewNopLogger())
+	// Without an allowlist, WatchEvents denies every call; the callers
+	// allowed for one tenant may not watch another.
+	allow := loginendpoint.TenantAllowlists{"acme": {"WatchEvents": {"spiffe://example.org/sync"}}}
+	endpoints := loginendpoint.NewEvents(svc, lim, allow, r, log.NewNopLogger(), stdopentracing.GlobalTracer(), nil)
+	stop := errors.New("stop")
+	watch := func(tenantID string) error {
+		ctx := caller.NewContext(context.Background(), caller.Identity{SPIFFEID: "spiffe://example.org/sync"})
+		resp, err := endpoints.WatchEventsEndpoint(tenant.WithClaims(ctx, tenant.Claims{Header: tenantID}), loginendpoint.WatchEventsRequest{
+			Send: func(loginservice.IdentityEvent) error { return stop },
+		})
+		if err == nil {
+			err = resp.(loginendpoint.WatchEventsResponse).Err
+		}
+		return err
+	}
+	assert.Equal(t, stop, watch("acme"))
+	assert.Equal(t, loginservice.ErrPermissionDenied, watch("globex"))
+}
@@ -9,12 +9,10 @@ import (
 
 	stdopentracing "github.com/opentracing/opentracing-go"
 	stdzipkin "github.com/openzipkin/zipkin-go"
-	"github.com/sony/gobreaker"
 	"golang.org/x/time/rate"
 
 	"loginsvc/pkg/loginendpoint"
 
-	"github.com/go-kit/kit/circuitbreaker"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/ratelimit"
@@ -28,24 +26,76 @@ import (
 )
 
 type grpcServer struct {
-	name grpctransport.Handler
+	name         grpctransport.Handler
+	authenticate grpctransport.Handler
+	check        grpctransport.Handler
 	pb.UnimplementedLoginServer
 }
 
 func (s *grpcServer) Name(ctx context.Context, req *pb.NameRequest) (*pb.NameReply, error) {
+	ctx = grpcRateLimitContext(ctx)
 	_, rep, err := s.name.ServeGRPC(ctx, req)
+	setGRPCRateLimitHeader(ctx)
 	if err != nil {
-		return nil, err
+		return nil, toGRPCStatus(err)
 	}
 	return rep.(*pb.NameReply), nil
 }
 
+func (s *grpcServer) Authenticate(ctx context.Context, req *pb.AuthenticateRequest) (*pb.AuthenticateReply, error) {
+	ctx = grpcRateLimitContext(ctx)
+	_, rep, err := s.authenticate.ServeGRPC(ctx, req)
+	setGRPCRateLimitHeader(ctx)
+	if err != nil {
+		return nil, toGRPCStatus(err)
+	}
+	return rep.(*pb.AuthenticateReply), nil
+}
+
+func (s *grpcServer) Check(ctx context.Context, req *pb.CheckRequest) (*pb.CheckReply, error) {
+	ctx = grpcRateLimitContext(ctx)
+	_, rep, err := s.check.ServeGRPC(ctx, req)
+	setGRPCRateLimitHeader(ctx)
+	if err != nil {
+		return nil, toGRPCStatus(err)
+	}
+	return rep.(*pb.CheckReply), nil
+}
+
 func (s *grpcServer) mustEmbedUnimplementedLoginServer() {}
 
 // NewGRPCServer makes a set of endpoints available as a gRPC LoginServer.
 func NewGRPCServer(endpoints loginendpoint.Set, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger) pb.LoginServer {
+	options := grpcServerOptions(zipkinTracer, logger)
+	g := &grpcServer{
+		name: grpctransport.NewServer(
+			endpoints.LoginEndpoint,
+			decodeGRPCNameRequest,
+			encodeGRPCNameResponse,
+			append(options, grpctransport.ServerBefore(opentracing.GRPCToContext(otTracer, "Name", logger)))...,
+		),
+		authenticate: grpctransport.NewServer(
+			endpoints.AuthenticateEndpoint,
+			decodeGRPCAuthenticateRequest,
+			encodeGRPCAuthenticateResponse,
+			append(options, grpctransport.ServerBefore(opentracing.GRPCToContext(otTracer, "Authenticate", logger)))...,
+		),
+		check: grpctransport.NewServer(
+			endpoints.CheckEndpoint,
+			decodeGRPCCheckRequest,
+			encodeGRPCCheckResponse,
+			append(options, grpctransport.ServerBefore(opentracing.GRPCToContext(otTracer, "Check", logger)))...,
+		),
+	}
+	return g
+}
+
+// grpcServerOptions are the options of every gRPC endpoint of the server.
+func grpcServerOptions(zipkinTracer *stdzipkin.Tracer, logger log.Logger) []grpctransport.ServerOption {
 	options := []grpctransport.ServerOption{
 		grpctransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		grpctransport.ServerBefore(grpcCallerContext),
+		grpctransport.ServerBefore(grpcTenantContext),
 	}
 
 	if zipkinTracer != nil {
@@ -60,23 +110,29 @@ func NewGRPCServer(endpoints loginendpoint.Set, otTracer stdopentracing.Tracer,
 		// Go kit gRPC Interceptor.
 		options = append(options, zipkin.GRPCServerTrace(zipkinTracer))
 	}
-
-	g := &grpcServer{
-		name: grpctransport.NewServer(
-			endpoints.LoginEndpoint,
-			decodeGRPCNameRequest,
-			encodeGRPCNameResponse,
-			append(options, grpctransport.ServerBefore(opentracing.GRPCToContext(otTracer, "Name", logger)))...,
-		),
-	}
-	return g
+	return options
 }
 
 // NewGRPCClient returns an LoginService backed by a gRPC server at the other end
-// of the conn. The caller is responsible for constructing the conn, and
-// eventually closing the underlying transport. We bake-in certain middlewares,
+// of the conn. The caller is responsible for constructing the conn, with
+// DialGRPC for instance, and eventually closing the underlying transport. We bake-in certain middlewares,
 // implementing the client library pattern.
-func NewGRPCClient(conn *grpc.ClientConn, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger) loginservice.Service {
+func NewGRPCClient(conn *grpc.ClientConn, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger, opts ...ClientOption) loginservice.Service {
+	o := newClientOptions(opts)
+	set := makeGRPCEndpoints(conn, "", otTracer, zipkinTracer, logger, o)
+	// Returning the endpoint.Set as a service.Service relies on the
+	// endpoint.Set implementing the Service methods. That's just a simple bit
+	// of glue code.
+	return loginendpoint.Set{
+		LoginEndpoint:        o.call(set.LoginEndpoint, true),
+		AuthenticateEndpoint: o.call(set.AuthenticateEndpoint, false),
+		CheckEndpoint:        o.call(set.CheckEndpoint, true),
+	}
+}
+
+// makeGRPCEndpoints returns the endpoints of a single connection, up to and
+// including their breakers, which are named after the method and suffix.
+func makeGRPCEndpoints(conn *grpc.ClientConn, suffix string, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger, o clientOptions) loginendpoint.Set {
 	// We construct a single ratelimiter middleware, to limit the total outgoing
 	// QPS from this client to all methods on the remote instance. We also
 	// construct per-endpoint circuitbreaker middlewares to demonstrate how
@@ -86,6 +142,9 @@ func NewGRPCClient(conn *grpc.ClientConn, otTracer stdopentracing.Tracer, zipkin
 
 	// global client middlewares
 	var options []grpctransport.ClientOption
+	if o.tenant != "" {
+		options = append(options, grpctransport.ClientBefore(grpcSetTenant(o.tenant)))
+	}
 
 	if zipkinTracer != nil {
 		// Zipkin GRPC Client Trace can either be instantiated per gRPC method with a
@@ -113,19 +172,53 @@ func NewGRPCClient(conn *grpc.ClientConn, otTracer stdopentracing.Tracer, zipkin
 			pb.NameReply{},
 			append(options, grpctransport.ClientBefore(opentracing.ContextToGRPC(otTracer, logger)))...,
 		).Endpoint()
+		nameEndpoint = grpcErrorDecoder(failedLoginResponse)(nameEndpoint)
+		nameEndpoint = o.attempt(nameEndpoint)
 		nameEndpoint = opentracing.TraceClient(otTracer, "Name")(nameEndpoint)
 		nameEndpoint = limiter(nameEndpoint)
-		nameEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
-			Name:    "Name",
-			Timeout: 30 * time.Second,
-		}))(nameEndpoint)
+		nameEndpoint = o.breakers.Middleware("Name"+suffix, nil)(nameEndpoint)
+	}
+
+	var authenticateEndpoint endpoint.Endpoint
+	{
+		authenticateEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.Login",
+			"Authenticate",
+			encodeGRPCAuthenticateRequest,
+			decodeGRPCAuthenticateResponse,
+			pb.AuthenticateReply{},
+			append(options, grpctransport.ClientBefore(opentracing.ContextToGRPC(otTracer, logger)))...,
+		).Endpoint()
+		authenticateEndpoint = grpcErrorDecoder(failedAuthenticateResponse)(authenticateEndpoint)
+		authenticateEndpoint = o.attempt(authenticateEndpoint)
+		authenticateEndpoint = opentracing.TraceClient(otTracer, "Authenticate")(authenticateEndpoint)
+		authenticateEndpoint = limiter(authenticateEndpoint)
+		authenticateEndpoint = o.breakers.Middleware("Authenticate"+suffix, nil)(authenticateEndpoint)
+	}
+
+	var checkEndpoint endpoint.Endpoint
+	{
+		checkEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.Login",
+			"Check",
+			encodeGRPCCheckRequest,
+			decodeGRPCCheckResponse,
+			pb.CheckReply{},
+			append(options, grpctransport.ClientBefore(opentracing.ContextToGRPC(otTracer, logger)))...,
+		).Endpoint()
+		checkEndpoint = grpcErrorDecoder(failedCheckResponse)(checkEndpoint)
+		checkEndpoint = o.attempt(checkEndpoint)
+		checkEndpoint = opentracing.TraceClient(otTracer, "Check")(checkEndpoint)
+		checkEndpoint = limiter(checkEndpoint)
+		checkEndpoint = o.breakers.Middleware("Check"+suffix, nil)(checkEndpoint)
 	}
 
-	// Returning the endpoint.Set as a service.Service relies on the
-	// endpoint.Set implementing the Service methods. That's just a simple bit
-	// of glue code.
 	return loginendpoint.Set{
-		LoginEndpoint: nameEndpoint,
+		LoginEndpoint:        nameEndpoint,
+		AuthenticateEndpoint: authenticateEndpoint,
+		CheckEndpoint:        checkEndpoint,
 	}
 }
 
@@ -138,17 +231,23 @@ func decodeGRPCNameRequest(_ context.Context, grpcReq interface{}) (interface{},
 
 // decodeGRPCNameResponse is a transport/grpc.DecodeResponseFunc that converts a
 // gRPC name reply to a user-domain name response. Primarily useful in a client.
+// Servers now fail with a gRPC status instead; the err field is only read for
+// older ones.
 func decodeGRPCNameResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
 	reply := grpcReply.(*pb.NameReply)
 	return loginendpoint.LoginResponse{V: string(reply.V), Err: str2err(reply.Err)}, nil
 }
 
-// encodeGRPCConcatResponse is a transport/grpc.EncodeResponseFunc that converts
-// a user-domain concat response to a gRPC concat reply. Primarily useful in a
-// server.
+// encodeGRPCNameResponse is a transport/grpc.EncodeResponseFunc that converts
+// a user-domain name response to a gRPC name reply. A failed response is
+// returned as an error, which the server sends as a gRPC status. Primarily
+// useful in a server.
 func encodeGRPCNameResponse(_ context.Context, response interface{}) (interface{}, error) {
 	resp := response.(loginendpoint.LoginResponse)
-	return &pb.NameReply{V: resp.V, Err: err2str(resp.Err)}, nil
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	return &pb.NameReply{V: resp.V}, nil
 }
 
 // encodeGRPCNameRequest is a transport/grpc.EncodeRequestFunc that converts a
@@ -166,20 +265,75 @@ func encodeGRPCConcatRequest(_ context.Context, request interface{}) (interface{
 	return &pb.NameRequest{N: req.N}, nil
 }
 
-// These annoying helper functions are required to translate Go error types to
-// and from strings, which is the type we use in our IDLs to represent errors.
-// There is special casing to treat empty strings as nil errors.
+// decodeGRPCAuthenticateRequest is a transport/grpc.DecodeRequestFunc that
+// converts a gRPC authenticate request to a user-domain one.
+func decodeGRPCAuthenticateRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.AuthenticateRequest)
+	return loginendpoint.AuthenticateRequest{Name: req.Name, Password: req.Password}, nil
+}
+
+// decodeGRPCAuthenticateResponse is a transport/grpc.DecodeResponseFunc that
+// converts a gRPC authenticate reply to a user-domain response.
+func decodeGRPCAuthenticateResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.AuthenticateReply)
+	return loginendpoint.AuthenticateResponse{SID: reply.Sid, Err: str2err(reply.Err)}, nil
+}
+
+// encodeGRPCAuthenticateResponse is a transport/grpc.EncodeResponseFunc that
+// converts a user-domain authenticate response to a gRPC reply, or its
+// error to a gRPC status.
+func encodeGRPCAuthenticateResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(loginendpoint.AuthenticateResponse)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	return &pb.AuthenticateReply{Sid: resp.SID}, nil
+}
+
+// encodeGRPCAuthenticateRequest is a transport/grpc.EncodeRequestFunc that
+// converts a user-domain authenticate request to a gRPC request.
+func encodeGRPCAuthenticateRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(loginendpoint.AuthenticateRequest)
+	return &pb.AuthenticateRequest{Name: req.Name, Password: req.Password}, nil
+}
 
+// decodeGRPCCheckRequest is a transport/grpc.DecodeRequestFunc that converts
+// a gRPC check request to a user-domain one.
+func decodeGRPCCheckRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.CheckRequest)
+	return loginendpoint.CheckRequest{Subject: req.Subject, Permission: req.Permission, Resource: req.Resource}, nil
+}
+
+// decodeGRPCCheckResponse is a transport/grpc.DecodeResponseFunc that
+// converts a gRPC check reply to a user-domain response.
+func decodeGRPCCheckResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.CheckReply)
+	return loginendpoint.CheckResponse{Allowed: reply.Allowed, Err: str2err(reply.Err)}, nil
+}
+
+// encodeGRPCCheckResponse is a transport/grpc.EncodeResponseFunc that
+// converts a user-domain check response to a gRPC reply, or its error to a
+// gRPC status.
+func encodeGRPCCheckResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(loginendpoint.CheckResponse)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	return &pb.CheckReply{Allowed: resp.Allowed}, nil
+}
+
+// encodeGRPCCheckRequest is a transport/grpc.EncodeRequestFunc that converts
+// a user-domain check request to a gRPC request.
+func encodeGRPCCheckRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(loginendpoint.CheckRequest)
+	return &pb.CheckRequest{Subject: req.Subject, Permission: req.Permission, Resource: req.Resource}, nil
+}
+
+// str2err translates the err string of replies from servers that predate
+// gRPC status errors. An empty string is a nil error.
 func str2err(s string) error {
 	if s == "" {
 		return nil
 	}
 	return errors.New(s)
 }
-
-func err2str(err error) string {
-	if err == nil {
-		return ""
-	}
-	return err.Error()
-}
@@ -15,7 +15,6 @@ import (
 
 	"loginsvc/pkg/loginservice"
 
-	"github.com/go-kit/kit/circuitbreaker"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/ratelimit"
@@ -25,14 +24,20 @@ import (
 	httptransport "github.com/go-kit/kit/transport/http"
 	stdopentracing "github.com/opentracing/opentracing-go"
 	stdzipkin "github.com/openzipkin/zipkin-go"
-	"github.com/sony/gobreaker"
 	"golang.org/x/time/rate"
 )
 
 func NewHTTPHandler(endpoints loginendpoint.Set, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger) http.Handler {
+	options := httpServerOptions(zipkinTracer, logger)
+	return withRequestID(withRateLimit(withTenant(newHTTPMux(endpoints, options, otTracer, logger))))
+}
+
+// httpServerOptions are the options of every HTTP endpoint of the server.
+func httpServerOptions(zipkinTracer *stdzipkin.Tracer, logger log.Logger) []httptransport.ServerOption {
 	options := []httptransport.ServerOption{
 		httptransport.ServerErrorEncoder(errorEncoder),
 		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		httptransport.ServerBefore(httpCallerContext),
 	}
 
 	if zipkinTracer != nil {
@@ -43,7 +48,11 @@ func NewHTTPHandler(endpoints loginendpoint.Set, otTracer stdopentracing.Tracer,
 		// We demonstrate a global tracing service here.
 		options = append(options, zipkin.HTTPServerTrace(zipkinTracer))
 	}
+	return options
+}
 
+// newHTTPMux routes the paths of the Service methods to endpoints.
+func newHTTPMux(endpoints loginendpoint.Set, options []httptransport.ServerOption, otTracer stdopentracing.Tracer, logger log.Logger) *http.ServeMux {
 	m := http.NewServeMux()
 	m.Handle("/name", httptransport.NewServer(
 		endpoints.LoginEndpoint,
@@ -51,17 +60,51 @@ func NewHTTPHandler(endpoints loginendpoint.Set, otTracer stdopentracing.Tracer,
 		encodeHTTPGenericResponse,
 		append(options, httptransport.ServerBefore(opentracing.HTTPToContext(otTracer, "Name", logger)))...,
 	))
+	m.Handle("/authenticate", httptransport.NewServer(
+		endpoints.AuthenticateEndpoint,
+		decodeHTTPAuthenticateRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(opentracing.HTTPToContext(otTracer, "Authenticate", logger)))...,
+	))
+	m.Handle("/check", httptransport.NewServer(
+		endpoints.CheckEndpoint,
+		decodeHTTPCheckRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(opentracing.HTTPToContext(otTracer, "Check", logger)))...,
+	))
 	return m
 }
 
-func NewHTTPClient(instance string, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger) (loginservice.Service, error) {
+func NewHTTPClient(instance string, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger, opts ...ClientOption) (loginservice.Service, error) {
+	o := newClientOptions(opts)
+	set, err := makeHTTPEndpoints(instance, "", otTracer, zipkinTracer, logger, o)
+	if err != nil {
+		return nil, err
+	}
+	// Returning the endpoint.Set as a service.Service relies on the
+	// endpoint.Set implementing the Service methods. That's just a simple bit
+	// of glue code.
+	return loginendpoint.Set{
+		LoginEndpoint:        o.call(set.LoginEndpoint, true),
+		AuthenticateEndpoint: o.call(set.AuthenticateEndpoint, false),
+		CheckEndpoint:        o.call(set.CheckEndpoint, true),
+	}, nil
+}
+
+// makeHTTPEndpoints returns the endpoints of a single instance, up to and
+// including their breakers, which are named after the method and suffix.
+func makeHTTPEndpoints(instance, suffix string, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger, o clientOptions) (loginendpoint.Set, error) {
 	// Quickly sanitize the instance string.
 	if !strings.HasPrefix(instance, "http") {
-		instance = "http://" + instance
+		if o.tls != nil {
+			instance = "https://" + instance
+		} else {
+			instance = "http://" + instance
+		}
 	}
 	u, err := url.Parse(instance)
 	if err != nil {
-		return nil, err
+		return loginendpoint.Set{}, err
 	}
 
 	// We construct a single ratelimiter middleware, to limit the total outgoing
@@ -74,6 +117,13 @@ func NewHTTPClient(instance string, otTracer stdopentracing.Tracer, zipkinTracer
 	// global client middlewares
 	var options []httptransport.ClientOption
 
+	if o.httpClient != nil {
+		options = append(options, httptransport.SetClient(o.httpClient))
+	}
+	if o.tenant != "" {
+		options = append(options, httptransport.ClientBefore(httpSetTenant(o.tenant)))
+	}
+
 	if zipkinTracer != nil {
 		// Zipkin HTTP Client Trace can either be instantiated per endpoint with a
 		// provided operation name or a global tracing client can be instantiated
@@ -95,39 +145,106 @@ func NewHTTPClient(instance string, otTracer stdopentracing.Tracer, zipkinTracer
 			decodeHTTPNameResponse,
 			append(options, httptransport.ClientBefore(opentracing.ContextToHTTP(otTracer, logger)))...,
 		).Endpoint()
+		nameEndpoint = o.attempt(nameEndpoint)
 		nameEndpoint = opentracing.TraceClient(otTracer, "Name")(nameEndpoint)
 		if zipkinTracer != nil {
 			nameEndpoint = zipkin.TraceEndpoint(zipkinTracer, "Name")(nameEndpoint)
 		}
 		nameEndpoint = limiter(nameEndpoint)
-		nameEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
-			Name:    "Name",
-			Timeout: 30 * time.Second,
-		}))(nameEndpoint)
+		nameEndpoint = o.breakers.Middleware("Name"+suffix, nil)(nameEndpoint)
+	}
+	var authenticateEndpoint endpoint.Endpoint
+	{
+		authenticateEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/authenticate"),
+			encodeHTTPGenericRequest,
+			decodeHTTPAuthenticateResponse,
+			append(options, httptransport.ClientBefore(opentracing.ContextToHTTP(otTracer, logger)))...,
+		).Endpoint()
+		authenticateEndpoint = o.attempt(authenticateEndpoint)
+		authenticateEndpoint = opentracing.TraceClient(otTracer, "Authenticate")(authenticateEndpoint)
+		if zipkinTracer != nil {
+			authenticateEndpoint = zipkin.TraceEndpoint(zipkinTracer, "Authenticate")(authenticateEndpoint)
+		}
+		authenticateEndpoint = limiter(authenticateEndpoint)
+		authenticateEndpoint = o.breakers.Middleware("Authenticate"+suffix, nil)(authenticateEndpoint)
+	}
+	var checkEndpoint endpoint.Endpoint
+	{
+		checkEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/check"),
+			encodeHTTPGenericRequest,
+			decodeHTTPCheckResponse,
+			append(options, httptransport.ClientBefore(opentracing.ContextToHTTP(otTracer, logger)))...,
+		).Endpoint()
+		checkEndpoint = o.attempt(checkEndpoint)
+		checkEndpoint = opentracing.TraceClient(otTracer, "Check")(checkEndpoint)
+		if zipkinTracer != nil {
+			checkEndpoint = zipkin.TraceEndpoint(zipkinTracer, "Check")(checkEndpoint)
+		}
+		checkEndpoint = limiter(checkEndpoint)
+		checkEndpoint = o.breakers.Middleware("Check"+suffix, nil)(checkEndpoint)
 	}
-	// Returning the endpoint.Set as a service.Service relies on the
-	// endpoint.Set implementing the Service methods. That's just a simple bit
-	// of glue code.
 	return loginendpoint.Set{
-		LoginEndpoint: nameEndpoint,
+		LoginEndpoint:        nameEndpoint,
+		AuthenticateEndpoint: authenticateEndpoint,
+		CheckEndpoint:        checkEndpoint,
 	}, nil
 }
 
 func decodeHTTPNameRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var req loginendpoint.LoginRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	return req, err
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, &loginservice.Error{Code: loginservice.CodeInvalidArgument, Message: "malformed request body", Err: err}
+	}
+	return req, nil
 }
 
 func decodeHTTPNameResponse(_ context.Context, r *http.Response) (interface{}, error) {
 	if r.StatusCode != http.StatusOK {
-		return nil, errors.New(r.Status)
+		return failedResponse(errorDecoder(r), failedLoginResponse)
 	}
 	var resp loginendpoint.LoginResponse
 	err := json.NewDecoder(r.Body).Decode(&resp)
 	return resp, err
 }
 
+func decodeHTTPAuthenticateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req loginendpoint.AuthenticateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, &loginservice.Error{Code: loginservice.CodeInvalidArgument, Message: "malformed request body", Err: err}
+	}
+	return req, nil
+}
+
+func decodeHTTPAuthenticateResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return failedResponse(errorDecoder(r), failedAuthenticateResponse)
+	}
+	var resp loginendpoint.AuthenticateResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPCheckRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req loginendpoint.CheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, &loginservice.Error{Code: loginservice.CodeInvalidArgument, Message: "malformed request body", Err: err}
+	}
+	return req, nil
+}
+
+func decodeHTTPCheckResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return failedResponse(errorDecoder(r), failedCheckResponse)
+	}
+	var resp loginendpoint.CheckResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
 // encodeHTTPGenericRequest is a transport/http.EncodeRequestFunc that
 // JSON-encodes any request to the request body. Primarily useful in a client.
 func encodeHTTPGenericRequest(_ context.Context, r *http.Request, request interface{}) error {
@@ -156,27 +273,67 @@ func copyURL(base *url.URL, path string) *url.URL {
 	return &next
 }
 
-func errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
-	w.WriteHeader(err2code(err))
-	json.NewEncoder(w).Encode(errorWrapper{Error: err.Error()})
+// problemContentType is the media type of RFC 7807 problem details.
+const problemContentType = "application/problem+json"
+
+// problemTypePrefix prefixes the code of a domain error to form the type of
+// its problem. Internal errors have type about:blank.
+const problemTypePrefix = "urn:loginsvc:problem:"
+
+// problem is an RFC 7807 problem details object. Instance is the request ID,
+// and Errors lists the invalid fields of an invalid_argument problem.
+type problem struct {
+	Type     string                        `json:"type"`
+	Title    string                        `json:"title"`
+	Status   int                           `json:"status"`
+	Detail   string                        `json:"detail,omitempty"`
+	Instance string                        `json:"instance,omitempty"`
+	Errors   []loginservice.FieldViolation `json:"errors,omitempty"`
+}
+
+// errorEncoder writes err as problem+json with the HTTP status of its code.
+// The text of internal errors is not sent, since it may come from the
+// database driver.
+func errorEncoder(ctx context.Context, err error, w http.ResponseWriter) {
+	p := problem{
+		Type:     "about:blank",
+		Status:   err2code(err),
+		Instance: RequestIDFromContext(ctx),
+	}
+	p.Title = http.StatusText(p.Status)
+	if e := domainError(err); e != nil {
+		p.Type = problemTypePrefix + string(e.Code)
+		p.Detail = e.Message
+		p.Errors = e.Violations
+	}
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
 }
 
 func err2code(err error) int {
-	// switch err {
-	// case loginservice.ErrTwoZeroes, loginservice.ErrMaxSizeExceeded, loginservice.ErrIntOverflow:
-	// 	return http.StatusBadRequest
-	// }
+	if e := domainError(err); e != nil {
+		if code, ok := httpStatuses[e.Code]; ok {
+			return code
+		}
+	}
 	return http.StatusInternalServerError
 }
 
+// errorDecoder rebuilds the error written by errorEncoder: a domain error
+// for problems with a loginsvc type, and a plain error otherwise.
 func errorDecoder(r *http.Response) error {
-	var w errorWrapper
-	if err := json.NewDecoder(r.Body).Decode(&w); err != nil {
-		return err
+	var p problem
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), problemContentType) || json.NewDecoder(r.Body).Decode(&p) != nil {
+		return errors.New(r.Status)
 	}
-	return errors.New(w.Error)
-}
-
-type errorWrapper struct {
-	Error string `json:"error"`
+	if !strings.HasPrefix(p.Type, problemTypePrefix) {
+		if p.Detail != "" {
+			return errors.New(p.Detail)
+		}
+		return errors.New(r.Status)
+	}
+	e := codeError(loginservice.Code(strings.TrimPrefix(p.Type, problemTypePrefix)), p.Detail)
+	e.Violations = p.Errors
+	return e
 }
@@ -0,0 +1,120 @@
+package logintransport
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"loginsvc/pkg/limiter"
+)
+
+const (
+	// ClientIDHeader identifies the client application on HTTP requests. It
+	// selects the per-client rate limit.
+	ClientIDHeader = "X-Client-ID"
+
+	// ClientIDMetadata identifies the client application on gRPC requests.
+	ClientIDMetadata = "x-client-id"
+)
+
+// rateLimitHeaders returns the headers describing res, following the IETF
+// RateLimit header fields draft, plus Retry-After when it was denied.
+func rateLimitHeaders(res limiter.Result) map[string]string {
+	if res.Limit == 0 {
+		return nil
+	}
+	h := map[string]string{
+		"RateLimit-Limit":     strconv.Itoa(res.Limit),
+		"RateLimit-Remaining": strconv.Itoa(res.Remaining),
+		"RateLimit-Reset":     ceilSeconds(res.Reset),
+	}
+	if !res.Allowed {
+		h["Retry-After"] = ceilSeconds(res.RetryAfter)
+	}
+	return h
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// withRateLimit prepares the context of every request for
+// loginendpoint.RateLimitingMiddleware and adds the rate limit headers to
+// the response.
+func withRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		ctx := limiter.NewContext(r.Context(), ip, r.Header.Get(ClientIDHeader))
+		next.ServeHTTP(&rateLimitWriter{ResponseWriter: w, ctx: ctx}, r.WithContext(ctx))
+	})
+}
+
+// rateLimitWriter adds the rate limit headers just before the status line
+// is written, once the endpoint has recorded its result.
+type rateLimitWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	wroteHeader bool
+}
+
+func (w *rateLimitWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if res, ok := limiter.ResultFromContext(w.ctx); ok {
+			for k, v := range rateLimitHeaders(res) {
+				w.Header().Set(k, v)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *rateLimitWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// grpcRateLimitContext prepares ctx for loginendpoint.RateLimitingMiddleware
+// from the peer address and metadata of a gRPC request.
+func grpcRateLimitContext(ctx context.Context) context.Context {
+	var ip, client string
+	if p, ok := peer.FromContext(ctx); ok {
+		if ip, _, _ = net.SplitHostPort(p.Addr.String()); ip == "" {
+			ip = p.Addr.String()
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(ClientIDMetadata); len(v) > 0 {
+			client = v[0]
+		}
+	}
+	return limiter.NewContext(ctx, ip, client)
+}
+
+// setGRPCRateLimitHeader sends the rate limit headers, lower-cased, as
+// response header metadata.
+func setGRPCRateLimitHeader(ctx context.Context) {
+	res, ok := limiter.ResultFromContext(ctx)
+	if !ok {
+		return
+	}
+	md := metadata.MD{}
+	for k, v := range rateLimitHeaders(res) {
+		md.Set(k, v)
+	}
+	if len(md) > 0 {
+		grpc.SetHeader(ctx, md)
+	}
+}
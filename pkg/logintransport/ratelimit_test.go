@@ -0,0 +1,93 @@
+package logintransport_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	kitgrpc "github.com/go-kit/kit/transport/grpc"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "loginsvc/pb"
+	"loginsvc/pkg/limiter"
+	"loginsvc/pkg/loginendpoint"
+	"loginsvc/pkg/logintransport"
+)
+
+// newLimitedEndpoints allows two requests per client ID.
+func newLimitedEndpoints() loginendpoint.Set {
+	l := limiter.New(limiter.NewMemoryStore(0), limiter.Rules{
+		PerClient: limiter.Rule{Rate: 0.001, Burst: 2},
+	}, 0, log.NewNopLogger())
+	set := newEndpoints()
+	set.LoginEndpoint = loginendpoint.RateLimitingMiddleware(l)(set.LoginEndpoint)
+	return set
+}
+
+func TestHTTPRateLimitHeaders(t *testing.T) {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	srv := httptest.NewServer(logintransport.NewHTTPHandler(newLimitedEndpoints(), tracer, nil, logger))
+	defer srv.Close()
+
+	post := func() *http.Response {
+		req, _ := http.NewRequest("POST", srv.URL+"/name", strings.NewReader(`{"N": "ed"}`))
+		req.Header.Set(logintransport.ClientIDHeader, "web")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := post()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "", resp.Header.Get("Retry-After"))
+	post()
+	resp = post()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "1000", resp.Header.Get("Retry-After"))
+}
+
+func TestGRPCRateLimitMetadata(t *testing.T) {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	lis := bufconn.Listen(1 << 16)
+	server := grpc.NewServer(grpc.UnaryInterceptor(kitgrpc.Interceptor))
+	pb.RegisterLoginServer(server, logintransport.NewGRPCServer(newLimitedEndpoints(), tracer, nil, logger))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewLoginClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), logintransport.ClientIDMetadata, "mobile")
+
+	var header metadata.MD
+	_, err = client.Name(ctx, &pb.NameRequest{N: "ed"}, grpc.Header(&header))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2"}, header.Get("ratelimit-limit"))
+	assert.Equal(t, []string{"1"}, header.Get("ratelimit-remaining"))
+
+	client.Name(ctx, &pb.NameRequest{N: "ed"})
+	_, err = client.Name(ctx, &pb.NameRequest{N: "ed"}, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"1000"}, header.Get("retry-after"))
+}
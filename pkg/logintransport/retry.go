@@ -0,0 +1,177 @@
+package logintransport
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/ratelimit"
+	"github.com/sony/gobreaker"
+
+	"loginsvc/pkg/loginservice"
+)
+
+// ClientRetries retries failed calls to idempotent methods up to
+// maxAttempts attempts in all. Before each retry the client sleeps for a
+// random duration up to base * 2^(retry-1), capped at max ("full jitter").
+// Requests rejected by a rate limiter or an open breaker are not retried.
+func ClientRetries(maxAttempts int, base, max time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.maxAttempts, o.baseBackoff, o.maxBackoff = maxAttempts, base, max
+	}
+}
+
+// ClientTimeout bounds the total time of a call, retries included.
+func ClientTimeout(d time.Duration) ClientOption {
+	return func(o *clientOptions) { o.timeout = d }
+}
+
+// ClientAttemptTimeout bounds the time of each attempt of a call, so that a
+// stuck connection can be retried within the total timeout.
+func ClientAttemptTimeout(d time.Duration) ClientOption {
+	return func(o *clientOptions) { o.attemptTimeout = d }
+}
+
+// ClientHedging sends up to extra additional copies of a request to an
+// idempotent method, each one after delay without a reply, and keeps the
+// first reply. Choose delay around the 95th percentile latency.
+func ClientHedging(delay time.Duration, extra int) ClientOption {
+	return func(o *clientOptions) { o.hedgeDelay, o.hedgeExtra = delay, extra }
+}
+
+// attempt wraps the transport endpoint of a single attempt.
+func (o clientOptions) attempt(next endpoint.Endpoint) endpoint.Endpoint {
+	if o.attemptTimeout <= 0 {
+		return next
+	}
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, o.attemptTimeout)
+		defer cancel()
+		return next(ctx, request)
+	}
+}
+
+// call wraps the endpoint of a whole call, with its attempts already behind
+// the client breaker. Only idempotent methods are hedged and retried.
+func (o clientOptions) call(next endpoint.Endpoint, idempotent bool) endpoint.Endpoint {
+	if idempotent && o.hedgeDelay > 0 && o.hedgeExtra > 0 {
+		next = hedge(o.hedgeDelay, o.hedgeExtra)(next)
+	}
+	if idempotent && o.maxAttempts > 1 {
+		next = retry(o.maxAttempts, o.baseBackoff, o.maxBackoff)(next)
+	}
+	if o.timeout > 0 {
+		next = deadline(o.timeout)(next)
+	}
+	return next
+}
+
+func deadline(d time.Duration) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next(ctx, request)
+		}
+	}
+}
+
+func retry(maxAttempts int, base, max time.Duration) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			for attempt := 1; ; attempt++ {
+				response, err := next(ctx, request)
+				if err == nil || attempt == maxAttempts || !retryable(err) {
+					return response, err
+				}
+				timer := time.NewTimer(backoff(attempt, base, max))
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return nil, err
+				}
+			}
+		}
+	}
+}
+
+// backoff returns the full jitter delay before retry number n.
+func backoff(n int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// retryable reports whether a call that failed with err may succeed if
+// tried again. Failed responses, i.e. domain errors about the request, never
+// reach it.
+func retryable(err error) bool {
+	switch {
+	case errors.Is(err, loginservice.ErrRateLimited),
+		errors.Is(err, ratelimit.ErrLimited),
+		errors.Is(err, gobreaker.ErrOpenState),
+		errors.Is(err, gobreaker.ErrTooManyRequests),
+		errors.Is(err, context.Canceled):
+		return false
+	}
+	return true
+}
+
+// hedge returns a middleware that sends another copy of the request after
+// each delay without a reply, up to extra copies, and returns the first
+// success. It fails once every copy sent has failed. Once it returns, the
+// copies still in flight are canceled, which the breakers of the attempts
+// don't count as failures.
+func hedge(delay time.Duration, extra int) endpoint.Middleware {
+	type result struct {
+		response interface{}
+		err      error
+	}
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			results := make(chan result, extra+1)
+			send := func() {
+				go func() {
+					response, err := next(ctx, request)
+					results <- result{response, err}
+				}()
+			}
+
+			send()
+			sent, pending := 1, 1
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			for {
+				select {
+				case r := <-results:
+					pending--
+					if r.err == nil || pending == 0 {
+						return r.response, r.err
+					}
+				case <-timer.C:
+					if sent <= extra {
+						send()
+						sent++
+						pending++
+						timer.Reset(delay)
+					}
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+		}
+	}
+}
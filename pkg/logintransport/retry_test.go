@@ -0,0 +1,228 @@
+package logintransport_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	kitgrpc "github.com/go-kit/kit/transport/grpc"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "loginsvc/pb"
+	"loginsvc/pkg/breaker"
+	"loginsvc/pkg/loginendpoint"
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/logintransport"
+)
+
+// flakyServer misbehaves on the calls it is told to: it drops the
+// connection, or stalls until the caller gives up. A server that is down
+// drops every call.
+type flakyServer struct {
+	down    bool
+	calls   int32
+	drop    map[int32]bool
+	stall   map[int32]bool
+	release chan struct{}
+}
+
+func (f *flakyServer) endpoints() loginendpoint.Set {
+	svc := errorService{errs: map[string]error{}}
+	return loginendpoint.Set{
+		LoginEndpoint:        loginendpoint.MakeLoginEndpoint(svc),
+		AuthenticateEndpoint: loginendpoint.MakeAuthenticateEndpoint(svc),
+	}
+}
+
+// stop releases the stalled calls once the test is over, so that the
+// server can shut down.
+func (f *flakyServer) stop(t *testing.T) {
+	f.release = make(chan struct{})
+	t.Cleanup(func() { close(f.release) })
+}
+
+// misbehave reports whether call n should fail, after stalling it if asked.
+func (f *flakyServer) misbehave(ctx context.Context) bool {
+	n := atomic.AddInt32(&f.calls, 1)
+	if f.stall[n] {
+		select {
+		case <-ctx.Done():
+		case <-f.release:
+		}
+		return true
+	}
+	return f.down || f.drop[n]
+}
+
+// httpInstance starts an HTTP server and returns its address.
+func (f *flakyServer) httpInstance(t *testing.T) string {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	handler := logintransport.NewHTTPHandler(f.endpoints(), tracer, nil, logger)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.misbehave(r.Context()) {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	f.stop(t)
+	return srv.Listener.Addr().String()
+}
+
+func (f *flakyServer) httpClient(t *testing.T, opts ...logintransport.ClientOption) loginservice.Service {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	client, err := logintransport.NewHTTPClient(f.httpInstance(t), tracer, nil, logger, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// serveGRPC starts a gRPC server on lis.
+func (f *flakyServer) serveGRPC(t *testing.T, lis net.Listener) {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if f.misbehave(ctx) {
+			return nil, status.Error(codes.Unavailable, "connection dropped")
+		}
+		return kitgrpc.Interceptor(ctx, req, info, handler)
+	}))
+	pb.RegisterLoginServer(server, logintransport.NewGRPCServer(f.endpoints(), tracer, nil, logger))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	f.stop(t)
+}
+
+// grpcInstance starts a gRPC server and returns its address.
+func (f *flakyServer) grpcInstance(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.serveGRPC(t, lis)
+	return lis.Addr().String()
+}
+
+func (f *flakyServer) grpcClient(t *testing.T, opts ...logintransport.ClientOption) loginservice.Service {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	lis := bufconn.Listen(1 << 16)
+	f.serveGRPC(t, lis)
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return logintransport.NewGRPCClient(conn, tracer, nil, logger, opts...)
+}
+
+var transports = map[string]func(*flakyServer, *testing.T, ...logintransport.ClientOption) loginservice.Service{
+	"HTTP": (*flakyServer).httpClient,
+	"gRPC": (*flakyServer).grpcClient,
+}
+
+func TestClientRetriesIdempotentCalls(t *testing.T) {
+	for name, newClient := range transports {
+		f := &flakyServer{drop: map[int32]bool{1: true, 2: true}}
+		client := newClient(f, t, logintransport.ClientRetries(3, time.Millisecond, 10*time.Millisecond))
+		sid, err := client.Name(context.Background(), "ed")
+		assert.NoError(t, err, name)
+		assert.Equal(t, "a123456789", sid, name)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&f.calls), name)
+	}
+}
+
+func TestClientGivesUpAfterMaxAttempts(t *testing.T) {
+	for name, newClient := range transports {
+		f := &flakyServer{drop: map[int32]bool{1: true, 2: true, 3: true}}
+		client := newClient(f, t, logintransport.ClientRetries(2, time.Millisecond, 10*time.Millisecond))
+		_, err := client.Name(context.Background(), "ed")
+		assert.Error(t, err, name)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&f.calls), name)
+	}
+}
+
+func TestClientDoesNotRetryNonIdempotentCalls(t *testing.T) {
+	for name, newClient := range transports {
+		f := &flakyServer{drop: map[int32]bool{1: true}}
+		client := newClient(f, t, logintransport.ClientRetries(3, time.Millisecond, 10*time.Millisecond))
+		_, err := client.Authenticate(context.Background(), "ed", "password")
+		assert.Error(t, err, name)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&f.calls), name)
+	}
+}
+
+func TestClientAttemptTimeout(t *testing.T) {
+	for name, newClient := range transports {
+		f := &flakyServer{stall: map[int32]bool{1: true}}
+		client := newClient(f, t,
+			logintransport.ClientRetries(2, time.Millisecond, 10*time.Millisecond),
+			logintransport.ClientAttemptTimeout(50*time.Millisecond),
+		)
+		begin := time.Now()
+		_, err := client.Name(context.Background(), "ed")
+		assert.NoError(t, err, name)
+		assert.Less(t, int64(time.Since(begin)), int64(time.Second), name)
+	}
+}
+
+func TestClientTimeoutBoundsRetries(t *testing.T) {
+	for name, newClient := range transports {
+		f := &flakyServer{stall: map[int32]bool{1: true, 2: true, 3: true}}
+		client := newClient(f, t,
+			logintransport.ClientRetries(3, time.Millisecond, 10*time.Millisecond),
+			logintransport.ClientTimeout(100*time.Millisecond),
+		)
+		begin := time.Now()
+		_, err := client.Name(context.Background(), "ed")
+		assert.Error(t, err, name)
+		assert.Less(t, int64(time.Since(begin)), int64(time.Second), name)
+	}
+}
+
+func TestClientHedging(t *testing.T) {
+	for name, newClient := range transports {
+		f := &flakyServer{stall: map[int32]bool{1: true}}
+		client := newClient(f, t, logintransport.ClientHedging(50*time.Millisecond, 1))
+		begin := time.Now()
+		sid, err := client.Name(context.Background(), "ed")
+		assert.NoError(t, err, name)
+		assert.Equal(t, "a123456789", sid, name)
+		assert.Less(t, int64(time.Since(begin)), int64(time.Second), name)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&f.calls), name)
+	}
+}
+
+func TestClientHedgingLeavesBreakerClosed(t *testing.T) {
+	for name, newClient := range transports {
+		// Every first copy stalls: the server is slow at times, not down.
+		f := &flakyServer{stall: map[int32]bool{}}
+		for n := int32(1); n < 40; n += 2 {
+			f.stall[n] = true
+		}
+		breakers := breaker.NewRegistry(nil, nil, nil)
+		client := newClient(f, t, logintransport.ClientHedging(10*time.Millisecond, 1), logintransport.ClientBreakers(breakers))
+		for i := 0; i < 20; i++ {
+			_, err := client.Name(context.Background(), "ed")
+			assert.NoError(t, err, name)
+		}
+		b := breakers.Breaker("Name")
+		assert.Eventually(t, func() bool { return b.Counts().Canceled == 20 }, time.Second, time.Millisecond, name)
+		assert.Equal(t, breaker.Counts{Successes: 20, Canceled: 20}, b.Counts(), name)
+		assert.Equal(t, gobreaker.StateClosed, b.State(), name)
+	}
+}
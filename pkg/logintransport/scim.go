@@ -0,0 +1,363 @@
+package logintransport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/tracing/opentracing"
+	httptransport "github.com/go-kit/kit/transport/http"
+	stdopentracing "github.com/opentracing/opentracing-go"
+
+	"loginsvc/pkg/loginendpoint"
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/scim"
+	"loginsvc/pkg/tenant"
+)
+
+// scimPrefix is the path the SCIM endpoints are served under.
+const scimPrefix = "/scim/v2"
+
+// scimRoute serves the methods of a SCIM path, by HTTP method.
+type scimRoute map[string]http.Handler
+
+// newSCIMHandler serves the endpoints of a ProvisioningService under
+// /scim/v2/ (RFC 7644, section 3.2): /Users and /Groups, with /Users/{id}
+// and /Groups/{id}, and the discovery resources, which need no token.
+// Errors are SCIM error responses.
+func newSCIMHandler(endpoints loginendpoint.SCIMSet, options []httptransport.ServerOption, otTracer stdopentracing.Tracer, logger log.Logger) http.Handler {
+	options = append(options,
+		httptransport.ServerErrorEncoder(scimErrorEncoder),
+		httptransport.ServerBefore(scimContext),
+	)
+	server := func(method string, e endpoint.Endpoint, dec httptransport.DecodeRequestFunc, enc httptransport.EncodeResponseFunc) http.Handler {
+		return httptransport.NewServer(e, dec, enc, append(options, httptransport.ServerBefore(opentracing.HTTPToContext(otTracer, method, logger)))...)
+	}
+	collections := map[string]scimRoute{
+		"Users": {
+			http.MethodGet:  server("SCIMListUsers", endpoints.ListUsersEndpoint, decodeSCIMListRequest, encodeSCIMResponse(http.StatusOK)),
+			http.MethodPost: server("SCIMCreateUser", endpoints.CreateUserEndpoint, decodeSCIMUserRequest, encodeSCIMResponse(http.StatusCreated)),
+		},
+		"Groups": {
+			http.MethodGet:  server("SCIMListGroups", endpoints.ListGroupsEndpoint, decodeSCIMListRequest, encodeSCIMResponse(http.StatusOK)),
+			http.MethodPost: server("SCIMCreateGroup", endpoints.CreateGroupEndpoint, decodeSCIMGroupRequest, encodeSCIMResponse(http.StatusCreated)),
+		},
+		"ServiceProviderConfig": {
+			http.MethodGet: scimDiscoveryHandler(func(base string) interface{} {
+				config := scim.NewServiceProviderConfig(loginservice.MaxPageSize)
+				config.Meta.Location = base + "/ServiceProviderConfig"
+				return config
+			}),
+		},
+		"ResourceTypes": {
+			http.MethodGet: scimDiscoveryHandler(func(base string) interface{} {
+				var resources []interface{}
+				for _, t := range scimResourceTypes(base) {
+					resources = append(resources, t)
+				}
+				return scim.NewListResponse(resources, len(resources), 1)
+			}),
+		},
+		"Schemas": {
+			http.MethodGet: scimDiscoveryHandler(func(base string) interface{} {
+				var resources []interface{}
+				for _, s := range scimSchemas(base) {
+					resources = append(resources, s)
+				}
+				return scim.NewListResponse(resources, len(resources), 1)
+			}),
+		},
+	}
+	resources := map[string]scimRoute{
+		"Users": {
+			http.MethodGet:    server("SCIMGetUser", endpoints.GetUserEndpoint, decodeSCIMResourceRequest, encodeSCIMResponse(http.StatusOK)),
+			http.MethodPut:    server("SCIMReplaceUser", endpoints.ReplaceUserEndpoint, decodeSCIMUserRequest, encodeSCIMResponse(http.StatusOK)),
+			http.MethodPatch:  server("SCIMPatchUser", endpoints.PatchUserEndpoint, decodeSCIMPatchRequest, encodeSCIMResponse(http.StatusOK)),
+			http.MethodDelete: server("SCIMDeleteUser", endpoints.DeleteUserEndpoint, decodeSCIMResourceRequest, encodeSCIMResponse(http.StatusNoContent)),
+		},
+		"Groups": {
+			http.MethodGet:    server("SCIMGetGroup", endpoints.GetGroupEndpoint, decodeSCIMResourceRequest, encodeSCIMResponse(http.StatusOK)),
+			http.MethodPut:    server("SCIMReplaceGroup", endpoints.ReplaceGroupEndpoint, decodeSCIMGroupRequest, encodeSCIMResponse(http.StatusOK)),
+			http.MethodPatch:  server("SCIMPatchGroup", endpoints.PatchGroupEndpoint, decodeSCIMPatchRequest, encodeSCIMResponse(http.StatusOK)),
+			http.MethodDelete: server("SCIMDeleteGroup", endpoints.DeleteGroupEndpoint, decodeSCIMResourceRequest, encodeSCIMResponse(http.StatusNoContent)),
+		},
+		"ResourceTypes": {
+			http.MethodGet: scimDiscoveryHandler(func(base string) interface{} {
+				return scimResourceTypes(base)
+			}),
+		},
+		"Schemas": {
+			http.MethodGet: scimDiscoveryHandler(func(base string) interface{} {
+				return scimSchemas(base)
+			}),
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		collection, id := splitSCIMPath(r.URL.Path)
+		route, ok := collections[collection]
+		if id != "" {
+			route, ok = resources[collection]
+		}
+		if !ok {
+			writeSCIMError(w, &scim.Error{Schemas: []string{scim.ErrorSchema}, Status: http.StatusNotFound, Detail: "no such resource"})
+			return
+		}
+		h, ok := route[r.Method]
+		if !ok {
+			var allowed []string
+			for m := range route {
+				allowed = append(allowed, m)
+			}
+			sort.Strings(allowed)
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeSCIMError(w, &scim.Error{Schemas: []string{scim.ErrorSchema}, Status: http.StatusMethodNotAllowed, Detail: r.Method + " is not supported here"})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// splitSCIMPath splits the path of a SCIM request into its collection and
+// the id of a resource in it, which may have slashes.
+func splitSCIMPath(path string) (collection, id string) {
+	path = strings.TrimPrefix(path, scimPrefix+"/")
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
+
+func scimResourceID(r *http.Request) string {
+	_, id := splitSCIMPath(r.URL.Path)
+	return id
+}
+
+type scimBaseKey struct{}
+
+// scimContext stores the bearer token of a request with scim.WithToken,
+// and its base URL, including a tenant.PathPrefix, for the locations of
+// resources.
+func scimContext(ctx context.Context, r *http.Request) context.Context {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		ctx = scim.WithToken(ctx, strings.TrimSpace(auth[7:]))
+	}
+	return context.WithValue(ctx, scimBaseKey{}, scimBaseURL(r))
+}
+
+func scimBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	base := scheme + "://" + r.Host
+	if c := tenant.ClaimsFromContext(r.Context()); c.Path != "" {
+		base += tenant.PathPrefix + c.Path
+	}
+	return base + scimPrefix
+}
+
+// scimDiscoveryHandler serves the discovery resource body returns for the
+// base URL of the request.
+func scimDiscoveryHandler(body func(base string) interface{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := scimResourceID(r); id != "" {
+			// body is a list of the resources of the collection.
+			if found := findSCIMDiscovery(body(scimBaseURL(r)), id); found != nil {
+				writeSCIM(w, http.StatusOK, found)
+				return
+			}
+			writeSCIMError(w, &scim.Error{Schemas: []string{scim.ErrorSchema}, Status: http.StatusNotFound, Detail: "no such resource"})
+			return
+		}
+		writeSCIM(w, http.StatusOK, body(scimBaseURL(r)))
+	})
+}
+
+func findSCIMDiscovery(list interface{}, id string) interface{} {
+	switch list := list.(type) {
+	case []scim.ResourceType:
+		for _, t := range list {
+			if t.ID == id {
+				return t
+			}
+		}
+	case []scim.Schema:
+		for _, s := range list {
+			if s.ID == id {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
+func scimResourceTypes(base string) []scim.ResourceType {
+	types := scim.ResourceTypes()
+	for i := range types {
+		types[i].Meta.Location = base + "/ResourceTypes/" + types[i].ID
+	}
+	return types
+}
+
+func scimSchemas(base string) []scim.Schema {
+	schemas := scim.Schemas()
+	for i := range schemas {
+		schemas[i].Meta.Location = base + "/Schemas/" + schemas[i].ID
+	}
+	return schemas
+}
+
+func decodeSCIMResourceRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return loginendpoint.SCIMResourceRequest{ID: scimResourceID(r)}, nil
+}
+
+func decodeSCIMUserRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	req := loginendpoint.SCIMUserRequest{ID: scimResourceID(r)}
+	return req, decodeSCIMBody(r, &req.User)
+}
+
+func decodeSCIMGroupRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	req := loginendpoint.SCIMGroupRequest{ID: scimResourceID(r)}
+	return req, decodeSCIMBody(r, &req.Group)
+}
+
+func decodeSCIMPatchRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	req := loginendpoint.SCIMPatchRequest{ID: scimResourceID(r)}
+	return req, decodeSCIMBody(r, &req.Patch)
+}
+
+// decodeSCIMListRequest reads the filter, startIndex and count query
+// parameters (RFC 7644, section 3.4.2).
+func decodeSCIMListRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	req := loginendpoint.SCIMListRequest{Query: scim.Query{Filter: q.Get("filter")}}
+	if s := q.Get("startIndex"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, invalidSCIMParameter("startIndex", err)
+		}
+		req.StartIndex = n
+	}
+	if s := q.Get("count"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, invalidSCIMParameter("count", err)
+		}
+		req.Count = &n
+	}
+	return req, nil
+}
+
+func invalidSCIMParameter(name string, err error) error {
+	e := scim.NewError(scim.TypeInvalidValue, "%s must be an integer", name)
+	return &loginservice.Error{Code: loginservice.CodeInvalidArgument, Message: e.Detail, Err: e}
+}
+
+// decodeSCIMBody is decodeHTTPBody for SCIM, whose malformed bodies are
+// invalidSyntax errors.
+func decodeSCIMBody(r *http.Request, req interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return &loginservice.Error{Code: loginservice.CodeInvalidArgument, Message: "malformed request body", Err: scim.NewError(scim.TypeInvalidSyntax, "%v", err)}
+	}
+	return nil
+}
+
+// encodeSCIMResponse writes the resource of a response with status, and
+// its location. Resources with status 201 Created also have their
+// location in the Location header.
+func encodeSCIMResponse(status int) httptransport.EncodeResponseFunc {
+	return func(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+		if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+			scimErrorEncoder(ctx, f.Failed(), w)
+			return nil
+		}
+		base, _ := ctx.Value(scimBaseKey{}).(string)
+		var body interface{}
+		switch resp := response.(type) {
+		case loginendpoint.SCIMUserResponse:
+			body = locateSCIMResource(base, resp.User)
+		case loginendpoint.SCIMGroupResponse:
+			body = locateSCIMResource(base, resp.Group)
+		case loginendpoint.SCIMListResponse:
+			for i, r := range resp.Resources {
+				resp.Resources[i] = locateSCIMResource(base, r)
+			}
+			body = resp.ListResponse
+		case loginendpoint.SCIMDeleteResponse:
+			w.WriteHeader(status)
+			return nil
+		}
+		if status == http.StatusCreated {
+			switch r := body.(type) {
+			case scim.User:
+				w.Header().Set("Location", r.Meta.Location)
+			case scim.Group:
+				w.Header().Set("Location", r.Meta.Location)
+			}
+		}
+		return writeSCIM(w, status, body)
+	}
+}
+
+// locateSCIMResource sets the location of a user or a group, and the $ref
+// of the members of a group, under base.
+func locateSCIMResource(base string, r interface{}) interface{} {
+	switch r := r.(type) {
+	case scim.User:
+		if r.Meta != nil {
+			r.Meta.Location = base + "/Users/" + url.PathEscape(r.ID)
+		}
+		return r
+	case scim.Group:
+		if r.Meta != nil {
+			r.Meta.Location = base + "/Groups/" + url.PathEscape(r.ID)
+		}
+		for i, m := range r.Members {
+			r.Members[i].Ref = base + "/" + m.Type + "s/" + url.PathEscape(m.Value)
+		}
+		return r
+	}
+	return r
+}
+
+func writeSCIM(w http.ResponseWriter, status int, body interface{}) error {
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(body)
+}
+
+// scimErrorEncoder is errorEncoder for SCIM: it writes the status of err
+// as a SCIM error, with the scimType of the scim.Error err wraps, or else
+// the one its code implies. Internal errors have no detail.
+func scimErrorEncoder(_ context.Context, err error, w http.ResponseWriter) {
+	e := &scim.Error{Schemas: []string{scim.ErrorSchema}, Status: err2code(err)}
+	e.Detail = http.StatusText(e.Status)
+	if d := domainError(err); d != nil {
+		e.Detail = d.Message
+		switch d.Code {
+		case loginservice.CodeInvalidArgument:
+			e.ScimType = scim.TypeInvalidValue
+		case loginservice.CodeAlreadyExists:
+			e.ScimType = scim.TypeUniqueness
+		}
+	}
+	var se *scim.Error
+	if errors.As(err, &se) {
+		e.ScimType = se.ScimType
+	}
+	if e.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+	}
+	writeSCIMError(w, e)
+}
+
+func writeSCIMError(w http.ResponseWriter, e *scim.Error) {
+	writeSCIM(w, e.Status, e)
+}
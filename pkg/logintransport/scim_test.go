@@ -0,0 +1,163 @@
+package logintransport_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+
+	"loginsvc/pkg/breaker"
+	"loginsvc/pkg/limiter"
+	"loginsvc/pkg/loginendpoint"
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/logintransport"
+	"loginsvc/pkg/scim"
+	"loginsvc/pkg/tenant"
+	"loginsvc/repo"
+)
+
+// groupRepo has the single group staff, with the user bo.
+type groupRepo struct {
+	repo.GroupRepository
+}
+
+func (groupRepo) Groups() ([]repo.Group, error) { return []repo.Group{{Name: "staff"}}, nil }
+
+func (groupRepo) Members(string) ([]string, error) { return []string{"bo"}, nil }
+
+func newSCIMServer(t *testing.T) *httptest.Server {
+	svc := loginservice.ProvisioningTenants{"acme": loginservice.NewProvisioning(adminService{accounts: map[string]loginservice.Account{
+		"bo": {Name: "bo", SID: "c2", Status: "active"},
+	}}, groupRepo{})}
+	sum := sha256.Sum256([]byte("s3cret"))
+	tokens := loginendpoint.SCIMTokens{"acme": {"workday": hex.EncodeToString(sum[:])}}
+	r := tenant.Resolver{Tenants: []string{"acme"}, Fallback: "acme"}
+	lim := limiter.New(limiter.NewMemoryStore(0), limiter.Rules{}, 0, log.NewNopLogger())
+	endpoints := loginendpoint.NewSCIM(svc, lim, breaker.NewRegistry(nil, nil, nil), tokens, r, log.NewNopLogger(), discard.NewHistogram(), stdopentracing.GlobalTracer(), nil)
+	srv := httptest.NewServer(logintransport.NewHTTPHandlerWithAdmin(loginendpoint.Set{}, loginendpoint.AdminSet{}, endpoints, stdopentracing.GlobalTracer(), nil, log.NewNopLogger()))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPSCIM(t *testing.T) {
+	srv := newSCIMServer(t)
+	call := func(method, path, token, body string) (*http.Response, map[string]interface{}) {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", scim.ContentType)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		var m map[string]interface{}
+		json.Unmarshal(b, &m)
+		return resp, m
+	}
+
+	// Calls without a valid token fail, as SCIM errors.
+	for _, token := range []string{"", "wrong"} {
+		resp, body := call("GET", "/scim/v2/Users", token, "")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, `Bearer realm="scim"`, resp.Header.Get("WWW-Authenticate"))
+		assert.Equal(t, scim.ContentType, resp.Header.Get("Content-Type"))
+		assert.Equal(t, "401", body["status"])
+		assert.Equal(t, []interface{}{scim.ErrorSchema}, body["schemas"])
+	}
+
+	resp, body := call("POST", "/scim/v2/Users", "s3cret", `{"schemas":["`+scim.UserSchema+`"],"userName":"al","externalId":"c1","emails":[{"value":"al@example.com","primary":true}]}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, srv.URL+"/scim/v2/Users/al", resp.Header.Get("Location"))
+	assert.Equal(t, "al", body["id"])
+	assert.Equal(t, srv.URL+"/scim/v2/Users/al", body["meta"].(map[string]interface{})["location"])
+	resp, body = call("POST", "/scim/v2/Users", "s3cret", `{"userName":"al","externalId":"c1"}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, scim.TypeUniqueness, body["scimType"])
+	resp, body = call("POST", "/scim/v2/Users", "s3cret", `{"userName":`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, scim.TypeInvalidSyntax, body["scimType"])
+
+	resp, body = call("GET", `/scim/v2/Users?filter=emails+co+"example"&startIndex=1&count=10`, "s3cret", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []interface{}{[]interface{}{scim.ListResponseSchema}, 1.0, 1.0, 1.0}, []interface{}{body["schemas"], body["totalResults"], body["startIndex"], body["itemsPerPage"]})
+	resp, body = call("GET", `/scim/v2/Users?filter=emails+co`, "s3cret", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, scim.TypeInvalidFilter, body["scimType"])
+	resp, _ = call("GET", `/scim/v2/Users?count=x`, "s3cret", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body = call("PATCH", "/scim/v2/Users/al", "s3cret", `{"schemas":["`+scim.PatchOpSchema+`"],"Operations":[{"op":"replace","path":"active","value":false}]}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, false, body["active"])
+	resp, body = call("PATCH", "/scim/v2/Users/al", "s3cret", `{"schemas":["`+scim.PatchOpSchema+`"],"Operations":[{"op":"replace","path":"userName","value":"bo"}]}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, scim.TypeMutability, body["scimType"])
+	resp, body = call("PUT", "/scim/v2/Users/al", "s3cret", `{"userName":"al","phoneNumbers":[{"value":"555"}]}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Nil(t, body["emails"])
+
+	resp, _ = call("DELETE", "/scim/v2/Users/al", "s3cret", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, body = call("GET", "/scim/v2/Users/al", "s3cret", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "user not found", body["detail"])
+
+	// Locations keep the tenant path, and members have references.
+	resp, body = call("GET", "/tenants/acme/scim/v2/Groups/staff", "s3cret", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []interface{}{map[string]interface{}{"value": "bo", "type": "User", "$ref": srv.URL + "/tenants/acme/scim/v2/Users/bo"}}, body["members"])
+	resp, _ = call("GET", "/scim/v2/Groups/managers", "s3cret", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = call("DELETE", "/scim/v2/Users", "s3cret", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, "GET, POST", resp.Header.Get("Allow"))
+	resp, body = call("GET", "/scim/v2/Bulk", "s3cret", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "404", body["status"])
+}
+
+func TestHTTPSCIMDiscovery(t *testing.T) {
+	srv := newSCIMServer(t)
+	get := func(path string) (int, map[string]interface{}) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, scim.ContentType, resp.Header.Get("Content-Type"))
+		var m map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&m)
+		return resp.StatusCode, m
+	}
+	code, body := get("/scim/v2/ServiceProviderConfig")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"supported": true}, body["patch"])
+	assert.Equal(t, map[string]interface{}{"supported": false, "maxOperations": 0.0, "maxPayloadSize": 0.0}, body["bulk"])
+
+	code, body = get("/scim/v2/ResourceTypes")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2.0, body["totalResults"])
+	code, body = get("/scim/v2/ResourceTypes/Group")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "/Groups", body["endpoint"])
+	assert.Equal(t, srv.URL+"/scim/v2/ResourceTypes/Group", body["meta"].(map[string]interface{})["location"])
+
+	code, body = get("/scim/v2/Schemas/" + scim.UserSchema)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "User", body["name"])
+	code, _ = get("/scim/v2/Schemas/urn:example")
+	assert.Equal(t, http.StatusNotFound, code)
+}
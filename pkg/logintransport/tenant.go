@@ -0,0 +1,73 @@
+package logintransport
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+
+	"loginsvc/pkg/tenant"
+)
+
+// withTenant records what a request says about its tenant, for
+// loginendpoint.TenantMiddleware: its host, its tenant header and a
+// tenant.PathPrefix, which it strips before routing.
+func withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := tenant.Claims{
+			Host:   tenant.StripPort(r.Host),
+			Header: r.Header.Get(tenant.Header),
+		}
+		t, rest := tenant.SplitPath(r.URL.Path)
+		c.Path = t
+		r = r.WithContext(tenant.WithClaims(r.Context(), c))
+		if t != "" {
+			// r is already a copy, but its URL is shared.
+			u := *r.URL
+			u.Path, u.RawPath = rest, ""
+			r.URL = &u
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// grpcTenantContext is withTenant for gRPC, whose requests name their
+// tenant by authority or metadata, or else in the message, as
+// WatchEvents requests may.
+func grpcTenantContext(ctx context.Context, md metadata.MD) context.Context {
+	var c tenant.Claims
+	if v := md.Get(":authority"); len(v) > 0 {
+		c.Host = tenant.StripPort(v[0])
+	}
+	if v := md.Get(strings.ToLower(tenant.Header)); len(v) > 0 {
+		c.Header = v[0]
+	}
+	return tenant.WithClaims(ctx, c)
+}
+
+// ClientTenant makes clients name tenant id in the tenant header or
+// metadata of every request.
+func ClientTenant(id string) ClientOption {
+	return func(o *clientOptions) { o.tenant = id }
+}
+
+// httpSetTenant is the ClientBefore function of ClientTenant.
+func httpSetTenant(id string) func(context.Context, *http.Request) context.Context {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if id != "" {
+			r.Header.Set(tenant.Header, id)
+		}
+		return ctx
+	}
+}
+
+// grpcSetTenant is httpSetTenant for gRPC.
+func grpcSetTenant(id string) func(context.Context, *metadata.MD) context.Context {
+	return func(ctx context.Context, md *metadata.MD) context.Context {
+		if id != "" {
+			md.Set(strings.ToLower(tenant.Header), id)
+		}
+		return ctx
+	}
+}
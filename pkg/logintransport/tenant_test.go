@@ -0,0 +1,128 @@
+package logintransport_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	kitgrpc "github.com/go-kit/kit/transport/grpc"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "loginsvc/pb"
+	"loginsvc/pkg/loginendpoint"
+	"loginsvc/pkg/loginservice"
+	"loginsvc/pkg/logintransport"
+	"loginsvc/pkg/tenant"
+)
+
+// tenantService answers Name with the SID its tenant gave the user.
+type tenantService struct {
+	loginservice.Service
+	sid string
+}
+
+func (s tenantService) Name(_ context.Context, n string) (string, error) {
+	return s.sid, nil
+}
+
+func newTenantEndpoints() loginendpoint.Set {
+	svc := loginservice.Tenants{
+		"acme":   tenantService{sid: "acme-1"},
+		"globex": tenantService{sid: "globex-1"},
+	}
+	r := tenant.Resolver{
+		Tenants: []string{"acme", "globex"},
+		Hosts:   map[string]string{"login.acme.example": "acme"},
+	}
+	return loginendpoint.Set{LoginEndpoint: loginendpoint.TenantMiddleware(r)(loginendpoint.MakeLoginEndpoint(svc))}
+}
+
+func TestHTTPTenant(t *testing.T) {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	srv := httptest.NewServer(logintransport.NewHTTPHandler(newTenantEndpoints(), tracer, nil, logger))
+	defer srv.Close()
+	ctx := context.Background()
+
+	for id, want := range map[string]string{"acme": "acme-1", "globex": "globex-1"} {
+		client, err := logintransport.NewHTTPClient(srv.URL, tracer, nil, logger, logintransport.ClientTenant(id))
+		assert.NoError(t, err)
+		sid, err := client.Name(ctx, "ed")
+		assert.NoError(t, err)
+		assert.Equal(t, want, sid)
+	}
+
+	// Requests naming no tenant, an unknown one or two fail closed.
+	for _, id := range []string{"", "initech"} {
+		client, _ := logintransport.NewHTTPClient(srv.URL, tracer, nil, logger, logintransport.ClientTenant(id))
+		_, err := client.Name(ctx, "ed")
+		assert.True(t, errors.Is(err, loginservice.ErrPermissionDenied), "%q: %v", id, err)
+	}
+
+	post := func(path, host, header string) (int, string) {
+		req, _ := http.NewRequest("POST", srv.URL+path, strings.NewReader(`{"N":"ed"}`))
+		req.Host = host
+		if header != "" {
+			req.Header.Set(tenant.Header, header)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return 0, ""
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	code, body := post("/name", "login.acme.example:8080", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "acme-1")
+	code, body = post("/tenants/globex/name", "", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "globex-1")
+	code, _ = post("/tenants/globex/name", "login.acme.example", "")
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = post("/name", "login.acme.example", "globex")
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestGRPCTenant(t *testing.T) {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	lis := bufconn.Listen(1 << 16)
+	server := grpc.NewServer(grpc.UnaryInterceptor(kitgrpc.Interceptor))
+	pb.RegisterLoginServer(server, logintransport.NewGRPCServer(newTenantEndpoints(), tracer, nil, logger))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	dial := func(authority string) *grpc.ClientConn {
+		conn, err := grpc.Dial(authority, grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}))
+		assert.NoError(t, err)
+		return conn
+	}
+	ctx := context.Background()
+
+	conn := dial("bufnet")
+	defer conn.Close()
+	sid, err := logintransport.NewGRPCClient(conn, tracer, nil, logger, logintransport.ClientTenant("globex")).Name(ctx, "ed")
+	assert.NoError(t, err)
+	assert.Equal(t, "globex-1", sid)
+	_, err = logintransport.NewGRPCClient(conn, tracer, nil, logger).Name(ctx, "ed")
+	assert.True(t, errors.Is(err, loginservice.ErrPermissionDenied), "%v", err)
+
+	acme := dial("login.acme.example:443")
+	defer acme.Close()
+	sid, err = logintransport.NewGRPCClient(acme, tracer, nil, logger).Name(ctx, "ed")
+	assert.NoError(t, err)
+	assert.Equal(t, "acme-1", sid)
+	_, err = logintransport.NewGRPCClient(acme, tracer, nil, logger, logintransport.ClientTenant("globex")).Name(ctx, "ed")
+	assert.True(t, errors.Is(err, loginservice.ErrPermissionDenied), "%v", err)
+}
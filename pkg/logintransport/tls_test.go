@@ -0,0 +1,61 @@
+package logintransport_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	kitgrpc "github.com/go-kit/kit/transport/grpc"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	pb "loginsvc/pb"
+	"loginsvc/pkg/logintransport"
+)
+
+func TestClientTLS(t *testing.T) {
+	tracer, logger := stdopentracing.GlobalTracer(), log.NewNopLogger()
+	srv := httptest.NewTLSServer(logintransport.NewHTTPHandler(newEndpoints(), tracer, nil, logger))
+	defer srv.Close()
+	config := srv.Client().Transport.(*http.Transport).TLSClientConfig
+	ctx := context.Background()
+
+	// The host:port of an instance defaults to https.
+	client, err := logintransport.NewHTTPClient(srv.Listener.Addr().String(), tracer, nil, logger, logintransport.ClientTLS(config))
+	assert.NoError(t, err)
+	sid, err := client.Name(ctx, "ed")
+	assert.NoError(t, err)
+	assert.Equal(t, "a123456789", sid)
+
+	// Without the CA the server isn't trusted.
+	client, err = logintransport.NewHTTPClient(srv.URL, tracer, nil, logger)
+	assert.NoError(t, err)
+	_, err = client.Name(ctx, "ed")
+	assert.Error(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(srv.TLS)), grpc.UnaryInterceptor(kitgrpc.Interceptor))
+	pb.RegisterLoginServer(server, logintransport.NewGRPCServer(newEndpoints(), tracer, nil, logger))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := logintransport.DialGRPC(ctx, lis.Addr().String(), logintransport.ClientTLS(config))
+	assert.NoError(t, err)
+	defer conn.Close()
+	sid, err = logintransport.NewGRPCClient(conn, tracer, nil, logger).Name(ctx, "ed")
+	assert.NoError(t, err)
+	assert.Equal(t, "a123456789", sid)
+
+	plain, err := logintransport.DialGRPC(ctx, lis.Addr().String())
+	assert.NoError(t, err)
+	defer plain.Close()
+	_, err = logintransport.NewGRPCClient(plain, tracer, nil, logger, logintransport.ClientTimeout(time.Second)).Name(ctx, "ed")
+	assert.Error(t, err)
+}
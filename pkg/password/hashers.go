@@ -0,0 +1,100 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"hash"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// DefaultBcryptCost is the bcrypt cost of new hashes. Hashes with a
+	// lower cost are upgraded on login.
+	DefaultBcryptCost = 12
+
+	// DefaultPBKDF2Iterations follows the OWASP recommendation for
+	// PBKDF2-HMAC-SHA256.
+	DefaultPBKDF2Iterations = 310000
+
+	// MaxPBKDF2Iterations bounds the iteration count of the PBKDF2 hashes
+	// accepted, so that a stored hash can't make a verification run for
+	// minutes.
+	MaxPBKDF2Iterations = 10 * DefaultPBKDF2Iterations
+)
+
+// Bcrypt hashes with bcrypt.
+type Bcrypt struct {
+	cost int
+}
+
+// NewBcrypt returns a bcrypt hasher with the given cost.
+func NewBcrypt(cost int) *Bcrypt {
+	return &Bcrypt{cost: cost}
+}
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	return string(hash), err
+}
+
+func (b *Bcrypt) Verify(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *Bcrypt) Outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < b.cost
+}
+
+// PBKDF2 hashes with PBKDF2-HMAC, producing hashes as parsed by Normalize.
+type PBKDF2 struct {
+	digest     string
+	iterations int
+}
+
+// NewPBKDF2 returns a PBKDF2 hasher for digest sha1, sha256 or sha512.
+func NewPBKDF2(digest string, iterations int) *PBKDF2 {
+	return &PBKDF2{digest: digest, iterations: iterations}
+}
+
+func (p *PBKDF2) Hash(password string) (string, error) {
+	h := pbkdf2Hash{digest: p.digest, iterations: p.iterations, salt: make([]byte, 16)}
+	if _, err := rand.Read(h.salt); err != nil {
+		return "", err
+	}
+	h.key = pbkdf2.Key([]byte(password), h.salt, h.iterations, 32, digestFunc(p.digest))
+	return h.String(), nil
+}
+
+func (p *PBKDF2) Verify(hash, password string) (bool, error) {
+	h, err := parsePBKDF2(hash)
+	if err != nil {
+		return false, err
+	}
+	key := pbkdf2.Key([]byte(password), h.salt, h.iterations, len(h.key), digestFunc(h.digest))
+	return subtle.ConstantTimeCompare(key, h.key) == 1, nil
+}
+
+func (p *PBKDF2) Outdated(hash string) bool {
+	h, err := parsePBKDF2(hash)
+	return err != nil || h.digest != p.digest || h.iterations < p.iterations
+}
+
+func digestFunc(digest string) func() hash.Hash {
+	switch digest {
+	case "sha1":
+		return sha1.New
+	case "sha512":
+		return sha512.New
+	}
+	return sha256.New
+}
@@ -0,0 +1,105 @@
+package password
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"errors"
+	"strings"
+)
+
+const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// MD5Crypt verifies $1$ hashes as produced by the FreeBSD MD5-crypt
+// algorithm. It is far too weak to hash new passwords, so Hash fails and
+// every hash is outdated.
+type MD5Crypt struct{}
+
+func (MD5Crypt) Hash(string) (string, error) {
+	return "", errors.New("password: md5-crypt must not be used for new hashes")
+}
+
+func (MD5Crypt) Verify(hash, password string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[1] != "1" || len(parts[3]) != 22 {
+		return false, ErrMalformedHash
+	}
+	want := md5Crypt([]byte(password), []byte(parts[2]))
+	return subtle.ConstantTimeCompare([]byte(want), []byte(hash)) == 1, nil
+}
+
+func (MD5Crypt) Outdated(string) bool {
+	return true
+}
+
+// md5Crypt implements the algorithm of FreeBSD's crypt-md5.c.
+func md5Crypt(password, salt []byte) string {
+	const magic = "$1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	alt := md5.New()
+	alt.Write(password)
+	alt.Write(salt)
+	alt.Write(password)
+	altSum := alt.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(password)
+	ctx.Write([]byte(magic))
+	ctx.Write(salt)
+	for n := len(password); n > 0; n -= 16 {
+		if n > 16 {
+			ctx.Write(altSum)
+		} else {
+			ctx.Write(altSum[:n])
+		}
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(password[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 == 1 {
+			round.Write(password)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write(salt)
+		}
+		if i%7 != 0 {
+			round.Write(password)
+		}
+		if i&1 == 1 {
+			round.Write(final)
+		} else {
+			round.Write(password)
+		}
+		final = round.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(magic)
+	out.Write(salt)
+	out.WriteByte('$')
+	for _, group := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		v := uint(final[group[0]])<<16 | uint(final[group[1]])<<8 | uint(final[group[2]])
+		for n := 0; n < 4; n++ {
+			out.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	v := uint(final[11])
+	for n := 0; n < 2; n++ {
+		out.WriteByte(itoa64[v&0x3f])
+		v >>= 6
+	}
+	return out.String()
+}
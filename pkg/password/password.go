@@ -0,0 +1,170 @@
+// Package password handles stored password hashes. Hashes are kept as PHC
+// strings (https://github.com/P-H-C/phc-string-format): the leading $<id>$
+// names the scheme, and the rest holds its parameters, salt and digest.
+package password
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnsupportedHash = errors.New("password: unsupported hash format")
+	ErrMalformedHash   = errors.New("password: malformed hash")
+)
+
+// b64 is the PHC flavour of base64: standard alphabet without padding.
+var b64 = base64.RawStdEncoding
+
+// Normalize validates a hash exported from another system and converts it to
+// the PHC string stored by loginsvc. It accepts
+//
+//   - bcrypt ($2a$, $2b$, $2y$) and MD5-crypt ($1$), kept as is;
+//   - PBKDF2 in PHC form, $pbkdf2-<digest>$i=<iterations>$<salt>$<hash>;
+//   - PBKDF2 as written by passlib, $pbkdf2-<digest>$<iterations>$<salt>$<hash>;
+//   - PBKDF2 as written by Django, pbkdf2_<digest>$<iterations>$<salt>$<hash>;
+//
+// where digest is sha1, sha256 or sha512.
+func Normalize(hash string) (string, error) {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		if err := validateBcrypt(hash); err != nil {
+			return "", err
+		}
+		return hash, nil
+	case strings.HasPrefix(hash, "$1$"):
+		parts := strings.Split(hash, "$")
+		if len(parts) != 4 || len(parts[2]) > 8 || len(parts[3]) != 22 {
+			return "", ErrMalformedHash
+		}
+		return hash, nil
+	case strings.HasPrefix(hash, "$pbkdf2-"):
+		return normalizePBKDF2(hash)
+	case strings.HasPrefix(hash, "pbkdf2_"):
+		return normalizeDjango(hash)
+	}
+	return "", ErrUnsupportedHash
+}
+
+// Scheme returns the PHC identifier of hash, e.g. "2b" or "pbkdf2-sha256".
+func Scheme(hash string) string {
+	parts := strings.SplitN(hash, "$", 3)
+	if len(parts) < 3 || parts[0] != "" {
+		return ""
+	}
+	return parts[1]
+}
+
+// Describe names the scheme and main parameter of hash for metrics, e.g.
+// "bcrypt-12", "pbkdf2-sha1" or "md5-crypt". It returns "none" for an empty
+// hash and "unknown" for an unsupported one.
+func Describe(hash string) string {
+	switch scheme := Scheme(hash); {
+	case hash == "":
+		return "none"
+	case scheme == "2a" || scheme == "2b" || scheme == "2y":
+		if validateBcrypt(hash) != nil {
+			return "unknown"
+		}
+		return "bcrypt-" + strings.TrimLeft(strings.Split(hash, "$")[2], "0")
+	case scheme == "1":
+		return "md5-crypt"
+	case strings.HasPrefix(scheme, "pbkdf2-"):
+		return scheme
+	}
+	return "unknown"
+}
+
+// validateBcrypt checks the shape of $2b$<cost>$<22 chars salt><31 chars hash>.
+func validateBcrypt(hash string) error {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || len(parts[3]) != 53 {
+		return ErrMalformedHash
+	}
+	cost, err := strconv.Atoi(parts[2])
+	if err != nil || cost < 4 || cost > 31 {
+		return ErrMalformedHash
+	}
+	return nil
+}
+
+// pbkdf2Hash holds the fields of a PBKDF2 PHC string.
+type pbkdf2Hash struct {
+	digest     string
+	iterations int
+	salt       []byte
+	key        []byte
+}
+
+func (h pbkdf2Hash) String() string {
+	return fmt.Sprintf("$pbkdf2-%s$i=%d$%s$%s", h.digest, h.iterations, b64.EncodeToString(h.salt), b64.EncodeToString(h.key))
+}
+
+func validDigest(digest string) bool {
+	return digest == "sha1" || digest == "sha256" || digest == "sha512"
+}
+
+// parsePBKDF2 parses the PHC form written by String, and the passlib form
+// whose iteration count has no "i=" and whose base64 uses '.' for '+'.
+func parsePBKDF2(hash string) (pbkdf2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 || parts[0] != "" {
+		return pbkdf2Hash{}, ErrMalformedHash
+	}
+	h := pbkdf2Hash{digest: strings.TrimPrefix(parts[1], "pbkdf2-")}
+	if !validDigest(h.digest) {
+		return pbkdf2Hash{}, ErrUnsupportedHash
+	}
+	decode := b64.DecodeString
+	iterations := parts[2]
+	if strings.HasPrefix(iterations, "i=") {
+		iterations = strings.TrimPrefix(iterations, "i=")
+	} else {
+		decode = func(s string) ([]byte, error) {
+			return b64.DecodeString(strings.Replace(s, ".", "+", -1))
+		}
+	}
+	var err error
+	if h.iterations, err = strconv.Atoi(iterations); err != nil || h.iterations < 1 || h.iterations > MaxPBKDF2Iterations {
+		return pbkdf2Hash{}, ErrMalformedHash
+	}
+	if h.salt, err = decode(parts[3]); err != nil || len(h.salt) == 0 {
+		return pbkdf2Hash{}, ErrMalformedHash
+	}
+	if h.key, err = decode(parts[4]); err != nil || len(h.key) == 0 {
+		return pbkdf2Hash{}, ErrMalformedHash
+	}
+	return h, nil
+}
+
+func normalizePBKDF2(hash string) (string, error) {
+	h, err := parsePBKDF2(hash)
+	if err != nil {
+		return "", err
+	}
+	return h.String(), nil
+}
+
+// normalizeDjango converts pbkdf2_sha256$<iterations>$<salt>$<base64 hash>.
+// Django uses the salt string itself as the salt bytes.
+func normalizeDjango(hash string) (string, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 {
+		return "", ErrMalformedHash
+	}
+	h := pbkdf2Hash{digest: strings.TrimPrefix(parts[0], "pbkdf2_"), salt: []byte(parts[2])}
+	if !validDigest(h.digest) {
+		return "", ErrUnsupportedHash
+	}
+	var err error
+	if h.iterations, err = strconv.Atoi(parts[1]); err != nil || h.iterations < 1 || h.iterations > MaxPBKDF2Iterations || len(h.salt) == 0 {
+		return "", ErrMalformedHash
+	}
+	if h.key, err = base64.StdEncoding.DecodeString(parts[3]); err != nil || len(h.key) == 0 {
+		return "", ErrMalformedHash
+	}
+	return h.String(), nil
+}
@@ -0,0 +1,61 @@
+package password_test
+
+import (
+	"testing"
+
+	"loginsvc/pkg/password"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+		err      error
+	}{
+		{
+			in:   "$2b$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+			want: "$2b$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+		},
+		{
+			in:   "$pbkdf2-sha256$i=29000$N2bMuZcSwhhjrBXCmNOaEw$m0ZJQ2SyCvRCkf5qtOE2JYnHjSKmRJ6b3l0WmA7WVKo",
+			want: "$pbkdf2-sha256$i=29000$N2bMuZcSwhhjrBXCmNOaEw$m0ZJQ2SyCvRCkf5qtOE2JYnHjSKmRJ6b3l0WmA7WVKo",
+		},
+		{
+			// passlib writes '.' instead of '+' and omits "i=".
+			in:   "$pbkdf2-sha256$29000$N2bMuZcSwhhjrBXCmNOaEw$m0ZJQ2SyCvRCkf5qtOE2JYnHjSK.RJ6b3l0WmA7WVKo",
+			want: "$pbkdf2-sha256$i=29000$N2bMuZcSwhhjrBXCmNOaEw$m0ZJQ2SyCvRCkf5qtOE2JYnHjSK+RJ6b3l0WmA7WVKo",
+		},
+		{
+			in:   "pbkdf2_sha256$260000$salt$bRDLjTBf9ntsa5gxzkVY9ENJKEjGi5HsrS0nFHuLVHo=",
+			want: "$pbkdf2-sha256$i=260000$c2FsdA$bRDLjTBf9ntsa5gxzkVY9ENJKEjGi5HsrS0nFHuLVHo",
+		},
+		{in: "$1$3azHgidD$SrJPt7B.9rekpmwJwtON31", want: "$1$3azHgidD$SrJPt7B.9rekpmwJwtON31"},
+		{in: "$2b$99$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", err: password.ErrMalformedHash},
+		{in: "$2b$10$tooshort", err: password.ErrMalformedHash},
+		{in: "$pbkdf2-md5$i=1$c2FsdA$c2FsdA", err: password.ErrUnsupportedHash},
+		{in: "$pbkdf2-sha1$i=0$c2FsdA$c2FsdA", err: password.ErrMalformedHash},
+		{in: "$pbkdf2-sha1$i=2000000000$c2FsdA$c2FsdA", err: password.ErrMalformedHash},
+		{in: "pbkdf2_sha256$2000000000$salt$c2FsdA==", err: password.ErrMalformedHash},
+		{in: "5f4dcc3b5aa765d61d8327deb882cf99", err: password.ErrUnsupportedHash},
+	} {
+		got, err := password.Normalize(tc.in)
+		assert.Equal(t, tc.err, err, tc.in)
+		assert.Equal(t, tc.want, got, tc.in)
+	}
+}
+
+func TestScheme(t *testing.T) {
+	assert.Equal(t, "2b", password.Scheme("$2b$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"))
+	assert.Equal(t, "pbkdf2-sha1", password.Scheme("$pbkdf2-sha1$i=1000$c2FsdA$c2FsdA"))
+	assert.Equal(t, "", password.Scheme("plain"))
+}
+
+func TestDescribe(t *testing.T) {
+	assert.Equal(t, "bcrypt-10", password.Describe("$2b$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"))
+	assert.Equal(t, "bcrypt-4", password.Describe("$2a$04$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"))
+	assert.Equal(t, "pbkdf2-sha1", password.Describe("$pbkdf2-sha1$i=1000$c2FsdA$c2FsdA"))
+	assert.Equal(t, "md5-crypt", password.Describe("$1$3azHgidD$SrJPt7B.9rekpmwJwtON31"))
+	assert.Equal(t, "none", password.Describe(""))
+	assert.Equal(t, "unknown", password.Describe("5f4dcc3b5aa765d61d8327deb882cf99"))
+}
@@ -0,0 +1,108 @@
+package password
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// Hasher implements one hashing scheme.
+type Hasher interface {
+	// Hash returns the PHC string of password.
+	Hash(password string) (string, error)
+
+	// Verify reports whether password matches hash.
+	Verify(hash, password string) (bool, error)
+
+	// Outdated reports whether hash was made with weaker parameters than
+	// Hash would use.
+	Outdated(hash string) bool
+}
+
+// Registry verifies hashes of every registered scheme and hashes new
+// passwords with the default one. Register all hashers before using it.
+type Registry struct {
+	hashers   map[string]Hasher
+	defaultID string
+
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+// NewRegistry returns a registry that hashes with the hasher registered
+// for defaultID. Register that hasher before hashing anything.
+func NewRegistry(defaultID string) *Registry {
+	return &Registry{hashers: map[string]Hasher{}, defaultID: defaultID}
+}
+
+// DefaultRegistry returns a registry that hashes with bcrypt at
+// DefaultBcryptCost and verifies bcrypt at any cost, PBKDF2 with SHA-1,
+// SHA-256 or SHA-512, and MD5-crypt.
+func DefaultRegistry() *Registry {
+	r := NewRegistry("2b")
+	r.Register(NewBcrypt(DefaultBcryptCost), "2a", "2b", "2y")
+	for _, digest := range []string{"sha1", "sha256", "sha512"} {
+		r.Register(NewPBKDF2(digest, DefaultPBKDF2Iterations), "pbkdf2-"+digest)
+	}
+	r.Register(MD5Crypt{}, "1")
+	return r
+}
+
+// Register makes h responsible for hashes whose PHC identifier is one of
+// ids.
+func (r *Registry) Register(h Hasher, ids ...string) {
+	for _, id := range ids {
+		r.hashers[id] = h
+	}
+}
+
+// SetDefault makes new passwords hash with the hasher registered for id,
+// which must be registered already. Call it before using the registry.
+func (r *Registry) SetDefault(id string) error {
+	if _, ok := r.hashers[id]; !ok {
+		return fmt.Errorf("password: no hasher registered for scheme %q", id)
+	}
+	r.defaultID = id
+	return nil
+}
+
+// Hash hashes password with the default scheme.
+func (r *Registry) Hash(password string) (string, error) {
+	h, ok := r.hashers[r.defaultID]
+	if !ok {
+		return "", fmt.Errorf("password: no hasher registered for default scheme %q", r.defaultID)
+	}
+	return h.Hash(password)
+}
+
+// Verify reports whether password matches hash, whatever its scheme.
+func (r *Registry) Verify(hash, password string) (bool, error) {
+	h, ok := r.hashers[Scheme(hash)]
+	if !ok {
+		return false, ErrUnsupportedHash
+	}
+	return h.Verify(hash, password)
+}
+
+// VerifyNothing spends as long as a real verification of the default scheme
+// and always fails. Call it when there is no hash to verify, e.g. for an
+// unknown user, so that response times don't tell that apart.
+func (r *Registry) VerifyNothing(password string) {
+	r.dummyOnce.Do(func() {
+		buf := make([]byte, 16)
+		rand.Read(buf)
+		r.dummyHash, _ = r.Hash(hex.EncodeToString(buf))
+	})
+	r.Verify(r.dummyHash, password)
+}
+
+// NeedsUpgrade reports whether hash should be replaced by a fresh one from
+// Hash: it uses another scheme than the default, or weaker parameters.
+func (r *Registry) NeedsUpgrade(hash string) bool {
+	h, ok := r.hashers[Scheme(hash)]
+	if !ok {
+		return true
+	}
+	return h != r.hashers[r.defaultID] || h.Outdated(hash)
+}
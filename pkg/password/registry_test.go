@@ -0,0 +1,79 @@
+package password_test
+
+import (
+	"testing"
+
+	"loginsvc/pkg/password"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRegistry() *password.Registry {
+	// Minimum costs keep the tests fast; the parameters are what matters.
+	r := password.NewRegistry("2b")
+	r.Register(password.NewBcrypt(5), "2a", "2b", "2y")
+	for _, digest := range []string{"sha1", "sha256", "sha512"} {
+		r.Register(password.NewPBKDF2(digest, 1000), "pbkdf2-"+digest)
+	}
+	r.Register(password.MD5Crypt{}, "1")
+	return r
+}
+
+func TestVerifyLegacySchemes(t *testing.T) {
+	r := newRegistry()
+	for _, hash := range []string{
+		// openssl passwd -1 -salt 3azHgidD password
+		"$1$3azHgidD$SrJPt7B.9rekpmwJwtON31",
+		"$1$saltstri$qQY4WxjABChYG1ccLpfkz/",
+		// bcrypt at cost 4, below the default.
+		"$2a$04$A1/2tuDSMvOQ0q7TF64qvOjUZOVE62be49X8mr2Nudgrmfw0Z5nIm",
+		// PBKDF2-HMAC-SHA256 of "password" with salt "salt".
+		"$pbkdf2-sha256$i=1000$c2FsdA$YywoEuRtRgQQK6dhjp1tfS+BKPYma0oDJk0qBGC33LM",
+	} {
+		ok, err := r.Verify(hash, "password")
+		assert.NoError(t, err, hash)
+		assert.True(t, ok, hash)
+		ok, _ = r.Verify(hash, "Password")
+		assert.False(t, ok, hash)
+		assert.True(t, r.NeedsUpgrade(hash), hash)
+	}
+}
+
+func TestHashRoundTrip(t *testing.T) {
+	r := newRegistry()
+	hash, err := r.Hash("s3cret")
+	assert.NoError(t, err)
+	assert.Equal(t, "bcrypt-5", password.Describe(hash))
+	ok, err := r.Verify(hash, "s3cret")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, r.NeedsUpgrade(hash))
+
+	p := password.NewPBKDF2("sha1", 1000)
+	hash, _ = p.Hash("s3cret")
+	ok, _ = r.Verify(hash, "s3cret")
+	assert.True(t, ok)
+	assert.False(t, p.Outdated(hash))
+	assert.True(t, password.NewPBKDF2("sha1", 2000).Outdated(hash))
+	assert.True(t, password.NewPBKDF2("sha256", 1000).Outdated(hash))
+}
+
+func TestVerifyUnsupported(t *testing.T) {
+	r := newRegistry()
+	_, err := r.Verify("5f4dcc3b5aa765d61d8327deb882cf99", "password")
+	assert.Equal(t, password.ErrUnsupportedHash, err)
+	assert.True(t, r.NeedsUpgrade(""))
+	r.VerifyNothing("password")
+}
+
+func TestSetDefault(t *testing.T) {
+	r := newRegistry()
+	assert.Error(t, r.SetDefault("argon2id"))
+	assert.NoError(t, r.SetDefault("pbkdf2-sha512"))
+	hash, err := r.Hash("s3cret")
+	assert.NoError(t, err)
+	assert.Equal(t, "pbkdf2-sha512", password.Scheme(hash))
+	assert.False(t, r.NeedsUpgrade(hash))
+	bcrypted, _ := password.NewBcrypt(5).Hash("s3cret")
+	assert.True(t, r.NeedsUpgrade(bcrypted))
+}
@@ -0,0 +1,77 @@
+// Package resp speaks just enough of RESP, the Redis serialization protocol,
+// for the Redis clients in loginsvc. See https://redis.io/topics/protocol.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// WriteCommand encodes args as a RESP array of bulk strings.
+func WriteCommand(w io.Writer, args ...string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(bw, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return bw.Flush()
+}
+
+// Error is an error reply sent by the server.
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// ReadReply decodes a single RESP value. Simple and bulk strings decode to
+// string, integers to int64, arrays to []interface{} and nil bulk strings or
+// arrays to nil. Error replies are returned as an Error.
+func ReadReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, Error(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = ReadReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+}
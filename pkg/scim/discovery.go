@@ -0,0 +1,194 @@
+package scim
+
+// ServiceProviderConfig describes the SCIM features the service supports
+// (RFC 7643, section 5).
+type ServiceProviderConfig struct {
+	Schemas               []string      `json:"schemas"`
+	DocumentationURI      string        `json:"documentationUri,omitempty"`
+	Patch                 Supported     `json:"patch"`
+	Bulk                  Bulk          `json:"bulk"`
+	Filter                FilterSupport `json:"filter"`
+	ChangePassword        Supported     `json:"changePassword"`
+	Sort                  Supported     `json:"sort"`
+	ETag                  Supported     `json:"etag"`
+	AuthenticationSchemes []AuthScheme  `json:"authenticationSchemes"`
+	Meta                  *Meta         `json:"meta,omitempty"`
+}
+
+// Supported tells whether a feature is supported.
+type Supported struct {
+	Supported bool `json:"supported"`
+}
+
+// Bulk describes the support of bulk operations.
+type Bulk struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+// FilterSupport describes the support of filters.
+type FilterSupport struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+// AuthScheme is a way to authenticate to the service.
+type AuthScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary,omitempty"`
+}
+
+// NewServiceProviderConfig returns the configuration of a service that
+// supports filters and PATCH, returns at most maxResults resources a page
+// and authenticates clients with bearer tokens.
+func NewServiceProviderConfig(maxResults int) ServiceProviderConfig {
+	return ServiceProviderConfig{
+		Schemas:        []string{ServiceProviderConfigSchema},
+		Patch:          Supported{true},
+		Filter:         FilterSupport{Supported: true, MaxResults: maxResults},
+		ChangePassword: Supported{true},
+		AuthenticationSchemes: []AuthScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "Bearer token",
+			Description: "A token issued to the client, in the Authorization header",
+			Primary:     true,
+		}},
+		Meta: &Meta{ResourceType: "ServiceProviderConfig"},
+	}
+}
+
+// ResourceType describes a type of resource (RFC 7643, section 6).
+type ResourceType struct {
+	Schemas  []string `json:"schemas"`
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Endpoint string   `json:"endpoint"`
+	Schema   string   `json:"schema"`
+	Meta     *Meta    `json:"meta,omitempty"`
+}
+
+// ResourceTypes returns the types of the resources of the service.
+func ResourceTypes() []ResourceType {
+	return []ResourceType{
+		{Schemas: []string{ResourceTypeSchema}, ID: "User", Name: "User", Endpoint: "/Users", Schema: UserSchema, Meta: &Meta{ResourceType: "ResourceType"}},
+		{Schemas: []string{ResourceTypeSchema}, ID: "Group", Name: "Group", Endpoint: "/Groups", Schema: GroupSchema, Meta: &Meta{ResourceType: "ResourceType"}},
+	}
+}
+
+// Schema describes the attributes of a resource (RFC 7643, section 7).
+type Schema struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Attributes  []Attribute `json:"attributes"`
+	Meta        *Meta       `json:"meta,omitempty"`
+}
+
+// Attribute describes an attribute of a Schema.
+type Attribute struct {
+	Name           string      `json:"name"`
+	Type           string      `json:"type"`
+	MultiValued    bool        `json:"multiValued"`
+	Description    string      `json:"description,omitempty"`
+	Required       bool        `json:"required"`
+	CaseExact      bool        `json:"caseExact"`
+	Mutability     string      `json:"mutability"`
+	Returned       string      `json:"returned"`
+	Uniqueness     string      `json:"uniqueness"`
+	ReferenceTypes []string    `json:"referenceTypes,omitempty"`
+	SubAttributes  []Attribute `json:"subAttributes,omitempty"`
+}
+
+// attr returns a single-valued, optional, read-write string attribute.
+func attr(name, description string) Attribute {
+	return Attribute{Name: name, Type: "string", Description: description, Mutability: "readWrite", Returned: "default", Uniqueness: "none"}
+}
+
+// multiValue returns a multi-valued attribute of which only one value is
+// kept.
+func multiValue(name, description string) Attribute {
+	a := attr(name, description)
+	a.Type = "complex"
+	a.MultiValued = true
+	a.SubAttributes = []Attribute{
+		attr("value", "The value"),
+		attr("type", `A label for the value, such as "work"`),
+		{Name: "primary", Type: "boolean", Description: "Whether this is the value kept", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+	}
+	return a
+}
+
+// Schemas returns the schemas of the resources of the service.
+func Schemas() []Schema {
+	id := attr("id", "The identifier of the resource, the same as its name")
+	id.CaseExact = true
+	id.Mutability = "readOnly"
+	id.Returned = "always"
+	id.Uniqueness = "server"
+
+	userName := attr("userName", "The name the user logs in with")
+	userName.Required = true
+	userName.CaseExact = true
+	userName.Mutability = "immutable"
+	userName.Uniqueness = "server"
+	externalID := attr("externalId", "The subject ID of the user, as tokens carry it")
+	externalID.Required = true
+	externalID.CaseExact = true
+	externalID.Mutability = "immutable"
+	active := Attribute{Name: "active", Type: "boolean", Description: "Whether the user may log in", Mutability: "readWrite", Returned: "default", Uniqueness: "none"}
+	password := attr("password", "The password of the user")
+	password.Mutability = "writeOnly"
+	password.Returned = "never"
+
+	displayName := attr("displayName", "The name of the group")
+	displayName.Required = true
+	displayName.CaseExact = true
+	displayName.Mutability = "immutable"
+	displayName.Uniqueness = "server"
+	value := attr("value", "The id of the member")
+	value.CaseExact = true
+	value.Mutability = "immutable"
+	ref := attr("$ref", "The URI of the member")
+	ref.Type = "reference"
+	ref.ReferenceTypes = []string{"User", "Group"}
+	ref.Mutability = "immutable"
+	typ := attr("type", `"User" or "Group"`)
+	typ.Mutability = "immutable"
+	members := Attribute{
+		Name:          "members",
+		Type:          "complex",
+		MultiValued:   true,
+		Description:   "The users and the groups nested in the group",
+		Mutability:    "readWrite",
+		Returned:      "default",
+		Uniqueness:    "none",
+		SubAttributes: []Attribute{value, ref, typ},
+	}
+
+	return []Schema{
+		{
+			Schemas:     []string{SchemaSchema},
+			ID:          UserSchema,
+			Name:        "User",
+			Description: "User Account",
+			Attributes: []Attribute{
+				id, userName, externalID, active, password,
+				multiValue("emails", "The email address of the user"),
+				multiValue("phoneNumbers", "The phone number of the user"),
+			},
+			Meta: &Meta{ResourceType: "Schema"},
+		},
+		{
+			Schemas:     []string{SchemaSchema},
+			ID:          GroupSchema,
+			Name:        "Group",
+			Description: "Group",
+			Attributes:  []Attribute{id, displayName, members},
+			Meta:        &Meta{ResourceType: "Schema"},
+		},
+	}
+}
@@ -0,0 +1,602 @@
+package scim
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter is a parsed filter expression (RFC 7644, section 3.4.2.2). Match
+// reports whether a resource, as a JSON object, matches it.
+type Filter interface {
+	Match(resource map[string]interface{}) bool
+	match(resource map[string]interface{}, parent string) bool
+}
+
+// AttrPath names an attribute, or a sub-attribute of a complex one, of
+// the schema URI, if any, as in
+// "urn:ietf:params:scim:schemas:core:2.0:User:name.givenName".
+type AttrPath struct {
+	URI  string
+	Attr string
+	Sub  string
+}
+
+func (p AttrPath) String() string {
+	s := p.Attr
+	if p.Sub != "" {
+		s += "." + p.Sub
+	}
+	if p.URI != "" {
+		s = p.URI + ":" + s
+	}
+	return s
+}
+
+// And matches the resources both L and R match.
+type And struct{ L, R Filter }
+
+// Or matches the resources L or R match.
+type Or struct{ L, R Filter }
+
+// Not matches the resources F doesn't.
+type Not struct{ F Filter }
+
+// Present matches the resources that have a value for Path.
+type Present struct{ Path AttrPath }
+
+// Compare matches the resources with a value for Path that compares to
+// Value with Op, one of eq, ne, co, sw, ew, gt, ge, lt and le. Value is a
+// string, a float64, a bool or nil.
+type Compare struct {
+	Path  AttrPath
+	Op    string
+	Value interface{}
+}
+
+// ValuePath matches the resources with a value of the multi-valued complex
+// attribute Path that F matches, as in emails[type eq "work"].
+type ValuePath struct {
+	Path AttrPath
+	F    Filter
+}
+
+func (f And) Match(r map[string]interface{}) bool       { return f.match(r, "") }
+func (f Or) Match(r map[string]interface{}) bool        { return f.match(r, "") }
+func (f Not) Match(r map[string]interface{}) bool       { return f.match(r, "") }
+func (f Present) Match(r map[string]interface{}) bool   { return f.match(r, "") }
+func (f Compare) Match(r map[string]interface{}) bool   { return f.match(r, "") }
+func (f ValuePath) Match(r map[string]interface{}) bool { return f.match(r, "") }
+
+func (f And) match(r map[string]interface{}, parent string) bool {
+	return f.L.match(r, parent) && f.R.match(r, parent)
+}
+
+func (f Or) match(r map[string]interface{}, parent string) bool {
+	return f.L.match(r, parent) || f.R.match(r, parent)
+}
+
+func (f Not) match(r map[string]interface{}, parent string) bool {
+	return !f.F.match(r, parent)
+}
+
+func (f Present) match(r map[string]interface{}, _ string) bool {
+	for _, v := range values(r, f.Path) {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			if v != "" {
+				return true
+			}
+		case map[string]interface{}:
+			if len(v) > 0 {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func (f Compare) match(r map[string]interface{}, parent string) bool {
+	vs := values(r, f.Path)
+	if f.Value == nil {
+		// "eq null" matches unassigned attributes, and "ne null" assigned
+		// ones.
+		present := Present{f.Path}.match(r, parent)
+		return present == (f.Op == "ne")
+	}
+	exact := caseExact[strings.ToLower(joinPath(parent, f.Path))]
+	for _, v := range vs {
+		if compare(v, f.Op, f.Value, exact) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f ValuePath) match(r map[string]interface{}, parent string) bool {
+	v, ok := lookup(container(r, f.Path.URI), f.Path.Attr)
+	if !ok {
+		return false
+	}
+	elems, ok := v.([]interface{})
+	if !ok {
+		elems = []interface{}{v}
+	}
+	for _, e := range elems {
+		if m, ok := e.(map[string]interface{}); ok && f.F.match(m, joinPath(parent, f.Path)) {
+			return true
+		}
+	}
+	return false
+}
+
+// caseExact lists the attributes whose string values are compared
+// case-sensitively, as the repository compares names.
+var caseExact = map[string]bool{
+	"id":            true,
+	"externalid":    true,
+	"username":      true,
+	"displayname":   true,
+	"members.value": true,
+}
+
+func joinPath(parent string, p AttrPath) string {
+	s := p.Attr
+	if p.Sub != "" {
+		s += "." + p.Sub
+	}
+	if parent != "" {
+		s = parent + "." + s
+	}
+	return s
+}
+
+// container returns the object holding the attributes of uri: r itself
+// for the core schemas, and the extension object of r otherwise.
+func container(r map[string]interface{}, uri string) map[string]interface{} {
+	if uri == "" || strings.EqualFold(uri, UserSchema) || strings.EqualFold(uri, GroupSchema) {
+		return r
+	}
+	m, _ := lookupMap(r, uri)
+	return m
+}
+
+// lookup returns the value of attr in m, whose names are case-insensitive.
+func lookup(m map[string]interface{}, attr string) (interface{}, bool) {
+	if v, ok := m[attr]; ok {
+		return v, true
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, attr) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func lookupMap(m map[string]interface{}, attr string) (map[string]interface{}, bool) {
+	v, _ := lookup(m, attr)
+	sub, ok := v.(map[string]interface{})
+	return sub, ok
+}
+
+// values returns the values of p in r: those of each value of a
+// multi-valued attribute, and the "value" sub-attribute of complex ones
+// unless p names another.
+func values(r map[string]interface{}, p AttrPath) []interface{} {
+	v, ok := lookup(container(r, p.URI), p.Attr)
+	if !ok {
+		return nil
+	}
+	elems, multi := v.([]interface{})
+	if !multi {
+		elems = []interface{}{v}
+	}
+	var vs []interface{}
+	for _, e := range elems {
+		m, complex := e.(map[string]interface{})
+		switch {
+		case p.Sub != "" && complex:
+			if sub, ok := lookup(m, p.Sub); ok {
+				vs = append(vs, sub)
+			}
+		case p.Sub != "":
+		case complex && multi:
+			if sub, ok := lookup(m, "value"); ok {
+				vs = append(vs, sub)
+			}
+		default:
+			vs = append(vs, e)
+		}
+	}
+	return vs
+}
+
+// compare reports whether v compares to want with op. Values of different
+// types never match; strings that are both times compare as times.
+func compare(v interface{}, op string, want interface{}, exact bool) bool {
+	var c int
+	switch want := want.(type) {
+	case bool:
+		got, ok := v.(bool)
+		if !ok {
+			return false
+		}
+		return (got == want) == (op == "eq")
+	case float64:
+		got, ok := v.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case got < want:
+			c = -1
+		case got > want:
+			c = 1
+		}
+	case string:
+		got, ok := v.(string)
+		if !ok {
+			return false
+		}
+		if c, ok := compareTimes(got, want); ok && op != "co" && op != "sw" && op != "ew" {
+			return cmp(c, op)
+		}
+		if !exact {
+			got, want = strings.ToLower(got), strings.ToLower(want)
+		}
+		switch op {
+		case "co":
+			return strings.Contains(got, want)
+		case "sw":
+			return strings.HasPrefix(got, want)
+		case "ew":
+			return strings.HasSuffix(got, want)
+		}
+		c = strings.Compare(got, want)
+	default:
+		return false
+	}
+	return cmp(c, op)
+}
+
+// cmp reports whether the result c of a comparison satisfies op.
+func cmp(c int, op string) bool {
+	switch op {
+	case "eq":
+		return c == 0
+	case "ne":
+		return c != 0
+	case "gt":
+		return c > 0
+	case "ge":
+		return c >= 0
+	case "lt":
+		return c < 0
+	case "le":
+		return c <= 0
+	}
+	return false
+}
+
+// compareTimes compares a and b as times, if both are.
+func compareTimes(a, b string) (int, bool) {
+	at, err := time.Parse(time.RFC3339Nano, a)
+	if err != nil {
+		return 0, false
+	}
+	bt, err := time.Parse(time.RFC3339Nano, b)
+	if err != nil {
+		return 0, false
+	}
+	switch {
+	case at.Before(bt):
+		return -1, true
+	case at.After(bt):
+		return 1, true
+	}
+	return 0, true
+}
+
+// MaxFilterLength and MaxFilterDepth bound the size of the filters, and of
+// the paths, that ParseFilter and ParsePath accept, and so the work of
+// parsing and matching them. Each parenthesized group, "not" and value
+// filter nests one level deeper.
+const (
+	MaxFilterLength = 4096
+	MaxFilterDepth  = 16
+)
+
+// ParseFilter parses a filter expression. Operators and attribute names
+// are case-insensitive; "not" binds tighter than "and", and "and" than
+// "or". Errors are invalidFilter ones.
+func ParseFilter(s string) (Filter, error) {
+	p, err := newParser(s, TypeInvalidFilter)
+	if err != nil {
+		return nil, err
+	}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", t.text)
+	}
+	return f, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// str is the value of a tokString.
+	str string
+}
+
+type parser struct {
+	tokens   []token
+	pos      int
+	scimType string
+	// depth is the number of filters being parsed that enclose the next
+	// token.
+	depth int
+}
+
+func newParser(s, scimType string) (*parser, error) {
+	p := &parser{scimType: scimType}
+	if len(s) > MaxFilterLength {
+		return nil, p.errorf("longer than %d bytes", MaxFilterLength)
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']':
+			kind := map[byte]tokenKind{'(': tokLParen, ')': tokRParen, '[': tokLBracket, ']': tokRBracket}[c]
+			p.tokens = append(p.tokens, token{kind: kind, text: string(c)})
+			i++
+		case c == '"':
+			// A JSON string, escapes included.
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, p.errorf("unterminated string")
+			}
+			var str string
+			if err := json.Unmarshal([]byte(s[i:j+1]), &str); err != nil {
+				return nil, p.errorf("invalid string %s", s[i:j+1])
+			}
+			p.tokens = append(p.tokens, token{kind: tokString, text: s[i : j+1], str: str})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n\r()[]\"", rune(s[j])) {
+				j++
+			}
+			p.tokens = append(p.tokens, token{kind: tokWord, text: s[i:j]})
+			i = j
+		}
+	}
+	return p, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return NewError(p.scimType, format, args...)
+}
+
+func (p *parser) peek() token {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return token{kind: tokEOF, text: "end of filter"}
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword consumes the word kw, if it is next.
+func (p *parser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == tokWord && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, what string) error {
+	if t := p.next(); t.kind != kind {
+		return p.errorf("expected %s, got %q", what, t.text)
+	}
+	return nil
+}
+
+func (p *parser) or() (Filter, error) {
+	if p.depth++; p.depth > MaxFilterDepth {
+		return nil, p.errorf("nested deeper than %d levels", MaxFilterDepth)
+	}
+	defer func() { p.depth-- }()
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = Or{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) and() (Filter, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = And{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) unary() (Filter, error) {
+	if p.keyword("not") {
+		if err := p.expect(tokLParen, `"("`); err != nil {
+			return nil, err
+		}
+		f, err := p.group()
+		if err != nil {
+			return nil, err
+		}
+		return Not{f}, nil
+	}
+	if p.peek().kind == tokLParen {
+		p.next()
+		return p.group()
+	}
+	t := p.next()
+	if t.kind != tokWord {
+		return nil, p.errorf("expected an attribute, got %q", t.text)
+	}
+	path, err := parseAttrPath(t.text, p.scimType)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind == tokLBracket {
+		p.next()
+		if path.Sub != "" {
+			return nil, p.errorf("%q has a sub-attribute before its filter", t.text)
+		}
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokRBracket, `"]"`); err != nil {
+			return nil, err
+		}
+		return ValuePath{Path: path, F: f}, nil
+	}
+	op := p.next()
+	if op.kind != tokWord {
+		return nil, p.errorf("expected an operator after %q, got %q", t.text, op.text)
+	}
+	switch name := strings.ToLower(op.text); name {
+	case "pr":
+		return Present{path}, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		switch v.(type) {
+		case string:
+		case nil, bool:
+			if name != "eq" && name != "ne" {
+				return nil, p.errorf("%s takes a string or a number", name)
+			}
+		default:
+			if name == "co" || name == "sw" || name == "ew" {
+				return nil, p.errorf("%s takes a string", name)
+			}
+		}
+		return Compare{Path: path, Op: name, Value: v}, nil
+	default:
+		return nil, p.errorf("unknown operator %q", op.text)
+	}
+}
+
+// group parses the rest of a parenthesized filter.
+func (p *parser) group() (Filter, error) {
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokRParen, `")"`); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *parser) value() (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return t.str, nil
+	case tokWord:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		if n, err := strconv.ParseFloat(t.text, 64); err == nil {
+			return n, nil
+		}
+	}
+	return nil, p.errorf("expected a value, got %q", t.text)
+}
+
+// parseAttrPath parses an attribute path, with the schema URI if any.
+func parseAttrPath(s, scimType string) (AttrPath, error) {
+	var p AttrPath
+	if i := strings.LastIndexByte(s, ':'); i >= 0 {
+		p.URI, s = s[:i], s[i+1:]
+	}
+	p.Attr = s
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		p.Attr, p.Sub = s[:i], s[i+1:]
+	}
+	if !validName(p.Attr) || (p.Sub != "" || strings.Contains(s, ".")) && !validName(p.Sub) {
+		return AttrPath{}, NewError(scimType, "invalid attribute %q", s)
+	}
+	return p, nil
+}
+
+// validName reports whether s is an attribute name: a letter or "$", then
+// letters, digits, "_" and "-".
+func validName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '$' && i == 0:
+		case i > 0 && (c >= '0' && c <= '9' || c == '_' || c == '-'):
+		default:
+			return false
+		}
+	}
+	return true
+}
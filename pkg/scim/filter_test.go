@@ -0,0 +1,149 @@
+package scim_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"loginsvc/pkg/scim"
+)
+
+// barbara is the user the examples of RFC 7643 describe, trimmed.
+var barbara = map[string]interface{}{
+	"schemas":    []interface{}{scim.UserSchema},
+	"id":         "bjensen",
+	"externalId": "2819c223-7f76-453a-919d-413861904646",
+	"userName":   "bjensen@example.com",
+	"name":       map[string]interface{}{"familyName": "Jensen", "givenName": "Barbara"},
+	"title":      "Tour Guide",
+	"userType":   "Employee",
+	"active":     true,
+	"emails": []interface{}{
+		map[string]interface{}{"value": "bjensen@example.com", "type": "work", "primary": true},
+		map[string]interface{}{"value": "babs@jensen.org", "type": "home"},
+	},
+	"meta": map[string]interface{}{
+		"resourceType": "User",
+		"created":      "2010-01-23T04:56:22Z",
+		"lastModified": "2011-05-13T04:42:34Z",
+	},
+	"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{
+		"employeeNumber": "701984",
+	},
+}
+
+// TestFilterExamples runs the filters of RFC 7644, section 3.4.2.2, and a
+// few more, against barbara.
+func TestFilterExamples(t *testing.T) {
+	for _, example := range []struct {
+		filter string
+		match  bool
+	}{
+		{`userName Eq "bjensen@example.com"`, true},
+		{`userName eq "BJensen@example.com"`, false}, // caseExact
+		{`name.familyName co "O'Malley"`, false},
+		{`name.familyName co "jens"`, true},
+		{`userName sw "J"`, false},
+		{`userName sw "b"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName sw "bj"`, true},
+		{`title pr`, true},
+		{`nickName pr`, false},
+		{`meta.lastModified gt "2011-05-13T04:42:34Z"`, false},
+		{`meta.lastModified ge "2011-05-13T04:42:34Z"`, true},
+		{`meta.lastModified lt "2011-05-13T04:42:34Z"`, false},
+		{`meta.lastModified le "2011-05-13T04:42:34+00:00"`, true},
+		{`meta.created lt "2011-01-01T00:00:00.000Z"`, true},
+		{`title pr and userType eq "Employee"`, true},
+		{`title pr or userType eq "Intern"`, true},
+		{`schemas eq "urn:ietf:params:scim:schemas:core:2.0:User"`, true},
+		{`userType eq "Employee" and (emails co "example.com" or emails.value co "example.org")`, true},
+		{`userType ne "Employee" and not (emails co "example.com" or emails.value co "example.org")`, false},
+		{`userType eq "Employee" and (emails.type eq "work")`, true},
+		{`userType eq "Employee" and emails[type eq "work" and value co "@example.com"]`, true},
+		{`emails[type eq "work" and value co "@example.org"] or ims[type eq "xmpp" and value co "@foo.com"]`, false},
+		{`emails[type eq "home" and value co "@jensen.org"]`, true},
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`nickName eq null`, true},
+		{`title ne null`, true},
+		{`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:employeeNumber eq "701984"`, true},
+		{`not (userName eq "bjensen@example.com") or title eq "Tour Guide"`, true},
+		{`userName eq "x" or userName eq "y" and title pr`, false},
+		{`title eq "x" and title eq "y" or active eq true`, true},
+	} {
+		f, err := scim.ParseFilter(example.filter)
+		if assert.NoError(t, err, example.filter) {
+			assert.Equal(t, example.match, f.Match(barbara), example.filter)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	f, err := scim.ParseFilter(`userName eq "bjensen" and not (emails[type eq "work"] or title pr)`)
+	assert.NoError(t, err)
+	assert.Equal(t, scim.And{
+		L: scim.Compare{Path: scim.AttrPath{Attr: "userName"}, Op: "eq", Value: "bjensen"},
+		R: scim.Not{F: scim.Or{
+			L: scim.ValuePath{Path: scim.AttrPath{Attr: "emails"}, F: scim.Compare{Path: scim.AttrPath{Attr: "type"}, Op: "eq", Value: "work"}},
+			R: scim.Present{Path: scim.AttrPath{Attr: "title"}},
+		}},
+	}, f)
+
+	f, err = scim.ParseFilter(`urn:ietf:params:scim:schemas:core:2.0:User:name.givenName EQ "a \"b\"" OR meta.version GE 2.5`)
+	assert.NoError(t, err)
+	assert.Equal(t, scim.Or{
+		L: scim.Compare{Path: scim.AttrPath{URI: scim.UserSchema, Attr: "name", Sub: "givenName"}, Op: "eq", Value: `a "b"`},
+		R: scim.Compare{Path: scim.AttrPath{Attr: "meta", Sub: "version"}, Op: "ge", Value: 2.5},
+	}, f)
+}
+
+func TestParseFilterInvalid(t *testing.T) {
+	for _, filter := range []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName eq bjensen`,
+		`userName like "b"`,
+		`userName eq "bjensen`,
+		`userName eq "b" and`,
+		`(userName eq "b"`,
+		`userName eq "b")`,
+		`emails[type eq "work"`,
+		`emails.value[type eq "work"]`,
+		`not userName eq "b"`,
+		`active gt true`,
+		`title co 2`,
+		`name. eq "b"`,
+		`1name eq "b"`,
+		`userName eq "b" title pr`,
+		`emails[type eq "work"].value ew ".com"`, // a sub-attribute after a filter is for PATCH paths only
+	} {
+		_, err := scim.ParseFilter(filter)
+		var e *scim.Error
+		if assert.True(t, errors.As(err, &e), filter) {
+			assert.Equal(t, scim.TypeInvalidFilter, e.ScimType, filter)
+			assert.Equal(t, 400, e.Status)
+		}
+	}
+}
+
+func TestParseFilterLimits(t *testing.T) {
+	nested := func(depth int) string {
+		return strings.Repeat("(", depth-1) + `userName eq "b"` + strings.Repeat(")", depth-1)
+	}
+	_, err := scim.ParseFilter(nested(scim.MaxFilterDepth))
+	assert.NoError(t, err)
+	long := `userName eq "` + strings.Repeat("b", scim.MaxFilterLength-len(`userName eq ""`)) + `"`
+	_, err = scim.ParseFilter(long)
+	assert.NoError(t, err)
+
+	for _, filter := range []string{nested(scim.MaxFilterDepth + 1), long + " "} {
+		_, err := scim.ParseFilter(filter)
+		var e *scim.Error
+		if assert.True(t, errors.As(err, &e)) {
+			assert.Equal(t, scim.TypeInvalidFilter, e.ScimType)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package scim
+
+import (
+	"reflect"
+	"strings"
+)
+
+// PatchRequest is the body of a PATCH request (RFC 7644, section 3.5.2).
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is an operation of a PatchRequest. Op is "add", "remove"
+// or "replace", in any case; Value is decoded as by encoding/json.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Validate returns an invalidSyntax error if r isn't a PatchOp message or
+// has no operations.
+func (r PatchRequest) Validate() error {
+	found := false
+	for _, s := range r.Schemas {
+		found = found || s == PatchOpSchema
+	}
+	if !found {
+		return NewError(TypeInvalidSyntax, "schemas must contain %s", PatchOpSchema)
+	}
+	if len(r.Operations) == 0 {
+		return NewError(TypeInvalidSyntax, "no operations")
+	}
+	return nil
+}
+
+// Path is the target of a PatchOperation: an attribute, or the values of
+// a multi-valued one that Filter matches, or the sub-attribute Sub of
+// these, as in emails[type eq "work"].value.
+type Path struct {
+	Attr   AttrPath
+	Filter Filter
+	Sub    string
+}
+
+// ParsePath parses the path of a PatchOperation. Errors are invalidPath
+// ones.
+func ParsePath(s string) (Path, error) {
+	open := strings.IndexByte(s, '[')
+	if open < 0 {
+		attr, err := parseAttrPath(s, TypeInvalidPath)
+		return Path{Attr: attr}, err
+	}
+	end := strings.LastIndexByte(s, ']')
+	if end < open {
+		return Path{}, NewError(TypeInvalidPath, "unterminated filter in %q", s)
+	}
+	attr, err := parseAttrPath(s[:open], TypeInvalidPath)
+	if err != nil {
+		return Path{}, err
+	}
+	if attr.Sub != "" {
+		return Path{}, NewError(TypeInvalidPath, "%q has a sub-attribute before its filter", s)
+	}
+	p, err := newParser(s[open+1:end], TypeInvalidPath)
+	if err != nil {
+		return Path{}, err
+	}
+	f, err := p.or()
+	if err != nil {
+		return Path{}, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return Path{}, p.errorf("unexpected %q in %q", t.text, s)
+	}
+	path := Path{Attr: attr, Filter: f}
+	if rest := s[end+1:]; rest != "" {
+		if !strings.HasPrefix(rest, ".") || !validName(rest[1:]) {
+			return Path{}, NewError(TypeInvalidPath, "invalid sub-attribute in %q", s)
+		}
+		path.Sub = rest[1:]
+	}
+	return path, nil
+}
+
+// Apply applies ops, in order, to the resource r, a JSON object. Filters
+// in paths that match no value fail with noTarget, but for add and replace
+// operations whose filter only sets an attribute, such as
+// emails[type eq "work"].value, which add the value the filter describes.
+// Removing an attribute that has no value does nothing.
+func Apply(r map[string]interface{}, ops []PatchOperation) error {
+	for _, op := range ops {
+		if err := apply(r, op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func apply(r map[string]interface{}, op PatchOperation) error {
+	name := strings.ToLower(op.Op)
+	switch name {
+	case "add", "replace", "remove":
+	default:
+		return NewError(TypeInvalidSyntax, "unknown operation %q", op.Op)
+	}
+	if op.Path != "" {
+		p, err := ParsePath(op.Path)
+		if err != nil {
+			return err
+		}
+		return applyPath(r, name, p, op.Value)
+	}
+	if name == "remove" {
+		return NewError(TypeNoTarget, "remove operations need a path")
+	}
+	attrs, ok := op.Value.(map[string]interface{})
+	if !ok {
+		return NewError(TypeInvalidValue, "%s operations without a path need an object", name)
+	}
+	for k, v := range attrs {
+		// The attributes of an extension come as an object under its URN.
+		if ext, ok := v.(map[string]interface{}); ok && isExtension(k) {
+			for attr, v := range ext {
+				p, err := ParsePath(k + ":" + attr)
+				if err != nil {
+					return err
+				}
+				if err := applyPath(r, name, p, v); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		p, err := ParsePath(k)
+		if err != nil {
+			return err
+		}
+		if err := applyPath(r, name, p, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isExtension reports whether the key k of an object is the URN of a
+// schema extension, rather than the path of a core attribute.
+func isExtension(k string) bool {
+	i := strings.LastIndexByte(k, ':')
+	if i < 0 {
+		return false
+	}
+	uri := k[:i]
+	return !strings.EqualFold(uri, UserSchema) && !strings.EqualFold(uri, GroupSchema)
+}
+
+func applyPath(r map[string]interface{}, op string, p Path, v interface{}) error {
+	c := container(r, p.Attr.URI)
+	if c == nil {
+		if op == "remove" {
+			return nil
+		}
+		c = map[string]interface{}{}
+		r[p.Attr.URI] = c
+	}
+	key := keyOf(c, p.Attr.Attr)
+	if op != "remove" && v == nil {
+		return NewError(TypeInvalidValue, "%s operations need a value", op)
+	}
+	switch {
+	case p.Filter != nil:
+		return applyFilter(c, key, op, p, v)
+	case p.Attr.Sub != "":
+		parent, ok := c[key]
+		if !ok {
+			if op == "remove" {
+				return nil
+			}
+			parent = map[string]interface{}{}
+			c[key] = parent
+		}
+		elems, ok := parent.([]interface{})
+		if !ok {
+			elems = []interface{}{parent}
+		}
+		for _, e := range elems {
+			m, ok := e.(map[string]interface{})
+			if !ok {
+				return NewError(TypeInvalidPath, "%s has no sub-attributes", p.Attr.Attr)
+			}
+			set(m, op, p.Attr.Sub, v)
+		}
+	default:
+		set(c, op, key, v)
+	}
+	return nil
+}
+
+// applyFilter applies op to the values of c[key] that the filter of p
+// matches.
+func applyFilter(c map[string]interface{}, key, op string, p Path, v interface{}) error {
+	elems, _ := c[key].([]interface{})
+	var kept []interface{}
+	matched := false
+	for _, e := range elems {
+		m, ok := e.(map[string]interface{})
+		if !ok || !p.Filter.match(m, p.Attr.Attr) {
+			kept = append(kept, e)
+			continue
+		}
+		matched = true
+		switch {
+		case op == "remove" && p.Sub == "":
+			continue
+		case p.Sub != "":
+			set(m, op, keyOf(m, p.Sub), v)
+		default:
+			values, ok := v.(map[string]interface{})
+			if !ok {
+				return NewError(TypeInvalidValue, "%s needs an object", p.Attr.Attr)
+			}
+			merge(m, values)
+		}
+		kept = append(kept, m)
+	}
+	if !matched {
+		if op == "remove" {
+			return nil
+		}
+		cmp, ok := p.Filter.(Compare)
+		s, isString := cmp.Value.(string)
+		if !ok || cmp.Op != "eq" || !isString || cmp.Path.URI != "" || cmp.Path.Sub != "" {
+			return NewError(TypeNoTarget, "no value of %s matches the filter", p.Attr.Attr)
+		}
+		e := map[string]interface{}{cmp.Path.Attr: s}
+		if p.Sub != "" {
+			e[p.Sub] = v
+		} else if values, ok := v.(map[string]interface{}); ok {
+			merge(e, values)
+		} else {
+			return NewError(TypeInvalidValue, "%s needs an object", p.Attr.Attr)
+		}
+		kept = append(kept, e)
+	}
+	if len(kept) == 0 {
+		delete(c, key)
+	} else {
+		c[key] = kept
+	}
+	return nil
+}
+
+// set applies op to the attribute key of m: add appends to multi-valued
+// attributes the values they don't have, add and replace merge objects
+// into complex ones and otherwise set them, and remove removes the values
+// v lists from multi-valued attributes, or else the attribute.
+func set(m map[string]interface{}, op, key string, v interface{}) {
+	old, exists := m[key]
+	oldValues, multi := old.([]interface{})
+	switch op {
+	case "remove":
+		if !multi || v == nil {
+			delete(m, key)
+			return
+		}
+		var kept []interface{}
+		for _, o := range oldValues {
+			if !contains(asList(v), o) {
+				kept = append(kept, o)
+			}
+		}
+		if len(kept) == 0 {
+			delete(m, key)
+		} else {
+			m[key] = kept
+		}
+	case "add":
+		if multi {
+			for _, n := range asList(v) {
+				if !contains(oldValues, n) {
+					oldValues = append(oldValues, n)
+				}
+			}
+			m[key] = oldValues
+			return
+		}
+		fallthrough
+	default:
+		oldMap, isMap := old.(map[string]interface{})
+		newMap, newIsMap := v.(map[string]interface{})
+		if exists && isMap && newIsMap {
+			merge(oldMap, newMap)
+			return
+		}
+		m[key] = v
+	}
+}
+
+// merge sets the attributes of values in m.
+func merge(m, values map[string]interface{}) {
+	for k, v := range values {
+		m[keyOf(m, k)] = v
+	}
+}
+
+// keyOf returns the key of m that names attr, in any case, or attr.
+func keyOf(m map[string]interface{}, attr string) string {
+	if _, ok := m[attr]; ok {
+		return attr
+	}
+	for k := range m {
+		if strings.EqualFold(k, attr) {
+			return k
+		}
+	}
+	return attr
+}
+
+func asList(v interface{}) []interface{} {
+	if l, ok := v.([]interface{}); ok {
+		return l
+	}
+	return []interface{}{v}
+}
+
+// contains reports whether values has v. Complex values with a "value"
+// sub-attribute are the same if their values are.
+func contains(values []interface{}, v interface{}) bool {
+	for _, o := range values {
+		if sameValue(o, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameValue(a, b interface{}) bool {
+	am, aok := a.(map[string]interface{})
+	bm, bok := b.(map[string]interface{})
+	if aok && bok {
+		av, aok := lookup(am, "value")
+		bv, bok := lookup(bm, "value")
+		if aok && bok {
+			return reflect.DeepEqual(av, bv)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// PatchUser returns u with ops applied. Boolean attributes may be sent as
+// the strings "True" and "False", as some identity providers do.
+func PatchUser(u User, ops []PatchOperation) (User, error) {
+	m := u.Map()
+	if err := Apply(m, ops); err != nil {
+		return User{}, err
+	}
+	key := keyOf(m, "active")
+	if s, ok := m[key].(string); ok {
+		switch strings.ToLower(s) {
+		case "true":
+			m[key] = true
+		case "false":
+			m[key] = false
+		}
+	}
+	var patched User
+	if err := fromMap(m, &patched); err != nil {
+		return User{}, err
+	}
+	return patched, nil
+}
+
+// PatchGroup returns g with ops applied.
+func PatchGroup(g Group, ops []PatchOperation) (Group, error) {
+	m := g.Map()
+	if err := Apply(m, ops); err != nil {
+		return Group{}, err
+	}
+	var patched Group
+	if err := fromMap(m, &patched); err != nil {
+		return Group{}, err
+	}
+	return patched, nil
+}
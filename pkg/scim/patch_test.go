@@ -0,0 +1,207 @@
+package scim_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"loginsvc/pkg/scim"
+)
+
+// patch applies the operations of the PatchOp message body to the
+// resource JSON object.
+func patch(t *testing.T, resource, body string) (map[string]interface{}, error) {
+	var r map[string]interface{}
+	if err := json.Unmarshal([]byte(resource), &r); err != nil {
+		t.Fatal(err)
+	}
+	var req scim.PatchRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	return r, scim.Apply(r, req.Operations)
+}
+
+func jsonObject(t *testing.T, s string) map[string]interface{} {
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+const tourGuides = `{"displayName": "Tour Guides", "members": [{"value": "bjensen", "type": "User"}, {"value": "jsmith", "type": "User"}]}`
+
+const babs = `{"userName": "bjensen", "title": "Tour Guide", "name": {"givenName": "Barbara", "familyName": "Jensen"},
+	"emails": [{"value": "bjensen@example.com", "type": "work", "primary": true}, {"value": "babs@jensen.org", "type": "home"}]}`
+
+// TestPatchExamples applies the operations of RFC 7644, section 3.5.2, and
+// those identity providers are known to send.
+func TestPatchExamples(t *testing.T) {
+	for _, example := range []struct {
+		name, resource, ops, want string
+	}{
+		{
+			"add members",
+			tourGuides,
+			`[{"op": "add", "path": "members", "value": [{"value": "adoe", "type": "User"}, {"value": "jsmith", "type": "User"}]}]`,
+			`{"displayName": "Tour Guides", "members": [{"value": "bjensen", "type": "User"}, {"value": "jsmith", "type": "User"}, {"value": "adoe", "type": "User"}]}`,
+		},
+		{
+			"add without a path",
+			babs,
+			`[{"op": "add", "value": {"nickName": "Babs", "name": {"middleName": "J"}, "emails": [{"value": "babs@example.org", "type": "other"}]}}]`,
+			`{"userName": "bjensen", "title": "Tour Guide", "nickName": "Babs", "name": {"givenName": "Barbara", "familyName": "Jensen", "middleName": "J"},
+				"emails": [{"value": "bjensen@example.com", "type": "work", "primary": true}, {"value": "babs@jensen.org", "type": "home"}, {"value": "babs@example.org", "type": "other"}]}`,
+		},
+		{
+			"remove a member",
+			tourGuides,
+			`[{"op": "remove", "path": "members[value eq \"bjensen\"]"}]`,
+			`{"displayName": "Tour Guides", "members": [{"value": "jsmith", "type": "User"}]}`,
+		},
+		{
+			"remove all members",
+			tourGuides,
+			`[{"op": "remove", "path": "members"}]`,
+			`{"displayName": "Tour Guides"}`,
+		},
+		{
+			"remove members by value",
+			tourGuides,
+			`[{"op": "remove", "path": "members", "value": [{"value": "bjensen"}, {"value": "nobody"}]}]`,
+			`{"displayName": "Tour Guides", "members": [{"value": "jsmith", "type": "User"}]}`,
+		},
+		{
+			"remove the last member by filter",
+			`{"displayName": "Tour Guides", "members": [{"value": "bjensen"}]}`,
+			`[{"op": "remove", "path": "members[value eq \"bjensen\"]"}]`,
+			`{"displayName": "Tour Guides"}`,
+		},
+		{
+			"replace all members",
+			tourGuides,
+			`[{"op": "remove", "path": "members"}, {"op": "add", "path": "members", "value": [{"value": "adoe"}]}]`,
+			`{"displayName": "Tour Guides", "members": [{"value": "adoe"}]}`,
+		},
+		{
+			"replace members",
+			tourGuides,
+			`[{"op": "replace", "path": "members", "value": [{"value": "adoe"}]}]`,
+			`{"displayName": "Tour Guides", "members": [{"value": "adoe"}]}`,
+		},
+		{
+			"replace a sub-attribute of a filtered value",
+			babs,
+			`[{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "barbara@example.com"}]`,
+			`{"userName": "bjensen", "title": "Tour Guide", "name": {"givenName": "Barbara", "familyName": "Jensen"},
+				"emails": [{"value": "barbara@example.com", "type": "work", "primary": true}, {"value": "babs@jensen.org", "type": "home"}]}`,
+		},
+		{
+			"replace a filtered value",
+			babs,
+			`[{"op": "replace", "path": "emails[type eq \"home\"]", "value": {"value": "babs@example.org"}}]`,
+			`{"userName": "bjensen", "title": "Tour Guide", "name": {"givenName": "Barbara", "familyName": "Jensen"},
+				"emails": [{"value": "bjensen@example.com", "type": "work", "primary": true}, {"value": "babs@example.org", "type": "home"}]}`,
+		},
+		{
+			"replace without a path",
+			babs,
+			`[{"op": "replace", "value": {"title": "Guide", "name": {"givenName": "Babs"}}}]`,
+			`{"userName": "bjensen", "title": "Guide", "name": {"givenName": "Babs", "familyName": "Jensen"},
+				"emails": [{"value": "bjensen@example.com", "type": "work", "primary": true}, {"value": "babs@jensen.org", "type": "home"}]}`,
+		},
+		{
+			"replace a sub-attribute",
+			babs,
+			`[{"op": "Replace", "path": "name.familyName", "value": "Doe"}, {"op": "Remove", "path": "title"}]`,
+			`{"userName": "bjensen", "name": {"givenName": "Barbara", "familyName": "Doe"},
+				"emails": [{"value": "bjensen@example.com", "type": "work", "primary": true}, {"value": "babs@jensen.org", "type": "home"}]}`,
+		},
+		{
+			"add a value through a filter",
+			`{"userName": "bjensen"}`,
+			`[{"op": "Add", "path": "phoneNumbers[type eq \"work\"].value", "value": "555-555-5555"}]`,
+			`{"userName": "bjensen", "phoneNumbers": [{"type": "work", "value": "555-555-5555"}]}`,
+		},
+		{
+			"attribute names are case-insensitive",
+			babs,
+			`[{"op": "replace", "path": "urn:ietf:params:scim:schemas:core:2.0:User:TITLE", "value": "Guide"}, {"op": "remove", "path": "Emails[Type eq \"home\"]"}]`,
+			`{"userName": "bjensen", "title": "Guide", "name": {"givenName": "Barbara", "familyName": "Jensen"},
+				"emails": [{"value": "bjensen@example.com", "type": "work", "primary": true}]}`,
+		},
+		{
+			"extensions",
+			`{"userName": "bjensen"}`,
+			`[{"op": "add", "value": {"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {"employeeNumber": "701984"}}},
+				{"op": "add", "path": "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department", "value": "Tours"}]`,
+			`{"userName": "bjensen", "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {"employeeNumber": "701984", "department": "Tours"}}`,
+		},
+		{
+			"removing what isn't there does nothing",
+			`{"userName": "bjensen"}`,
+			`[{"op": "remove", "path": "title"}, {"op": "remove", "path": "emails[type eq \"work\"]"}, {"op": "remove", "path": "name.givenName"}]`,
+			`{"userName": "bjensen"}`,
+		},
+	} {
+		r, err := patch(t, example.resource, `{"schemas": ["`+scim.PatchOpSchema+`"], "Operations": `+example.ops+`}`)
+		if assert.NoError(t, err, example.name) {
+			assert.Equal(t, jsonObject(t, example.want), r, example.name)
+		}
+	}
+}
+
+func TestPatchInvalid(t *testing.T) {
+	for _, example := range []struct {
+		body, scimType string
+	}{
+		{`{"Operations": [{"op": "add", "path": "title", "value": "Guide"}]}`, scim.TypeInvalidSyntax},
+		{`{"schemas": ["` + scim.PatchOpSchema + `"]}`, scim.TypeInvalidSyntax},
+		{`{"schemas": ["` + scim.PatchOpSchema + `"], "Operations": [{"op": "move", "path": "title"}]}`, scim.TypeInvalidSyntax},
+		{`{"schemas": ["` + scim.PatchOpSchema + `"], "Operations": [{"op": "remove"}]}`, scim.TypeNoTarget},
+		{`{"schemas": ["` + scim.PatchOpSchema + `"], "Operations": [{"op": "add", "value": "Guide"}]}`, scim.TypeInvalidValue},
+		{`{"schemas": ["` + scim.PatchOpSchema + `"], "Operations": [{"op": "add", "path": "title"}]}`, scim.TypeInvalidValue},
+		{`{"schemas": ["` + scim.PatchOpSchema + `"], "Operations": [{"op": "add", "path": "emails[type eq \"work\"", "value": "x"}]}`, scim.TypeInvalidPath},
+		{`{"schemas": ["` + scim.PatchOpSchema + `"], "Operations": [{"op": "add", "path": "emails[type eq]", "value": "x"}]}`, scim.TypeInvalidPath},
+		{`{"schemas": ["` + scim.PatchOpSchema + `"], "Operations": [{"op": "add", "path": "emails[type eq \"work\"].", "value": "x"}]}`, scim.TypeInvalidPath},
+		{`{"schemas": ["` + scim.PatchOpSchema + `"], "Operations": [{"op": "add", "path": "title!", "value": "x"}]}`, scim.TypeInvalidPath},
+		{`{"schemas": ["` + scim.PatchOpSchema + `"], "Operations": [{"op": "replace", "path": "emails[type sw \"h\"].value", "value": "x"}]}`, scim.TypeNoTarget},
+		{`{"schemas": ["` + scim.PatchOpSchema + `"], "Operations": [{"op": "replace", "path": "userName.first", "value": "x"}]}`, scim.TypeInvalidPath},
+	} {
+		_, err := patch(t, `{"userName": "bjensen", "emails": [{"value": "bjensen@example.com", "type": "work"}]}`, example.body)
+		var e *scim.Error
+		if assert.True(t, errors.As(err, &e), example.body) {
+			assert.Equal(t, example.scimType, e.ScimType, example.body)
+		}
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	active := true
+	u := scim.User{Schemas: []string{scim.UserSchema}, ID: "bjensen", UserName: "bjensen", Active: &active}
+	u, err := scim.PatchUser(u, []scim.PatchOperation{
+		{Op: "Replace", Path: "active", Value: "False"},
+		{Op: "Add", Path: `emails[type eq "work"].value`, Value: "bjensen@example.com"},
+	})
+	assert.NoError(t, err)
+	if assert.NotNil(t, u.Active) {
+		assert.False(t, *u.Active)
+	}
+	assert.Equal(t, "bjensen@example.com", scim.Primary(u.Emails))
+
+	_, err = scim.PatchUser(u, []scim.PatchOperation{{Op: "replace", Path: "active", Value: "maybe"}})
+	var e *scim.Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, scim.TypeInvalidValue, e.ScimType)
+	}
+
+	g, err := scim.PatchGroup(scim.Group{DisplayName: "Tour Guides"}, []scim.PatchOperation{{Op: "add", Path: "members", Value: []interface{}{map[string]interface{}{"value": "bjensen"}}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []scim.Member{{Value: "bjensen"}}, g.Members)
+}
@@ -0,0 +1,195 @@
+// Package scim implements the protocol side of SCIM 2.0 (RFC 7643 and RFC
+// 7644), through which identity providers and HR systems provision users
+// and groups: the resources as sent on the wire, filter expressions, PATCH
+// operations, error responses and the discovery resources.
+//
+// Resources are identified by name: the id of a User is its userName and
+// that of a Group its displayName, and neither can change. The externalId
+// of a User is its subject ID, the one Authenticate returns.
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// The URNs of the schemas and messages of SCIM 2.0.
+const (
+	UserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ResourceTypeSchema          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	ListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// ContentType is the media type of SCIM requests and responses.
+const ContentType = "application/scim+json"
+
+// Meta holds the metadata of a resource. Location is set by the transport,
+// which knows the URL the resource is served at.
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	Location     string     `json:"location,omitempty"`
+}
+
+// User is a SCIM User. Only the attributes the users table stores are
+// kept: of several emails or phone numbers, only the primary one, or else
+// the first.
+type User struct {
+	Schemas    []string `json:"schemas"`
+	ID         string   `json:"id,omitempty"`
+	ExternalID string   `json:"externalId,omitempty"`
+	UserName   string   `json:"userName"`
+	// Active is nil if the request doesn't set it, which leaves the user
+	// as it is, or active when created.
+	Active *bool `json:"active,omitempty"`
+	// Password is write-only: it is never returned.
+	Password     string       `json:"password,omitempty"`
+	Emails       []MultiValue `json:"emails,omitempty"`
+	PhoneNumbers []MultiValue `json:"phoneNumbers,omitempty"`
+	Meta         *Meta        `json:"meta,omitempty"`
+}
+
+// MultiValue is a value of a multi-valued attribute such as emails.
+type MultiValue struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Primary returns the primary value of values, or else the first, or ""
+// if there are none.
+func Primary(values []MultiValue) string {
+	for _, v := range values {
+		if v.Primary {
+			return v.Value
+		}
+	}
+	if len(values) > 0 {
+		return values[0].Value
+	}
+	return ""
+}
+
+// Group is a SCIM Group.
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// Member is a member of a Group: a user, or a group nested in it.
+type Member struct {
+	// Value is the id of the member.
+	Value string `json:"value"`
+	Ref   string `json:"$ref,omitempty"`
+	// Type is "User" or "Group". Requests may leave it out, and a member
+	// is then the group of that id if there is one.
+	Type string `json:"type,omitempty"`
+}
+
+// The types of Member.
+const (
+	MemberUser  = "User"
+	MemberGroup = "Group"
+)
+
+// Query selects and pages the resources of a list request.
+type Query struct {
+	// Filter is a filter expression, as parsed by ParseFilter; empty
+	// selects every resource.
+	Filter string
+	// StartIndex is the 1-based index of the first resource of the page.
+	StartIndex int
+	// Count is the size of the page, or nil for the default.
+	Count *int
+}
+
+// ListResponse is a page of resources.
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// NewListResponse returns the page of resources starting at startIndex,
+// out of total.
+func NewListResponse(resources []interface{}, total, startIndex int) ListResponse {
+	if resources == nil {
+		resources = []interface{}{}
+	}
+	return ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+// The scimType of the errors of requests that are invalid for SCIM.
+const (
+	TypeInvalidFilter = "invalidFilter"
+	TypeTooMany       = "tooMany"
+	TypeUniqueness    = "uniqueness"
+	TypeMutability    = "mutability"
+	TypeInvalidSyntax = "invalidSyntax"
+	TypeInvalidPath   = "invalidPath"
+	TypeNoTarget      = "noTarget"
+	TypeInvalidValue  = "invalidValue"
+)
+
+// Error is a SCIM error response. The errors of this package are 400 Bad
+// Request ones, with a ScimType.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   int      `json:"status,string"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+func (e *Error) Error() string { return "scim: " + e.Detail }
+
+// NewError returns a 400 Bad Request error of scimType.
+func NewError(scimType, format string, args ...interface{}) *Error {
+	return &Error{Schemas: []string{ErrorSchema}, Status: 400, ScimType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+// toMap returns v as a JSON object.
+func toMap(v interface{}) map[string]interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		// The resources of this package always marshal.
+		panic(err)
+	}
+	var m map[string]interface{}
+	json.Unmarshal(b, &m)
+	return m
+}
+
+// fromMap decodes the JSON object m into v.
+func fromMap(m map[string]interface{}, v interface{}) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return NewError(TypeInvalidValue, "%v", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return NewError(TypeInvalidValue, "%v", err)
+	}
+	return nil
+}
+
+// Map returns u as a JSON object, for filters and PATCH operations.
+func (u User) Map() map[string]interface{} { return toMap(u) }
+
+// Map returns g as a JSON object, for filters and PATCH operations.
+func (g Group) Map() map[string]interface{} { return toMap(g) }
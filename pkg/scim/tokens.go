@@ -0,0 +1,40 @@
+package scim
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+// Tokens maps the names of SCIM clients to the hex-encoded SHA-256 digest
+// of their bearer token, so that the configuration holds no tokens.
+type Tokens map[string]string
+
+// Authenticate returns the name of the client token belongs to.
+func (t Tokens) Authenticate(token string) (name string, ok bool) {
+	if token == "" {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(token))
+	digest := []byte(hex.EncodeToString(sum[:]))
+	for n, want := range t {
+		if subtle.ConstantTimeCompare(digest, []byte(want)) == 1 {
+			name, ok = n, true
+		}
+	}
+	return name, ok
+}
+
+type tokenKey struct{}
+
+// WithToken returns a copy of ctx carrying the bearer token of a request.
+func WithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+// TokenFromContext returns the bearer token ctx carries, or "".
+func TokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(tokenKey{}).(string)
+	return token
+}
@@ -0,0 +1,166 @@
+// Package shutdown stops servers gracefully. Shutdown first fails
+// readiness, so that load balancers stop sending traffic, then lets the
+// servers finish the requests in flight within a timeout.
+package shutdown
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"google.golang.org/grpc"
+)
+
+// Drainer shuts down the servers it runs together. Add its Run and Stop to a
+// group.Group before the servers and anything they depend on: the group
+// interrupts actors in the order they were added, and Stop returns only
+// once the servers drained.
+type Drainer struct {
+	delay   time.Duration
+	timeout time.Duration
+	logger  log.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mtx     sync.Mutex
+	drains  []func(ctx context.Context) error
+	drained chan struct{}
+	once    sync.Once
+}
+
+// NewDrainer returns a Drainer that keeps serving for delay once shutdown
+// begins, then gives requests in flight up to timeout to complete before it
+// closes their connections.
+func NewDrainer(delay, timeout time.Duration, logger log.Logger) *Drainer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Drainer{
+		delay:   delay,
+		timeout: timeout,
+		logger:  logger,
+		ctx:     ctx,
+		cancel:  cancel,
+		drained: make(chan struct{}),
+	}
+}
+
+// Context returns a context that is done as soon as shutdown begins, before
+// the servers drain.
+func (d *Drainer) Context() context.Context { return d.ctx }
+
+// ShuttingDown reports whether shutdown has begun. Readiness checks should
+// fail once it has.
+func (d *Drainer) ShuttingDown() bool { return d.ctx.Err() != nil }
+
+// Run blocks until the servers drained.
+func (d *Drainer) Run() error {
+	<-d.drained
+	return nil
+}
+
+// Stop begins shutdown and drains the servers concurrently. It returns once
+// they all stopped.
+func (d *Drainer) Stop(error) {
+	d.once.Do(func() {
+		d.cancel()
+		d.logger.Log("event", "shutdown", "delay", d.delay, "timeout", d.timeout)
+		time.Sleep(d.delay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+		defer cancel()
+		d.mtx.Lock()
+		drains := d.drains
+		d.mtx.Unlock()
+		var wg sync.WaitGroup
+		for _, drain := range drains {
+			wg.Add(1)
+			go func(drain func(context.Context) error) {
+				defer wg.Done()
+				if err := drain(ctx); err != nil {
+					d.logger.Log("during", "drain", "err", err)
+				}
+			}(drain)
+		}
+		wg.Wait()
+		close(d.drained)
+	})
+}
+
+func (d *Drainer) add(drain func(ctx context.Context) error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.drains = append(d.drains, drain)
+}
+
+// HTTP returns a group actor serving srv on l. Stop drains it with
+// srv.Shutdown and closes the connections still active at the timeout.
+func (d *Drainer) HTTP(srv *http.Server, l net.Listener) (execute func() error, interrupt func(error)) {
+	d.add(func(ctx context.Context) error {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+			return err
+		}
+		return nil
+	})
+	return func() error {
+		if err := srv.Serve(l); err != http.ErrServerClosed {
+			return err
+		}
+		<-d.drained
+		return nil
+	}, d.Stop
+}
+
+// GRPC returns a group actor serving srv on l. Stop drains it with
+// srv.GracefulStop and stops it outright at the timeout.
+func (d *Drainer) GRPC(srv *grpc.Server, l net.Listener) (execute func() error, interrupt func(error)) {
+	d.add(func(ctx context.Context) error {
+		stopped := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+			return nil
+		case <-ctx.Done():
+			srv.Stop()
+			return ctx.Err()
+		}
+	})
+	return func() error {
+		if err := srv.Serve(l); err != nil {
+			return err
+		}
+		<-d.drained
+		return nil
+	}, d.Stop
+}
+
+// StreamInterceptor returns a gRPC stream interceptor whose streams are
+// done as soon as shutdown begins. Long-lived streams would otherwise hold
+// up GracefulStop until the timeout; their clients resume them elsewhere.
+func (d *Drainer) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel := context.WithCancel(ss.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-d.ctx.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		return handler(srv, drainingStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type drainingStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s drainingStream) Context() context.Context { return s.ctx }
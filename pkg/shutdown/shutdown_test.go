@@ -0,0 +1,205 @@
+package shutdown_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/oklog/pkg/group"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+
+	pb "loginsvc/pb"
+	"loginsvc/pkg/shutdown"
+)
+
+// slowLogin answers Name once released.
+type slowLogin struct {
+	pb.UnimplementedLoginServer
+	started, release chan struct{}
+}
+
+func (s slowLogin) Name(_ context.Context, req *pb.NameRequest) (*pb.NameReply, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return &pb.NameReply{V: "a123456789"}, nil
+}
+
+// server runs a drainer, an HTTP and a gRPC server, and an actor standing
+// in for the signal handler, in a group like cmd/loginsvc does.
+type server struct {
+	drainer          *shutdown.Drainer
+	httpAddr         string
+	grpcAddr         string
+	started, release chan struct{}
+	signal           chan struct{}
+	done             chan error
+}
+
+func newServer(t *testing.T, timeout time.Duration) *server {
+	s := &server{
+		drainer: shutdown.NewDrainer(0, timeout, log.NewNopLogger()),
+		started: make(chan struct{}, 2),
+		release: make(chan struct{}),
+		signal:  make(chan struct{}),
+		done:    make(chan error, 1),
+	}
+	var g group.Group
+	g.Add(s.drainer.Run, s.drainer.Stop)
+
+	httpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.httpAddr = httpListener.Addr().String()
+	g.Add(s.drainer.HTTP(&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.started <- struct{}{}
+		<-s.release
+		w.Write([]byte("done"))
+	})}, httpListener))
+
+	grpcListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.grpcAddr = grpcListener.Addr().String()
+	grpcServer := grpc.NewServer()
+	pb.RegisterLoginServer(grpcServer, slowLogin{started: s.started, release: s.release})
+	g.Add(s.drainer.GRPC(grpcServer, grpcListener))
+
+	g.Add(func() error {
+		<-s.signal
+		return errors.New("received signal terminated")
+	}, func(error) {})
+	go func() { s.done <- g.Run() }()
+	return s
+}
+
+// inFlight starts an HTTP request and a gRPC call and waits until the
+// servers are handling both. It returns their outcomes.
+func (s *server) inFlight(t *testing.T) (httpResult, grpcResult chan error) {
+	httpResult, grpcResult = make(chan error, 1), make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + s.httpAddr)
+		if err == nil {
+			var body []byte
+			body, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err == nil && string(body) != "done" {
+				err = errors.New("unexpected body " + string(body))
+			}
+		}
+		httpResult <- err
+	}()
+	conn, err := grpc.Dial(s.grpcAddr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		_, err := pb.NewLoginClient(conn).Name(context.Background(), &pb.NameRequest{N: "ed"})
+		grpcResult <- err
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-s.started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("requests not started")
+		}
+	}
+	return httpResult, grpcResult
+}
+
+func TestInFlightRequestsComplete(t *testing.T) {
+	s := newServer(t, 5*time.Second)
+	httpResult, grpcResult := s.inFlight(t)
+
+	assert.False(t, s.drainer.ShuttingDown())
+	close(s.signal)
+	assert.Eventually(t, s.drainer.ShuttingDown, time.Second, time.Millisecond)
+	assert.Error(t, s.drainer.Context().Err())
+
+	// The listeners close at once, while the requests are still in flight.
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", s.httpAddr)
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, time.Second, time.Millisecond)
+	select {
+	case <-s.done:
+		t.Fatal("stopped before the requests completed")
+	default:
+	}
+
+	close(s.release)
+	assert.NoError(t, <-httpResult)
+	assert.NoError(t, <-grpcResult)
+	select {
+	case err := <-s.done:
+		assert.EqualError(t, err, "received signal terminated")
+	case <-time.After(5 * time.Second):
+		t.Fatal("not stopped")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	s := newServer(t, 100*time.Millisecond)
+	defer close(s.release)
+	httpResult, grpcResult := s.inFlight(t)
+
+	begin := time.Now()
+	close(s.signal)
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not stopped")
+	}
+	assert.Less(t, int64(time.Since(begin)), int64(time.Second))
+	assert.Error(t, <-httpResult)
+	assert.Error(t, <-grpcResult)
+}
+
+// idleEvents holds streams open until their context is done.
+type idleEvents struct {
+	pb.UnimplementedIdentityEventsServer
+	started chan struct{}
+}
+
+func (s idleEvents) WatchEvents(_ *pb.WatchEventsRequest, stream pb.IdentityEvents_WatchEventsServer) error {
+	close(s.started)
+	<-stream.Context().Done()
+	return nil
+}
+
+func TestStreamsEndOnShutdown(t *testing.T) {
+	d := shutdown.NewDrainer(0, time.Second, log.NewNopLogger())
+	srv := grpc.NewServer(grpc.StreamInterceptor(d.StreamInterceptor()))
+	started := make(chan struct{})
+	pb.RegisterIdentityEventsServer(srv, idleEvents{started: started})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	defer srv.Stop()
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stream, err := pb.NewIdentityEventsClient(conn).WatchEvents(context.Background(), &pb.WatchEventsRequest{})
+	assert.NoError(t, err)
+	<-started
+	d.Stop(nil)
+	_, err = stream.Recv()
+	assert.Equal(t, io.EOF, err)
+}
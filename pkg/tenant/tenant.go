@@ -0,0 +1,139 @@
+// Package tenant resolves the tenant, one of the business units sharing
+// loginsvc, that a request is for. The transports record what a request
+// says about its tenant; the endpoints resolve it once, failing closed,
+// and everything after them is scoped to that tenant.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+)
+
+// Default is the tenant of the data stored before tenants were introduced,
+// and of every request when none are configured.
+const Default = "default"
+
+// Header is the HTTP header, and lower cased the gRPC metadata key, in
+// which callers may name their tenant.
+const Header = "X-Tenant-ID"
+
+// PathPrefix starts HTTP paths that name their tenant, as in
+// /tenants/acme/authenticate.
+const PathPrefix = "/tenants/"
+
+var (
+	// ErrMissing is returned for a request that names no tenant when there
+	// is no default one.
+	ErrMissing = errors.New("tenant: no tenant named")
+	// ErrUnknown is returned for a request naming a tenant that isn't
+	// configured.
+	ErrUnknown = errors.New("tenant: unknown tenant")
+	// ErrConflict is returned for a request naming different tenants in
+	// different places.
+	ErrConflict = errors.New("tenant: conflicting tenants named")
+)
+
+// Claims are what a request says about its tenant. Empty fields say
+// nothing.
+type Claims struct {
+	// Host is the host the request was sent to, without port.
+	Host string
+	// Header is the value of the Header header or metadata.
+	Header string
+	// Path is the tenant named by a PathPrefix path.
+	Path string
+	// Message is the tenant named in the request message itself, as
+	// WatchEvents requests may.
+	Message string
+}
+
+// Resolver decides which tenant the claims of a request name.
+type Resolver struct {
+	// Tenants are the known tenants. Others are rejected.
+	Tenants []string
+	// Hosts maps host names to the tenant they serve. Hosts missing from
+	// it name no tenant.
+	Hosts map[string]string
+	// Fallback is the tenant of requests naming none; empty rejects them.
+	Fallback string
+}
+
+// Resolve returns the tenant named by c. Every tenant named must be the
+// same, and known.
+func (r Resolver) Resolve(c Claims) (string, error) {
+	var named []string
+	if t, ok := r.Hosts[strings.ToLower(c.Host)]; ok {
+		named = append(named, t)
+	}
+	for _, t := range []string{c.Header, c.Path, c.Message} {
+		if t != "" {
+			named = append(named, t)
+		}
+	}
+	if len(named) == 0 {
+		if r.Fallback == "" {
+			return "", ErrMissing
+		}
+		named = append(named, r.Fallback)
+	}
+	for _, t := range named[1:] {
+		if t != named[0] {
+			return "", ErrConflict
+		}
+	}
+	for _, t := range r.Tenants {
+		if t == named[0] {
+			return t, nil
+		}
+	}
+	return "", ErrUnknown
+}
+
+// SplitPath splits a PathPrefix path into the tenant it names and the
+// rest of the path. Other paths name no tenant.
+func SplitPath(path string) (tenant, rest string) {
+	if !strings.HasPrefix(path, PathPrefix) {
+		return "", path
+	}
+	tenant = strings.TrimPrefix(path, PathPrefix)
+	if i := strings.IndexByte(tenant, '/'); i >= 0 {
+		return tenant[:i], tenant[i:]
+	}
+	return tenant, "/"
+}
+
+// StripPort returns the host of a host[:port] authority.
+func StripPort(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return hostport
+}
+
+type claimsKey struct{}
+
+type idKey struct{}
+
+// WithClaims returns a context for a request making claims c.
+func WithClaims(ctx context.Context, c Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, c)
+}
+
+// ClaimsFromContext returns the claims stored by WithClaims.
+func ClaimsFromContext(ctx context.Context) Claims {
+	c, _ := ctx.Value(claimsKey{}).(Claims)
+	return c
+}
+
+// NewContext returns a context for a request resolved to tenant id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// FromContext returns the tenant stored by NewContext, if any.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(idKey{}).(string)
+	return id, ok && id != ""
+}
@@ -0,0 +1,62 @@
+package tenant_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"loginsvc/pkg/tenant"
+)
+
+func TestResolve(t *testing.T) {
+	r := tenant.Resolver{
+		Tenants: []string{"acme", "globex"},
+		Hosts:   map[string]string{"login.acme.example": "acme"},
+	}
+	for _, tc := range []struct {
+		claims tenant.Claims
+		want   string
+		err    error
+	}{
+		{tenant.Claims{Host: "login.acme.example"}, "acme", nil},
+		{tenant.Claims{Host: "LOGIN.ACME.EXAMPLE"}, "acme", nil},
+		{tenant.Claims{Header: "globex"}, "globex", nil},
+		{tenant.Claims{Path: "globex"}, "globex", nil},
+		{tenant.Claims{Host: "login.acme.example", Header: "acme", Path: "acme"}, "acme", nil},
+		{tenant.Claims{Host: "login.acme.example", Header: "globex"}, "", tenant.ErrConflict},
+		{tenant.Claims{Header: "acme", Path: "globex"}, "", tenant.ErrConflict},
+		{tenant.Claims{Message: "globex"}, "globex", nil},
+		{tenant.Claims{Host: "login.acme.example", Message: "globex"}, "", tenant.ErrConflict},
+		{tenant.Claims{Header: "initech"}, "", tenant.ErrUnknown},
+		{tenant.Claims{Host: "login.initech.example"}, "", tenant.ErrMissing},
+		{tenant.Claims{}, "", tenant.ErrMissing},
+	} {
+		got, err := r.Resolve(tc.claims)
+		assert.Equal(t, tc.err, err, "%+v", tc.claims)
+		assert.Equal(t, tc.want, got, "%+v", tc.claims)
+	}
+
+	// A fallback is used only for requests naming no tenant, and must be
+	// known too.
+	r.Fallback = "acme"
+	got, err := r.Resolve(tenant.Claims{})
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", got)
+	_, err = r.Resolve(tenant.Claims{Header: "initech"})
+	assert.Equal(t, tenant.ErrUnknown, err)
+	r.Fallback = "initech"
+	_, err = r.Resolve(tenant.Claims{})
+	assert.Equal(t, tenant.ErrUnknown, err)
+}
+
+func TestSplitPath(t *testing.T) {
+	for path, want := range map[string][2]string{
+		"/tenants/acme/authenticate": {"acme", "/authenticate"},
+		"/tenants/acme":              {"acme", "/"},
+		"/authenticate":              {"", "/authenticate"},
+		"/tenantsacme/name":          {"", "/tenantsacme/name"},
+	} {
+		tenant, rest := tenant.SplitPath(path)
+		assert.Equal(t, want, [2]string{tenant, rest}, path)
+	}
+}
@@ -0,0 +1,258 @@
+// Package tlsconfig builds the TLS configuration of servers and clients from
+// PEM files, which are read again when they change on disk, so certificates
+// can be rotated without a restart.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Options name the files and settings of a TLS configuration.
+type Options struct {
+	// CertFile and KeyFile hold the certificate chain and private key
+	// presented to peers. Servers need them; clients only for mutual TLS.
+	CertFile, KeyFile string
+
+	// CAFile holds the CA bundle peers are verified against. For servers it
+	// turns on mutual TLS: clients must present a certificate it signed. For
+	// clients it replaces the system roots.
+	CAFile string
+
+	// MinVersion is the oldest TLS version accepted, TLS 1.2 by default.
+	MinVersion uint16
+
+	// CipherSuites restricts the TLS 1.2 cipher suites; TLS 1.3 suites
+	// aren't configurable. Nil leaves Go's defaults.
+	CipherSuites []uint16
+
+	// ServerName overrides the name clients verify the server certificate
+	// against, which by default is the host dialed.
+	ServerName string
+}
+
+// Reloader holds the certificate and CA bundle named by Options, and reads
+// them again at the next handshake after any of the files changes. If the
+// new files can't be loaded, for instance while only the certificate of a
+// pair has been replaced, the previous ones stay in use.
+type Reloader struct {
+	opts   Options
+	logger log.Logger
+
+	mu     sync.Mutex
+	cert   *tls.Certificate
+	pool   *x509.CertPool
+	stamps map[string]stamp
+}
+
+// stamp identifies a version of a file.
+type stamp struct {
+	mod  time.Time
+	size int64
+}
+
+// New returns a Reloader for opts, failing if the files can't be loaded.
+func New(opts Options, logger log.Logger) (*Reloader, error) {
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.New("tlsconfig: certificate and key files go together")
+	}
+	if opts.MinVersion == 0 {
+		opts.MinVersion = tls.VersionTLS12
+	}
+	r := &Reloader{
+		opts:   opts,
+		logger: log.With(logger, "component", "tlsconfig"),
+	}
+	stamps := r.stat()
+	if err := r.load(stamps); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// MutualTLS reports whether servers configured by r require client
+// certificates.
+func (r *Reloader) MutualTLS() bool { return r.opts.CAFile != "" }
+
+// ServerConfig returns the configuration of a server offering protos by
+// ALPN, such as "h2" and "http/1.1" for HTTP or "h2" for gRPC. It needs a
+// certificate.
+func (r *Reloader) ServerConfig(protos ...string) *tls.Config {
+	base := &tls.Config{
+		MinVersion:   r.opts.MinVersion,
+		CipherSuites: r.opts.CipherSuites,
+		NextProtos:   protos,
+	}
+	if r.MutualTLS() {
+		base.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return &tls.Config{
+		MinVersion: r.opts.MinVersion,
+		NextProtos: protos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			if cert == nil {
+				return nil, errors.New("tlsconfig: no server certificate")
+			}
+			c := base.Clone()
+			c.Certificates = []tls.Certificate{*cert}
+			c.ClientCAs = pool
+			return c, nil
+		},
+	}
+}
+
+// ClientConfig returns the configuration of a client. Its certificate, if
+// any, is reloaded like a server's; the CA bundle is read once, since the
+// roots are fixed when the configuration is built.
+func (r *Reloader) ClientConfig() *tls.Config {
+	_, pool := r.current()
+	c := &tls.Config{
+		MinVersion:   r.opts.MinVersion,
+		CipherSuites: r.opts.CipherSuites,
+		RootCAs:      pool,
+		ServerName:   r.opts.ServerName,
+	}
+	if r.opts.CertFile != "" {
+		c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		}
+	}
+	return c
+}
+
+// current returns the certificate and CA bundle, reloading them first if
+// the files changed since they were loaded.
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	stamps := r.stat()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !equal(stamps, r.stamps) {
+		if err := r.loadLocked(stamps); err != nil {
+			r.logger.Log("during", "reload", "err", err)
+		} else {
+			r.logger.Log("reloaded", strings.Join(r.files(), ","))
+		}
+	}
+	return r.cert, r.pool
+}
+
+func (r *Reloader) load(stamps map[string]stamp) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadLocked(stamps)
+}
+
+// loadLocked reads the files, and records stamps even if they can't be
+// loaded, so that a bad version is only reported once.
+func (r *Reloader) loadLocked(stamps map[string]stamp) error {
+	r.stamps = stamps
+	var cert *tls.Certificate
+	if r.opts.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+		if err != nil {
+			return fmt.Errorf("tlsconfig: %v", err)
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if r.opts.CAFile != "" {
+		b, err := ioutil.ReadFile(r.opts.CAFile)
+		if err != nil {
+			return fmt.Errorf("tlsconfig: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("tlsconfig: no certificates in %s", r.opts.CAFile)
+		}
+	}
+	r.cert, r.pool = cert, pool
+	return nil
+}
+
+func (r *Reloader) files() []string {
+	var files []string
+	for _, f := range []string{r.opts.CertFile, r.opts.KeyFile, r.opts.CAFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// stat stamps the files, following symlinks, as swapped by mounted secrets.
+// Files that can't be stated get a zero stamp, and the error surfaces when
+// they are loaded.
+func (r *Reloader) stat() map[string]stamp {
+	stamps := make(map[string]stamp)
+	for _, f := range r.files() {
+		if fi, err := os.Stat(f); err == nil {
+			stamps[f] = stamp{fi.ModTime(), fi.Size()}
+		} else {
+			stamps[f] = stamp{}
+		}
+	}
+	return stamps
+}
+
+func equal(a, b map[string]stamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for f, s := range a {
+		if t, ok := b[f]; !ok || !s.mod.Equal(t.mod) || s.size != t.size {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseVersion parses a TLS version given as "1.0" to "1.3". The empty
+// string stands for the default, TLS 1.2.
+func ParseVersion(s string) (uint16, error) {
+	switch s {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("tlsconfig: unknown TLS version %q", s)
+}
+
+// ParseCipherSuites parses a comma separated list of cipher suite names, as
+// in TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Suites with known security
+// issues are refused. The empty string stands for Go's defaults.
+func ParseCipherSuites(s string) ([]uint16, error) {
+	if s == "" {
+		return nil, nil
+	}
+	ids := make(map[string]uint16)
+	for _, c := range tls.CipherSuites() {
+		ids[c.Name] = c.ID
+	}
+	var suites []uint16
+	for _, name := range strings.Split(s, ",") {
+		id, ok := ids[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("tlsconfig: unknown or insecure cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
@@ -0,0 +1,240 @@
+package tlsconfig_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"loginsvc/internal/testca"
+	"loginsvc/pkg/tlsconfig"
+)
+
+// issue writes a certificate from ca for name, valid for clients and for
+// the server localhost, and its key to dir, and returns their paths.
+func issue(t *testing.T, ca *testca.Authority, dir, name string, serial int64) (certFile, keyFile string) {
+	cert := ca.Issue(t, name, serial)
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	assert.NoError(t, err)
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	write(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}))
+	write(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certFile, keyFile
+}
+
+// write writes a file and moves its modification time forward, so that a
+// rewrite within the timestamp resolution is still seen as a change.
+func write(t *testing.T, path string, b []byte) {
+	old := time.Now()
+	if fi, err := os.Stat(path); err == nil {
+		old = fi.ModTime()
+	}
+	assert.NoError(t, ioutil.WriteFile(path, b, 0600))
+	next := old.Add(time.Second)
+	assert.NoError(t, os.Chtimes(path, next, next))
+}
+
+// handshake connects a client to a server over loopback and returns the
+// server certificate serial seen by the client and the client certificate
+// name seen by the server.
+func handshake(server, client *tls.Config) (serial int64, clientName string, err error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, "", err
+	}
+	defer l.Close()
+	type result struct {
+		state tls.ConnectionState
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		s, err := l.Accept()
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer s.Close()
+		conn := tls.Server(s, server)
+		err = conn.Handshake()
+		if err == nil {
+			// Under TLS 1.3 the client learns that its certificate was
+			// refused on its first read.
+			_, err = conn.Write([]byte{0})
+		}
+		done <- result{conn.ConnectionState(), err}
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		return 0, "", err
+	}
+	defer c.Close()
+	conn := tls.Client(c, client)
+	if err := conn.Handshake(); err != nil {
+		<-done
+		return 0, "", err
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		<-done
+		return 0, "", err
+	}
+	r := <-done
+	if r.err != nil {
+		return 0, "", r.err
+	}
+	if len(r.state.PeerCertificates) > 0 {
+		clientName = r.state.PeerCertificates[0].Subject.CommonName
+	}
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), clientName, nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := testca.New(t, "ca")
+	caFile := filepath.Join(dir, "ca.PEM")
+	write(t, caFile, ca.PEM)
+	serverCert, serverKey := issue(t, ca, dir, "server", 10)
+	clientCert, clientKey := issue(t, ca, dir, "client", 20)
+
+	server, err := tlsconfig.New(tlsconfig.Options{CertFile: serverCert, KeyFile: serverKey, CAFile: caFile}, log.NewNopLogger())
+	assert.NoError(t, err)
+	assert.True(t, server.MutualTLS())
+	client, err := tlsconfig.New(tlsconfig.Options{CertFile: clientCert, KeyFile: clientKey, CAFile: caFile, ServerName: "localhost"}, log.NewNopLogger())
+	assert.NoError(t, err)
+
+	serial, name, err := handshake(server.ServerConfig(), client.ClientConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), serial)
+	assert.Equal(t, "client", name)
+
+	// Without a certificate, or with one from another CA, clients are
+	// refused.
+	anonymous, err := tlsconfig.New(tlsconfig.Options{CAFile: caFile, ServerName: "localhost"}, log.NewNopLogger())
+	assert.NoError(t, err)
+	_, _, err = handshake(server.ServerConfig(), anonymous.ClientConfig())
+	assert.Error(t, err)
+
+	otherCert, otherKey := issue(t, testca.New(t, "other"), t.TempDir(), "client", 30)
+	other, err := tlsconfig.New(tlsconfig.Options{CertFile: otherCert, KeyFile: otherKey, CAFile: caFile, ServerName: "localhost"}, log.NewNopLogger())
+	assert.NoError(t, err)
+	_, _, err = handshake(server.ServerConfig(), other.ClientConfig())
+	assert.Error(t, err)
+
+	// Without mutual TLS the anonymous client gets in.
+	plain, err := tlsconfig.New(tlsconfig.Options{CertFile: serverCert, KeyFile: serverKey}, log.NewNopLogger())
+	assert.NoError(t, err)
+	assert.False(t, plain.MutualTLS())
+	_, name, err = handshake(plain.ServerConfig(), anonymous.ClientConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, "", name)
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := testca.New(t, "ca")
+	caFile := filepath.Join(dir, "ca.PEM")
+	write(t, caFile, ca.PEM)
+	serverCert, serverKey := issue(t, ca, dir, "server", 10)
+
+	server, err := tlsconfig.New(tlsconfig.Options{CertFile: serverCert, KeyFile: serverKey}, log.NewNopLogger())
+	assert.NoError(t, err)
+	client, err := tlsconfig.New(tlsconfig.Options{CAFile: caFile, ServerName: "localhost"}, log.NewNopLogger())
+	assert.NoError(t, err)
+	config := server.ServerConfig()
+
+	serial, _, err := handshake(config, client.ClientConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), serial)
+
+	// A rotated pair is picked up by the next handshake.
+	issue(t, ca, dir, "server", 11)
+	serial, _, err = handshake(config, client.ClientConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), serial)
+
+	// A broken pair leaves the previous one in use.
+	write(t, serverKey, []byte("garbage"))
+	serial, _, err = handshake(config, client.ClientConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), serial)
+}
+
+func TestReloadClientCA(t *testing.T) {
+	dir := t.TempDir()
+	ca, next := testca.New(t, "ca"), testca.New(t, "next")
+	caFile := filepath.Join(dir, "ca.PEM")
+	write(t, caFile, ca.PEM)
+	serverCert, serverKey := issue(t, ca, dir, "server", 10)
+	clientCert, clientKey := issue(t, next, t.TempDir(), "client", 20)
+
+	server, err := tlsconfig.New(tlsconfig.Options{CertFile: serverCert, KeyFile: serverKey, CAFile: caFile}, log.NewNopLogger())
+	assert.NoError(t, err)
+	client, err := tlsconfig.New(tlsconfig.Options{CertFile: clientCert, KeyFile: clientKey, CAFile: caFile, ServerName: "localhost"}, log.NewNopLogger())
+	assert.NoError(t, err)
+	config := server.ServerConfig()
+
+	_, _, err = handshake(config, client.ClientConfig())
+	assert.Error(t, err)
+
+	write(t, caFile, append(append([]byte{}, ca.PEM...), next.PEM...))
+	_, name, err := handshake(config, client.ClientConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, "client", name)
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	_, err := tlsconfig.New(tlsconfig.Options{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: filepath.Join(dir, "missing.key")}, log.NewNopLogger())
+	assert.Error(t, err)
+	_, err = tlsconfig.New(tlsconfig.Options{CertFile: filepath.Join(dir, "server.crt")}, log.NewNopLogger())
+	assert.Error(t, err)
+	caFile := filepath.Join(dir, "ca.PEM")
+	write(t, caFile, []byte("not a certificate"))
+	_, err = tlsconfig.New(tlsconfig.Options{CAFile: caFile}, log.NewNopLogger())
+	assert.Error(t, err)
+}
+
+func TestMinVersion(t *testing.T) {
+	dir := t.TempDir()
+	ca := testca.New(t, "ca")
+	caFile := filepath.Join(dir, "ca.PEM")
+	write(t, caFile, ca.PEM)
+	serverCert, serverKey := issue(t, ca, dir, "server", 10)
+
+	server, err := tlsconfig.New(tlsconfig.Options{CertFile: serverCert, KeyFile: serverKey, MinVersion: tls.VersionTLS13}, log.NewNopLogger())
+	assert.NoError(t, err)
+	client, err := tlsconfig.New(tlsconfig.Options{CAFile: caFile, ServerName: "localhost"}, log.NewNopLogger())
+	assert.NoError(t, err)
+	config := client.ClientConfig()
+	config.MaxVersion = tls.VersionTLS12
+	_, _, err = handshake(server.ServerConfig(), config)
+	assert.Error(t, err)
+}
+
+func TestParse(t *testing.T) {
+	for s, want := range map[string]uint16{"": 0, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13} {
+		v, err := tlsconfig.ParseVersion(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, want, v, s)
+	}
+	_, err := tlsconfig.ParseVersion("1.4")
+	assert.Error(t, err)
+
+	suites, err := tlsconfig.ParseCipherSuites("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, suites)
+	suites, err = tlsconfig.ParseCipherSuites("")
+	assert.NoError(t, err)
+	assert.Nil(t, suites)
+	_, err = tlsconfig.ParseCipherSuites("TLS_RSA_WITH_RC4_128_SHA")
+	assert.Error(t, err)
+}
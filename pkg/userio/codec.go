@@ -0,0 +1,224 @@
+// Package userio streams users in and out of a repository as CSV or JSON
+// Lines, for migrations from and to other systems.
+package userio
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Format is a supported file format.
+type Format string
+
+const (
+	CSV   Format = "csv"
+	JSONL Format = "jsonl"
+)
+
+// ParseFormat returns the format named s.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case CSV, JSONL:
+		return f, nil
+	}
+	return "", fmt.Errorf("userio: unknown format %q", s)
+}
+
+// Record is the exchange representation of a user. CSV files carry the
+// same fields as columns, named by a header row.
+type Record struct {
+	Name         string `json:"name"`
+	SID          string `json:"sid"`
+	Email        string `json:"email,omitempty"`
+	Phone        string `json:"phone,omitempty"`
+	TOTPSecret   string `json:"totp_secret,omitempty"`
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+var columns = []string{"name", "sid", "email", "phone", "totp_secret", "password_hash"}
+
+func (r *Record) fields() []*string {
+	return []*string{&r.Name, &r.SID, &r.Email, &r.Phone, &r.TOTPSecret, &r.PasswordHash}
+}
+
+// Decoder reads records one at a time. Decode returns the record and its
+// line number, or io.EOF at the end of the input. A *RowError concerns a
+// single line and decoding may continue after it; any other error is fatal.
+type Decoder interface {
+	Decode() (Record, int, error)
+}
+
+// RowError is a problem with a single input row.
+type RowError struct {
+	Line int
+	Name string
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d (%s): %v", e.Line, e.Name, e.Err)
+}
+
+// Encoder writes records one at a time. Flush must be called at the end.
+type Encoder interface {
+	Encode(Record) error
+	Flush() error
+}
+
+// NewDecoder returns a Decoder reading f from r. For CSV the header is read
+// immediately; it may list the columns in any order and omit optional ones.
+func NewDecoder(r io.Reader, f Format) (Decoder, error) {
+	switch f {
+	case CSV:
+		return newCSVDecoder(r)
+	case JSONL:
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 64*1024), 1024*1024)
+		return &jsonlDecoder{s: s}, nil
+	}
+	return nil, fmt.Errorf("userio: unknown format %q", f)
+}
+
+// NewEncoder returns an Encoder writing f to w.
+func NewEncoder(w io.Writer, f Format) (Encoder, error) {
+	switch f {
+	case CSV:
+		return &csvEncoder{w: csv.NewWriter(w)}, nil
+	case JSONL:
+		bw := bufio.NewWriter(w)
+		return &jsonlEncoder{w: bw, enc: json.NewEncoder(bw)}, nil
+	}
+	return nil, fmt.Errorf("userio: unknown format %q", f)
+}
+
+type csvDecoder struct {
+	r       *csv.Reader
+	indexes []int // column of each field, or -1
+	width   int
+}
+
+func newCSVDecoder(r io.Reader) (*csvDecoder, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("userio: reading header: %v", err)
+	}
+	d := &csvDecoder{r: cr, indexes: make([]int, len(columns)), width: len(header)}
+	for i := range d.indexes {
+		d.indexes[i] = -1
+	}
+	for i, name := range header {
+		found := false
+		for j, column := range columns {
+			if strings.TrimSpace(strings.ToLower(name)) == column {
+				d.indexes[j], found = i, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("userio: unknown column %q", name)
+		}
+	}
+	if d.indexes[0] < 0 || d.indexes[1] < 0 {
+		return nil, fmt.Errorf("userio: header must have name and sid columns")
+	}
+	return d, nil
+}
+
+func (d *csvDecoder) Decode() (Record, int, error) {
+	row, err := d.r.Read()
+	if pe, ok := err.(*csv.ParseError); ok {
+		return Record{}, pe.StartLine, &RowError{Line: pe.StartLine, Err: pe.Err}
+	}
+	if err != nil {
+		return Record{}, 0, err
+	}
+	line, _ := d.r.FieldPos(0)
+	if len(row) != d.width {
+		return Record{}, line, &RowError{Line: line, Err: fmt.Errorf("expected %d fields, got %d", d.width, len(row))}
+	}
+	var rec Record
+	for i, field := range rec.fields() {
+		if d.indexes[i] >= 0 {
+			*field = row[d.indexes[i]]
+		}
+	}
+	return rec, line, nil
+}
+
+type jsonlDecoder struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func (d *jsonlDecoder) Decode() (Record, int, error) {
+	for d.s.Scan() {
+		d.line++
+		text := strings.TrimSpace(d.s.Text())
+		if text == "" {
+			continue
+		}
+		var rec Record
+		dec := json.NewDecoder(strings.NewReader(text))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rec); err != nil {
+			return Record{}, d.line, &RowError{Line: d.line, Err: err}
+		}
+		return rec, d.line, nil
+	}
+	if err := d.s.Err(); err != nil {
+		return Record{}, d.line + 1, err
+	}
+	return Record{}, d.line, io.EOF
+}
+
+type csvEncoder struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (e *csvEncoder) Encode(rec Record) error {
+	if !e.wroteHeader {
+		if err := e.w.Write(columns); err != nil {
+			return err
+		}
+		e.wroteHeader = true
+	}
+	row := make([]string, len(columns))
+	for i, field := range rec.fields() {
+		row[i] = *field
+	}
+	return e.w.Write(row)
+}
+
+func (e *csvEncoder) Flush() error {
+	if !e.wroteHeader {
+		if err := e.w.Write(columns); err != nil {
+			return err
+		}
+		e.wroteHeader = true
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type jsonlEncoder struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (e *jsonlEncoder) Encode(rec Record) error {
+	return e.enc.Encode(rec)
+}
+
+func (e *jsonlEncoder) Flush() error {
+	return e.w.Flush()
+}
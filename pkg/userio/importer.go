@@ -0,0 +1,203 @@
+package userio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"loginsvc/pkg/password"
+	"loginsvc/repo"
+)
+
+// DefaultBatchSize is the number of users written per transaction.
+const DefaultBatchSize = 500
+
+var (
+	errConflict    = errors.New("user already exists")
+	errSIDConflict = errors.New("sid belongs to another user")
+	errDuplicate   = errors.New("duplicate in input")
+)
+
+// ImportOptions configure Import.
+type ImportOptions struct {
+	// BatchSize is the number of users written per transaction.
+	BatchSize int
+
+	// Policy decides what happens to users that already exist. Under
+	// ConflictFail the import stops at the first one; earlier batches stay
+	// committed.
+	Policy repo.ConflictPolicy
+
+	// DryRun validates the input and checks it against the repository
+	// without writing anything. Every problem is reported, including each
+	// conflict under ConflictFail.
+	DryRun bool
+}
+
+// Report summarizes an import. In a dry run the counts are predictions.
+type Report struct {
+	Created int
+	Updated int
+	Skipped int
+	Invalid int
+}
+
+// Import reads users from dec and writes them to r in batches. Rows that
+// fail validation, conflicting rows in a dry run and rows whose sid belongs
+// to another user are passed to onError and not imported. Password hashes are normalized with
+// password.Normalize, so users keep their legacy hash until they log in.
+func Import(r repo.BulkRepository, dec Decoder, opts ImportOptions, onError func(*RowError)) (Report, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	imp := importer{
+		repo:    r,
+		opts:    opts,
+		onError: onError,
+		names:   map[string]bool{},
+		sids:    map[string]bool{},
+	}
+	for {
+		rec, line, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			imp.reject(rowErr)
+			continue
+		}
+		if err != nil {
+			return imp.report, err
+		}
+
+		u, err := imp.validate(rec)
+		if err != nil {
+			imp.reject(&RowError{Line: line, Name: rec.Name, Err: err})
+			continue
+		}
+		imp.batch = append(imp.batch, u)
+		imp.lines = append(imp.lines, line)
+		if len(imp.batch) == opts.BatchSize {
+			if err := imp.flush(); err != nil {
+				return imp.report, err
+			}
+		}
+	}
+	return imp.report, imp.flush()
+}
+
+type importer struct {
+	repo    repo.BulkRepository
+	opts    ImportOptions
+	onError func(*RowError)
+	report  Report
+
+	names map[string]bool
+	sids  map[string]bool
+
+	batch []repo.User
+	lines []int
+}
+
+func (imp *importer) reject(err *RowError) {
+	imp.report.Invalid++
+	imp.onError(err)
+}
+
+func (imp *importer) validate(rec Record) (repo.User, error) {
+	u := repo.User{
+		Name:       strings.TrimSpace(rec.Name),
+		SID:        strings.TrimSpace(rec.SID),
+		Email:      strings.TrimSpace(rec.Email),
+		Phone:      strings.TrimSpace(rec.Phone),
+		TOTPSecret: strings.TrimSpace(rec.TOTPSecret),
+	}
+	switch {
+	case u.Name == "" || len(u.Name) > 50:
+		return repo.User{}, errors.New("name must be 1 to 50 characters")
+	case u.SID == "" || len(u.SID) > 50:
+		return repo.User{}, errors.New("sid must be 1 to 50 characters")
+	case u.Email != "" && !strings.Contains(u.Email, "@"):
+		return repo.User{}, errors.New("invalid email")
+	case imp.names[u.Name]:
+		return repo.User{}, fmt.Errorf("name: %v", errDuplicate)
+	case imp.sids[u.SID]:
+		return repo.User{}, fmt.Errorf("sid: %v", errDuplicate)
+	}
+	if rec.PasswordHash != "" {
+		hash, err := password.Normalize(strings.TrimSpace(rec.PasswordHash))
+		if err != nil {
+			return repo.User{}, err
+		}
+		u.PasswordHash = hash
+	}
+	imp.names[u.Name] = true
+	imp.sids[u.SID] = true
+	return u, nil
+}
+
+func (imp *importer) flush() error {
+	if len(imp.batch) == 0 {
+		return nil
+	}
+	defer func() {
+		imp.batch, imp.lines = imp.batch[:0], imp.lines[:0]
+	}()
+
+	// A dry run reports every conflict instead of stopping at the first,
+	// which is what skipping them does.
+	policy := imp.opts.Policy
+	if imp.opts.DryRun && policy == repo.ConflictFail {
+		policy = repo.ConflictSkip
+	}
+	results, err := imp.repo.ImportUsers(imp.batch, policy, imp.opts.DryRun)
+	var conflict *repo.ConflictError
+	if errors.As(err, &conflict) {
+		rowErr := &RowError{Line: imp.lines[conflict.Index], Name: conflict.Name, Err: errConflict}
+		if conflict.Owner != "" {
+			rowErr.Err = errSIDConflict
+		}
+		imp.reject(rowErr)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	for i, result := range results {
+		switch {
+		case result == repo.SIDConflict:
+			imp.reject(&RowError{Line: imp.lines[i], Name: imp.batch[i].Name, Err: errSIDConflict})
+		case result == repo.Skipped && policy != imp.opts.Policy:
+			imp.reject(&RowError{Line: imp.lines[i], Name: imp.batch[i].Name, Err: errConflict})
+		case result == repo.Skipped:
+			imp.report.Skipped++
+		case result == repo.Updated:
+			imp.report.Updated++
+		default:
+			imp.report.Created++
+		}
+	}
+	return nil
+}
+
+// Export writes every user of r to enc and returns how many were written.
+func Export(r repo.BulkRepository, enc Encoder) (int, error) {
+	n := 0
+	err := r.ExportUsers(func(u repo.User) error {
+		n++
+		return enc.Encode(Record{
+			Name:         u.Name,
+			SID:          u.SID,
+			Email:        u.Email,
+			Phone:        u.Phone,
+			TOTPSecret:   u.TOTPSecret,
+			PasswordHash: u.PasswordHash,
+		})
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, enc.Flush()
+}
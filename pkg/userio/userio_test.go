@@ -0,0 +1,149 @@
+package userio_test
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"loginsvc/pkg/userio"
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRepo(t *testing.T) *repo.MySQLLoginRepo {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := ioutil.ReadFile("../../sqlite.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	c := repo.NewCluster(db, nil, repo.HealthCheckInterval(time.Hour))
+	t.Cleanup(func() { c.Close() })
+	return repo.NewMySQLLoginRepo(c, nil)
+}
+
+const input = `name,sid,password_hash
+al,c111111111,$2b$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy
+bo,,
+ed,a000000000,
+cy,d333333333,pbkdf2_sha256$260000$salt$bRDLjTBf9ntsa5gxzkVY9ENJKEjGi5HsrS0nFHuLVHo=
+al,e444444444,
+di,f555555555,5f4dcc3b5aa765d61d8327deb882cf99
+"unterminated
+`
+
+func importString(t *testing.T, r repo.BulkRepository, in string, opts userio.ImportOptions) (userio.Report, []string, error) {
+	dec, err := userio.NewDecoder(strings.NewReader(in), userio.CSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rejected []string
+	report, err := userio.Import(r, dec, opts, func(e *userio.RowError) {
+		rejected = append(rejected, e.Error())
+	})
+	return report, rejected, err
+}
+
+func TestImportCSV(t *testing.T) {
+	r := newRepo(t)
+	report, rejected, err := importString(t, r, input, userio.ImportOptions{BatchSize: 2, Policy: repo.ConflictSkip})
+	assert.NoError(t, err)
+	assert.Equal(t, userio.Report{Created: 2, Skipped: 1, Invalid: 4}, report)
+	assert.Equal(t, []string{
+		"line 3 (bo): sid must be 1 to 50 characters",
+		"line 6 (al): name: duplicate in input",
+		"line 7 (di): password: unsupported hash format",
+		`line 8: extraneous or missing " in quoted-field`,
+	}, rejected)
+
+	al, err := r.User("al")
+	assert.NoError(t, err)
+	assert.Equal(t, "$2b$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", al.PasswordHash)
+	cy, _ := r.User("cy")
+	assert.Equal(t, "$pbkdf2-sha256$i=260000$c2FsdA$bRDLjTBf9ntsa5gxzkVY9ENJKEjGi5HsrS0nFHuLVHo", cy.PasswordHash)
+	sid, _ := r.Name("ed")
+	assert.Equal(t, "a123456789", sid, "skipped user must be left alone")
+}
+
+func TestImportDryRunReportsEveryConflict(t *testing.T) {
+	r := newRepo(t)
+	report, rejected, err := importString(t, r, "name,sid\ned,a000000000\nal,c111111111\n", userio.ImportOptions{DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, userio.Report{Created: 1, Invalid: 1}, report)
+	assert.Equal(t, []string{"line 2 (ed): user already exists"}, rejected)
+	_, err = r.User("al")
+	assert.Equal(t, sql.ErrNoRows, err, "a dry run must not write")
+}
+
+func TestImportConflictPolicies(t *testing.T) {
+	r := newRepo(t)
+	_, rejected, err := importString(t, r, "name,sid\nal,c111111111\ned,a000000000\n", userio.ImportOptions{Policy: repo.ConflictFail})
+	assert.Error(t, err)
+	assert.Equal(t, []string{"line 3 (ed): user already exists"}, rejected)
+	_, err = r.User("al")
+	assert.Equal(t, sql.ErrNoRows, err, "the failed batch must be rolled back")
+
+	report, _, err := importString(t, r, "name,sid\ned,a000000000\n", userio.ImportOptions{Policy: repo.ConflictOverwrite})
+	assert.NoError(t, err)
+	assert.Equal(t, userio.Report{Updated: 1}, report)
+	sid, _ := r.Name("ed")
+	assert.Equal(t, "a000000000", sid)
+}
+
+func TestImportSIDConflicts(t *testing.T) {
+	r := newRepo(t)
+	assert.NoError(t, r.Register(repo.User{Name: "al", SID: "c111111111"}))
+	in := "name,sid\nbo,a123456789\ncy,d333333333\n"
+
+	// ed has a123456789.
+	report, rejected, err := importString(t, r, in, userio.ImportOptions{DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, userio.Report{Created: 1, Invalid: 1}, report)
+	assert.Equal(t, []string{"line 2 (bo): sid belongs to another user"}, rejected)
+
+	_, rejected, err = importString(t, r, in, userio.ImportOptions{Policy: repo.ConflictFail})
+	assert.Error(t, err)
+	assert.Equal(t, []string{"line 2 (bo): sid belongs to another user"}, rejected)
+	_, err = r.User("cy")
+	assert.Equal(t, sql.ErrNoRows, err, "the failed batch must be rolled back")
+
+	// Overwriting doesn't take a sid from its user either.
+	report, rejected, err = importString(t, r, "name,sid\ned,c111111111\ncy,d333333333\n", userio.ImportOptions{Policy: repo.ConflictOverwrite})
+	assert.NoError(t, err)
+	assert.Equal(t, userio.Report{Created: 1, Invalid: 1}, report)
+	assert.Equal(t, []string{"line 2 (ed): sid belongs to another user"}, rejected)
+	sid, _ := r.Name("ed")
+	assert.Equal(t, "a123456789", sid)
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	src := newRepo(t)
+	importString(t, src, input, userio.ImportOptions{Policy: repo.ConflictSkip})
+
+	for _, f := range []userio.Format{userio.CSV, userio.JSONL} {
+		var buf bytes.Buffer
+		enc, _ := userio.NewEncoder(&buf, f)
+		n, err := userio.Export(src, enc)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, n)
+
+		dst := newRepo(t)
+		dec, err := userio.NewDecoder(&buf, f)
+		assert.NoError(t, err)
+		report, err := userio.Import(dst, dec, userio.ImportOptions{Policy: repo.ConflictOverwrite}, func(e *userio.RowError) { t.Error(e) })
+		assert.NoError(t, err)
+		assert.Equal(t, userio.Report{Created: 2, Updated: 1}, report)
+		al, _ := dst.User("al")
+		assert.Equal(t, "$2b$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", al.PasswordHash)
+	}
+}
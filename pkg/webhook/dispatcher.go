@@ -0,0 +1,149 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"loginsvc/repo"
+)
+
+// Options tune a Dispatcher. Zero fields take the defaults.
+type Options struct {
+	// MaxAttempts bounds the attempts of a delivery before it is dead.
+	// Defaults to 8.
+	MaxAttempts int
+	// The delay before the retry after the nth failed attempt is
+	// MinBackoff * 2^(n-1), capped at MaxBackoff. They default to 10s and
+	// 1h.
+	MinBackoff, MaxBackoff time.Duration
+	// Timeout bounds each attempt. Defaults to 10s.
+	Timeout time.Duration
+	// BatchSize bounds the events dispatched and the deliveries attempted
+	// by RunOnce. Defaults to 100.
+	BatchSize int
+}
+
+func (o *Options) setDefaults() {
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 8
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = 10 * time.Second
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = time.Hour
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+}
+
+// Dispatcher delivers the events of the outbox of a tenant. Several
+// replicas may run one for the same tenant: each event and each attempt is
+// claimed by one of them.
+type Dispatcher struct {
+	outbox repo.Outbox
+	tenant string
+	client *http.Client
+	opts   Options
+	logger log.Logger
+}
+
+// NewDispatcher returns a Dispatcher of the events of outbox, that of
+// tenant, posting them with client.
+func NewDispatcher(outbox repo.Outbox, tenant string, client *http.Client, opts Options, logger log.Logger) *Dispatcher {
+	opts.setDefaults()
+	return &Dispatcher{outbox: outbox, tenant: tenant, client: client, opts: opts, logger: logger}
+}
+
+// RunOnce dispatches the pending outbox events, attempts the deliveries
+// that are due, and returns how many succeeded. Failed attempts are
+// recorded and logged, not returned.
+func (d *Dispatcher) RunOnce(ctx context.Context) (int, error) {
+	if _, err := d.outbox.DispatchOutbox(d.opts.BatchSize); err != nil {
+		return 0, err
+	}
+	// The lease outlasts the attempts of the batch, so that no other
+	// dispatcher claims a delivery while it is attempted.
+	lease := time.Duration(d.opts.BatchSize+1) * d.opts.Timeout
+	deliveries, err := d.outbox.ClaimDeliveries(time.Now(), lease, d.opts.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	delivered := 0
+	for _, dl := range deliveries {
+		if ctx.Err() != nil {
+			// The unattempted deliveries are due again when the lease ends.
+			return delivered, ctx.Err()
+		}
+		attempts := dl.Attempts + 1
+		state, next, lastErr := repo.DeliveryDelivered, time.Now(), ""
+		if err := d.post(ctx, dl); err != nil {
+			lastErr = err.Error()
+			state = repo.DeliveryPending
+			next = time.Now().Add(d.backoff(attempts))
+			if attempts >= d.opts.MaxAttempts {
+				state = repo.DeliveryDead
+			}
+			d.logger.Log("delivery", dl.ID, "url", dl.Subscription.URL, "event", dl.Event.Type, "attempts", attempts, "state", state, "err", err)
+		} else {
+			delivered++
+		}
+		if err := d.outbox.RecordDelivery(dl.ID, state, attempts, next, lastErr); err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+// backoff returns the delay before the retry after the nth failed attempt.
+func (d *Dispatcher) backoff(n int) time.Duration {
+	delay := d.opts.MinBackoff
+	for i := 1; i < n && delay < d.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.opts.MaxBackoff {
+		delay = d.opts.MaxBackoff
+	}
+	return delay
+}
+
+// post makes an attempt of dl.
+func (d *Dispatcher) post(ctx context.Context, dl repo.Delivery) error {
+	body, err := json.Marshal(Payload{ID: dl.Event.ID, Type: dl.Event.Type, Tenant: d.tenant, User: dl.Event.User, Time: dl.Event.Time})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.Subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(dl.Subscription.Secret, time.Now(), body))
+	req.Header.Set(EventHeader, dl.Event.Type)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(dl.ID, 10))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook: %s replied %s", dl.Subscription.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package webhook_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"loginsvc/pkg/webhook"
+	"loginsvc/repo"
+)
+
+func newRepo(t *testing.T) *repo.MySQLLoginRepo {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := ioutil.ReadFile("../../sqlite.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	c := repo.NewCluster(db, nil, repo.HealthCheckInterval(time.Hour))
+	t.Cleanup(func() { c.Close() })
+	return repo.NewMySQLLoginRepo(c, nil)
+}
+
+// receiver records the payloads it accepts, after failing the number of
+// requests in fail.
+type receiver struct {
+	t      *testing.T
+	secret string
+
+	mtx      sync.Mutex
+	fail     int
+	requests int
+	payloads []webhook.Payload
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	assert.NoError(rc.t, webhook.VerifySignature(rc.secret, r.Header.Get(webhook.SignatureHeader), body, time.Minute, time.Now()))
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+	rc.requests++
+	if rc.fail > 0 {
+		rc.fail--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var p webhook.Payload
+	assert.NoError(rc.t, json.Unmarshal(body, &p))
+	assert.Equal(rc.t, p.Type, r.Header.Get(webhook.EventHeader))
+	rc.payloads = append(rc.payloads, p)
+}
+
+// runUntilIdle runs d until nothing is pending but the backoff of failed
+// deliveries, which opts keeps to milliseconds.
+func runUntilIdle(t *testing.T, d *webhook.Dispatcher) {
+	for i := 0; i < 20; i++ {
+		_, err := d.RunOnce(context.Background())
+		assert.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+var opts = webhook.Options{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond, Timeout: time.Second}
+
+func TestDispatcher(t *testing.T) {
+	r := newRepo(t)
+	all := &receiver{t: t, secret: "s1", fail: 2}
+	locked := &receiver{t: t, secret: "s2"}
+	for _, rc := range []*receiver{all, locked} {
+		srv := httptest.NewServer(rc)
+		defer srv.Close()
+		s := repo.Subscription{URL: srv.URL, Secret: rc.secret}
+		if rc == locked {
+			s.EventTypes = []string{repo.EventUserLocked}
+		}
+		_, err := r.CreateSubscription(s)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, r.Register(repo.User{Name: "al", SID: "c111111111"}))
+	assert.NoError(t, r.SetStatus("al", repo.StatusDisabled))
+
+	d := webhook.NewDispatcher(r, "default", http.DefaultClient, opts, log.NewNopLogger())
+	runUntilIdle(t, d)
+
+	// The first two attempts failed, and the retry delivered.
+	assert.Equal(t, 4, all.requests)
+	if assert.Len(t, all.payloads, 2) {
+		assert.Equal(t, repo.EventUserCreated, all.payloads[0].Type)
+		assert.Equal(t, "al", all.payloads[0].User)
+		assert.Equal(t, "default", all.payloads[0].Tenant)
+		assert.Equal(t, repo.EventUserLocked, all.payloads[1].Type)
+	}
+	// Filtered subscriptions get the events they list only.
+	if assert.Len(t, locked.payloads, 1) {
+		assert.Equal(t, repo.EventUserLocked, locked.payloads[0].Type)
+		assert.Equal(t, all.payloads[1].ID, locked.payloads[0].ID)
+	}
+	subs, _ := r.Subscriptions()
+	for _, s := range subs {
+		assert.Zero(t, s.Pending+s.Dead)
+	}
+}
+
+func TestDispatcherDeadLetter(t *testing.T) {
+	r := newRepo(t)
+	rc := &receiver{t: t, secret: "s1", fail: 100}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	s, err := r.CreateSubscription(repo.Subscription{URL: srv.URL, Secret: rc.secret})
+	assert.NoError(t, err)
+	assert.NoError(t, r.RevokeSessions("ed", time.Now()))
+
+	d := webhook.NewDispatcher(r, "default", http.DefaultClient, opts, log.NewNopLogger())
+	runUntilIdle(t, d)
+	assert.Equal(t, opts.MaxAttempts, rc.requests)
+	subs, _ := r.Subscriptions()
+	assert.Equal(t, 1, subs[0].Dead)
+
+	// Redelivered dead deliveries get all their attempts again.
+	rc.fail = 0
+	n, err := r.RedeliverDead(s.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	runUntilIdle(t, d)
+	if assert.Len(t, rc.payloads, 1) {
+		assert.Equal(t, repo.EventSessionRevoked, rc.payloads[0].Type)
+		assert.Equal(t, "ed", rc.payloads[0].User)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	now := time.Unix(1600000000, 0)
+	header := webhook.Sign("s1", now, body)
+	assert.Regexp(t, `^t=1600000000,v1=[0-9a-f]{64}$`, header)
+
+	assert.NoError(t, webhook.VerifySignature("s1", header, body, time.Minute, now.Add(30*time.Second)))
+	for _, c := range []struct {
+		secret, header string
+		body           []byte
+		now            time.Time
+		want           error
+	}{
+		{"s2", header, body, now, webhook.ErrInvalidSignature},
+		{"s1", header, []byte(`{"id":2}`), now, webhook.ErrInvalidSignature},
+		{"s1", header, body, now.Add(2 * time.Minute), webhook.ErrExpiredSignature},
+		{"s1", "v1=00", body, now, webhook.ErrMalformedSignature},
+		{"s1", "t=1600000000,v1=zz", body, now, webhook.ErrMalformedSignature},
+	} {
+		assert.Equal(t, c.want, webhook.VerifySignature(c.secret, c.header, c.body, time.Minute, c.now), c.header)
+	}
+}
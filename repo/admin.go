@@ -0,0 +1,316 @@
+package repo
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"loginsvc/pkg/password"
+)
+
+// Status is the state of a user account.
+type Status string
+
+const (
+	StatusActive Status = "active"
+	// StatusDisabled users can't authenticate until they are active again.
+	StatusDisabled Status = "disabled"
+)
+
+// ErrInvalidCursor is returned by ListUsers for a cursor it didn't issue.
+var ErrInvalidCursor = errors.New("repo: invalid cursor")
+
+// UserAdmin is implemented by repositories through which support staff
+// manage users. Writes to unknown users have no effect.
+type UserAdmin interface {
+	// ListUsers returns a page of the users matching q.
+	ListUsers(q UserQuery) (UserPage, error)
+	// SetStatus sets the status of the user named n. Disabling an active
+	// user writes a user.locked event to the outbox.
+	SetStatus(n string, s Status) error
+	// RevokeSessions records that the sessions of the user named n started
+	// before at are revoked, and writes a session.revoked event to the
+	// outbox.
+	RevokeSessions(n string, at time.Time) error
+	// ChangePassword replaces the password hash of the user named n, as
+	// LoginRepository.SetPasswordHash does, and writes a password.changed
+	// event to the outbox. Rehashing the same password on login uses
+	// SetPasswordHash, and isn't an event.
+	ChangePassword(n, hash string) error
+	// DeleteUser deletes the user named n, its roles and its group
+	// memberships.
+	DeleteUser(n string) error
+}
+
+// UserSort orders the users listed by ListUsers.
+type UserSort string
+
+const (
+	SortByName    UserSort = "name"
+	SortByCreated UserSort = "created"
+)
+
+// DefaultPageSize is the number of users listed when a UserQuery sets no
+// Limit.
+const DefaultPageSize = 50
+
+// UserQuery selects the users listed by ListUsers. Zero fields don't
+// filter. Every filter is served by an index of the users table, or of
+// user_roles for Role and of user_email_prefixes for EmailPrefix.
+type UserQuery struct {
+	Status Status
+	// CreatedFrom and CreatedTo bound the creation time, CreatedTo
+	// excluded.
+	CreatedFrom, CreatedTo time.Time
+	// Role is a role assigned directly, not through a group.
+	Role string
+	// NamePrefix matches the start of the name.
+	NamePrefix string
+	// SID matches the whole subject ID.
+	SID string
+	// EmailPrefix matches the start of the address, ignoring case and
+	// surrounding blanks. Addresses are encrypted, so it is looked up
+	// among the blind indexes of their prefixes.
+	EmailPrefix string
+
+	// Sort orders the users, by name if empty; Desc reverses it. Users
+	// created at the same time are ordered by name.
+	Sort UserSort
+	Desc bool
+	// Cursor continues the list after the page that returned it, and must
+	// come with the same query.
+	Cursor string
+	// Offset skips that many users at the start of the page, for clients
+	// that page by position rather than by cursor.
+	Offset int
+	// Limit is the size of the page, DefaultPageSize if zero.
+	Limit int
+	// CountTotal fills UserPage.Total, at the cost of counting the users.
+	CountTotal bool
+}
+
+// UserPage is a page of users and the cursor of the next one, empty after
+// the last page.
+type UserPage struct {
+	Users []User
+	Next  string
+	// Total is the number of users the query selects, without its Cursor,
+	// Offset and Limit, if it asked for it.
+	Total int
+}
+
+// ListUsers implements UserAdmin.
+func (repo *sqlLoginRepo) ListUsers(q UserQuery) (UserPage, error) {
+	if q.EmailPrefix != "" && repo.keyring == nil {
+		return UserPage{}, ErrNoKeyring
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	where := []string{"tenant_id = ?"}
+	args := []interface{}{repo.tenant}
+	if q.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, q.Status)
+	}
+	if !q.CreatedFrom.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, toUnix(q.CreatedFrom))
+	}
+	if !q.CreatedTo.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, toUnix(q.CreatedTo))
+	}
+	if q.Role != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM user_roles ur WHERE ur.tenant_id = users.tenant_id AND ur.user_name = users.name AND ur.role = ?)")
+		args = append(args, q.Role)
+	}
+	if q.NamePrefix != "" {
+		where = append(where, "name LIKE ? ESCAPE '!'")
+		args = append(args, escapeLike(q.NamePrefix)+"%")
+	}
+	if q.EmailPrefix != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM user_email_prefixes p WHERE p.tenant_id = users.tenant_id AND p.user_name = users.name AND p.prefix_bidx = ?)")
+		args = append(args, blindIndex(repo.keyring, q.EmailPrefix))
+	}
+	if q.SID != "" {
+		where = append(where, "sid = ?")
+		args = append(args, q.SID)
+	}
+
+	var page UserPage
+	if q.CountTotal {
+		err := repo.cluster.Reader("").QueryRow("SELECT COUNT(*) FROM users WHERE "+strings.Join(where, " AND ")+";", args...).Scan(&page.Total)
+		if err != nil {
+			return UserPage{}, err
+		}
+	}
+	cmp, dir := ">", "ASC"
+	if q.Desc {
+		cmp, dir = "<", "DESC"
+	}
+	order := "name " + dir
+	if q.Cursor != "" {
+		created, name, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return UserPage{}, err
+		}
+		if q.Sort == SortByCreated {
+			where = append(where, "(created_at "+cmp+" ? OR (created_at = ? AND name "+cmp+" ?))")
+			args = append(args, created, created, name)
+		} else {
+			where = append(where, "name "+cmp+" ?")
+			args = append(args, name)
+		}
+	}
+	if q.Sort == SortByCreated {
+		order = "created_at " + dir + ", " + order
+	}
+	offset := q.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	args = append(args, limit+1, offset)
+
+	rows, err := repo.cluster.Reader("").Query(selectUser+"WHERE "+strings.Join(where, " AND ")+" ORDER BY "+order+" LIMIT ? OFFSET ?;", args...)
+	if err != nil {
+		return UserPage{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		u, err := repo.scanUser(rows)
+		if err != nil {
+			return UserPage{}, err
+		}
+		page.Users = append(page.Users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return UserPage{}, err
+	}
+	if len(page.Users) > limit {
+		page.Users = page.Users[:limit]
+		last := page.Users[limit-1]
+		page.Next = encodeCursor(toUnix(last.Created), last.Name)
+	}
+	return page, nil
+}
+
+// SetStatus implements UserAdmin.
+func (repo *sqlLoginRepo) SetStatus(n string, s Status) error {
+	tx, err := repo.cluster.Writer(repo.key(n)).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE users SET status = ? WHERE tenant_id = ? AND name = ? AND status <> ?;", s, repo.tenant, n, s)
+	if err != nil {
+		return err
+	}
+	if changed, err := res.RowsAffected(); err != nil {
+		return err
+	} else if changed > 0 && s == StatusDisabled {
+		if err := appendOutbox(tx, repo.tenant, EventUserLocked, n); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RevokeSessions implements UserAdmin.
+func (repo *sqlLoginRepo) RevokeSessions(n string, at time.Time) error {
+	return repo.writeWithEvent(n, EventSessionRevoked, "UPDATE users SET sessions_revoked_at = ? WHERE tenant_id = ? AND name = ?;", toUnix(at), repo.tenant, n)
+}
+
+// ChangePassword implements UserAdmin.
+func (repo *sqlLoginRepo) ChangePassword(n, hash string) error {
+	return repo.writeWithEvent(n, EventPasswordChanged, "UPDATE users SET password_hash = ?, password_scheme = ? WHERE tenant_id = ? AND name = ?;", hash, password.Describe(hash), repo.tenant, n)
+}
+
+// writeWithEvent updates the user named n with query, and writes an event
+// of typ to the outbox in the same transaction if the user exists.
+func (repo *sqlLoginRepo) writeWithEvent(n, typ, query string, args ...interface{}) error {
+	tx, err := repo.cluster.Writer(repo.key(n)).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if changed, err := res.RowsAffected(); err != nil {
+		return err
+	} else if changed > 0 {
+		if err := appendOutbox(tx, repo.tenant, typ, n); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteUser implements UserAdmin.
+func (repo *sqlLoginRepo) DeleteUser(n string) error {
+	tx, err := repo.cluster.Writer(repo.key(n)).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		"DELETE FROM user_roles WHERE tenant_id = ? AND user_name = ?;",
+		"DELETE FROM group_members WHERE tenant_id = ? AND user_name = ?;",
+		"DELETE FROM user_email_prefixes WHERE tenant_id = ? AND user_name = ?;",
+		"DELETE FROM users WHERE tenant_id = ? AND name = ?;",
+	} {
+		if _, err := tx.Exec(stmt, repo.tenant, n); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, with ! as the escape
+// character.
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// encodeCursor returns the cursor of the page after the user created at
+// created and named name.
+func encodeCursor(created int64, name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(created, 10) + ":" + name))
+}
+
+func decodeCursor(cursor string) (int64, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 {
+		return 0, "", ErrInvalidCursor
+	}
+	created, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	return created, parts[1], nil
+}
+
+// toUnix returns t in Unix seconds, 0 for the zero time.
+func toUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// fromUnix is the inverse of toUnix.
+func fromUnix(s int64) time.Time {
+	if s == 0 {
+		return time.Time{}
+	}
+	return time.Unix(s, 0)
+}
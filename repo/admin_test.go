@@ -0,0 +1,160 @@
+package repo_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"loginsvc/pkg/envelope"
+	"loginsvc/pkg/invalidation"
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func names(users []repo.User) []string {
+	var ns []string
+	for _, u := range users {
+		ns = append(ns, u.Name)
+	}
+	return ns
+}
+
+func TestListUsers(t *testing.T) {
+	k, _ := envelope.NewKeyring("k1", map[string][]byte{"k1": envelope.GenerateKey()}, envelope.GenerateKey())
+	r, _ := newEncryptedRepo(t, k)
+	day := func(d int) time.Time { return time.Date(2021, 3, d, 0, 0, 0, 0, time.UTC) }
+	// ed, from sqlite.sql, was created at an unknown time.
+	for _, u := range []repo.User{
+		{Name: "al", SID: "c1", Email: "al@example.com", Created: day(1)},
+		{Name: "alice", SID: "c2", Email: "alice@example.org", Created: day(2), Status: repo.StatusDisabled},
+		{Name: "al_x", SID: "c3", Created: day(2)},
+		{Name: "bo", SID: "c4", Created: day(3)},
+	} {
+		assert.NoError(t, r.Register(u))
+	}
+	assert.NoError(t, r.CreateRole("reader"))
+	assert.NoError(t, r.AssignRole("bo", "reader"))
+	assert.NoError(t, r.AssignRole("alice", "reader"))
+
+	list := func(q repo.UserQuery) []string {
+		page, err := r.ListUsers(q)
+		assert.NoError(t, err)
+		return names(page.Users)
+	}
+	assert.Equal(t, []string{"al", "al_x", "alice", "bo", "ed"}, list(repo.UserQuery{}))
+	assert.Equal(t, []string{"alice"}, list(repo.UserQuery{Status: repo.StatusDisabled}))
+	assert.Equal(t, []string{"al_x", "alice", "bo"}, list(repo.UserQuery{CreatedFrom: day(2)}))
+	assert.Equal(t, []string{"al_x", "alice"}, list(repo.UserQuery{CreatedFrom: day(2), CreatedTo: day(3)}))
+	assert.Equal(t, []string{"alice", "bo"}, list(repo.UserQuery{Role: "reader"}))
+	assert.Equal(t, []string{"al", "al_x", "alice"}, list(repo.UserQuery{NamePrefix: "al"}))
+	// _ is no wildcard.
+	assert.Equal(t, []string{"al_x"}, list(repo.UserQuery{NamePrefix: "al_"}))
+	assert.Equal(t, []string{"al"}, list(repo.UserQuery{EmailPrefix: " AL@example.com"}))
+	assert.Equal(t, []string{"al", "alice"}, list(repo.UserQuery{EmailPrefix: "Al"}))
+	assert.Equal(t, []string{"alice"}, list(repo.UserQuery{EmailPrefix: "alice@"}))
+	assert.Empty(t, list(repo.UserQuery{EmailPrefix: "al@example.com.au"}))
+	assert.Equal(t, []string{"bo"}, list(repo.UserQuery{SID: "c4"}))
+	assert.Equal(t, []string{"bo", "alice", "al_x", "al", "ed"}, list(repo.UserQuery{Sort: repo.SortByCreated, Desc: true}))
+
+	// Pages follow each other without gaps or repeats.
+	var all []string
+	q := repo.UserQuery{Sort: repo.SortByCreated, Limit: 2}
+	for {
+		page, err := r.ListUsers(q)
+		assert.NoError(t, err)
+		all = append(all, names(page.Users)...)
+		if page.Next == "" {
+			break
+		}
+		q.Cursor = page.Next
+	}
+	assert.Equal(t, []string{"ed", "al", "al_x", "alice", "bo"}, all)
+
+	page, err := r.ListUsers(repo.UserQuery{Desc: true, Limit: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ed", "bo", "alice"}, names(page.Users))
+	page, err = r.ListUsers(repo.UserQuery{Desc: true, Limit: 3, Cursor: page.Next})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"al_x", "al"}, names(page.Users))
+	assert.Empty(t, page.Next)
+
+	// Offset pages by position, and Total counts across pages.
+	page, err = r.ListUsers(repo.UserQuery{Status: repo.StatusActive, Offset: 1, Limit: 2, CountTotal: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"al_x", "bo"}, names(page.Users))
+	assert.Equal(t, 4, page.Total)
+	page, err = r.ListUsers(repo.UserQuery{SID: "c3", CountTotal: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"al_x"}, names(page.Users))
+	assert.Equal(t, 1, page.Total)
+
+	// The prefixes of an address follow its updates.
+	assert.NoError(t, r.UpdateUser(repo.User{Name: "al", Email: "bo@example.com"}))
+	assert.Equal(t, []string{"alice"}, list(repo.UserQuery{EmailPrefix: "al"}))
+	assert.Equal(t, []string{"al"}, list(repo.UserQuery{EmailPrefix: "bo@"}))
+	assert.NoError(t, r.DeleteUser("al"))
+	assert.Empty(t, list(repo.UserQuery{EmailPrefix: "bo@"}))
+
+	_, err = r.ListUsers(repo.UserQuery{Cursor: "not a cursor"})
+	assert.Equal(t, repo.ErrInvalidCursor, err)
+}
+
+func TestUserAdminWrites(t *testing.T) {
+	r, _ := newEncryptedRepo(t, nil)
+	assert.NoError(t, r.Register(repo.User{Name: "al", SID: "c111111111"}))
+	u, err := r.User("al")
+	assert.NoError(t, err)
+	assert.Equal(t, repo.StatusActive, u.Status)
+	assert.WithinDuration(t, time.Now(), u.Created, time.Minute)
+	assert.True(t, u.SessionsRevoked.IsZero())
+
+	revoked := time.Unix(1600000000, 0)
+	assert.NoError(t, r.SetStatus("al", repo.StatusDisabled))
+	assert.NoError(t, r.RevokeSessions("al", revoked))
+	u, _ = r.User("al")
+	assert.Equal(t, repo.StatusDisabled, u.Status)
+	assert.Equal(t, revoked, u.SessionsRevoked)
+
+	assert.NoError(t, r.CreateRole("reader"))
+	assert.NoError(t, r.AssignRole("al", "reader"))
+	assert.NoError(t, r.CreateGroup("staff"))
+	assert.NoError(t, r.AddMember("staff", "al"))
+	assert.NoError(t, r.DeleteUser("al"))
+	_, err = r.User("al")
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// A new al inherits nothing from the old one.
+	assert.NoError(t, r.Register(repo.User{Name: "al", SID: "c111111111"}))
+	roles, err := r.UserRoles("al")
+	assert.NoError(t, err)
+	assert.Empty(t, roles)
+	members, err := r.Members("staff")
+	assert.NoError(t, err)
+	assert.Empty(t, members)
+}
+
+// deletingAdmin deletes users from a countingRepo.
+type deletingAdmin struct {
+	repo.UserAdmin
+	users *countingRepo
+}
+
+func (a deletingAdmin) DeleteUser(n string) error {
+	delete(a.users.sids, n)
+	return nil
+}
+
+func TestDeleteUserInvalidates(t *testing.T) {
+	bus := invalidation.NewInProcBus()
+	backend := &countingRepo{sids: map[string]string{"ed": "a123456789"}}
+	users := repo.NewCachingLoginRepository(backend, bus, time.Hour)
+	roles := repo.NewCachingRoleRepository(nil, bus, time.Hour)
+	admin := repo.NewCachingUserAdmin(deletingAdmin{users: backend}, users, roles)
+
+	users.Name("ed")
+	assert.NoError(t, admin.DeleteUser("ed"))
+	sid, _ := users.Name("ed")
+	assert.Empty(t, sid)
+	assert.Equal(t, 2, backend.reads)
+}
@@ -0,0 +1,187 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// AuditEvent is a record of the audit log of a tenant. Each record holds
+// the hash of the one before it, so a change to any of them breaks the
+// chain; see package audit.
+type AuditEvent struct {
+	Seq     int64     `json:"seq"`
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Actor   string    `json:"actor"`
+	Subject string    `json:"subject,omitempty"`
+	IP      string    `json:"ip,omitempty"`
+	Outcome string    `json:"outcome"`
+	Detail  string    `json:"detail,omitempty"`
+	Prev    string    `json:"prev"`
+	Hash    string    `json:"hash"`
+}
+
+// AuditQuery filters the events listed by AuditEvents. Zero fields don't
+// filter.
+type AuditQuery struct {
+	// From is inclusive, To exclusive.
+	From, To time.Time
+	Type     string
+	Actor    string
+	Subject  string
+	// AfterSeq lists the events after it, or before it with Desc.
+	AfterSeq int64
+	Desc     bool
+	// Limit is DefaultPageSize if zero.
+	Limit int
+}
+
+// AuditLog is the append-only audit log of a tenant. Events are only ever
+// appended and, past their retention, pruned from the start.
+type AuditLog interface {
+	// LastAuditEvent returns the last event, or sql.ErrNoRows if there is
+	// none.
+	LastAuditEvent() (AuditEvent, error)
+	// AppendAuditEvent stores the event chain returns for the head of the
+	// log, the Seq and Hash of its last event or zero if it has none, and
+	// returns it. Appends of every replica are serialized on the head, so
+	// the event chain returns follows the head it was given.
+	AppendAuditEvent(chain func(head AuditEvent) AuditEvent) (AuditEvent, error)
+	// AuditEvents lists the events matching q in order of Seq.
+	AuditEvents(q AuditQuery) ([]AuditEvent, error)
+	// PruneAuditEvents deletes the events up to and including seq.
+	PruneAuditEvents(seq int64) error
+}
+
+// auditTables are the tables of AuditLog.
+var auditTables = []table{
+	{"audit_log", []string{"tenant_id", "seq", "occurred_at", "type", "actor", "subject", "ip", "outcome", "detail", "prev_hash", "hash"}},
+	{"audit_chain", []string{"tenant_id", "seq", "hash"}},
+}
+
+const selectAuditEvent = "SELECT seq, occurred_at, type, actor, subject, ip, outcome, detail, prev_hash, hash FROM audit_log "
+
+// LastAuditEvent reads from the primary, which replicas may lag behind,
+// since the event is the one the next appended event follows.
+func (repo *sqlLoginRepo) LastAuditEvent() (AuditEvent, error) {
+	return scanAuditEvent(repo.cluster.Writer("").QueryRow(selectAuditEvent+"WHERE tenant_id = ? ORDER BY seq DESC LIMIT 1;", repo.tenant))
+}
+
+// AppendAuditEvent first moves the head of the tenant in audit_chain on,
+// which locks it until the commit, so that appends of every replica follow
+// one another. The first append of a tenant creates its head from its last
+// event; if another creates it meanwhile the append fails, and the next
+// one finds it.
+func (repo *sqlLoginRepo) AppendAuditEvent(chain func(head AuditEvent) AuditEvent) (AuditEvent, error) {
+	tx, err := repo.cluster.Writer("").Begin()
+	if err != nil {
+		return AuditEvent{}, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE audit_chain SET seq = seq + 1 WHERE tenant_id = ?;", repo.tenant)
+	if err != nil {
+		return AuditEvent{}, err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return AuditEvent{}, err
+	} else if affected == 0 {
+		var last AuditEvent
+		err := tx.QueryRow("SELECT seq, hash FROM audit_log WHERE tenant_id = ? ORDER BY seq DESC LIMIT 1;", repo.tenant).Scan(&last.Seq, &last.Hash)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return AuditEvent{}, err
+		}
+		if _, err := tx.Exec("INSERT INTO audit_chain (tenant_id, seq, hash) VALUES (?, ?, ?);", repo.tenant, last.Seq+1, last.Hash); err != nil {
+			return AuditEvent{}, err
+		}
+	}
+	var head AuditEvent
+	if err := tx.QueryRow("SELECT seq - 1, hash FROM audit_chain WHERE tenant_id = ?;", repo.tenant).Scan(&head.Seq, &head.Hash); err != nil {
+		return AuditEvent{}, err
+	}
+
+	e := chain(head)
+	if _, err := tx.Exec(
+		"INSERT INTO audit_log (tenant_id, seq, occurred_at, type, actor, subject, ip, outcome, detail, prev_hash, hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);",
+		repo.tenant, e.Seq, e.Time.UnixNano(), e.Type, e.Actor, e.Subject, e.IP, e.Outcome, e.Detail, e.Prev, e.Hash,
+	); err != nil {
+		return AuditEvent{}, err
+	}
+	if _, err := tx.Exec("UPDATE audit_chain SET hash = ? WHERE tenant_id = ?;", e.Hash, repo.tenant); err != nil {
+		return AuditEvent{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return AuditEvent{}, err
+	}
+	return e, nil
+}
+
+func (repo *sqlLoginRepo) AuditEvents(q AuditQuery) ([]AuditEvent, error) {
+	where := []string{"tenant_id = ?"}
+	args := []interface{}{repo.tenant}
+	if !q.From.IsZero() {
+		where = append(where, "occurred_at >= ?")
+		args = append(args, q.From.UnixNano())
+	}
+	if !q.To.IsZero() {
+		where = append(where, "occurred_at < ?")
+		args = append(args, q.To.UnixNano())
+	}
+	for _, f := range []struct{ column, value string }{
+		{"type", q.Type}, {"actor", q.Actor}, {"subject", q.Subject},
+	} {
+		if f.value != "" {
+			where = append(where, f.column+" = ?")
+			args = append(args, f.value)
+		}
+	}
+	order := "ASC"
+	if q.Desc {
+		order = "DESC"
+		if q.AfterSeq > 0 {
+			where = append(where, "seq < ?")
+			args = append(args, q.AfterSeq)
+		}
+	} else if q.AfterSeq > 0 {
+		where = append(where, "seq > ?")
+		args = append(args, q.AfterSeq)
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	args = append(args, limit)
+
+	rows, err := repo.cluster.Reader("").Query(selectAuditEvent+"WHERE "+strings.Join(where, " AND ")+" ORDER BY seq "+order+" LIMIT ?;", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []AuditEvent
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (repo *sqlLoginRepo) PruneAuditEvents(seq int64) error {
+	_, err := repo.cluster.Writer("").Exec("DELETE FROM audit_log WHERE tenant_id = ? AND seq <= ?;", repo.tenant, seq)
+	return err
+}
+
+func scanAuditEvent(s scanner) (AuditEvent, error) {
+	var (
+		e  AuditEvent
+		ns int64
+	)
+	if err := s.Scan(&e.Seq, &ns, &e.Type, &e.Actor, &e.Subject, &e.IP, &e.Outcome, &e.Detail, &e.Prev, &e.Hash); err != nil {
+		return AuditEvent{}, err
+	}
+	e.Time = time.Unix(0, ns).UTC()
+	return e, nil
+}
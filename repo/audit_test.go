@@ -0,0 +1,113 @@
+package repo_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"loginsvc/pkg/audit"
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	r, db := newEncryptedRepo(t, nil)
+	_, err := r.LastAuditEvent()
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	rec := audit.NewRecorder(r, nil)
+	for _, e := range []repo.AuditEvent{
+		{Type: audit.TypeLogin, Actor: "web", Subject: "al", IP: "10.0.0.1", Outcome: audit.OutcomeSuccess},
+		{Type: audit.TypeLogin, Actor: "web", Subject: "bo", Outcome: audit.OutcomeFailure, Detail: "invalid credentials"},
+		{Type: audit.AdminType("DisableUser"), Actor: "support", Subject: "bo", Outcome: audit.OutcomeSuccess},
+	} {
+		_, err := rec.Record(context.Background(), e)
+		assert.NoError(t, err)
+	}
+	// Other tenants have chains of their own.
+	_, err = audit.NewRecorder(r.ForTenant("acme", nil), nil).Record(context.Background(), repo.AuditEvent{Type: audit.TypeLogin, Subject: "al"})
+	assert.NoError(t, err)
+
+	last, err := r.LastAuditEvent()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), last.Seq)
+	events, err := r.AuditEvents(repo.AuditQuery{Subject: "bo"})
+	assert.NoError(t, err)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "invalid credentials", events[0].Detail)
+		assert.Equal(t, last, events[1])
+	}
+	events, _ = r.AuditEvents(repo.AuditQuery{Type: audit.TypeLogin, Desc: true, Limit: 1})
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "bo", events[0].Subject)
+	}
+	events, _ = r.AuditEvents(repo.AuditQuery{AfterSeq: 1, To: last.Time})
+	assert.Len(t, events, 1)
+
+	report, err := audit.Verify(r)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Events)
+
+	// An event changed in the database breaks the chain.
+	_, err = db.Exec("UPDATE audit_log SET outcome = 'success' WHERE tenant_id = 'default' AND seq = 2;")
+	assert.NoError(t, err)
+	_, err = audit.Verify(r)
+	assert.Equal(t, &audit.ChainError{Seq: 2, Reason: "hash mismatch"}, err)
+
+	seq, err := rec.Prune(context.Background(), time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), seq)
+	events, _ = r.AuditEvents(repo.AuditQuery{})
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, audit.TypePruned, events[0].Type)
+	}
+}
+
+func TestAuditLogAppendsAcrossReplicas(t *testing.T) {
+	dir := t.TempDir()
+	newSqliteFile(t, dir, "primary", "a123456789").Close()
+	var recs []*audit.Recorder
+	for i := 0; i < 3; i++ {
+		db, err := sql.Open("sqlite3", filepath.Join(dir, "primary.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := repo.NewCluster(db, nil, repo.HealthCheckInterval(time.Hour))
+		defer c.Close()
+		recs = append(recs, audit.NewRecorder(repo.NewMySQLLoginRepo(c, nil), nil))
+	}
+
+	// Appends of every replica interleave into one chain, and none is lost.
+	var wg sync.WaitGroup
+	for _, rec := range recs {
+		wg.Add(1)
+		go func(rec *audit.Recorder) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				_, err := rec.Record(context.Background(), repo.AuditEvent{Type: audit.TypeLogin, Subject: "al"})
+				assert.NoError(t, err)
+			}
+		}(rec)
+	}
+	wg.Wait()
+	db, _ := sql.Open("sqlite3", filepath.Join(dir, "primary.db"))
+	defer db.Close()
+	r := repo.NewMySQLLoginRepo(repo.NewCluster(db, nil, repo.HealthCheckInterval(time.Hour)), nil)
+	report, err := audit.Verify(r)
+	assert.NoError(t, err)
+	assert.Equal(t, 60, report.Events)
+
+	// A log without a head, such as one recorded before audit_chain, goes
+	// on from its last event.
+	_, err = db.Exec("DELETE FROM audit_chain;")
+	assert.NoError(t, err)
+	e, err := recs[0].Record(context.Background(), repo.AuditEvent{Type: audit.TypeLogin, Subject: "bo"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(61), e.Seq)
+	_, err = audit.Verify(r)
+	assert.NoError(t, err)
+}
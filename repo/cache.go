@@ -0,0 +1,419 @@
+package repo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"loginsvc/pkg/invalidation"
+)
+
+// CachingLoginRepository is a LoginRepository that keeps recently read users
+// in memory. An entry is dropped when its ttl elapses or when an
+// invalidation for the user arrives on the bus, whichever comes first, so
+// the ttl is also the upper bound on staleness if the bus loses a message.
+type CachingLoginRepository struct {
+	next        LoginRepository
+	bus         invalidation.Bus
+	unsubscribe func()
+
+	sids *ttlCache
+}
+
+// NewCachingLoginRepository wraps next with a cache subscribed to the user
+// topic of bus.
+func NewCachingLoginRepository(next LoginRepository, bus invalidation.Bus, ttl time.Duration) *CachingLoginRepository {
+	r := &CachingLoginRepository{
+		next: next,
+		bus:  bus,
+		sids: newTTLCache(ttl),
+	}
+	r.unsubscribe = bus.Subscribe(invalidation.TopicUser, r.sids.evict)
+	return r
+}
+
+func (r *CachingLoginRepository) Name(n string) (string, error) {
+	sid, generation, ok := r.sids.get(n)
+	if ok {
+		return sid.(string), nil
+	}
+
+	fresh, err := r.next.Name(n)
+	if err != nil {
+		return "", err
+	}
+	r.sids.fill(n, fresh, generation)
+	return fresh, nil
+}
+
+// User reads through to the wrapped repository; only sids are cached.
+func (r *CachingLoginRepository) User(n string) (User, error) {
+	return r.next.User(n)
+}
+
+func (r *CachingLoginRepository) UserByEmail(email string) (User, error) {
+	return r.next.UserByEmail(email)
+}
+
+// Register writes through to the wrapped repository and invalidates the user
+// everywhere.
+func (r *CachingLoginRepository) Register(u User) error {
+	if err := r.next.Register(u); err != nil {
+		return err
+	}
+	return r.Invalidate(context.Background(), u.Name)
+}
+
+// UpdateUser writes through to the wrapped repository and invalidates the
+// user everywhere.
+func (r *CachingLoginRepository) UpdateUser(u User) error {
+	if err := r.next.UpdateUser(u); err != nil {
+		return err
+	}
+	return r.Invalidate(context.Background(), u.Name)
+}
+
+// SetPasswordHash writes through to the wrapped repository and invalidates
+// the user everywhere.
+func (r *CachingLoginRepository) SetPasswordHash(n, hash string) error {
+	if err := r.next.SetPasswordHash(n, hash); err != nil {
+		return err
+	}
+	return r.Invalidate(context.Background(), n)
+}
+
+// Invalidate evicts the user n on this replica and on every other replica
+// sharing the bus. Call it after any write to the user.
+func (r *CachingLoginRepository) Invalidate(ctx context.Context, n string) error {
+	return r.bus.Publish(ctx, invalidation.TopicUser, n)
+}
+
+// Close detaches the cache from the bus.
+func (r *CachingLoginRepository) Close() {
+	r.unsubscribe()
+}
+
+// CachingRoleRepository is a RoleRepository that keeps the permissions of
+// recently checked users in memory, under the same rules as
+// CachingLoginRepository. Assigning or unassigning a role invalidates the
+// user; any change to a role invalidates every user.
+type CachingRoleRepository struct {
+	next        RoleRepository
+	bus         invalidation.Bus
+	unsubscribe func()
+
+	permissions *ttlCache
+}
+
+// NewCachingRoleRepository wraps next with a cache subscribed to the role
+// topic of bus.
+func NewCachingRoleRepository(next RoleRepository, bus invalidation.Bus, ttl time.Duration) *CachingRoleRepository {
+	r := &CachingRoleRepository{
+		next:        next,
+		bus:         bus,
+		permissions: newTTLCache(ttl),
+	}
+	r.unsubscribe = bus.Subscribe(invalidation.TopicRole, r.permissions.evict)
+	return r
+}
+
+// UserPermissions is served from the cache when possible.
+func (r *CachingRoleRepository) UserPermissions(user string) ([]Permission, error) {
+	perms, generation, ok := r.permissions.get(user)
+	if ok {
+		return perms.([]Permission), nil
+	}
+
+	fresh, err := r.next.UserPermissions(user)
+	if err != nil {
+		return nil, err
+	}
+	r.permissions.fill(user, fresh, generation)
+	return fresh, nil
+}
+
+func (r *CachingRoleRepository) Roles() ([]Role, error) {
+	return r.next.Roles()
+}
+
+func (r *CachingRoleRepository) UserRoles(user string) ([]string, error) {
+	return r.next.UserRoles(user)
+}
+
+func (r *CachingRoleRepository) CreateRole(name string) error {
+	return r.next.CreateRole(name)
+}
+
+// DeleteRole writes through and invalidates every user.
+func (r *CachingRoleRepository) DeleteRole(name string) error {
+	if err := r.next.DeleteRole(name); err != nil {
+		return err
+	}
+	return r.Invalidate(context.Background(), "")
+}
+
+// Grant writes through and invalidates every user.
+func (r *CachingRoleRepository) Grant(role string, p Permission) error {
+	if err := r.next.Grant(role, p); err != nil {
+		return err
+	}
+	return r.Invalidate(context.Background(), "")
+}
+
+// Revoke writes through and invalidates every user.
+func (r *CachingRoleRepository) Revoke(role string, p Permission) error {
+	if err := r.next.Revoke(role, p); err != nil {
+		return err
+	}
+	return r.Invalidate(context.Background(), "")
+}
+
+// AssignRole writes through and invalidates the user.
+func (r *CachingRoleRepository) AssignRole(user, role string) error {
+	if err := r.next.AssignRole(user, role); err != nil {
+		return err
+	}
+	return r.Invalidate(context.Background(), user)
+}
+
+// UnassignRole writes through and invalidates the user.
+func (r *CachingRoleRepository) UnassignRole(user, role string) error {
+	if err := r.next.UnassignRole(user, role); err != nil {
+		return err
+	}
+	return r.Invalidate(context.Background(), user)
+}
+
+// Invalidate evicts the permissions of user, or of every user if user is
+// empty, on this replica and on every other replica sharing the bus. Call
+// it after writing to the role tables behind the cache's back.
+func (r *CachingRoleRepository) Invalidate(ctx context.Context, user string) error {
+	return r.bus.Publish(ctx, invalidation.TopicRole, user)
+}
+
+// Close detaches the cache from the bus.
+func (r *CachingRoleRepository) Close() {
+	r.unsubscribe()
+}
+
+// ttlCache holds the values of the caching repositories for ttl, unless
+// an invalidation evicts them first.
+type ttlCache struct {
+	ttl time.Duration
+
+	mtx sync.Mutex
+	// generation counts evictions. A value read while one arrived may be
+	// older than the eviction, so fill drops it.
+	generation uint64
+	entries    map[string]ttlEntry
+	swept      time.Time
+}
+
+type ttlEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newTTLCache(ttl time.Duration) *ttlCache {
+	return &ttlCache{ttl: ttl, entries: map[string]ttlEntry{}, swept: time.Now()}
+}
+
+// get returns the value of key if it hasn't expired, and otherwise the
+// generation to fill it with once read.
+func (c *ttlCache) get(key string) (value interface{}, generation uint64, ok bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	e, ok := c.entries[key]
+	if ok && time.Now().Before(e.expires) {
+		return e.value, c.generation, true
+	}
+	delete(c.entries, key)
+	return nil, c.generation, false
+}
+
+// fill stores value for key, unless an eviction arrived since get returned
+// generation. It also drops every expired entry once per ttl, so that
+// entries no longer read don't pile up.
+func (c *ttlCache) fill(key string, value interface{}, generation uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if generation != c.generation {
+		return
+	}
+	now := time.Now()
+	if now.Sub(c.swept) >= c.ttl {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.swept = now
+	}
+	c.entries[key] = ttlEntry{value: value, expires: now.Add(c.ttl)}
+}
+
+// evict drops the entry of the message's key, or every entry if it has
+// none.
+func (c *ttlCache) evict(m invalidation.Message) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.generation++
+	if m.Key == "" {
+		c.entries = map[string]ttlEntry{}
+		return
+	}
+	delete(c.entries, m.Key)
+}
+
+// CachingGroupRepository is a GroupRepository whose writes invalidate the
+// permissions cached by a CachingRoleRepository. Changing the members of a
+// group invalidates the user; any other change to a group invalidates
+// every user.
+type CachingGroupRepository struct {
+	next  GroupRepository
+	roles *CachingRoleRepository
+}
+
+// NewCachingGroupRepository wraps next, invalidating roles on writes.
+func NewCachingGroupRepository(next GroupRepository, roles *CachingRoleRepository) *CachingGroupRepository {
+	return &CachingGroupRepository{next: next, roles: roles}
+}
+
+func (r *CachingGroupRepository) CreateGroup(name string) error {
+	return r.next.CreateGroup(name)
+}
+
+// DeleteGroup writes through and invalidates every user.
+func (r *CachingGroupRepository) DeleteGroup(name string) error {
+	return r.invalidate(r.next.DeleteGroup(name), "")
+}
+
+func (r *CachingGroupRepository) Groups() ([]Group, error) {
+	return r.next.Groups()
+}
+
+// AddMember writes through and invalidates the user.
+func (r *CachingGroupRepository) AddMember(group, user string) error {
+	return r.invalidate(r.next.AddMember(group, user), user)
+}
+
+// RemoveMember writes through and invalidates the user.
+func (r *CachingGroupRepository) RemoveMember(group, user string) error {
+	return r.invalidate(r.next.RemoveMember(group, user), user)
+}
+
+func (r *CachingGroupRepository) Members(group string) ([]string, error) {
+	return r.next.Members(group)
+}
+
+// Nest writes through and invalidates every user.
+func (r *CachingGroupRepository) Nest(group, parent string) error {
+	return r.invalidate(r.next.Nest(group, parent), "")
+}
+
+// Unnest writes through and invalidates every user.
+func (r *CachingGroupRepository) Unnest(group, parent string) error {
+	return r.invalidate(r.next.Unnest(group, parent), "")
+}
+
+// AssignGroupRole writes through and invalidates every user.
+func (r *CachingGroupRepository) AssignGroupRole(group, role string) error {
+	return r.invalidate(r.next.AssignGroupRole(group, role), "")
+}
+
+// UnassignGroupRole writes through and invalidates every user.
+func (r *CachingGroupRepository) UnassignGroupRole(group, role string) error {
+	return r.invalidate(r.next.UnassignGroupRole(group, role), "")
+}
+
+func (r *CachingGroupRepository) EffectiveRoles(user string) ([]string, error) {
+	return r.next.EffectiveRoles(user)
+}
+
+// invalidate invalidates user, or every user, unless the write failed.
+func (r *CachingGroupRepository) invalidate(err error, user string) error {
+	if err != nil {
+		return err
+	}
+	return r.roles.Invalidate(context.Background(), user)
+}
+
+// CachingUserAdmin is a UserAdmin whose writes invalidate the users and
+// permissions cached by a CachingLoginRepository and a
+// CachingRoleRepository. Only ChangePassword and DeleteUser change what
+// they cache.
+type CachingUserAdmin struct {
+	next  UserAdmin
+	users *CachingLoginRepository
+	roles *CachingRoleRepository
+}
+
+// NewCachingUserAdmin wraps next, invalidating users and roles on writes.
+func NewCachingUserAdmin(next UserAdmin, users *CachingLoginRepository, roles *CachingRoleRepository) *CachingUserAdmin {
+	return &CachingUserAdmin{next: next, users: users, roles: roles}
+}
+
+func (r *CachingUserAdmin) ListUsers(q UserQuery) (UserPage, error) {
+	return r.next.ListUsers(q)
+}
+
+func (r *CachingUserAdmin) SetStatus(n string, s Status) error {
+	return r.next.SetStatus(n, s)
+}
+
+func (r *CachingUserAdmin) RevokeSessions(n string, at time.Time) error {
+	return r.next.RevokeSessions(n, at)
+}
+
+// ChangePassword writes through and invalidates the user.
+func (r *CachingUserAdmin) ChangePassword(n, hash string) error {
+	if err := r.next.ChangePassword(n, hash); err != nil {
+		return err
+	}
+	return r.users.Invalidate(context.Background(), n)
+}
+
+// DeleteUser writes through and invalidates the user and its permissions.
+func (r *CachingUserAdmin) DeleteUser(n string) error {
+	if err := r.next.DeleteUser(n); err != nil {
+		return err
+	}
+	if err := r.users.Invalidate(context.Background(), n); err != nil {
+		return err
+	}
+	return r.roles.Invalidate(context.Background(), n)
+}
+
+// CachingBulkRepository is a BulkRepository whose imports invalidate the
+// users cached by a CachingLoginRepository.
+type CachingBulkRepository struct {
+	next  BulkRepository
+	users *CachingLoginRepository
+}
+
+// NewCachingBulkRepository wraps next, invalidating users on imports.
+func NewCachingBulkRepository(next BulkRepository, users *CachingLoginRepository) *CachingBulkRepository {
+	return &CachingBulkRepository{next: next, users: users}
+}
+
+// ImportUsers writes through and, unless dryRun, invalidates every user it
+// created or updated. The users are imported even if an invalidation fails.
+func (r *CachingBulkRepository) ImportUsers(users []User, policy ConflictPolicy, dryRun bool) ([]ImportResult, error) {
+	results, err := r.next.ImportUsers(users, policy, dryRun)
+	if err != nil || dryRun {
+		return results, err
+	}
+	for i, result := range results {
+		if result != Created && result != Updated {
+			continue
+		}
+		if err := r.users.Invalidate(context.Background(), users[i].Name); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+func (r *CachingBulkRepository) ExportUsers(fn func(User) error) error {
+	return r.next.ExportUsers(fn)
+}
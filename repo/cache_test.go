@@ -0,0 +1,137 @@
+package repo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"loginsvc/pkg/invalidation"
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingRepo struct {
+	repo.LoginRepository
+	sids  map[string]string
+	reads int
+}
+
+func (r *countingRepo) Name(n string) (string, error) {
+	r.reads++
+	return r.sids[n], nil
+}
+
+func (r *countingRepo) Register(u repo.User) error {
+	r.sids[u.Name] = u.SID
+	return nil
+}
+
+func TestCacheEvictsOnInvalidation(t *testing.T) {
+	bus := invalidation.NewInProcBus()
+	backend := &countingRepo{sids: map[string]string{"ed": "a123456789"}}
+	replica1 := repo.NewCachingLoginRepository(backend, bus, time.Hour)
+	replica2 := repo.NewCachingLoginRepository(backend, bus, time.Hour)
+
+	replica1.Name("ed")
+	replica2.Name("ed")
+	replica2.Name("ed")
+	assert.Equal(t, 2, backend.reads)
+
+	backend.sids["ed"] = "b987654321"
+	replica1.Invalidate(context.Background(), "ed")
+
+	sid, err := replica2.Name("ed")
+	assert.NoError(t, err)
+	assert.Equal(t, "b987654321", sid)
+	assert.Equal(t, 3, backend.reads)
+}
+
+func TestCacheExpiresEntries(t *testing.T) {
+	backend := &countingRepo{sids: map[string]string{"ed": "a123456789"}}
+	cache := repo.NewCachingLoginRepository(backend, invalidation.NewInProcBus(), time.Millisecond)
+	cache.Name("ed")
+	time.Sleep(5 * time.Millisecond)
+	cache.Name("ed")
+	assert.Equal(t, 2, backend.reads)
+}
+
+// racingRepo runs during after reading a sid, as if a write and its
+// invalidation came while the read was on its way back.
+type racingRepo struct {
+	*countingRepo
+	during func()
+}
+
+func (r *racingRepo) Name(n string) (string, error) {
+	sid, err := r.countingRepo.Name(n)
+	if r.during != nil {
+		r.during()
+		r.during = nil
+	}
+	return sid, err
+}
+
+func TestCacheDropsReadsRacingInvalidation(t *testing.T) {
+	bus := invalidation.NewInProcBus()
+	backend := &racingRepo{countingRepo: &countingRepo{sids: map[string]string{"ed": "a123456789"}}}
+	cache := repo.NewCachingLoginRepository(backend, bus, time.Hour)
+	backend.during = func() {
+		backend.sids["ed"] = "b987654321"
+		cache.Invalidate(context.Background(), "ed")
+	}
+
+	sid, _ := cache.Name("ed")
+	assert.Equal(t, "a123456789", sid)
+	sid, _ = cache.Name("ed")
+	assert.Equal(t, "b987654321", sid, "the stale read must not be cached")
+	cache.Name("ed")
+	assert.Equal(t, 2, backend.reads)
+}
+
+func TestCacheRegisterInvalidates(t *testing.T) {
+	bus := invalidation.NewInProcBus()
+	backend := &countingRepo{sids: map[string]string{}}
+	replica1 := repo.NewCachingLoginRepository(backend, bus, time.Hour)
+	replica2 := repo.NewCachingLoginRepository(backend, bus, time.Hour)
+
+	replica2.Name("al")
+	assert.NoError(t, replica1.Register(repo.User{Name: "al", SID: "c111111111"}))
+	sid, _ := replica2.Name("al")
+	assert.Equal(t, "c111111111", sid)
+}
+
+// importingRepo overwrites the users of a countingRepo.
+type importingRepo struct {
+	repo.BulkRepository
+	users *countingRepo
+}
+
+func (r importingRepo) ImportUsers(users []repo.User, policy repo.ConflictPolicy, dryRun bool) ([]repo.ImportResult, error) {
+	results := make([]repo.ImportResult, len(users))
+	for i, u := range users {
+		results[i] = repo.Updated
+		if !dryRun {
+			r.users.sids[u.Name] = u.SID
+		}
+	}
+	return results, nil
+}
+
+func TestImportInvalidates(t *testing.T) {
+	bus := invalidation.NewInProcBus()
+	backend := &countingRepo{sids: map[string]string{"ed": "a123456789"}}
+	replica := repo.NewCachingLoginRepository(backend, bus, time.Hour)
+	importer := repo.NewCachingBulkRepository(importingRepo{users: backend}, repo.NewCachingLoginRepository(nil, bus, 0))
+
+	replica.Name("ed")
+	_, err := importer.ImportUsers([]repo.User{{Name: "ed", SID: "b987654321"}}, repo.ConflictOverwrite, true)
+	assert.NoError(t, err)
+	replica.Name("ed")
+	assert.Equal(t, 1, backend.reads, "a dry run must not invalidate")
+
+	_, err = importer.ImportUsers([]repo.User{{Name: "ed", SID: "b987654321"}}, repo.ConflictOverwrite, false)
+	assert.NoError(t, err)
+	sid, _ := replica.Name("ed")
+	assert.Equal(t, "b987654321", sid)
+}
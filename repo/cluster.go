@@ -0,0 +1,218 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+)
+
+const (
+	defaultHealthCheckInterval  = 5 * time.Second
+	defaultReadYourWritesWindow = 5 * time.Second
+	healthCheckTimeout          = time.Second
+)
+
+// Cluster routes queries between a primary database and its read replicas.
+// Reads are spread round-robin over the replicas that passed their last
+// health check and fall back to the primary when none did. Writes always go
+// to the primary, and so do reads of a key written within the
+// read-your-writes window, because the replicas may not have caught up yet.
+type Cluster struct {
+	primary *pool
+	readers []*pool
+	next    uint32
+
+	interval time.Duration
+	window   time.Duration
+	logger   log.Logger
+
+	queries   metrics.Counter
+	up        metrics.Gauge
+	openConns metrics.Gauge
+
+	mtx    sync.Mutex
+	recent map[string]time.Time
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+type pool struct {
+	name    string
+	db      *sql.DB
+	healthy int32
+}
+
+// ClusterOption sets an optional parameter for clusters.
+type ClusterOption func(*Cluster)
+
+// HealthCheckInterval sets how often the replicas are pinged. Defaults to 5s.
+func HealthCheckInterval(d time.Duration) ClusterOption {
+	return func(c *Cluster) { c.interval = d }
+}
+
+// ReadYourWritesWindow sets how long reads of a freshly written key are
+// pinned to the primary. It should exceed the replication lag. Defaults to
+// 5s.
+func ReadYourWritesWindow(d time.Duration) ClusterOption {
+	return func(c *Cluster) { c.window = d }
+}
+
+// ClusterLogger logs replica health transitions.
+func ClusterLogger(logger log.Logger) ClusterOption {
+	return func(c *Cluster) { c.logger = logger }
+}
+
+// ClusterInstrumentation records, per pool, the number of queries routed to
+// it (labels "pool" and "op"), whether it is healthy (label "pool") and its
+// open connections (label "pool").
+func ClusterInstrumentation(queries metrics.Counter, up, openConns metrics.Gauge) ClusterOption {
+	return func(c *Cluster) {
+		c.queries, c.up, c.openConns = queries, up, openConns
+	}
+}
+
+// NewCluster returns a Cluster over primary and readers and starts the
+// background health checks. Every reader starts out healthy.
+func NewCluster(primary *sql.DB, readers []*sql.DB, options ...ClusterOption) *Cluster {
+	c := &Cluster{
+		primary:   &pool{name: "primary", db: primary, healthy: 1},
+		interval:  defaultHealthCheckInterval,
+		window:    defaultReadYourWritesWindow,
+		logger:    log.NewNopLogger(),
+		queries:   discard.NewCounter(),
+		up:        discard.NewGauge(),
+		openConns: discard.NewGauge(),
+		recent:    map[string]time.Time{},
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for i, db := range readers {
+		c.readers = append(c.readers, &pool{name: fmt.Sprintf("reader-%d", i), db: db, healthy: 1})
+	}
+	for _, option := range options {
+		option(c)
+	}
+	for _, p := range c.pools() {
+		c.up.With("pool", p.name).Set(1)
+	}
+	go c.loop()
+	return c
+}
+
+// Reader returns the database to read key from. An empty key is never
+// pinned to the primary.
+func (c *Cluster) Reader(key string) *sql.DB {
+	p := c.reader(key)
+	c.queries.With("pool", p.name, "op", "read").Add(1)
+	return p.db
+}
+
+func (c *Cluster) reader(key string) *pool {
+	if key != "" {
+		c.mtx.Lock()
+		written, ok := c.recent[key]
+		c.mtx.Unlock()
+		if ok && time.Since(written) < c.window {
+			return c.primary
+		}
+	}
+	n := len(c.readers)
+	start := int(atomic.AddUint32(&c.next, 1))
+	for i := 0; i < n; i++ {
+		p := c.readers[(start+i)%n]
+		if atomic.LoadInt32(&p.healthy) == 1 {
+			return p
+		}
+	}
+	return c.primary
+}
+
+// Writer returns the primary and pins subsequent reads of key to it for the
+// read-your-writes window.
+func (c *Cluster) Writer(key string) *sql.DB {
+	c.pin(key)
+	c.queries.With("pool", c.primary.name, "op", "write").Add(1)
+	return c.primary.db
+}
+
+// pin routes reads of key to the primary for the read-your-writes window.
+func (c *Cluster) pin(key string) {
+	if key == "" {
+		return
+	}
+	c.mtx.Lock()
+	c.recent[key] = time.Now()
+	c.mtx.Unlock()
+}
+
+// CheckHealth pings every pool once and updates which readers may serve
+// reads. It is called periodically by the cluster itself.
+func (c *Cluster) CheckHealth(ctx context.Context) {
+	for _, p := range c.pools() {
+		ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		err := p.db.PingContext(ctx)
+		cancel()
+
+		var healthy int32
+		if err == nil {
+			healthy = 1
+		}
+		if atomic.SwapInt32(&p.healthy, healthy) != healthy {
+			c.logger.Log("pool", p.name, "healthy", err == nil, "err", err)
+		}
+		c.up.With("pool", p.name).Set(float64(healthy))
+		c.openConns.With("pool", p.name).Set(float64(p.db.Stats().OpenConnections))
+	}
+
+	c.mtx.Lock()
+	for key, written := range c.recent {
+		if time.Since(written) >= c.window {
+			delete(c.recent, key)
+		}
+	}
+	c.mtx.Unlock()
+}
+
+// Ping pings the primary.
+func (c *Cluster) Ping(ctx context.Context) error {
+	return c.primary.db.PingContext(ctx)
+}
+
+// Close stops the health checks and closes every database.
+func (c *Cluster) Close() error {
+	close(c.quit)
+	<-c.done
+	var err error
+	for _, p := range c.pools() {
+		if cerr := p.db.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (c *Cluster) loop() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.CheckHealth(context.Background())
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+func (c *Cluster) pools() []*pool {
+	return append([]*pool{c.primary}, c.readers...)
+}
@@ -0,0 +1,108 @@
+package repo_test
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newSqliteFile loads the schema into a fresh SQLite file under dir. Each
+// file stands in for one MySQL server; giving ed a different sid in each
+// tells which server answered.
+func newSqliteFile(t *testing.T, dir, name, sid string) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(dir, name+".db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := ioutil.ReadFile("../sqlite.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE users SET sid = ? WHERE name = 'ed';", sid); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestCluster(t *testing.T) (*repo.Cluster, []*sql.DB) {
+	dir := t.TempDir()
+	primary := newSqliteFile(t, dir, "primary", "primary")
+	readers := []*sql.DB{
+		newSqliteFile(t, dir, "reader0", "reader0"),
+		newSqliteFile(t, dir, "reader1", "reader1"),
+	}
+	c := repo.NewCluster(primary, readers, repo.HealthCheckInterval(time.Hour), repo.ReadYourWritesWindow(time.Hour))
+	t.Cleanup(func() { c.Close() })
+	return c, readers
+}
+
+func TestClusterSpreadsReadsAcrossReaders(t *testing.T) {
+	c, _ := newTestCluster(t)
+	r := repo.NewMySQLLoginRepo(c, nil)
+
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		sid, err := r.Name("ed")
+		assert.NoError(t, err)
+		seen[sid]++
+	}
+	assert.Equal(t, map[string]int{"reader0": 2, "reader1": 2}, seen)
+}
+
+func TestClusterSkipsUnhealthyReaders(t *testing.T) {
+	c, readers := newTestCluster(t)
+	r := repo.NewMySQLLoginRepo(c, nil)
+
+	readers[0].Close()
+	c.CheckHealth(context.Background())
+	for i := 0; i < 3; i++ {
+		sid, err := r.Name("ed")
+		assert.NoError(t, err)
+		assert.Equal(t, "reader1", sid)
+	}
+
+	readers[1].Close()
+	c.CheckHealth(context.Background())
+	sid, err := r.Name("ed")
+	assert.NoError(t, err)
+	assert.Equal(t, "primary", sid)
+}
+
+func TestClusterReadsYourWritesFromPrimary(t *testing.T) {
+	c, _ := newTestCluster(t)
+	r := repo.NewMySQLLoginRepo(c, nil)
+
+	// The readers never see al: only the primary can answer.
+	assert.NoError(t, r.Register(repo.User{Name: "al", SID: "c111111111"}))
+	sid, err := r.Name("al")
+	assert.NoError(t, err)
+	assert.Equal(t, "c111111111", sid)
+
+	sid, err = r.Name("ed")
+	assert.NoError(t, err)
+	assert.NotEqual(t, "primary", sid)
+}
+
+func TestClusterReadYourWritesWindowExpires(t *testing.T) {
+	dir := t.TempDir()
+	primary := newSqliteFile(t, dir, "primary", "primary")
+	reader := newSqliteFile(t, dir, "reader0", "reader0")
+	c := repo.NewCluster(primary, []*sql.DB{reader}, repo.HealthCheckInterval(time.Hour), repo.ReadYourWritesWindow(time.Millisecond))
+	defer c.Close()
+	r := repo.NewMySQLLoginRepo(c, nil)
+
+	assert.NoError(t, r.Register(repo.User{Name: "al", SID: "c111111111"}))
+	time.Sleep(5 * time.Millisecond)
+	_, err := r.Name("al")
+	assert.Equal(t, sql.ErrNoRows, err)
+}
@@ -0,0 +1,147 @@
+package repo
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"loginsvc/pkg/envelope"
+)
+
+// ErrNoKeyring is returned when a user with personal data is written but no
+// keyring is configured. Such data is never stored in plaintext.
+var ErrNoKeyring = errors.New("repo: no keyring configured for encrypted columns")
+
+// cryptField describes a User field tagged `crypt`.
+type cryptField struct {
+	index  int
+	column string
+	blind  bool
+}
+
+var userCryptFields = cryptFields(reflect.TypeOf(User{}))
+
+func cryptFields(t reflect.Type) []cryptField {
+	var fields []cryptField
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("crypt")
+		if !ok {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		f := cryptField{index: i, column: parts[0]}
+		for _, option := range parts[1:] {
+			if option == "index" {
+				f.blind = true
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// normalize is applied to values before computing their blind index, so
+// lookups are insensitive to case and surrounding blanks.
+func normalize(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// blindIndex returns the blind index of value, or "" for an empty value.
+func blindIndex(k *envelope.Keyring, value string) string {
+	if value == "" || k == nil {
+		return ""
+	}
+	return k.BlindIndex(normalize(value))
+}
+
+// prefixIndexes returns the blind indexes of the prefixes of value, itself
+// included, or none for an empty value. Like the blind index of a whole
+// value, they tell which users share a prefix, and nothing else.
+func prefixIndexes(k *envelope.Keyring, value string) []string {
+	value = normalize(value)
+	if value == "" || k == nil {
+		return nil
+	}
+	var indexes []string
+	for i := range value {
+		if i > 0 {
+			indexes = append(indexes, k.BlindIndex(value[:i]))
+		}
+	}
+	return append(indexes, k.BlindIndex(value))
+}
+
+// fieldAAD returns the additional data that binds the value of column to
+// the user name of tenant, so that a value copied to another row, column or
+// tenant no longer decrypts.
+func fieldAAD(tenant, name, column string) []byte {
+	return []byte(column + "\x00" + tenant + "\x00" + name)
+}
+
+// sealUser returns a copy of u, a user of tenant, with its tagged fields
+// encrypted, and the blind indexes of the indexed ones keyed by column.
+// Empty fields are left empty.
+func sealUser(k *envelope.Keyring, tenant string, u User) (User, map[string]string, error) {
+	v := reflect.ValueOf(&u).Elem()
+	indexes := map[string]string{}
+	for _, f := range userCryptFields {
+		field := v.Field(f.index)
+		plaintext := field.String()
+		if f.blind {
+			indexes[f.column] = blindIndex(k, plaintext)
+		}
+		if plaintext == "" {
+			continue
+		}
+		if k == nil {
+			return User{}, nil, ErrNoKeyring
+		}
+		ciphertext, err := k.Encrypt([]byte(plaintext), fieldAAD(tenant, u.Name, f.column))
+		if err != nil {
+			return User{}, nil, err
+		}
+		field.SetString(ciphertext)
+	}
+	return u, indexes, nil
+}
+
+// openUser returns a copy of u, a user of tenant, with its tagged fields
+// decrypted.
+func openUser(k *envelope.Keyring, tenant string, u User) (User, error) {
+	v := reflect.ValueOf(&u).Elem()
+	for _, f := range userCryptFields {
+		field := v.Field(f.index)
+		if field.String() == "" {
+			continue
+		}
+		if k == nil {
+			return User{}, ErrNoKeyring
+		}
+		plaintext, err := k.Decrypt(field.String(), fieldAAD(tenant, u.Name, f.column))
+		if err != nil {
+			return User{}, err
+		}
+		field.SetString(string(plaintext))
+	}
+	return u, nil
+}
+
+// rewrapUser rewraps the data keys of u's tagged fields with the primary
+// master key and reports whether anything changed.
+func rewrapUser(k *envelope.Keyring, u *User) (bool, error) {
+	v := reflect.ValueOf(u).Elem()
+	changed := false
+	for _, f := range userCryptFields {
+		field := v.Field(f.index)
+		if !k.NeedsRewrap(field.String()) {
+			continue
+		}
+		ciphertext, err := k.Rewrap(field.String())
+		if err != nil {
+			return false, err
+		}
+		field.SetString(ciphertext)
+		changed = true
+	}
+	return changed, nil
+}
@@ -0,0 +1,97 @@
+package repo_test
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"loginsvc/pkg/envelope"
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newEncryptedRepo(t *testing.T, k *envelope.Keyring) (*repo.MySQLLoginRepo, *sql.DB) {
+	db := newSqliteFile(t, t.TempDir(), "primary", "a123456789")
+	c := repo.NewCluster(db, nil, repo.HealthCheckInterval(time.Hour))
+	t.Cleanup(func() { c.Close() })
+	return repo.NewMySQLLoginRepo(c, k), db
+}
+
+func TestEncryptedColumns(t *testing.T) {
+	k, _ := envelope.NewKeyring("k1", map[string][]byte{"k1": envelope.GenerateKey()}, envelope.GenerateKey())
+	r, db := newEncryptedRepo(t, k)
+
+	al := repo.User{Name: "al", SID: "c111111111", Email: "Al@Example.com", Phone: "+886912345678", TOTPSecret: "JBSWY3DPEHPK3PXP", Status: repo.StatusActive, Created: time.Unix(1600000000, 0)}
+	assert.NoError(t, r.Register(al))
+
+	var email, phone, secret string
+	db.QueryRow("SELECT email, phone, totp_secret FROM users WHERE name = 'al';").Scan(&email, &phone, &secret)
+	for _, stored := range []string{email, phone, secret} {
+		assert.True(t, envelope.IsEncrypted(stored), stored)
+	}
+	assert.False(t, strings.Contains(email, "Example"))
+
+	u, err := r.User("al")
+	assert.NoError(t, err)
+	assert.Equal(t, al, u)
+
+	u, err = r.UserByEmail(" al@example.COM")
+	assert.NoError(t, err)
+	assert.Equal(t, "al", u.Name)
+
+	al.Email = "al@example.org"
+	assert.NoError(t, r.UpdateUser(al))
+	_, err = r.UserByEmail("al@example.com")
+	assert.Equal(t, sql.ErrNoRows, err)
+	u, err = r.UserByEmail("al@example.org")
+	assert.NoError(t, err)
+	assert.Equal(t, al, u)
+}
+
+func TestEncryptedColumnsBoundToUser(t *testing.T) {
+	k, _ := envelope.NewKeyring("k1", map[string][]byte{"k1": envelope.GenerateKey()}, envelope.GenerateKey())
+	r, db := newEncryptedRepo(t, k)
+	assert.NoError(t, r.Register(repo.User{Name: "al", SID: "c111111111", Phone: "+886912345678"}))
+	assert.NoError(t, r.Register(repo.User{Name: "bo", SID: "d222222222", Phone: "+886987654321"}))
+	assert.NoError(t, r.ForTenant("acme", k).Register(repo.User{Name: "al", SID: "e444444444", Phone: "+886911111111"}))
+
+	// A value copied to another user, column or tenant doesn't open.
+	db.Exec("UPDATE users SET phone = (SELECT phone FROM users WHERE name = 'al' AND tenant_id = 'default') WHERE name = 'bo';")
+	_, err := r.User("bo")
+	assert.Error(t, err)
+	db.Exec("UPDATE users SET totp_secret = phone WHERE name = 'al' AND tenant_id = 'default';")
+	_, err = r.User("al")
+	assert.Error(t, err)
+	db.Exec("UPDATE users SET totp_secret = '', phone = (SELECT phone FROM users WHERE name = 'al' AND tenant_id = 'default') WHERE name = 'al' AND tenant_id = 'acme';")
+	_, err = r.ForTenant("acme", k).User("al")
+	assert.Error(t, err)
+}
+
+func TestEncryptedColumnsRequireKeyring(t *testing.T) {
+	r, _ := newEncryptedRepo(t, nil)
+	assert.NoError(t, r.Register(repo.User{Name: "al", SID: "c111111111"}))
+	assert.Equal(t, repo.ErrNoKeyring, r.Register(repo.User{Name: "bo", SID: "d222222222", Email: "bo@example.com"}))
+}
+
+func TestRewrapAfterRotation(t *testing.T) {
+	k1, k2, index := envelope.GenerateKey(), envelope.GenerateKey(), envelope.GenerateKey()
+	old, _ := envelope.NewKeyring("k1", map[string][]byte{"k1": k1}, index)
+	r, db := newEncryptedRepo(t, old)
+	al := repo.User{Name: "al", SID: "c111111111", Email: "al@example.com", Status: repo.StatusActive, Created: time.Unix(1600000000, 0)}
+	assert.NoError(t, r.Register(al))
+
+	rotated, _ := envelope.NewKeyring("k2", map[string][]byte{"k1": k1, "k2": k2}, index)
+	c := repo.NewCluster(db, nil, repo.HealthCheckInterval(time.Hour))
+	r = repo.NewMySQLLoginRepo(c, rotated)
+	n, err := r.Rewrap()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	retired, _ := envelope.NewKeyring("k2", map[string][]byte{"k2": k2}, index)
+	r = repo.NewMySQLLoginRepo(c, retired)
+	u, err := r.UserByEmail("al@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, al, u)
+}
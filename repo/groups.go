@@ -0,0 +1,327 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var (
+	// ErrUnknownGroup is returned when adding a member to, nesting, or
+	// assigning a role to a group that doesn't exist.
+	ErrUnknownGroup = errors.New("repo: unknown group")
+	// ErrGroupCycle is returned when nesting a group would make it a member
+	// of itself.
+	ErrGroupCycle = errors.New("repo: group cycle")
+)
+
+// Group is a named set of users and of other groups, its members, which
+// inherit the roles of the group and of the groups it is nested in.
+type Group struct {
+	Name string
+	// Parents are the groups this group is nested in.
+	Parents []string
+	Roles   []string
+}
+
+// GroupRepository is implemented by repositories that also store groups.
+// Like those of RoleRepository, writes are idempotent.
+type GroupRepository interface {
+	CreateGroup(name string) error
+	// DeleteGroup deletes a group, its memberships, nestings and roles.
+	// Its members lose the roles they inherited through it.
+	DeleteGroup(name string) error
+	// Groups returns every group with its parents and roles, by name.
+	Groups() ([]Group, error)
+
+	// AddMember adds the user named user to a group. It returns
+	// sql.ErrNoRows if there is no such user.
+	AddMember(group, user string) error
+	RemoveMember(group, user string) error
+	// Members returns the names of the users in a group directly.
+	Members(group string) ([]string, error)
+
+	// Nest makes group a member of parent. It returns ErrGroupCycle if
+	// parent is group or already one of its members, at any depth.
+	Nest(group, parent string) error
+	Unnest(group, parent string) error
+
+	AssignGroupRole(group, role string) error
+	UnassignGroupRole(group, role string) error
+
+	// EffectiveRoles returns the names of the roles of a user, assigned
+	// directly or through the groups the user is a member of, at any depth.
+	EffectiveRoles(user string) ([]string, error)
+}
+
+// effectiveRoles is a common table expression of the effective roles of a
+// user, whose parameters are bound by effectiveRolesArgs. The groups of the
+// user are resolved with a recursive query, which takes MySQL 8 or SQLite
+// 3.8.3. UNION drops the groups already visited, so the query ends even if
+// concurrent nestings slipped a cycle past Nest.
+const effectiveRoles = "WITH RECURSIVE member_of (name) AS (" +
+	"SELECT group_name FROM group_members WHERE tenant_id = ? AND user_name = ? " +
+	"UNION SELECT p.parent FROM group_parents p JOIN member_of m ON p.group_name = m.name WHERE p.tenant_id = ?" +
+	"), effective_roles (role) AS (" +
+	"SELECT role FROM user_roles WHERE tenant_id = ? AND user_name = ? " +
+	"UNION SELECT g.role FROM group_roles g JOIN member_of m ON g.group_name = m.name WHERE g.tenant_id = ?" +
+	") "
+
+// effectiveRolesArgs returns the arguments of effectiveRoles for user,
+// followed by more.
+func (repo *sqlLoginRepo) effectiveRolesArgs(user string, more ...interface{}) []interface{} {
+	args := []interface{}{repo.tenant, user, repo.tenant, repo.tenant, user, repo.tenant}
+	return append(args, more...)
+}
+
+// CreateGroup implements GroupRepository.
+func (repo *sqlLoginRepo) CreateGroup(name string) error {
+	tx, err := repo.cluster.Writer("").Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM usergroups WHERE tenant_id = ? AND name = ?;", repo.tenant, name).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	if _, err := tx.Exec("INSERT INTO usergroups (tenant_id, name) VALUES (?, ?);", repo.tenant, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteGroup implements GroupRepository.
+func (repo *sqlLoginRepo) DeleteGroup(name string) error {
+	tx, err := repo.cluster.Writer("").Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, query := range []string{
+		"DELETE FROM group_members WHERE tenant_id = ? AND group_name = ?;",
+		"DELETE FROM group_parents WHERE tenant_id = ? AND group_name = ?;",
+		"DELETE FROM group_parents WHERE tenant_id = ? AND parent = ?;",
+		"DELETE FROM group_roles WHERE tenant_id = ? AND group_name = ?;",
+		"DELETE FROM usergroups WHERE tenant_id = ? AND name = ?;",
+	} {
+		if _, err := tx.Exec(query, repo.tenant, name); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Groups implements GroupRepository.
+func (repo *sqlLoginRepo) Groups() ([]Group, error) {
+	db := repo.cluster.Reader("")
+	rows, err := db.Query("SELECT name FROM usergroups WHERE tenant_id = ? ORDER BY name;", repo.tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var groups []Group
+	index := map[string]int{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		index[name] = len(groups)
+		groups = append(groups, Group{Name: name})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, q := range []struct {
+		query string
+		add   func(g *Group, name string)
+	}{
+		{"SELECT group_name, parent FROM group_parents WHERE tenant_id = ? ORDER BY group_name, parent;", func(g *Group, name string) { g.Parents = append(g.Parents, name) }},
+		{"SELECT group_name, role FROM group_roles WHERE tenant_id = ? ORDER BY group_name, role;", func(g *Group, name string) { g.Roles = append(g.Roles, name) }},
+	} {
+		if err := scanPairs(db, q.query, repo.tenant, func(group, name string) {
+			if i, ok := index[group]; ok {
+				q.add(&groups[i], name)
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// AddMember implements GroupRepository.
+func (repo *sqlLoginRepo) AddMember(group, user string) error {
+	tx, err := repo.cluster.Writer(repo.key(user)).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE tenant_id = ? AND name = ?;", repo.tenant, user).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := groupExists(tx, repo.tenant, group); err != nil {
+		return err
+	}
+	if err := tx.QueryRow("SELECT COUNT(*) FROM group_members WHERE tenant_id = ? AND group_name = ? AND user_name = ?;", repo.tenant, group, user).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	if _, err := tx.Exec("INSERT INTO group_members (tenant_id, group_name, user_name) VALUES (?, ?, ?);", repo.tenant, group, user); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveMember implements GroupRepository.
+func (repo *sqlLoginRepo) RemoveMember(group, user string) error {
+	_, err := repo.cluster.Writer(repo.key(user)).Exec("DELETE FROM group_members WHERE tenant_id = ? AND group_name = ? AND user_name = ?;", repo.tenant, group, user)
+	return err
+}
+
+// Members implements GroupRepository.
+func (repo *sqlLoginRepo) Members(group string) ([]string, error) {
+	return scanNames(repo.cluster.Reader(""), "SELECT user_name FROM group_members WHERE tenant_id = ? AND group_name = ? ORDER BY user_name;", repo.tenant, group)
+}
+
+// Nest implements GroupRepository. The ancestors of parent are read in the
+// transaction that adds the nesting.
+func (repo *sqlLoginRepo) Nest(group, parent string) error {
+	if group == parent {
+		return ErrGroupCycle
+	}
+	tx, err := repo.cluster.Writer("").Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, name := range []string{group, parent} {
+		if err := groupExists(tx, repo.tenant, name); err != nil {
+			return err
+		}
+	}
+	var n int
+	err = tx.QueryRow(
+		"WITH RECURSIVE ancestors (name) AS ("+
+			"SELECT parent FROM group_parents WHERE tenant_id = ? AND group_name = ? "+
+			"UNION SELECT p.parent FROM group_parents p JOIN ancestors a ON p.group_name = a.name WHERE p.tenant_id = ?"+
+			") SELECT COUNT(*) FROM ancestors WHERE name = ?;",
+		repo.tenant, parent, repo.tenant, group,
+	).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrGroupCycle
+	}
+	if err := tx.QueryRow("SELECT COUNT(*) FROM group_parents WHERE tenant_id = ? AND group_name = ? AND parent = ?;", repo.tenant, group, parent).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	if _, err := tx.Exec("INSERT INTO group_parents (tenant_id, group_name, parent) VALUES (?, ?, ?);", repo.tenant, group, parent); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Unnest implements GroupRepository.
+func (repo *sqlLoginRepo) Unnest(group, parent string) error {
+	_, err := repo.cluster.Writer("").Exec("DELETE FROM group_parents WHERE tenant_id = ? AND group_name = ? AND parent = ?;", repo.tenant, group, parent)
+	return err
+}
+
+// AssignGroupRole implements GroupRepository.
+func (repo *sqlLoginRepo) AssignGroupRole(group, role string) error {
+	tx, err := repo.cluster.Writer("").Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := groupExists(tx, repo.tenant, group); err != nil {
+		return err
+	}
+	if err := roleExists(tx, repo.tenant, role); err != nil {
+		return err
+	}
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM group_roles WHERE tenant_id = ? AND group_name = ? AND role = ?;", repo.tenant, group, role).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	if _, err := tx.Exec("INSERT INTO group_roles (tenant_id, group_name, role) VALUES (?, ?, ?);", repo.tenant, group, role); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UnassignGroupRole implements GroupRepository.
+func (repo *sqlLoginRepo) UnassignGroupRole(group, role string) error {
+	_, err := repo.cluster.Writer("").Exec("DELETE FROM group_roles WHERE tenant_id = ? AND group_name = ? AND role = ?;", repo.tenant, group, role)
+	return err
+}
+
+// EffectiveRoles implements GroupRepository, in a single query.
+func (repo *sqlLoginRepo) EffectiveRoles(user string) ([]string, error) {
+	return scanNames(repo.cluster.Reader(repo.key(user)), effectiveRoles+"SELECT role FROM effective_roles ORDER BY role;", repo.effectiveRolesArgs(user)...)
+}
+
+func groupExists(tx *sql.Tx, tenant, group string) error {
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM usergroups WHERE tenant_id = ? AND name = ?;", tenant, group).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUnknownGroup
+	}
+	return nil
+}
+
+// scanNames returns the single column of the rows of query.
+func scanNames(db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// scanPairs calls f with the two columns of each row of query, which
+// selects the rows of tenant.
+func scanPairs(db *sql.DB, query, tenant string, f func(a, b string)) error {
+	rows, err := db.Query(query, tenant)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a, b string
+		if err := rows.Scan(&a, &b); err != nil {
+			return err
+		}
+		f(a, b)
+	}
+	return rows.Err()
+}
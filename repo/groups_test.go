@@ -0,0 +1,120 @@
+package repo_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"loginsvc/pkg/invalidation"
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroups(t *testing.T) {
+	r, _ := newEncryptedRepo(t, nil)
+	assert.NoError(t, r.Register(repo.User{Name: "al", SID: "c111111111"}))
+
+	var (
+		roles  repo.RoleRepository  = r
+		groups repo.GroupRepository = r
+	)
+	assert.NoError(t, roles.CreateRole("reader"))
+	assert.NoError(t, roles.CreateRole("writer"))
+	assert.NoError(t, roles.Grant("reader", repo.Permission{Name: "orders.read"}))
+	assert.NoError(t, roles.Grant("writer", repo.Permission{Name: "orders.write"}))
+
+	// al is in support, which is in staff, which is in everyone.
+	for _, name := range []string{"everyone", "staff", "support", "support"} {
+		assert.NoError(t, groups.CreateGroup(name))
+	}
+	assert.NoError(t, groups.Nest("support", "staff"))
+	assert.NoError(t, groups.Nest("staff", "everyone"))
+	assert.NoError(t, groups.Nest("staff", "everyone"))
+	assert.NoError(t, groups.AddMember("support", "al"))
+	assert.NoError(t, groups.AddMember("support", "al"))
+	assert.Equal(t, sql.ErrNoRows, groups.AddMember("support", "bo"))
+	assert.Equal(t, repo.ErrUnknownGroup, groups.AddMember("missing", "al"))
+	assert.NoError(t, groups.AssignGroupRole("everyone", "reader"))
+	assert.NoError(t, roles.AssignRole("al", "writer"))
+	assert.Equal(t, repo.ErrUnknownRole, groups.AssignGroupRole("staff", "missing"))
+
+	names, err := groups.EffectiveRoles("al")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"reader", "writer"}, names)
+	perms, err := roles.UserPermissions("al")
+	assert.NoError(t, err)
+	assert.Equal(t, []repo.Permission{{"orders.read", "*"}, {"orders.write", "*"}}, perms)
+	names, err = groups.EffectiveRoles("ed")
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+
+	all, err := groups.Groups()
+	assert.NoError(t, err)
+	assert.Equal(t, []repo.Group{
+		{Name: "everyone", Roles: []string{"reader"}},
+		{Name: "staff", Parents: []string{"everyone"}},
+		{Name: "support", Parents: []string{"staff"}},
+	}, all)
+	members, err := groups.Members("support")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"al"}, members)
+
+	// Breaking the chain drops the inherited role.
+	assert.NoError(t, groups.Unnest("staff", "everyone"))
+	names, err = groups.EffectiveRoles("al")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"writer"}, names)
+
+	assert.NoError(t, groups.DeleteGroup("support"))
+	all, err = groups.Groups()
+	assert.NoError(t, err)
+	assert.Equal(t, []repo.Group{{Name: "everyone", Roles: []string{"reader"}}, {Name: "staff"}}, all)
+}
+
+func TestGroupCycles(t *testing.T) {
+	r, _ := newEncryptedRepo(t, nil)
+	for _, name := range []string{"a", "b", "c"} {
+		assert.NoError(t, r.CreateGroup(name))
+	}
+	assert.NoError(t, r.Nest("a", "b"))
+	assert.NoError(t, r.Nest("b", "c"))
+
+	assert.Equal(t, repo.ErrGroupCycle, r.Nest("a", "a"))
+	assert.Equal(t, repo.ErrGroupCycle, r.Nest("b", "a"))
+	assert.Equal(t, repo.ErrGroupCycle, r.Nest("c", "a"))
+	assert.Equal(t, repo.ErrUnknownGroup, r.Nest("a", "missing"))
+	assert.NoError(t, r.Nest("a", "c"))
+}
+
+// memberGroups is a GroupRepository whose writes succeed and do nothing.
+type memberGroups struct {
+	repo.GroupRepository
+}
+
+func (memberGroups) AddMember(group, user string) error { return nil }
+
+func (memberGroups) Nest(group, parent string) error { return nil }
+
+func TestGroupCacheInvalidation(t *testing.T) {
+	bus := invalidation.NewInProcBus()
+	backend := &countingRoles{perms: map[string][]repo.Permission{}}
+	replica1 := repo.NewCachingGroupRepository(memberGroups{}, repo.NewCachingRoleRepository(backend, bus, time.Hour))
+	replica2 := repo.NewCachingRoleRepository(backend, bus, time.Hour)
+
+	replica2.UserPermissions("al")
+	replica2.UserPermissions("bo")
+	assert.Equal(t, 2, backend.reads)
+
+	// A membership change evicts the user only.
+	assert.NoError(t, replica1.AddMember("support", "al"))
+	replica2.UserPermissions("al")
+	replica2.UserPermissions("bo")
+	assert.Equal(t, 3, backend.reads)
+
+	// A nesting evicts everyone.
+	assert.NoError(t, replica1.Nest("support", "staff"))
+	replica2.UserPermissions("al")
+	replica2.UserPermissions("bo")
+	assert.Equal(t, 5, backend.reads)
+}
@@ -0,0 +1,48 @@
+package repo_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"loginsvc/pkg/envelope"
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthChecks(t *testing.T) {
+	k, _ := envelope.NewKeyring("k1", map[string][]byte{"k1": envelope.GenerateKey()}, envelope.GenerateKey())
+	r, _ := newEncryptedRepo(t, k)
+	ctx := context.Background()
+	var checker repo.HealthChecker = r
+	assert.NoError(t, checker.Ping(ctx))
+	assert.NoError(t, checker.CheckSchema(ctx))
+	assert.NoError(t, checker.CheckKeys(ctx))
+
+	plain, _ := newEncryptedRepo(t, nil)
+	assert.NoError(t, plain.CheckKeys(ctx))
+}
+
+func TestCheckSchemaMissingColumn(t *testing.T) {
+	// The schema before password schemes were tracked.
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "old.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, sid TEXT, email TEXT, email_bidx TEXT, phone TEXT, totp_secret TEXT, password_hash TEXT);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := repo.NewCluster(db, nil, repo.HealthCheckInterval(time.Hour))
+	t.Cleanup(func() { c.Close() })
+	r := repo.NewMySQLLoginRepo(c, nil)
+
+	assert.NoError(t, r.Ping(context.Background()))
+	err = r.CheckSchema(context.Background())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "password_scheme")
+	}
+}
@@ -3,28 +3,57 @@ package repo
 import (
 	"database/sql"
 	"loginsvc/config"
+	"loginsvc/pkg/envelope"
+	"loginsvc/pkg/tenant"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
 type MySQLLoginRepo struct {
-	db *sql.DB
+	sqlLoginRepo
 }
 
-func GetMySQLLoginRepo() *MySQLLoginRepo {
-	connStr := config.GetMysqliteConnectionString()
-	db, err := sql.Open("mysql", connStr)
+// GetMySQLLoginRepo connects to the primary and the read replicas listed in
+// the config. Without replicas every query goes to the primary.
+func GetMySQLLoginRepo(options ...ClusterOption) *MySQLLoginRepo {
+	primary, err := sql.Open("mysql", config.GetMysqliteConnectionString())
 	if err != nil {
 		panic(err)
 	}
-	return &MySQLLoginRepo{db}
+	var readers []*sql.DB
+	for _, connStr := range config.GetMysqlReaderConnectionStrings() {
+		db, err := sql.Open("mysql", connStr)
+		if err != nil {
+			panic(err)
+		}
+		readers = append(readers, db)
+	}
+	return NewMySQLLoginRepo(NewCluster(primary, readers, options...), mustLoadKeyring())
+}
+
+// NewMySQLLoginRepo returns a repository that routes its queries through c
+// and encrypts tagged columns with k. k may be nil if no personal data is
+// stored.
+func NewMySQLLoginRepo(c *Cluster, k *envelope.Keyring) *MySQLLoginRepo {
+	return &MySQLLoginRepo{sqlLoginRepo{cluster: c, keyring: k, tenant: tenant.Default}}
 }
 
-func (repo *MySQLLoginRepo) Name(n string) (string, error) {
-	var name string
-	err := repo.db.QueryRow("SELECT sid FROM users WHERE name = ?;", n).Scan(&name)
+// mustLoadKeyring loads the keyring named in the config, if any.
+func mustLoadKeyring() *envelope.Keyring {
+	path := config.GetMasterKeyFile()
+	if path == "" {
+		return nil
+	}
+	k, err := envelope.LoadKeyring(path)
 	if err != nil {
-		return "", err
+		panic(err)
 	}
-	return name, nil
+	return k
+}
+
+// ForTenant returns a repository sharing the connections of repo, scoped
+// to the rows of tenant id. It encrypts with the keyring k, or that of repo
+// if k is nil.
+func (repo *MySQLLoginRepo) ForTenant(id string, k *envelope.Keyring) *MySQLLoginRepo {
+	return &MySQLLoginRepo{repo.sqlLoginRepo.forTenant(id, k)}
 }
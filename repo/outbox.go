@@ -0,0 +1,533 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"loginsvc/pkg/envelope"
+)
+
+// The identity events written to the outbox, in the transaction of the
+// change they record.
+const (
+	EventUserCreated     = "user.created"
+	EventUserLocked      = "user.locked"
+	EventPasswordChanged = "password.changed"
+	EventSessionRevoked  = "session.revoked"
+)
+
+// EventTypes lists every identity event type.
+var EventTypes = []string{EventUserCreated, EventUserLocked, EventPasswordChanged, EventSessionRevoked}
+
+// OutboxEvent is an identity event of a user of the tenant.
+type OutboxEvent struct {
+	ID int64
+	// Seq orders the events of an EventLog by commit. It is zero until
+	// SequenceOutbox numbers the event.
+	Seq  int64
+	Type string
+	User string
+	Time time.Time
+}
+
+// Subscription is a webhook endpoint that receives the events of the types
+// it lists, or of every type if it lists none.
+type Subscription struct {
+	ID  int64
+	URL string
+	// Secret signs the deliveries; see package webhook. Subscriptions
+	// leaves it empty, only deliveries carry it.
+	Secret     string
+	EventTypes []string
+	Created    time.Time
+	// Pending and Dead count the deliveries to the endpoint, as listed by
+	// Subscriptions.
+	Pending, Dead int
+}
+
+// Matches reports whether the subscription receives events of typ.
+func (s Subscription) Matches(typ string) bool {
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range s.EventTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// DeliveryState is the state of a Delivery.
+type DeliveryState string
+
+const (
+	// DeliveryPending deliveries are attempted when due.
+	DeliveryPending DeliveryState = "pending"
+	// DeliveryDelivered deliveries were acknowledged by the endpoint.
+	DeliveryDelivered DeliveryState = "delivered"
+	// DeliveryDead deliveries ran out of attempts. They stay dead until
+	// RedeliverDead.
+	DeliveryDead DeliveryState = "dead"
+)
+
+// Delivery is the delivery of an event to a subscription.
+type Delivery struct {
+	ID           int64
+	Event        OutboxEvent
+	Subscription Subscription
+	// Attempts counts the attempts made before this one.
+	Attempts int
+}
+
+// Outbox is the event outbox of a tenant, and the deliveries of its events.
+type Outbox interface {
+	// DispatchOutbox turns up to limit undispatched events, oldest first,
+	// into a pending delivery per matching subscription, and returns the
+	// number of events dispatched.
+	DispatchOutbox(limit int) (int, error)
+	// ClaimDeliveries returns up to limit pending deliveries due at now,
+	// oldest first, and postpones them by lease, so that a dispatcher
+	// claiming them meanwhile skips them.
+	ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]Delivery, error)
+	// RecordDelivery records the state of delivery id after an attempt, and
+	// when a pending one is next due.
+	RecordDelivery(id int64, state DeliveryState, attempts int, next time.Time, lastErr string) error
+}
+
+// EventLog reads the outbox of a tenant as a log of events, in the order
+// they were committed. An event takes its ID when it is written, and its
+// transaction may commit after that of an event with a greater ID, so the
+// log is ordered by Seq instead, which SequenceOutbox gives committed events.
+type EventLog interface {
+	// SequenceOutbox numbers up to limit committed events without a Seq,
+	// in order of ID, after every event numbered before them, and returns
+	// how many it numbered.
+	SequenceOutbox(limit int) (int, error)
+	// OutboxEvents lists up to limit numbered events after the event
+	// afterSeq, dispatched or not.
+	OutboxEvents(afterSeq int64, limit int) ([]OutboxEvent, error)
+	// LastOutboxSeq returns the Seq of the last numbered event, or 0 if
+	// there is none.
+	LastOutboxSeq() (int64, error)
+}
+
+// SubscriptionRepository stores the webhook subscriptions of a tenant.
+type SubscriptionRepository interface {
+	// CreateSubscription stores s and returns it with its ID and Created
+	// set. Only the events dispatched afterwards are delivered to it.
+	CreateSubscription(s Subscription) (Subscription, error)
+	// Subscriptions lists the subscriptions in order of ID, without their
+	// secrets.
+	Subscriptions() ([]Subscription, error)
+	// DeleteSubscription deletes subscription id and its deliveries, or
+	// returns sql.ErrNoRows if there is none.
+	DeleteSubscription(id int64) error
+	// RedeliverDead makes the dead deliveries of subscription id pending
+	// again, with their attempts reset, and returns how many there were.
+	RedeliverDead(id int64) (int, error)
+}
+
+// outboxTables are the tables of Outbox and SubscriptionRepository.
+var outboxTables = []table{
+	{"outbox", []string{"tenant_id", "type", "user_name", "occurred_at", "dispatched", "seq"}},
+	{"outbox_sequence", []string{"id", "seq"}},
+	{"webhook_subscriptions", []string{"tenant_id", "url", "secret", "event_types", "created_at"}},
+	{"webhook_deliveries", []string{"tenant_id", "subscription_id", "event_id", "state", "attempts", "next_attempt_at", "last_error"}},
+}
+
+// appendOutbox writes an event of typ about user to the outbox of tenant,
+// through the transaction of the change it records.
+func appendOutbox(e execer, tenant, typ, user string) error {
+	_, err := e.Exec(
+		"INSERT INTO outbox (tenant_id, type, user_name, occurred_at) VALUES (?, ?, ?, ?);",
+		tenant, typ, user, time.Now().UnixNano(),
+	)
+	return err
+}
+
+// DispatchOutbox marks each event dispatched in the transaction that
+// creates its deliveries, only if it wasn't already, so that concurrent
+// dispatchers don't deliver it twice.
+func (repo *sqlLoginRepo) DispatchOutbox(limit int) (int, error) {
+	db := repo.cluster.Writer("")
+	rows, err := db.Query("SELECT id, type, user_name, occurred_at FROM outbox WHERE tenant_id = ? AND dispatched = 0 ORDER BY id LIMIT ?;", repo.tenant, limit)
+	if err != nil {
+		return 0, err
+	}
+	var events []OutboxEvent
+	for rows.Next() {
+		e, err := scanOutboxEvent(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+	subs, err := repo.subscriptions(db)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	n := 0
+	for _, e := range events {
+		res, err := tx.Exec("UPDATE outbox SET dispatched = 1 WHERE id = ? AND dispatched = 0;", e.ID)
+		if err != nil {
+			return 0, err
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return 0, err
+		} else if affected == 0 {
+			continue
+		}
+		n++
+		for _, s := range subs {
+			if !s.Matches(e.Type) {
+				continue
+			}
+			if _, err := tx.Exec(
+				"INSERT INTO webhook_deliveries (tenant_id, subscription_id, event_id, state, next_attempt_at, last_error) VALUES (?, ?, ?, ?, ?, '');",
+				repo.tenant, s.ID, e.ID, DeliveryPending, e.Time.UnixNano(),
+			); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, tx.Commit()
+}
+
+// SequenceOutbox numbers events in a transaction that first moves the
+// single row of outbox_sequence on, which locks it until the commit: the
+// events numbered by a transaction are visible before another transaction
+// can number any. Events numbered meanwhile by another transaction keep
+// their Seq, leaving a gap.
+func (repo *sqlLoginRepo) SequenceOutbox(limit int) (int, error) {
+	db := repo.cluster.Writer("")
+	rows, err := db.Query("SELECT id FROM outbox WHERE tenant_id = ? AND seq IS NULL ORDER BY id LIMIT ?;", repo.tenant, limit)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE outbox_sequence SET seq = seq + ? WHERE id = 1;", len(ids))
+	if err != nil {
+		return 0, err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return 0, err
+	} else if affected == 0 {
+		return 0, errors.New("repo: outbox_sequence has no row")
+	}
+	var last int64
+	if err := tx.QueryRow("SELECT seq FROM outbox_sequence WHERE id = 1;").Scan(&last); err != nil {
+		return 0, err
+	}
+	n := 0
+	for i, id := range ids {
+		res, err := tx.Exec("UPDATE outbox SET seq = ? WHERE id = ? AND seq IS NULL;", last-int64(len(ids)-1-i), id)
+		if err != nil {
+			return 0, err
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return 0, err
+		} else if affected > 0 {
+			n++
+		}
+	}
+	return n, tx.Commit()
+}
+
+// OutboxEvents reads from the primary, which replicas may lag behind: a
+// watcher reading a replica could pass over events the replica doesn't
+// have yet.
+func (repo *sqlLoginRepo) OutboxEvents(afterSeq int64, limit int) ([]OutboxEvent, error) {
+	rows, err := repo.cluster.Writer("").Query("SELECT id, type, user_name, occurred_at, seq FROM outbox WHERE tenant_id = ? AND seq > ? ORDER BY seq LIMIT ?;", repo.tenant, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []OutboxEvent
+	for rows.Next() {
+		var (
+			e  OutboxEvent
+			ns int64
+		)
+		if err := rows.Scan(&e.ID, &e.Type, &e.User, &ns, &e.Seq); err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(0, ns).UTC()
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (repo *sqlLoginRepo) LastOutboxSeq() (int64, error) {
+	var seq int64
+	err := repo.cluster.Writer("").QueryRow("SELECT COALESCE(MAX(seq), 0) FROM outbox WHERE tenant_id = ?;", repo.tenant).Scan(&seq)
+	return seq, err
+}
+
+func scanOutboxEvent(s scanner) (OutboxEvent, error) {
+	var (
+		e  OutboxEvent
+		ns int64
+	)
+	if err := s.Scan(&e.ID, &e.Type, &e.User, &ns); err != nil {
+		return OutboxEvent{}, err
+	}
+	e.Time = time.Unix(0, ns).UTC()
+	return e, nil
+}
+
+const selectDelivery = `SELECT d.id, d.attempts, d.next_attempt_at, o.id, o.type, o.user_name, o.occurred_at, s.id, s.url, s.secret, s.event_types, s.created_at
+FROM webhook_deliveries d
+JOIN outbox o ON o.id = d.event_id
+JOIN webhook_subscriptions s ON s.id = d.subscription_id `
+
+// ClaimDeliveries claims each delivery by moving its next_attempt_at, only
+// if no other dispatcher moved it first. A delivery whose secret can't be
+// decrypted, such as one sealed for another subscription, is dead at once
+// rather than holding up the others on every attempt.
+func (repo *sqlLoginRepo) ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]Delivery, error) {
+	db := repo.cluster.Writer("")
+	rows, err := db.Query(selectDelivery+"WHERE d.tenant_id = ? AND d.state = ? AND d.next_attempt_at <= ? ORDER BY d.next_attempt_at, d.id LIMIT ?;",
+		repo.tenant, DeliveryPending, now.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+	type due struct {
+		Delivery
+		next      int64
+		secretErr error
+	}
+	var candidates []due
+	for rows.Next() {
+		var (
+			d                 due
+			occurred, created int64
+			secret, types     string
+		)
+		if err := rows.Scan(&d.ID, &d.Attempts, &d.next, &d.Event.ID, &d.Event.Type, &d.Event.User, &occurred, &d.Subscription.ID, &d.Subscription.URL, &secret, &types, &created); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.Event.Time = time.Unix(0, occurred).UTC()
+		d.Subscription.EventTypes = splitEventTypes(types)
+		d.Subscription.Created = fromUnix(created)
+		d.Subscription.Secret, d.secretErr = repo.openSecret(d.Subscription.ID, secret)
+		if d.secretErr == ErrNoKeyring {
+			rows.Close()
+			return nil, ErrNoKeyring
+		}
+		candidates = append(candidates, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var claimed []Delivery
+	for _, d := range candidates {
+		if d.secretErr != nil {
+			_, err := db.Exec("UPDATE webhook_deliveries SET state = ?, last_error = ? WHERE id = ? AND state = ? AND next_attempt_at = ?;",
+				DeliveryDead, "secret: "+d.secretErr.Error(), d.ID, DeliveryPending, d.next)
+			if err != nil {
+				return claimed, err
+			}
+			continue
+		}
+		res, err := db.Exec("UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = ? AND state = ? AND next_attempt_at = ?;",
+			now.Add(lease).UnixNano(), d.ID, DeliveryPending, d.next)
+		if err != nil {
+			return claimed, err
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return claimed, err
+		} else if affected == 1 {
+			claimed = append(claimed, d.Delivery)
+		}
+	}
+	return claimed, nil
+}
+
+func (repo *sqlLoginRepo) RecordDelivery(id int64, state DeliveryState, attempts int, next time.Time, lastErr string) error {
+	_, err := repo.cluster.Writer("").Exec(
+		"UPDATE webhook_deliveries SET state = ?, attempts = ?, next_attempt_at = ?, last_error = ? WHERE tenant_id = ? AND id = ?;",
+		state, attempts, next.UnixNano(), lastErr, repo.tenant, id,
+	)
+	return err
+}
+
+// CreateSubscription encrypts the secret if the repository has a keyring,
+// bound to the subscription once it has its ID. Unlike personal data,
+// secrets are stored without one, since webhooks work without encrypted
+// columns.
+func (repo *sqlLoginRepo) CreateSubscription(s Subscription) (Subscription, error) {
+	tx, err := repo.cluster.Writer("").Begin()
+	if err != nil {
+		return Subscription{}, err
+	}
+	defer tx.Rollback()
+	secret := s.Secret
+	if repo.keyring != nil {
+		secret = ""
+	}
+	s.Created = time.Now().Truncate(time.Second)
+	res, err := tx.Exec(
+		"INSERT INTO webhook_subscriptions (tenant_id, url, secret, event_types, created_at) VALUES (?, ?, ?, ?, ?);",
+		repo.tenant, s.URL, secret, strings.Join(s.EventTypes, ","), toUnix(s.Created),
+	)
+	if err != nil {
+		return Subscription{}, err
+	}
+	if s.ID, err = res.LastInsertId(); err != nil {
+		return Subscription{}, err
+	}
+	if repo.keyring != nil {
+		if secret, err = repo.keyring.Encrypt([]byte(s.Secret), secretAAD(repo.tenant, s.ID)); err != nil {
+			return Subscription{}, err
+		}
+		if _, err := tx.Exec("UPDATE webhook_subscriptions SET secret = ? WHERE id = ?;", secret, s.ID); err != nil {
+			return Subscription{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return Subscription{}, err
+	}
+	return s, nil
+}
+
+func (repo *sqlLoginRepo) Subscriptions() ([]Subscription, error) {
+	subs, err := repo.subscriptions(repo.cluster.Reader(""))
+	if err != nil {
+		return nil, err
+	}
+	for i := range subs {
+		if err := repo.cluster.Reader("").QueryRow(
+			"SELECT COALESCE(SUM(CASE WHEN state = ? THEN 1 ELSE 0 END), 0), COALESCE(SUM(CASE WHEN state = ? THEN 1 ELSE 0 END), 0) FROM webhook_deliveries WHERE tenant_id = ? AND subscription_id = ?;",
+			DeliveryPending, DeliveryDead, repo.tenant, subs[i].ID,
+		).Scan(&subs[i].Pending, &subs[i].Dead); err != nil {
+			return nil, err
+		}
+	}
+	return subs, nil
+}
+
+func (repo *sqlLoginRepo) DeleteSubscription(id int64) error {
+	tx, err := repo.cluster.Writer("").Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("DELETE FROM webhook_subscriptions WHERE tenant_id = ? AND id = ?;", repo.tenant, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE tenant_id = ? AND subscription_id = ?;", repo.tenant, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (repo *sqlLoginRepo) RedeliverDead(id int64) (int, error) {
+	res, err := repo.cluster.Writer("").Exec(
+		"UPDATE webhook_deliveries SET state = ?, attempts = 0, next_attempt_at = ? WHERE tenant_id = ? AND subscription_id = ? AND state = ?;",
+		DeliveryPending, time.Now().UnixNano(), repo.tenant, id, DeliveryDead,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// subscriptions lists the subscriptions of the tenant from db, without
+// their secrets.
+func (repo *sqlLoginRepo) subscriptions(db *sql.DB) ([]Subscription, error) {
+	rows, err := db.Query("SELECT id, url, event_types, created_at FROM webhook_subscriptions WHERE tenant_id = ? ORDER BY id;", repo.tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var subs []Subscription
+	for rows.Next() {
+		var (
+			s       Subscription
+			types   string
+			created int64
+		)
+		if err := rows.Scan(&s.ID, &s.URL, &types, &created); err != nil {
+			return nil, err
+		}
+		s.EventTypes, s.Created = splitEventTypes(types), fromUnix(created)
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
+
+// secretAAD returns the additional data that binds the secret of
+// subscription id to it and its tenant, so that a secret copied to another
+// subscription or tenant no longer decrypts.
+func secretAAD(tenant string, id int64) []byte {
+	return []byte("webhook_subscriptions.secret\x00" + tenant + "\x00" + strconv.FormatInt(id, 10))
+}
+
+// openSecret decrypts the stored secret of subscription id, if it was
+// encrypted.
+func (repo *sqlLoginRepo) openSecret(id int64, stored string) (string, error) {
+	if !envelope.IsEncrypted(stored) {
+		return stored, nil
+	}
+	if repo.keyring == nil {
+		return "", ErrNoKeyring
+	}
+	secret, err := repo.keyring.Decrypt(stored, secretAAD(repo.tenant, id))
+	return string(secret), err
+}
+
+func splitEventTypes(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
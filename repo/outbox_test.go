@@ -0,0 +1,185 @@
+package repo_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"loginsvc/pkg/envelope"
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboxEvents(t *testing.T) {
+	r, db := newEncryptedRepo(t, nil)
+	assert.NoError(t, r.Register(repo.User{Name: "al", SID: "c111111111"}))
+	assert.NoError(t, r.SetStatus("al", repo.StatusDisabled))
+	// Disabling a disabled user or enabling one isn't an event.
+	assert.NoError(t, r.SetStatus("al", repo.StatusDisabled))
+	assert.NoError(t, r.SetStatus("al", repo.StatusActive))
+	assert.NoError(t, r.ChangePassword("al", "$1$3azHgidD$SrJPt7B.9rekpmwJwtON31"))
+	assert.NoError(t, r.RevokeSessions("al", time.Now()))
+	// Nor are rehashes, or writes to unknown users.
+	assert.NoError(t, r.SetPasswordHash("al", "$1$3azHgidD$SrJPt7B.9rekpmwJwtON31"))
+	assert.NoError(t, r.RevokeSessions("cy", time.Now()))
+	_, err := r.ImportUsers([]repo.User{{Name: "bo", SID: "d222222222"}}, repo.ConflictFail, false)
+	assert.NoError(t, err)
+
+	rows, err := db.Query("SELECT type, user_name FROM outbox WHERE tenant_id = 'default' ORDER BY id;")
+	assert.NoError(t, err)
+	var events [][2]string
+	for rows.Next() {
+		var e [2]string
+		rows.Scan(&e[0], &e[1])
+		events = append(events, e)
+	}
+	rows.Close()
+	assert.Equal(t, [][2]string{
+		{repo.EventUserCreated, "al"},
+		{repo.EventUserLocked, "al"},
+		{repo.EventPasswordChanged, "al"},
+		{repo.EventSessionRevoked, "al"},
+		{repo.EventUserCreated, "bo"},
+	}, events)
+
+	// Other tenants have logs of their own.
+	assert.NoError(t, r.ForTenant("acme", nil).Register(repo.User{Name: "al", SID: "c111111111"}))
+	n, err := r.SequenceOutbox(3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, _ = r.SequenceOutbox(10)
+	assert.Equal(t, 2, n)
+	log, err := r.OutboxEvents(2, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, log, 2) {
+		assert.Equal(t, []int64{3, 4}, []int64{log[0].ID, log[1].ID})
+		assert.Equal(t, []int64{3, 4}, []int64{log[0].Seq, log[1].Seq})
+		assert.Equal(t, repo.EventPasswordChanged, log[0].Type)
+	}
+	last, err := r.LastOutboxSeq()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), last)
+	last, _ = r.ForTenant("globex", nil).LastOutboxSeq()
+	assert.Zero(t, last)
+
+	// An event committed after one with a greater ID was numbered follows
+	// it in the log.
+	assert.NoError(t, r.ForTenant("acme", nil).Register(repo.User{Name: "bo", SID: "d222222222"}))
+	_, err = db.Exec("INSERT INTO outbox (id, tenant_id, type, user_name, occurred_at) VALUES (100, 'default', 'user.locked', 'bo', 0);")
+	assert.NoError(t, err)
+	r.SequenceOutbox(10)
+	_, err = db.Exec("INSERT INTO outbox (id, tenant_id, type, user_name, occurred_at) VALUES (50, 'default', 'user.locked', 'al', 0);")
+	assert.NoError(t, err)
+	r.SequenceOutbox(10)
+	log, _ = r.OutboxEvents(5, 10)
+	if assert.Len(t, log, 2) {
+		assert.Equal(t, []int64{100, 50}, []int64{log[0].ID, log[1].ID})
+		assert.Equal(t, []int64{6, 7}, []int64{log[0].Seq, log[1].Seq})
+	}
+	// Tenants share the sequence.
+	n, _ = r.ForTenant("acme", nil).SequenceOutbox(10)
+	assert.Equal(t, 2, n)
+	last, _ = r.ForTenant("acme", nil).LastOutboxSeq()
+	assert.Equal(t, int64(9), last)
+
+	// The event is written in the transaction of the change: without an
+	// outbox, neither is.
+	_, err = db.Exec("DROP TABLE outbox;")
+	assert.NoError(t, err)
+	assert.Error(t, r.Register(repo.User{Name: "cy", SID: "e333333333"}))
+	_, err = r.User("cy")
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	k, _ := envelope.NewKeyring("k1", map[string][]byte{"k1": envelope.GenerateKey()}, envelope.GenerateKey())
+	r, db := newEncryptedRepo(t, k)
+	all, err := r.CreateSubscription(repo.Subscription{URL: "https://a.example.com/hook", Secret: "s1"})
+	assert.NoError(t, err)
+	locked, err := r.CreateSubscription(repo.Subscription{URL: "https://b.example.com/hook", Secret: "s2", EventTypes: []string{repo.EventUserLocked}})
+	assert.NoError(t, err)
+	var secret string
+	db.QueryRow("SELECT secret FROM webhook_subscriptions WHERE id = ?;", all.ID).Scan(&secret)
+	assert.True(t, envelope.IsEncrypted(secret))
+
+	assert.NoError(t, r.Register(repo.User{Name: "al", SID: "c111111111"}))
+	assert.NoError(t, r.SetStatus("al", repo.StatusDisabled))
+	n, err := r.DispatchOutbox(10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, _ = r.DispatchOutbox(10)
+	assert.Zero(t, n)
+
+	now := time.Now()
+	claimed, err := r.ClaimDeliveries(now, time.Minute, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 3) {
+		assert.Equal(t, repo.EventUserCreated, claimed[0].Event.Type)
+		assert.Equal(t, "al", claimed[0].Event.User)
+		assert.Equal(t, "s1", claimed[0].Subscription.Secret)
+	}
+	// Claimed deliveries aren't due again until the lease ends.
+	again, _ := r.ClaimDeliveries(now, time.Minute, 10)
+	assert.Empty(t, again)
+	again, _ = r.ClaimDeliveries(now.Add(2*time.Minute), time.Minute, 10)
+	assert.Len(t, again, 3)
+
+	for _, d := range claimed {
+		state := repo.DeliveryDelivered
+		if d.Subscription.ID == locked.ID {
+			state = repo.DeliveryDead
+		}
+		assert.NoError(t, r.RecordDelivery(d.ID, state, 1, now, "500 Internal Server Error"))
+	}
+	subs, err := r.Subscriptions()
+	assert.NoError(t, err)
+	if assert.Len(t, subs, 2) {
+		assert.Equal(t, []string{repo.EventUserLocked}, subs[1].EventTypes)
+		assert.Equal(t, 0, subs[0].Dead)
+		assert.Equal(t, 1, subs[1].Dead)
+	}
+
+	n, err = r.RedeliverDead(locked.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	claimed, _ = r.ClaimDeliveries(time.Now(), time.Minute, 10)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, 0, claimed[0].Attempts)
+		assert.Equal(t, repo.EventUserLocked, claimed[0].Event.Type)
+	}
+
+	assert.NoError(t, r.DeleteSubscription(locked.ID))
+	assert.Equal(t, sql.ErrNoRows, r.DeleteSubscription(locked.ID))
+	var deliveries int
+	db.QueryRow("SELECT COUNT(*) FROM webhook_deliveries WHERE subscription_id = ?;", locked.ID).Scan(&deliveries)
+	assert.Zero(t, deliveries)
+	// Other tenants have subscriptions of their own.
+	subs, _ = r.ForTenant("acme", k).Subscriptions()
+	assert.Empty(t, subs)
+}
+
+func TestWebhookSecretsBoundToSubscription(t *testing.T) {
+	k, _ := envelope.NewKeyring("k1", map[string][]byte{"k1": envelope.GenerateKey()}, envelope.GenerateKey())
+	r, db := newEncryptedRepo(t, k)
+	a, _ := r.CreateSubscription(repo.Subscription{URL: "https://a.example.com/hook", Secret: "s1"})
+	b, _ := r.CreateSubscription(repo.Subscription{URL: "https://b.example.com/hook", Secret: "s2"})
+
+	// A secret copied to another subscription doesn't open, and only the
+	// deliveries to that one fail.
+	_, err := db.Exec("UPDATE webhook_subscriptions SET secret = (SELECT secret FROM webhook_subscriptions WHERE id = ?) WHERE id = ?;", a.ID, b.ID)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Register(repo.User{Name: "al", SID: "c111111111"}))
+	r.DispatchOutbox(10)
+	claimed, err := r.ClaimDeliveries(time.Now(), time.Minute, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, a.ID, claimed[0].Subscription.ID)
+		assert.Equal(t, "s1", claimed[0].Subscription.Secret)
+	}
+	subs, err := r.Subscriptions()
+	assert.NoError(t, err)
+	if assert.Len(t, subs, 2) {
+		assert.Equal(t, 1, subs[1].Dead)
+	}
+}
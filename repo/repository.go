@@ -1,5 +1,120 @@
 package repo
 
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
 type LoginRepository interface {
 	Name(n string) (string, error)
+	User(n string) (User, error)
+	UserByEmail(email string) (User, error)
+	Register(u User) error
+	UpdateUser(u User) error
+
+	// SetPasswordHash replaces the password hash of the user named n.
+	SetPasswordHash(n, hash string) error
+}
+
+// User is a row of the users table. Fields tagged `crypt` hold personal data
+// or secrets: the repository encrypts them before they are written and
+// decrypts them after they are read. The tag names the column, which is
+// bound to the ciphertext so values cannot be swapped between columns; the
+// "index" option also maintains a blind index in <column>_bidx so the
+// column supports exact-match lookups.
+type User struct {
+	Name         string
+	SID          string
+	Email        string `crypt:"email,index"`
+	Phone        string `crypt:"phone"`
+	TOTPSecret   string `crypt:"totp_secret"`
+	PasswordHash string
+
+	// Status, Created and SessionsRevoked are managed by the repository
+	// and through UserAdmin: Register and ImportUsers store new users as
+	// active and created now, unless they say otherwise, and the other
+	// writes leave them alone.
+	Status          Status
+	Created         time.Time
+	SessionsRevoked time.Time
+}
+
+// BulkRepository is implemented by repositories that can import and export
+// users in bulk.
+type BulkRepository interface {
+	// ImportUsers writes users in a single transaction and returns what
+	// happened to each of them. Users that already exist, by name, are
+	// handled according to policy; under ConflictFail the transaction is
+	// rolled back and a *ConflictError returned. So are users whose sid
+	// belongs to another user, except that overwriting never takes a sid
+	// from its user: they are SIDConflict under ConflictSkip and
+	// ConflictOverwrite. With dryRun the transaction is always rolled
+	// back, so the results only predict the outcome.
+	ImportUsers(users []User, policy ConflictPolicy, dryRun bool) ([]ImportResult, error)
+
+	// ExportUsers calls fn with every user, in insertion order, until fn
+	// returns an error.
+	ExportUsers(fn func(User) error) error
+}
+
+// SchemeCounter is implemented by repositories that can count users by
+// password scheme, as named by password.Describe.
+type SchemeCounter interface {
+	CountPasswordSchemes() (map[string]int, error)
+}
+
+// HealthChecker is implemented by repositories that can tell whether they
+// are ready to serve.
+type HealthChecker interface {
+	// Ping checks that the primary database is reachable.
+	Ping(ctx context.Context) error
+
+	// CheckSchema checks that the users, role, audit and outbox tables have every
+	// column the repository queries, i.e. that the latest mysql.sql or
+	// sqlite.sql changes were applied.
+	CheckSchema(ctx context.Context) error
+
+	// CheckKeys checks that the keyring, if one is configured, encrypts and
+	// decrypts.
+	CheckKeys(ctx context.Context) error
+}
+
+// ConflictPolicy decides what ImportUsers does with existing users.
+type ConflictPolicy int
+
+const (
+	ConflictFail ConflictPolicy = iota
+	ConflictSkip
+	// ConflictOverwrite updates the sid of existing users, and whichever of
+	// their other fields the imported user has.
+	ConflictOverwrite
+)
+
+// ImportResult is the outcome of importing a single user.
+type ImportResult int
+
+const (
+	Created ImportResult = iota
+	Updated
+	Skipped
+	// SIDConflict is a user left as it was, or not created, because its
+	// sid belongs to another user.
+	SIDConflict
+)
+
+// ConflictError reports the first conflicting user met under ConflictFail:
+// one that already exists or, if Owner is set, one whose sid belongs to
+// the user Owner.
+type ConflictError struct {
+	Index int
+	Name  string
+	Owner string
+}
+
+func (e *ConflictError) Error() string {
+	if e.Owner != "" {
+		return fmt.Sprintf("repo: the sid of user %q belongs to user %q", e.Name, e.Owner)
+	}
+	return fmt.Sprintf("repo: user %q already exists", e.Name)
 }
@@ -0,0 +1,258 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrUnknownRole is returned when granting a permission to, or assigning, a
+// role that doesn't exist.
+var ErrUnknownRole = errors.New("repo: unknown role")
+
+// AnyResource is the resource of a permission granted on every resource.
+const AnyResource = "*"
+
+// Permission allows an action, as in "orders.read", on a resource, as in
+// "orders/42", or on AnyResource.
+type Permission struct {
+	Name     string
+	Resource string
+}
+
+// Role is a named set of permissions, assigned to users.
+type Role struct {
+	Name        string
+	Permissions []Permission
+}
+
+// RoleRepository is implemented by repositories that store roles, the
+// permissions granted to them and the roles assigned to users. Writes are
+// idempotent: creating an existing role or granting a permission twice
+// succeeds and changes nothing.
+type RoleRepository interface {
+	CreateRole(name string) error
+	// DeleteRole deletes a role, its permissions and its assignments.
+	DeleteRole(name string) error
+	// Roles returns every role with its permissions, by name.
+	Roles() ([]Role, error)
+
+	Grant(role string, p Permission) error
+	Revoke(role string, p Permission) error
+
+	// AssignRole gives the user named user a role. It returns
+	// sql.ErrNoRows if there is no such user.
+	AssignRole(user, role string) error
+	UnassignRole(user, role string) error
+	// UserRoles returns the names of the roles assigned to a user
+	// directly.
+	UserRoles(user string) ([]string, error)
+	// UserPermissions returns the permissions of all the roles of a user,
+	// including those inherited from groups when the repository is also a
+	// GroupRepository.
+	UserPermissions(user string) ([]Permission, error)
+}
+
+// roleTables are the tables the role and group queries use.
+var roleTables = []table{
+	{"roles", []string{"tenant_id", "name"}},
+	{"permissions", []string{"tenant_id", "role", "permission", "resource"}},
+	{"user_roles", []string{"tenant_id", "user_name", "role"}},
+	{"usergroups", []string{"tenant_id", "name"}},
+	{"group_members", []string{"tenant_id", "group_name", "user_name"}},
+	{"group_parents", []string{"tenant_id", "group_name", "parent"}},
+	{"group_roles", []string{"tenant_id", "group_name", "role"}},
+}
+
+// CreateRole implements RoleRepository.
+func (repo *sqlLoginRepo) CreateRole(name string) error {
+	tx, err := repo.cluster.Writer("").Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM roles WHERE tenant_id = ? AND name = ?;", repo.tenant, name).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	if _, err := tx.Exec("INSERT INTO roles (tenant_id, name) VALUES (?, ?);", repo.tenant, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteRole implements RoleRepository.
+func (repo *sqlLoginRepo) DeleteRole(name string) error {
+	tx, err := repo.cluster.Writer("").Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, query := range []string{
+		"DELETE FROM user_roles WHERE tenant_id = ? AND role = ?;",
+		"DELETE FROM group_roles WHERE tenant_id = ? AND role = ?;",
+		"DELETE FROM permissions WHERE tenant_id = ? AND role = ?;",
+		"DELETE FROM roles WHERE tenant_id = ? AND name = ?;",
+	} {
+		if _, err := tx.Exec(query, repo.tenant, name); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Roles implements RoleRepository.
+func (repo *sqlLoginRepo) Roles() ([]Role, error) {
+	rows, err := repo.cluster.Reader("").Query(
+		"SELECT r.name, p.permission, p.resource FROM roles r LEFT JOIN permissions p ON p.tenant_id = r.tenant_id AND p.role = r.name WHERE r.tenant_id = ? ORDER BY r.name, p.permission, p.resource;",
+		repo.tenant,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var roles []Role
+	for rows.Next() {
+		var (
+			name               string
+			permission, object sql.NullString
+		)
+		if err := rows.Scan(&name, &permission, &object); err != nil {
+			return nil, err
+		}
+		if len(roles) == 0 || roles[len(roles)-1].Name != name {
+			roles = append(roles, Role{Name: name})
+		}
+		if permission.Valid {
+			r := &roles[len(roles)-1]
+			r.Permissions = append(r.Permissions, Permission{Name: permission.String, Resource: object.String})
+		}
+	}
+	return roles, rows.Err()
+}
+
+// Grant implements RoleRepository. An empty resource stands for
+// AnyResource.
+func (repo *sqlLoginRepo) Grant(role string, p Permission) error {
+	if p.Resource == "" {
+		p.Resource = AnyResource
+	}
+	tx, err := repo.cluster.Writer("").Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := roleExists(tx, repo.tenant, role); err != nil {
+		return err
+	}
+	var n int
+	err = tx.QueryRow("SELECT COUNT(*) FROM permissions WHERE tenant_id = ? AND role = ? AND permission = ? AND resource = ?;", repo.tenant, role, p.Name, p.Resource).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	if _, err := tx.Exec("INSERT INTO permissions (tenant_id, role, permission, resource) VALUES (?, ?, ?, ?);", repo.tenant, role, p.Name, p.Resource); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Revoke implements RoleRepository. An empty resource stands for
+// AnyResource.
+func (repo *sqlLoginRepo) Revoke(role string, p Permission) error {
+	if p.Resource == "" {
+		p.Resource = AnyResource
+	}
+	_, err := repo.cluster.Writer("").Exec("DELETE FROM permissions WHERE tenant_id = ? AND role = ? AND permission = ? AND resource = ?;", repo.tenant, role, p.Name, p.Resource)
+	return err
+}
+
+// AssignRole implements RoleRepository.
+func (repo *sqlLoginRepo) AssignRole(user, role string) error {
+	tx, err := repo.cluster.Writer(repo.key(user)).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE tenant_id = ? AND name = ?;", repo.tenant, user).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := roleExists(tx, repo.tenant, role); err != nil {
+		return err
+	}
+	if err := tx.QueryRow("SELECT COUNT(*) FROM user_roles WHERE tenant_id = ? AND user_name = ? AND role = ?;", repo.tenant, user, role).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	if _, err := tx.Exec("INSERT INTO user_roles (tenant_id, user_name, role) VALUES (?, ?, ?);", repo.tenant, user, role); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UnassignRole implements RoleRepository.
+func (repo *sqlLoginRepo) UnassignRole(user, role string) error {
+	_, err := repo.cluster.Writer(repo.key(user)).Exec("DELETE FROM user_roles WHERE tenant_id = ? AND user_name = ? AND role = ?;", repo.tenant, user, role)
+	return err
+}
+
+// UserRoles implements RoleRepository.
+func (repo *sqlLoginRepo) UserRoles(user string) ([]string, error) {
+	rows, err := repo.cluster.Reader(repo.key(user)).Query("SELECT role FROM user_roles WHERE tenant_id = ? AND user_name = ? ORDER BY role;", repo.tenant, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// UserPermissions implements RoleRepository. It resolves the effective
+// roles of the user in the same query.
+func (repo *sqlLoginRepo) UserPermissions(user string) ([]Permission, error) {
+	rows, err := repo.cluster.Reader(repo.key(user)).Query(
+		effectiveRoles+"SELECT DISTINCT p.permission, p.resource FROM effective_roles r JOIN permissions p ON p.role = r.role WHERE p.tenant_id = ? ORDER BY p.permission, p.resource;",
+		repo.effectiveRolesArgs(user, repo.tenant)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var perms []Permission
+	for rows.Next() {
+		var p Permission
+		if err := rows.Scan(&p.Name, &p.Resource); err != nil {
+			return nil, err
+		}
+		perms = append(perms, p)
+	}
+	return perms, rows.Err()
+}
+
+func roleExists(tx *sql.Tx, tenant, role string) error {
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM roles WHERE tenant_id = ? AND name = ?;", tenant, role).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUnknownRole
+	}
+	return nil
+}
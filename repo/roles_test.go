@@ -0,0 +1,110 @@
+package repo_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"loginsvc/pkg/invalidation"
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoles(t *testing.T) {
+	r, _ := newEncryptedRepo(t, nil)
+	assert.NoError(t, r.Register(repo.User{Name: "al", SID: "c111111111"}))
+
+	var roles repo.RoleRepository = r
+	assert.NoError(t, roles.CreateRole("reader"))
+	assert.NoError(t, roles.CreateRole("reader"))
+	assert.NoError(t, roles.CreateRole("admin"))
+	assert.NoError(t, roles.Grant("reader", repo.Permission{Name: "orders.read"}))
+	assert.NoError(t, roles.Grant("reader", repo.Permission{Name: "orders.read", Resource: "*"}))
+	assert.NoError(t, roles.Grant("admin", repo.Permission{Name: "orders.write", Resource: "orders/42"}))
+	assert.NoError(t, roles.Grant("admin", repo.Permission{Name: "orders.read", Resource: "orders/42"}))
+	assert.Equal(t, repo.ErrUnknownRole, roles.Grant("missing", repo.Permission{Name: "orders.read"}))
+
+	all, err := roles.Roles()
+	assert.NoError(t, err)
+	assert.Equal(t, []repo.Role{
+		{Name: "admin", Permissions: []repo.Permission{{"orders.read", "orders/42"}, {"orders.write", "orders/42"}}},
+		{Name: "reader", Permissions: []repo.Permission{{"orders.read", "*"}}},
+	}, all)
+
+	assert.NoError(t, roles.AssignRole("al", "reader"))
+	assert.NoError(t, roles.AssignRole("al", "reader"))
+	assert.NoError(t, roles.AssignRole("ed", "admin"))
+	assert.Equal(t, repo.ErrUnknownRole, roles.AssignRole("al", "missing"))
+	assert.Equal(t, sql.ErrNoRows, roles.AssignRole("bo", "reader"))
+
+	names, err := roles.UserRoles("al")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"reader"}, names)
+	perms, err := roles.UserPermissions("al")
+	assert.NoError(t, err)
+	assert.Equal(t, []repo.Permission{{"orders.read", "*"}}, perms)
+
+	assert.NoError(t, roles.AssignRole("al", "admin"))
+	perms, err = roles.UserPermissions("al")
+	assert.NoError(t, err)
+	assert.Equal(t, []repo.Permission{{"orders.read", "*"}, {"orders.read", "orders/42"}, {"orders.write", "orders/42"}}, perms)
+
+	assert.NoError(t, roles.Revoke("admin", repo.Permission{Name: "orders.read", Resource: "orders/42"}))
+	assert.NoError(t, roles.UnassignRole("al", "reader"))
+	perms, err = roles.UserPermissions("al")
+	assert.NoError(t, err)
+	assert.Equal(t, []repo.Permission{{"orders.write", "orders/42"}}, perms)
+
+	assert.NoError(t, roles.DeleteRole("admin"))
+	names, err = roles.UserRoles("ed")
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+	all, err = roles.Roles()
+	assert.NoError(t, err)
+	assert.Equal(t, []repo.Role{{Name: "reader", Permissions: []repo.Permission{{"orders.read", "*"}}}}, all)
+}
+
+// countingRoles counts the permission reads of an in-memory role
+// repository.
+type countingRoles struct {
+	repo.RoleRepository
+	perms map[string][]repo.Permission
+	reads int
+}
+
+func (r *countingRoles) UserPermissions(user string) ([]repo.Permission, error) {
+	r.reads++
+	return r.perms[user], nil
+}
+
+func (r *countingRoles) AssignRole(user, role string) error { return nil }
+
+func (r *countingRoles) Grant(role string, p repo.Permission) error { return nil }
+
+func TestRoleCacheInvalidation(t *testing.T) {
+	bus := invalidation.NewInProcBus()
+	backend := &countingRoles{perms: map[string][]repo.Permission{"al": {{"orders.read", "*"}}}}
+	replica1 := repo.NewCachingRoleRepository(backend, bus, time.Hour)
+	replica2 := repo.NewCachingRoleRepository(backend, bus, time.Hour)
+
+	replica2.UserPermissions("al")
+	replica2.UserPermissions("bo")
+	replica2.UserPermissions("al")
+	assert.Equal(t, 2, backend.reads)
+
+	// An assignment evicts the user only.
+	backend.perms["al"] = nil
+	assert.NoError(t, replica1.AssignRole("al", "reader"))
+	perms, err := replica2.UserPermissions("al")
+	assert.NoError(t, err)
+	assert.Empty(t, perms)
+	replica2.UserPermissions("bo")
+	assert.Equal(t, 3, backend.reads)
+
+	// A grant evicts everyone.
+	assert.NoError(t, replica1.Grant("reader", repo.Permission{Name: "orders.read"}))
+	replica2.UserPermissions("al")
+	replica2.UserPermissions("bo")
+	assert.Equal(t, 5, backend.reads)
+}
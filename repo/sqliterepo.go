@@ -3,25 +3,23 @@ package repo
 import (
 	"database/sql"
 	"loginsvc/config"
+	"loginsvc/pkg/envelope"
+	"loginsvc/pkg/tenant"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type SqliteLoginRepository struct {
-	db *sql.DB
+	sqlLoginRepo
 }
 
 func GetSqliteLoginRepository() *SqliteLoginRepository {
 	connStr := config.GetSqliteConnectionString()
 	db, _ := sql.Open("sqlite3", connStr)
-	return &SqliteLoginRepository{db}
+	return &SqliteLoginRepository{sqlLoginRepo{cluster: NewCluster(db, nil), keyring: mustLoadKeyring(), tenant: tenant.Default}}
 }
 
-func (repo *SqliteLoginRepository) Name(n string) (string, error) {
-	var name string
-	err := repo.db.QueryRow("SELECT sid FROM users WHERE name = ?;", n).Scan(&name)
-	if err != nil {
-		return "", err
-	}
-	return name, nil
+// ForTenant is MySQLLoginRepo.ForTenant.
+func (repo *SqliteLoginRepository) ForTenant(id string, k *envelope.Keyring) *SqliteLoginRepository {
+	return &SqliteLoginRepository{repo.sqlLoginRepo.forTenant(id, k)}
 }
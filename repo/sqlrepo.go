@@ -0,0 +1,396 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"loginsvc/pkg/envelope"
+	"loginsvc/pkg/password"
+)
+
+// sqlLoginRepo implements LoginRepository for every database/sql driver
+// that understands ? placeholders. The concrete repositories embed it.
+// Every query is scoped to the rows of tenant.
+type sqlLoginRepo struct {
+	cluster *Cluster
+	keyring *envelope.Keyring
+	tenant  string
+}
+
+// forTenant returns a copy of repo scoped to tenant id, encrypting with k
+// unless it is nil.
+func (repo sqlLoginRepo) forTenant(id string, k *envelope.Keyring) sqlLoginRepo {
+	repo.tenant = id
+	if k != nil {
+		repo.keyring = k
+	}
+	return repo
+}
+
+// key returns the cluster routing key of the user named n, which is only
+// unique within the tenant.
+func (repo *sqlLoginRepo) key(n string) string {
+	return repo.tenant + "/" + n
+}
+
+const selectUser = "SELECT name, sid, email, phone, totp_secret, password_hash, status, created_at, sessions_revoked_at FROM users "
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func (repo *sqlLoginRepo) Name(n string) (string, error) {
+	var name string
+	err := repo.cluster.Reader(repo.key(n)).QueryRow("SELECT sid FROM users WHERE tenant_id = ? AND name = ?;", repo.tenant, n).Scan(&name)
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func (repo *sqlLoginRepo) User(n string) (User, error) {
+	return repo.scanUser(repo.cluster.Reader(repo.key(n)).QueryRow(selectUser+"WHERE tenant_id = ? AND name = ?;", repo.tenant, n))
+}
+
+// UserByEmail finds a user by the blind index of its email, so the lookup
+// works although the column itself is encrypted.
+func (repo *sqlLoginRepo) UserByEmail(email string) (User, error) {
+	if repo.keyring == nil {
+		return User{}, ErrNoKeyring
+	}
+	bidx := blindIndex(repo.keyring, email)
+	return repo.scanUser(repo.cluster.Reader("").QueryRow(selectUser+"WHERE tenant_id = ? AND email_bidx = ?;", repo.tenant, bidx))
+}
+
+func (repo *sqlLoginRepo) Register(u User) error {
+	sealed, indexes, err := sealUser(repo.keyring, repo.tenant, u)
+	if err != nil {
+		return err
+	}
+	tx, err := repo.cluster.Writer(repo.key(u.Name)).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := insertUser(tx, repo.tenant, sealed, indexes); err != nil {
+		return err
+	}
+	if err := setEmailPrefixes(tx, repo.tenant, u.Name, prefixIndexes(repo.keyring, u.Email)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateUser replaces the personal data of the user named u.Name.
+func (repo *sqlLoginRepo) UpdateUser(u User) error {
+	sealed, indexes, err := sealUser(repo.keyring, repo.tenant, u)
+	if err != nil {
+		return err
+	}
+	tx, err := repo.cluster.Writer(repo.key(u.Name)).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(
+		"UPDATE users SET email = ?, email_bidx = ?, phone = ?, totp_secret = ? WHERE tenant_id = ? AND name = ?;",
+		sealed.Email, indexes["email"], sealed.Phone, sealed.TOTPSecret, repo.tenant, sealed.Name,
+	)
+	if err != nil {
+		return err
+	}
+	// An unknown user gets no prefixes.
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	if err := setEmailPrefixes(tx, repo.tenant, u.Name, prefixIndexes(repo.keyring, u.Email)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetPasswordHash replaces the password hash of the user named n and records
+// its scheme.
+func (repo *sqlLoginRepo) SetPasswordHash(n, hash string) error {
+	_, err := repo.cluster.Writer(repo.key(n)).Exec(
+		"UPDATE users SET password_hash = ?, password_scheme = ? WHERE tenant_id = ? AND name = ?;",
+		hash, password.Describe(hash), repo.tenant, n,
+	)
+	return err
+}
+
+// CountPasswordSchemes implements SchemeCounter.
+func (repo *sqlLoginRepo) CountPasswordSchemes() (map[string]int, error) {
+	rows, err := repo.cluster.Reader("").Query("SELECT password_scheme, COUNT(*) FROM users WHERE tenant_id = ? GROUP BY password_scheme;", repo.tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	for rows.Next() {
+		var (
+			scheme string
+			n      int
+		)
+		if err := rows.Scan(&scheme, &n); err != nil {
+			return nil, err
+		}
+		counts[scheme] = n
+	}
+	return counts, rows.Err()
+}
+
+// Rewrap moves every encrypted column still wrapped with a retired master
+// key to the primary one and returns the number of users updated. Run it
+// after adding a new primary key, before removing the old one.
+func (repo *sqlLoginRepo) Rewrap() (int, error) {
+	if repo.keyring == nil {
+		return 0, ErrNoKeyring
+	}
+	rows, err := repo.cluster.Writer("").Query(selectUser+"WHERE tenant_id = ?;", repo.tenant)
+	if err != nil {
+		return 0, err
+	}
+	var stale []User
+	for rows.Next() {
+		u, err := scanSealedUser(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		changed, err := rewrapUser(repo.keyring, &u)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if changed {
+			stale = append(stale, u)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i, u := range stale {
+		_, err := repo.cluster.Writer(repo.key(u.Name)).Exec(
+			"UPDATE users SET email = ?, phone = ?, totp_secret = ? WHERE tenant_id = ? AND name = ?;",
+			u.Email, u.Phone, u.TOTPSecret, repo.tenant, u.Name,
+		)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(stale), nil
+}
+
+// ImportUsers implements BulkRepository.
+func (repo *sqlLoginRepo) ImportUsers(users []User, policy ConflictPolicy, dryRun bool) ([]ImportResult, error) {
+	sealed := make([]User, len(users))
+	indexes := make([]map[string]string, len(users))
+	for i, u := range users {
+		var err error
+		if sealed[i], indexes[i], err = sealUser(repo.keyring, repo.tenant, u); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := repo.cluster.Writer("").Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	results := make([]ImportResult, len(users))
+	for i, u := range sealed {
+		var exists int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE tenant_id = ? AND name = ?;", repo.tenant, u.Name).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists > 0 && policy != ConflictOverwrite {
+			if policy == ConflictFail {
+				return nil, &ConflictError{Index: i, Name: u.Name}
+			}
+			results[i] = Skipped
+			continue
+		}
+		// The rows written so far are visible to the transaction, so
+		// the owner of the sid may be a user imported earlier.
+		var owner string
+		err := tx.QueryRow("SELECT name FROM users WHERE tenant_id = ? AND sid = ? AND name <> ?;", repo.tenant, u.SID, u.Name).Scan(&owner)
+		switch {
+		case err == nil && policy == ConflictFail:
+			return nil, &ConflictError{Index: i, Name: u.Name, Owner: owner}
+		case err == nil:
+			results[i] = SIDConflict
+			continue
+		case !errors.Is(err, sql.ErrNoRows):
+			return nil, err
+		case exists == 0:
+			results[i], err = Created, insertUser(tx, repo.tenant, u, indexes[i])
+		default:
+			results[i], err = Updated, overwriteUser(tx, repo.tenant, u, indexes[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+		// An overwrite without an email keeps the stored one.
+		if users[i].Email != "" || results[i] == Created {
+			if err := setEmailPrefixes(tx, repo.tenant, u.Name, prefixIndexes(repo.keyring, users[i].Email)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if dryRun {
+		return results, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		repo.cluster.pin(repo.key(u.Name))
+	}
+	return results, nil
+}
+
+// ExportUsers implements BulkRepository.
+func (repo *sqlLoginRepo) ExportUsers(fn func(User) error) error {
+	rows, err := repo.cluster.Reader("").Query(selectUser+"WHERE tenant_id = ? ORDER BY id;", repo.tenant)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		u, err := repo.scanUser(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// insertUser inserts u, which is active and created now unless it says
+// otherwise, and writes its user.created event to the outbox.
+func insertUser(e execer, tenant string, u User, indexes map[string]string) error {
+	if u.Status == "" {
+		u.Status = StatusActive
+	}
+	if u.Created.IsZero() {
+		u.Created = time.Now()
+	}
+	_, err := e.Exec(
+		"INSERT INTO users (tenant_id, name, sid, email, email_bidx, phone, totp_secret, password_hash, password_scheme, status, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);",
+		tenant, u.Name, u.SID, u.Email, indexes["email"], u.Phone, u.TOTPSecret, u.PasswordHash, password.Describe(u.PasswordHash), u.Status, toUnix(u.Created),
+	)
+	if err != nil {
+		return err
+	}
+	return appendOutbox(e, tenant, EventUserCreated, u.Name)
+}
+
+// setEmailPrefixes replaces the email prefix indexes of the user named n
+// of tenant with indexes.
+func setEmailPrefixes(e execer, tenant, n string, indexes []string) error {
+	if _, err := e.Exec("DELETE FROM user_email_prefixes WHERE tenant_id = ? AND user_name = ?;", tenant, n); err != nil {
+		return err
+	}
+	for _, bidx := range indexes {
+		if _, err := e.Exec("INSERT INTO user_email_prefixes (tenant_id, user_name, prefix_bidx) VALUES (?, ?, ?);", tenant, n, bidx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// overwriteUser updates the sid of u, and only those of its other columns
+// the import provides: an empty field leaves the credentials or personal
+// data stored as they are.
+func overwriteUser(e execer, tenant string, u User, indexes map[string]string) error {
+	set, args := []string{"sid = ?"}, []interface{}{u.SID}
+	if u.Email != "" {
+		set, args = append(set, "email = ?", "email_bidx = ?"), append(args, u.Email, indexes["email"])
+	}
+	if u.Phone != "" {
+		set, args = append(set, "phone = ?"), append(args, u.Phone)
+	}
+	if u.TOTPSecret != "" {
+		set, args = append(set, "totp_secret = ?"), append(args, u.TOTPSecret)
+	}
+	if u.PasswordHash != "" {
+		set, args = append(set, "password_hash = ?", "password_scheme = ?"), append(args, u.PasswordHash, password.Describe(u.PasswordHash))
+	}
+	_, err := e.Exec("UPDATE users SET "+strings.Join(set, ", ")+" WHERE tenant_id = ? AND name = ?;", append(args, tenant, u.Name)...)
+	return err
+}
+
+func (repo *sqlLoginRepo) scanUser(row scanner) (User, error) {
+	u, err := scanSealedUser(row)
+	if err != nil {
+		return User{}, err
+	}
+	return openUser(repo.keyring, repo.tenant, u)
+}
+
+// scanSealedUser scans a row selected by selectUser without decrypting it.
+func scanSealedUser(row scanner) (User, error) {
+	var (
+		u                User
+		created, revoked int64
+	)
+	if err := row.Scan(&u.Name, &u.SID, &u.Email, &u.Phone, &u.TOTPSecret, &u.PasswordHash, &u.Status, &created, &revoked); err != nil {
+		return User{}, err
+	}
+	u.Created, u.SessionsRevoked = fromUnix(created), fromUnix(revoked)
+	return u, nil
+}
+
+// emailPrefixTable holds the blind indexes of the prefixes of the email
+// addresses of the users.
+var emailPrefixTable = table{"user_email_prefixes", []string{"tenant_id", "user_name", "prefix_bidx"}}
+
+// userColumns are the columns of the users table the repository queries.
+var userColumns = []string{"name", "sid", "email", "email_bidx", "phone", "totp_secret", "password_hash", "password_scheme", "tenant_id", "status", "created_at", "sessions_revoked_at"}
+
+func (repo *sqlLoginRepo) Ping(ctx context.Context) error {
+	return repo.cluster.Ping(ctx)
+}
+
+// table names a table and the columns the repository queries.
+type table struct {
+	name    string
+	columns []string
+}
+
+// CheckSchema selects every column of userColumns and emailPrefixTable,
+// then of roleTables, auditTables and outboxTables, from no rows of the
+// primary, which fails if one is missing. Like Ping, it isn't counted as a query.
+func (repo *sqlLoginRepo) CheckSchema(ctx context.Context) error {
+	tables := append([]table{{"users", userColumns}, emailPrefixTable}, roleTables...)
+	tables = append(append(tables, auditTables...), outboxTables...)
+	for _, t := range tables {
+		rows, err := repo.cluster.primary.db.QueryContext(ctx, "SELECT "+strings.Join(t.columns, ", ")+" FROM "+t.name+" LIMIT 0;")
+		if err != nil {
+			return err
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (repo *sqlLoginRepo) CheckKeys(context.Context) error {
+	if repo.keyring == nil {
+		return nil
+	}
+	return repo.keyring.Check()
+}
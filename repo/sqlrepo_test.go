@@ -0,0 +1,60 @@
+package repo_test
+
+import (
+	"testing"
+
+	"loginsvc/pkg/envelope"
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountPasswordSchemes(t *testing.T) {
+	r, _ := newEncryptedRepo(t, nil)
+	assert.NoError(t, r.Register(repo.User{Name: "al", SID: "c111111111", PasswordHash: "$1$3azHgidD$SrJPt7B.9rekpmwJwtON31"}))
+	assert.NoError(t, r.Register(repo.User{Name: "bo", SID: "d222222222", PasswordHash: "$1$saltstri$qQY4WxjABChYG1ccLpfkz/"}))
+
+	counts, err := r.CountPasswordSchemes()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"none": 1, "md5-crypt": 2}, counts)
+
+	bcrypt := "$2b$12$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
+	assert.NoError(t, r.SetPasswordHash("al", bcrypt))
+	u, err := r.User("al")
+	assert.NoError(t, err)
+	assert.Equal(t, bcrypt, u.PasswordHash)
+
+	counts, err = r.CountPasswordSchemes()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"none": 1, "md5-crypt": 1, "bcrypt-12": 1}, counts)
+}
+
+func TestImportOverwriteKeepsUnsetFields(t *testing.T) {
+	k, _ := envelope.NewKeyring("k1", map[string][]byte{"k1": envelope.GenerateKey()}, envelope.GenerateKey())
+	r, _ := newEncryptedRepo(t, k)
+	bcrypt := "$2b$12$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
+	assert.NoError(t, r.Register(repo.User{Name: "al", SID: "c111111111", Email: "al@example.com", Phone: "+886912345678", PasswordHash: bcrypt}))
+
+	results, err := r.ImportUsers([]repo.User{{Name: "al", SID: "e444444444"}}, repo.ConflictOverwrite, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []repo.ImportResult{repo.Updated}, results)
+	al, err := r.UserByEmail("al@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"e444444444", "+886912345678", bcrypt}, []string{al.SID, al.Phone, al.PasswordHash})
+	page, err := r.ListUsers(repo.UserQuery{EmailPrefix: "al@example.c"})
+	assert.NoError(t, err)
+	assert.Len(t, page.Users, 1)
+
+	_, err = r.ImportUsers([]repo.User{{Name: "al", SID: "e444444444", Email: "al@example.org"}}, repo.ConflictOverwrite, false)
+	assert.NoError(t, err)
+	al, err = r.UserByEmail("al@example.org")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"+886912345678", bcrypt}, []string{al.Phone, al.PasswordHash})
+	page, err = r.ListUsers(repo.UserQuery{EmailPrefix: "al@example.c"})
+	assert.NoError(t, err)
+	assert.Empty(t, page.Users)
+
+	counts, err := r.CountPasswordSchemes()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"none": 1, "bcrypt-12": 1}, counts)
+}
@@ -0,0 +1,59 @@
+package repo_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"loginsvc/pkg/envelope"
+	"loginsvc/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	k, _ := envelope.NewKeyring("k1", map[string][]byte{"k1": envelope.GenerateKey()}, envelope.GenerateKey())
+	base, _ := newEncryptedRepo(t, k)
+	acme, globex := base.ForTenant("acme", nil), base.ForTenant("globex", nil)
+
+	// The same names live side by side in different tenants.
+	assert.NoError(t, acme.Register(repo.User{Name: "al", SID: "c111111111", Email: "al@example.com"}))
+	assert.NoError(t, globex.Register(repo.User{Name: "al", SID: "c111111111", Email: "al@example.com"}))
+	assert.Error(t, acme.Register(repo.User{Name: "al", SID: "c222222222"}))
+	assert.NoError(t, globex.UpdateUser(repo.User{Name: "al", Email: "al@globex.example"}))
+	u, err := acme.User("al")
+	assert.NoError(t, err)
+	assert.Equal(t, "al@example.com", u.Email)
+	_, err = globex.UserByEmail("al@example.com")
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// Users of the default tenant, like ed, are invisible to the others.
+	_, err = acme.Name("ed")
+	assert.Equal(t, sql.ErrNoRows, err)
+	sid, err := base.Name("ed")
+	assert.NoError(t, err)
+	assert.Equal(t, "a123456789", sid)
+
+	assert.NoError(t, acme.CreateRole("reader"))
+	assert.NoError(t, acme.Grant("reader", repo.Permission{Name: "orders.read"}))
+	assert.NoError(t, acme.AssignRole("al", "reader"))
+	assert.Equal(t, repo.ErrUnknownRole, globex.AssignRole("al", "reader"))
+	assert.NoError(t, acme.CreateGroup("staff"))
+	assert.Equal(t, repo.ErrUnknownGroup, globex.AddMember("staff", "al"))
+
+	perms, err := globex.UserPermissions("al")
+	assert.NoError(t, err)
+	assert.Empty(t, perms)
+	roles, err := globex.Roles()
+	assert.NoError(t, err)
+	assert.Empty(t, roles)
+	groups, err := globex.Groups()
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+
+	var exported []string
+	assert.NoError(t, globex.ExportUsers(func(u repo.User) error {
+		exported = append(exported, u.Name+" "+u.Email)
+		return nil
+	}))
+	assert.Equal(t, []string{"al al@globex.example"}, exported)
+}
@@ -1,7 +1,188 @@
+-- Every table is partitioned by tenant_id: names are unique per tenant
+-- only, and each query of the repository is scoped to one tenant.
+--
+-- created_at and sessions_revoked_at are Unix seconds; 0 is unknown or
+-- never. The status and created_at indexes serve the admin user lists.
 CREATE TABLE IF NOT EXISTS `users` (
   `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `tenant_id` TEXT NOT NULL DEFAULT 'default',
   `name` TEXT NOT NULL,
-  `sid` TEXT NOT NULL
+  `sid` TEXT NOT NULL,
+  `email` TEXT NOT NULL DEFAULT '',
+  `email_bidx` TEXT NOT NULL DEFAULT '',
+  `phone` TEXT NOT NULL DEFAULT '',
+  `totp_secret` TEXT NOT NULL DEFAULT '',
+  `password_hash` TEXT NOT NULL DEFAULT '',
+  `password_scheme` TEXT NOT NULL DEFAULT 'none',
+  `status` TEXT NOT NULL DEFAULT 'active',
+  `created_at` INTEGER NOT NULL DEFAULT 0,
+  `sessions_revoked_at` INTEGER NOT NULL DEFAULT 0,
+  UNIQUE (`tenant_id`, `name`),
+  UNIQUE (`tenant_id`, `sid`)
 );
 
-INSERT INTO `users` (`name`, `sid`) VALUES ('ed', 'a123456789');
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS `users_email_bidx_index` ON `users` (`tenant_id`, `email_bidx`);
+CREATE INDEX IF NOT EXISTS `users_status_index` ON `users` (`tenant_id`, `status`, `name`);
+CREATE INDEX IF NOT EXISTS `users_created_index` ON `users` (`tenant_id`, `created_at`, `name`);
+
+-- The blind indexes of every prefix of the email address of a user, for
+-- the admin user lists to search the encrypted addresses by prefix.
+CREATE TABLE IF NOT EXISTS `user_email_prefixes` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `tenant_id` TEXT NOT NULL DEFAULT 'default',
+  `user_name` TEXT NOT NULL,
+  `prefix_bidx` TEXT NOT NULL,
+  UNIQUE (`tenant_id`, `user_name`, `prefix_bidx`)
+);
+
+CREATE INDEX IF NOT EXISTS `user_email_prefixes_bidx_index` ON `user_email_prefixes` (`tenant_id`, `prefix_bidx`);
+
+-- Roles group permissions, which are granted on a resource or on every
+-- resource ('*'). Users are assigned roles by name.
+CREATE TABLE IF NOT EXISTS `roles` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `tenant_id` TEXT NOT NULL DEFAULT 'default',
+  `name` TEXT NOT NULL,
+  UNIQUE (`tenant_id`, `name`)
+);
+
+CREATE TABLE IF NOT EXISTS `permissions` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `tenant_id` TEXT NOT NULL DEFAULT 'default',
+  `role` TEXT NOT NULL,
+  `permission` TEXT NOT NULL,
+  `resource` TEXT NOT NULL DEFAULT '*',
+  UNIQUE (`tenant_id`, `role`, `permission`, `resource`)
+);
+
+CREATE TABLE IF NOT EXISTS `user_roles` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `tenant_id` TEXT NOT NULL DEFAULT 'default',
+  `user_name` TEXT NOT NULL,
+  `role` TEXT NOT NULL,
+  UNIQUE (`tenant_id`, `user_name`, `role`)
+);
+
+CREATE INDEX IF NOT EXISTS `user_roles_role_index` ON `user_roles` (`tenant_id`, `role`);
+
+-- Groups hold users and other groups, and pass their roles on to all of
+-- their members. GROUPS is reserved in MySQL 8, hence usergroups.
+CREATE TABLE IF NOT EXISTS `usergroups` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `tenant_id` TEXT NOT NULL DEFAULT 'default',
+  `name` TEXT NOT NULL,
+  UNIQUE (`tenant_id`, `name`)
+);
+
+CREATE TABLE IF NOT EXISTS `group_members` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `tenant_id` TEXT NOT NULL DEFAULT 'default',
+  `group_name` TEXT NOT NULL,
+  `user_name` TEXT NOT NULL,
+  UNIQUE (`tenant_id`, `user_name`, `group_name`)
+);
+
+CREATE INDEX IF NOT EXISTS `group_members_group_index` ON `group_members` (`tenant_id`, `group_name`);
+
+CREATE TABLE IF NOT EXISTS `group_parents` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `tenant_id` TEXT NOT NULL DEFAULT 'default',
+  `group_name` TEXT NOT NULL,
+  `parent` TEXT NOT NULL,
+  UNIQUE (`tenant_id`, `group_name`, `parent`)
+);
+
+CREATE INDEX IF NOT EXISTS `group_parents_parent_index` ON `group_parents` (`tenant_id`, `parent`);
+
+CREATE TABLE IF NOT EXISTS `group_roles` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `tenant_id` TEXT NOT NULL DEFAULT 'default',
+  `group_name` TEXT NOT NULL,
+  `role` TEXT NOT NULL,
+  UNIQUE (`tenant_id`, `group_name`, `role`)
+);
+
+CREATE INDEX IF NOT EXISTS `group_roles_role_index` ON `group_roles` (`tenant_id`, `role`);
+
+-- The audit log is append-only: each event holds the hash of the one
+-- before it in its tenant, so changed or removed events are detected.
+-- occurred_at is Unix nanoseconds.
+CREATE TABLE IF NOT EXISTS `audit_log` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `tenant_id` TEXT NOT NULL DEFAULT 'default',
+  `seq` INTEGER NOT NULL,
+  `occurred_at` INTEGER NOT NULL,
+  `type` TEXT NOT NULL,
+  `actor` TEXT NOT NULL DEFAULT '',
+  `subject` TEXT NOT NULL DEFAULT '',
+  `ip` TEXT NOT NULL DEFAULT '',
+  `outcome` TEXT NOT NULL DEFAULT '',
+  `detail` TEXT NOT NULL DEFAULT '',
+  `prev_hash` TEXT NOT NULL DEFAULT '',
+  `hash` TEXT NOT NULL,
+  UNIQUE (`tenant_id`, `seq`)
+);
+
+CREATE INDEX IF NOT EXISTS `audit_log_time_index` ON `audit_log` (`tenant_id`, `occurred_at`);
+
+-- audit_chain holds the head of the audit log of each tenant: the seq and
+-- hash of its last event. Appending an event locks it until the commit.
+CREATE TABLE IF NOT EXISTS `audit_chain` (
+  `tenant_id` TEXT PRIMARY KEY,
+  `seq` INTEGER NOT NULL,
+  `hash` TEXT NOT NULL DEFAULT ''
+);
+
+-- The outbox holds the identity events of users, written in the transaction
+-- of the change they record. The webhook dispatcher copies each event into
+-- a delivery per matching subscription, then marks it dispatched. seq
+-- numbers committed events in commit order for WatchEvents, and is NULL
+-- until then.
+-- occurred_at and next_attempt_at are Unix nanoseconds.
+CREATE TABLE IF NOT EXISTS `outbox` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `tenant_id` TEXT NOT NULL DEFAULT 'default',
+  `type` TEXT NOT NULL,
+  `user_name` TEXT NOT NULL,
+  `occurred_at` INTEGER NOT NULL,
+  `dispatched` INTEGER NOT NULL DEFAULT 0,
+  `seq` INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS `outbox_dispatched_index` ON `outbox` (`tenant_id`, `dispatched`, `id`);
+CREATE INDEX IF NOT EXISTS `outbox_seq_index` ON `outbox` (`tenant_id`, `seq`);
+
+-- outbox_sequence has a single row, the last seq given to an outbox event.
+-- Numbering events locks it until they commit.
+CREATE TABLE IF NOT EXISTS `outbox_sequence` (
+  `id` INTEGER PRIMARY KEY,
+  `seq` INTEGER NOT NULL
+);
+
+INSERT OR IGNORE INTO `outbox_sequence` (`id`, `seq`) VALUES (1, 0);
+
+-- event_types is a comma-separated list; empty matches every type.
+CREATE TABLE IF NOT EXISTS `webhook_subscriptions` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `tenant_id` TEXT NOT NULL DEFAULT 'default',
+  `url` TEXT NOT NULL,
+  `secret` TEXT NOT NULL,
+  `event_types` TEXT NOT NULL DEFAULT '',
+  `created_at` INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS `webhook_deliveries` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `tenant_id` TEXT NOT NULL DEFAULT 'default',
+  `subscription_id` INTEGER NOT NULL,
+  `event_id` INTEGER NOT NULL,
+  `state` TEXT NOT NULL DEFAULT 'pending',
+  `attempts` INTEGER NOT NULL DEFAULT 0,
+  `next_attempt_at` INTEGER NOT NULL DEFAULT 0,
+  `last_error` TEXT NOT NULL DEFAULT '',
+  UNIQUE (`subscription_id`, `event_id`)
+);
+
+CREATE INDEX IF NOT EXISTS `webhook_deliveries_due_index` ON `webhook_deliveries` (`tenant_id`, `state`, `next_attempt_at`);
+
+INSERT INTO `users` (`name`, `sid`) VALUES ('ed', 'a123456789');